@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/gateway/template/internal/config"
+	"github.com/gateway/template/internal/middleware"
+	"github.com/gateway/template/internal/problem"
+	"github.com/gateway/template/pkg/auth"
+	"github.com/gateway/template/pkg/logger"
+)
+
+// tokenDebugRequest is the request body of the /auth/debug endpoint, the
+// token can also be sent in the Authorization header
+type tokenDebugRequest struct {
+	Token string `json:"token"`
+}
+
+// tokenDebugResponse explains whether a token is accepted and why not
+type tokenDebugResponse struct {
+	Valid    bool                   `json:"valid"`
+	Reason   string                 `json:"reason,omitempty"` // see auth.FailureReason
+	Error    string                 `json:"error,omitempty"`
+	Header   map[string]interface{} `json:"header,omitempty"`
+	Claims   jwt.MapClaims          `json:"claims,omitempty"`
+	Expected tokenExpectations      `json:"expected"`
+}
+
+// tokenExpectations are the claim values the gateway requires
+type tokenExpectations struct {
+	Issuer         string   `json:"issuer"`
+	Audience       string   `json:"audience"`
+	TrustedIssuers []string `json:"trusted_issuers,omitempty"` // also accepted
+}
+
+// tokenDebug returns a handler that decodes a token and reports the exact
+// reason it fails validation, which a bare 401 does not tell
+func tokenDebug(cfg *config.JWTConfig) (http.HandlerFunc, error) {
+	authCfg := middleware.AuthConfig(cfg)
+	manager, err := auth.NewManager(authCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body tokenDebugRequest
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				problem.Write(w, r, http.StatusBadRequest, "invalid request body")
+				return
+			}
+		}
+		if body.Token == "" {
+			body.Token, _ = auth.ExtractBearerToken(r.Header.Get("Authorization"))
+		}
+		body.Token = strings.TrimSpace(body.Token)
+		if body.Token == "" {
+			problem.Write(w, r, http.StatusBadRequest, "token is required in the body or the Authorization header")
+			return
+		}
+
+		resp := tokenDebugResponse{
+			Expected: tokenExpectations{Issuer: authCfg.Issuer, Audience: authCfg.Audience},
+		}
+		for _, trusted := range authCfg.TrustedIssuers {
+			resp.Expected.TrustedIssuers = append(resp.Expected.TrustedIssuers, trusted.Issuer)
+		}
+
+		// decode without verification so claims are shown for rejected tokens too
+		if token, _, err := jwt.NewParser().ParseUnverified(body.Token, jwt.MapClaims{}); err == nil {
+			resp.Header = token.Header
+			resp.Claims, _ = token.Claims.(jwt.MapClaims)
+		}
+
+		if _, err := manager.ValidateToken(body.Token); err != nil {
+			resp.Reason = auth.FailureReason(err)
+			resp.Error = err.Error()
+		} else {
+			resp.Valid = true
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(resp)
+	}, nil
+}
+
+// tokenRefreshResponse is the response body of the /auth/refresh endpoint
+type tokenRefreshResponse struct {
+	Token     string `json:"token"`
+	TokenType string `json:"token_type"`
+	ExpiresIn int64  `json:"expires_in"` // seconds
+}
+
+// tokenRefresh returns a handler that exchanges the bearer token of the
+// request, valid or expired within the refresh window, for a new one
+func tokenRefresh(cfg *config.JWTConfig, log logger.Logger) (http.HandlerFunc, error) {
+	authCfg := middleware.AuthConfig(cfg)
+	manager, err := auth.NewManager(authCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, err := auth.ExtractBearerToken(r.Header.Get("Authorization"))
+		if err != nil {
+			problem.Write(w, r, http.StatusUnauthorized, err.Error())
+			return
+		}
+
+		refreshed, err := manager.RefreshToken(token)
+		if err != nil {
+			message := "invalid token"
+			if errors.Is(err, auth.ErrRefreshWindowExpired) {
+				message = "token expired too long ago, sign in again"
+			}
+
+			log.Warn("token refresh failed",
+				"path", r.URL.Path,
+				"reason", auth.FailureReason(err),
+				"error", err.Error(),
+			)
+
+			problem.Write(w, r, http.StatusUnauthorized, message)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		_ = json.NewEncoder(w).Encode(tokenRefreshResponse{
+			Token:     refreshed,
+			TokenType: "Bearer",
+			ExpiresIn: int64(authCfg.Expiration.Seconds()),
+		})
+	}, nil
+}
+
+// sessionResponse is the response body of the /auth/session endpoint
+type sessionResponse struct {
+	UserID    string `json:"user_id"`
+	CSRFToken string `json:"csrf_token"`           // send in the X-CSRF-Token header of unsafe requests
+	ExpiresAt int64  `json:"expires_at,omitempty"` // Unix seconds, when the session ends with its token
+}
+
+// sessionLogin returns a handler that exchanges the bearer token of the
+// request for an encrypted HttpOnly session cookie carrying the token, which
+// authenticates later requests in its place
+func sessionLogin(cfg *config.JWTConfig, log logger.Logger) (http.HandlerFunc, error) {
+	validator, err := middleware.NewTokenValidator(cfg)
+	if err != nil {
+		return nil, err
+	}
+	sessions, err := auth.NewSessionCipher(cfg.SessionCookie.Secret)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		authHeader := r.Header.Get("Authorization")
+		claims, err := validator.ValidateRequest(authHeader)
+		if err != nil {
+			var authErr *auth.AuthError
+			statusCode := http.StatusUnauthorized
+			message := "unauthorized"
+			if errors.As(err, &authErr) {
+				statusCode = authErr.Code
+				message = authErr.Message
+			}
+
+			log.Warn("session login failed",
+				"path", r.URL.Path,
+				"reason", auth.FailureReason(err),
+				"error", err.Error(),
+			)
+
+			problem.Write(w, r, statusCode, message)
+			return
+		}
+
+		token, _ := auth.ExtractBearerToken(authHeader)
+		value, err := sessions.Seal(token)
+		if err != nil {
+			log.Error("failed to seal session", "error", err)
+			problem.Write(w, r, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		// the cookie lives as long as the token, which is checked on every request
+		var expires time.Time
+		resp := sessionResponse{UserID: claims.UserID, CSRFToken: sessions.CSRFToken(value)}
+		if claims.ExpiresAt != nil {
+			expires = claims.ExpiresAt.Time
+			resp.ExpiresAt = expires.Unix()
+		}
+
+		http.SetCookie(w, middleware.NewSessionCookie(&cfg.SessionCookie, value, expires))
+		http.SetCookie(w, middleware.NewCSRFCookie(&cfg.SessionCookie, resp.CSRFToken, expires))
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		_ = json.NewEncoder(w).Encode(resp)
+	}, nil
+}
+
+// sessionLogout returns a handler that deletes the session cookies
+func sessionLogout(cfg *config.JWTConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, middleware.NewSessionCookie(&cfg.SessionCookie, "", time.Time{}))
+		http.SetCookie(w, middleware.NewCSRFCookie(&cfg.SessionCookie, "", time.Time{}))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// newRevocationStore creates the revocation store selected in cfg
+func newRevocationStore(cfg *config.JWTConfig) (auth.RevocationStore, error) {
+	switch cfg.RevocationStore {
+	case "memory":
+		return auth.NewMemoryRevocationStore(), nil
+	case "redis":
+		return auth.NewRedisRevocationStore(cfg.RedisURL)
+	default:
+		return nil, fmt.Errorf("unknown revocation store %q", cfg.RevocationStore)
+	}
+}
+
+// revokeRequest is the request body of the /admin/revoke endpoint, exactly
+// one field must be set
+type revokeRequest struct {
+	Token   string `json:"token"` // revoke this token
+	JTI     string `json:"jti"`   // revoke the token with this ID
+	Subject string `json:"sub"`   // revoke all tokens issued to this subject so far
+}
+
+// revokeResponse tells which token ID or subject was revoked
+type revokeResponse struct {
+	JTI     string `json:"jti,omitempty"`
+	Subject string `json:"sub,omitempty"`
+}
+
+// tokenRevoker revokes tokens, implemented by the JWT and PASETO validators
+type tokenRevoker interface {
+	Revoke(ctx context.Context, tokenString string) (*auth.Claims, error)
+	RevokeID(ctx context.Context, jti string, expiresAt time.Time) error
+	RevokeSubject(ctx context.Context, subject string) error
+}
+
+// revokeTokens returns a handler that revokes a token, a token ID or all
+// tokens of a subject before they expire
+func revokeTokens(cfg *config.JWTConfig, log logger.Logger) (http.HandlerFunc, error) {
+	validator, err := middleware.NewTokenValidator(cfg)
+	if err != nil {
+		return nil, err
+	}
+	manager, ok := validator.(tokenRevoker)
+	if !ok {
+		return nil, fmt.Errorf("%s tokens cannot be revoked", cfg.TokenFormat)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body revokeRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			problem.Write(w, r, http.StatusBadRequest, "invalid request body")
+			return
+		}
+
+		set := 0
+		for _, field := range []string{body.Token, body.JTI, body.Subject} {
+			if field != "" {
+				set++
+			}
+		}
+		if set != 1 {
+			problem.Write(w, r, http.StatusBadRequest, "exactly one of token, jti or sub is required")
+			return
+		}
+
+		var resp revokeResponse
+		var err error
+		switch {
+		case body.Token != "":
+			claims, err := manager.Revoke(r.Context(), body.Token)
+			if err != nil {
+				if errors.Is(err, auth.ErrInvalidToken) || errors.Is(err, auth.ErrInvalidClaims) {
+					problem.Write(w, r, http.StatusBadRequest, err.Error())
+					return
+				}
+				log.Error("failed to revoke token", "error", err)
+				problem.Write(w, r, http.StatusServiceUnavailable, "failed to revoke token")
+				return
+			}
+			resp = revokeResponse{JTI: claims.ID, Subject: claims.UserID}
+		case body.JTI != "":
+			err = manager.RevokeID(r.Context(), body.JTI, time.Time{})
+			resp.JTI = body.JTI
+		default:
+			err = manager.RevokeSubject(r.Context(), body.Subject)
+			resp.Subject = body.Subject
+		}
+		if err != nil {
+			log.Error("failed to revoke token", "error", err)
+			problem.Write(w, r, http.StatusServiceUnavailable, "failed to revoke token")
+			return
+		}
+
+		log.Info("token revoked", "jti", resp.JTI, "sub", resp.Subject)
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(resp)
+	}, nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gateway/template/internal/config"
+	"github.com/gateway/template/pkg/auth"
+	"github.com/gateway/template/pkg/logger"
+)
+
+// TestRevokeTokensConcurrent revokes token IDs and subjects from concurrent
+// requests, run with -race to catch state shared between them
+func TestRevokeTokensConcurrent(t *testing.T) {
+	store := auth.NewMemoryRevocationStore()
+	auth.SetDefaultRevocationStore(store)
+	defer auth.SetDefaultRevocationStore(nil)
+
+	cfg := &config.JWTConfig{Secret: "test-secret", Expiration: time.Hour, RevocationStore: "memory"}
+	handler, err := revokeTokens(cfg, logger.NewMockLogger())
+	if err != nil {
+		t.Fatalf("revokeTokens() failed: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			body := fmt.Sprintf(`{"jti":"token-%d"}`, i)
+			if i%2 == 1 {
+				body = fmt.Sprintf(`{"sub":"user-%d"}`, i)
+			}
+			rec := httptest.NewRecorder()
+			handler(rec, httptest.NewRequest(http.MethodPost, "/admin/revoke", strings.NewReader(body)))
+			if rec.Code != http.StatusOK {
+				t.Errorf("revoke %s: expected status 200, got %d: %s", body, rec.Code, rec.Body)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := range 20 {
+		key := fmt.Sprintf("jti:token-%d", i)
+		if i%2 == 1 {
+			key = fmt.Sprintf("sub:user-%d", i)
+		}
+		revokedAt, err := store.RevokedAt(context.Background(), key)
+		if err != nil || revokedAt.IsZero() {
+			t.Errorf("expected %s to be revoked", key)
+		}
+	}
+}
+
+func TestRevokeTokensRequiresOneField(t *testing.T) {
+	auth.SetDefaultRevocationStore(auth.NewMemoryRevocationStore())
+	defer auth.SetDefaultRevocationStore(nil)
+
+	handler, err := revokeTokens(&config.JWTConfig{Secret: "test-secret", Expiration: time.Hour}, logger.NewMockLogger())
+	if err != nil {
+		t.Fatalf("revokeTokens() failed: %v", err)
+	}
+
+	for _, body := range []string{`{}`, `{"jti":"a","sub":"b"}`, `not json`} {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodPost, "/admin/revoke", strings.NewReader(body)))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("revoke %s: expected status 400, got %d", body, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gateway/template/internal/cache"
+	"github.com/gateway/template/internal/middleware"
+	"github.com/gateway/template/internal/problem"
+	"github.com/gateway/template/pkg/logger"
+)
+
+// cachePurgeRequest is the request body of the /admin/cache/purge endpoint,
+// exactly one field must be set
+type cachePurgeRequest struct {
+	Path   string `json:"path"`   // purge the responses to this gateway path, whatever their query
+	Prefix string `json:"prefix"` // purge the responses to gateway paths starting with this prefix
+	Tag    string `json:"tag"`    // purge the responses the upstream tagged with this tag
+}
+
+// cachePurgeResponse tells how many responses were purged
+type cachePurgeResponse struct {
+	Purged int `json:"purged"`
+}
+
+// purgeCache returns a handler removing responses from the cache, so
+// updated content is served before they expire
+func purgeCache(store *cache.Cache, log logger.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body cachePurgeRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			problem.Write(w, r, http.StatusBadRequest, "invalid request body")
+			return
+		}
+
+		var match func(*cache.Entry) bool
+		switch {
+		case body.Path != "" && body.Prefix == "" && body.Tag == "":
+			match = func(e *cache.Entry) bool { return e.Path == body.Path }
+		case body.Prefix != "" && body.Path == "" && body.Tag == "":
+			match = func(e *cache.Entry) bool { return strings.HasPrefix(e.Path, body.Prefix) }
+		case body.Tag != "" && body.Path == "" && body.Prefix == "":
+			match = func(e *cache.Entry) bool { return slices.Contains(e.Tags, body.Tag) }
+		default:
+			problem.Write(w, r, http.StatusBadRequest, "exactly one of path, prefix or tag is required")
+			return
+		}
+
+		resp := cachePurgeResponse{Purged: store.Purge(match)}
+		log.Info("cache purged", "path", body.Path, "prefix", body.Prefix, "tag", body.Tag, "purged", resp.Purged)
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// maxWarmURLs caps the URLs of a cache warm-up request, warmConcurrency the
+// requests it sends at once and warmTimeout each of them
+const (
+	maxWarmURLs     = 100
+	warmConcurrency = 8
+	warmTimeout     = 30 * time.Second
+)
+
+// cacheWarmRequest is the request body of the /admin/cache/warm endpoint
+type cacheWarmRequest struct {
+	URLs    []string          `json:"urls"`    // gateway paths with their query
+	Headers map[string]string `json:"headers"` // headers of the requests, e.g. Accept
+}
+
+// cacheWarmResult is the outcome of fetching one URL
+type cacheWarmResult struct {
+	URL    string `json:"url"`
+	Status int    `json:"status"`          // status of the response
+	Cache  string `json:"cache,omitempty"` // X-Cache of the response, empty outside cache paths
+}
+
+// cacheWarmResponse lists the outcome of each URL in the order of the
+// request
+type cacheWarmResponse struct {
+	Results []cacheWarmResult `json:"results"`
+}
+
+// warmCache returns a handler filling the cache, after a deploy or a purge,
+// by sending GET requests for a list of URLs to gateway, the gateway's
+// router, so they pass the middleware and authentication of their service.
+// They are answered by the upstream even if a response is cached, which
+// they replace.
+func warmCache(gateway http.Handler, log logger.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body cacheWarmRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			problem.Write(w, r, http.StatusBadRequest, "invalid request body")
+			return
+		}
+		if len(body.URLs) == 0 || len(body.URLs) > maxWarmURLs {
+			problem.Write(w, r, http.StatusBadRequest, fmt.Sprintf("between 1 and %d urls are required", maxWarmURLs))
+			return
+		}
+		targets := make([]*url.URL, len(body.URLs))
+		for i, rawURL := range body.URLs {
+			u, err := url.Parse(rawURL)
+			if err != nil || !strings.HasPrefix(rawURL, "/") || strings.HasPrefix(rawURL, "//") {
+				problem.Write(w, r, http.StatusBadRequest, fmt.Sprintf("url %q must be a gateway path", rawURL))
+				return
+			}
+			targets[i] = u
+		}
+		header := make(http.Header, len(body.Headers))
+		for name, value := range body.Headers {
+			header.Set(name, value)
+		}
+
+		resp := cacheWarmResponse{Results: make([]cacheWarmResult, len(targets))}
+		slots := make(chan struct{}, warmConcurrency)
+		var wg sync.WaitGroup
+		for i, target := range targets {
+			wg.Add(1)
+			slots <- struct{}{}
+			go func() {
+				defer func() { <-slots; wg.Done() }()
+				resp.Results[i] = warmURL(r, target, header, gateway)
+				resp.Results[i].URL = body.URLs[i]
+			}()
+		}
+		wg.Wait()
+
+		log.Info("cache warmed", "urls", len(targets))
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// warmURL sends the GET request of a cache warm-up for target. It carries
+// none of the context of r, such as the admin's claims, which would become
+// part of the cache key.
+func warmURL(r *http.Request, target *url.URL, header http.Header, gateway http.Handler) cacheWarmResult {
+	ctx, cancel := context.WithTimeout(middleware.WithCacheRefresh(context.Background()), warmTimeout)
+	defer cancel()
+	stop := context.AfterFunc(r.Context(), cancel)
+	defer stop()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return cacheWarmResult{Status: http.StatusBadRequest}
+	}
+	req.Header = header.Clone()
+	req.Host = r.Host
+	req.RemoteAddr = r.RemoteAddr
+	req.RequestURI = target.RequestURI()
+
+	rec := &warmRecorder{header: make(http.Header)}
+	gateway.ServeHTTP(rec, req)
+	return cacheWarmResult{Status: rec.status, Cache: rec.header.Get(middleware.CacheStatusHeader)}
+}
+
+// warmRecorder is the ResponseWriter of a warm-up request, dropping the body
+type warmRecorder struct {
+	header http.Header
+	status int
+}
+
+func (rec *warmRecorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *warmRecorder) WriteHeader(code int) {
+	if rec.status == 0 {
+		rec.status = code
+	}
+}
+
+func (rec *warmRecorder) Write(b []byte) (int, error) {
+	rec.WriteHeader(http.StatusOK)
+	return len(b), nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/gateway/template/internal/cache"
+	"github.com/gateway/template/internal/config"
+	"github.com/gateway/template/internal/middleware"
+	"github.com/gateway/template/pkg/logger"
+)
+
+func TestPurgeCache(t *testing.T) {
+	// responses tagged with their product and catalog
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		product := strings.TrimPrefix(r.URL.Path, "/crm/catalog/")
+		w.Header().Set("Surrogate-Key", "catalog product-"+product)
+		fmt.Fprint(w, product)
+	})
+	cfg := &config.TargetCacheConfig{Paths: []string{"/crm/*"}, TTL: time.Minute}
+
+	tests := []struct {
+		body   string
+		status int
+		purged int
+	}{
+		{`{"path":"/crm/catalog/1"}`, http.StatusOK, 2},
+		{`{"prefix":"/crm/catalog/"}`, http.StatusOK, 4},
+		{`{"prefix":"/billing/"}`, http.StatusOK, 0},
+		{`{"tag":"product-2"}`, http.StatusOK, 1},
+		{`{"tag":"catalog"}`, http.StatusOK, 4},
+		{`{"path":"/crm/catalog/1","tag":"catalog"}`, http.StatusBadRequest, 0},
+		{`{}`, http.StatusBadRequest, 0},
+		{`not json`, http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.body, func(t *testing.T) {
+			store := cache.New(1 << 20)
+			h := middleware.Cache("crm", cfg, store, 1<<20, "Surrogate-Key")(upstream)
+			for _, target := range []string{"/crm/catalog/1", "/crm/catalog/1?page=2", "/crm/catalog/2", "/crm/catalog/3"} {
+				h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+			}
+
+			rec := httptest.NewRecorder()
+			purgeCache(store, logger.NewMockLogger())(rec, httptest.NewRequest(http.MethodPost, "/admin/cache/purge", strings.NewReader(tt.body)))
+			if rec.Code != tt.status {
+				t.Fatalf("expected status %d, got %d: %s", tt.status, rec.Code, rec.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			var resp cachePurgeResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || resp.Purged != tt.purged {
+				t.Errorf("expected %d purged responses, got %+v, %v", tt.purged, resp, err)
+			}
+			if store.Len() != 4-tt.purged {
+				t.Errorf("expected %d cached responses left, got %d", 4-tt.purged, store.Len())
+			}
+		})
+	}
+}
+
+func TestWarmCache(t *testing.T) {
+	var requests atomic.Int32
+	store := cache.New(1 << 20)
+	cfg := &config.TargetCacheConfig{Paths: []string{"/crm/catalog/*"}, TTL: time.Minute}
+	router := chi.NewRouter()
+	router.With(middleware.Cache("crm", cfg, store, 1<<20, "")).Get("/crm/*", func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.URL.Path == "/crm/catalog/missing" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, r.Header.Get("Accept-Language"))
+	})
+	handler := warmCache(router, logger.NewMockLogger())
+
+	warm := func(body string) (*httptest.ResponseRecorder, cacheWarmResponse) {
+		// the admin's user must not end up in the cache key
+		req := httptest.NewRequest(http.MethodPost, "/admin/cache/warm", strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDContextKey, "admin"))
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		var resp cacheWarmResponse
+		_ = json.NewDecoder(bytes.NewReader(rec.Body.Bytes())).Decode(&resp)
+		return rec, resp
+	}
+
+	body := `{"urls":["/crm/catalog/1","/crm/catalog/2?page=2","/crm/catalog/missing","/crm/contacts"],"headers":{"Accept-Language":"de"}}`
+	rec, resp := warm(body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body)
+	}
+	want := []cacheWarmResult{
+		{URL: "/crm/catalog/1", Status: http.StatusOK, Cache: "MISS"},
+		{URL: "/crm/catalog/2?page=2", Status: http.StatusOK, Cache: "MISS"},
+		{URL: "/crm/catalog/missing", Status: http.StatusNotFound, Cache: "MISS"},
+		{URL: "/crm/contacts", Status: http.StatusOK},
+	}
+	if !slices.Equal(resp.Results, want) {
+		t.Errorf("unexpected results %+v", resp.Results)
+	}
+
+	// clients with the same headers are answered from the warmed cache
+	get := httptest.NewRequest(http.MethodGet, "/crm/catalog/1", nil)
+	get.Header.Set("Accept-Language", "de")
+	client := httptest.NewRecorder()
+	router.ServeHTTP(client, get)
+	if client.Header().Get(middleware.CacheStatusHeader) != "HIT" || client.Body.String() != "de" {
+		t.Errorf("expected the warmed response, got %s %q", client.Header().Get(middleware.CacheStatusHeader), client.Body)
+	}
+
+	// warming again fetches the cached responses anew
+	before := requests.Load()
+	if _, resp := warm(`{"urls":["/crm/catalog/1"],"headers":{"Accept-Language":"de"}}`); resp.Results[0].Cache != "MISS" || requests.Load() != before+1 {
+		t.Errorf("expected the cached response to be refreshed, got %+v", resp.Results)
+	}
+
+	tooMany := make([]string, maxWarmURLs+1)
+	for i := range tooMany {
+		tooMany[i] = "/crm/catalog/1"
+	}
+	encoded, _ := json.Marshal(cacheWarmRequest{URLs: tooMany})
+	for _, body := range []string{string(encoded), `{"urls":[]}`, `{"urls":["https://example.com/crm"]}`, `{"urls":["//example.com/crm"]}`, `not json`} {
+		if rec, _ := warm(body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%.40s: expected status 400, got %d", body, rec.Code)
+		}
+	}
+}
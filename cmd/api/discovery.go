@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/gateway/template/internal/config"
+	"github.com/gateway/template/internal/discovery"
+	"github.com/gateway/template/internal/problem"
+	"github.com/gateway/template/internal/proxy"
+	"github.com/gateway/template/pkg/logger"
+	"github.com/go-chi/chi/v5"
+)
+
+// discoveredService is a service found by service discovery
+type discoveredService struct {
+	target  config.TargetConfig
+	proxy   *proxy.ReverseProxy
+	handler http.Handler
+}
+
+// serviceRouter routes requests to discovered services by their first path
+// segment. Services are added, updated and removed while the gateway runs;
+// each one gets the same middleware chain as statically configured services.
+type serviceRouter struct {
+	log      logger.Logger
+	proxyLog logger.Logger
+
+	// updateMu serializes updates and reconfiguration
+	updateMu sync.Mutex
+
+	mu       sync.RWMutex
+	cfg      *config.Config
+	services map[string]*discoveredService
+	notFound http.Handler // answers paths of no service, nil for a 404
+
+	// register mounts a service on a router, set by buildHandler
+	register func(router chi.Router, name string, target config.TargetConfig, serviceProxy *proxy.ReverseProxy) error
+}
+
+// newServiceRouter creates an empty router for discovered services
+func newServiceRouter(cfg *config.Config, log logger.Logger) *serviceRouter {
+	return &serviceRouter{
+		cfg:      cfg,
+		log:      logger.ForComponent(log, "server"),
+		proxyLog: logger.ForComponent(log, "proxy"),
+		services: make(map[string]*discoveredService),
+	}
+}
+
+// ServeHTTP dispatches to the discovered service named by the first path segment
+func (s *serviceRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+
+	s.mu.RLock()
+	svc, ok := s.services[name]
+	notFound := s.notFound
+	s.mu.RUnlock()
+
+	if !ok {
+		if notFound != nil {
+			notFound.ServeHTTP(w, r)
+			return
+		}
+		problem.Write(w, r, http.StatusNotFound, "no service is routed at this path")
+		return
+	}
+	svc.handler.ServeHTTP(w, r)
+}
+
+// configure sets the config and route registration of discovered services
+// and the handler of paths matching none, services discovered so far are
+// rebuilt with them
+func (s *serviceRouter) configure(cfg *config.Config, notFound http.Handler, register func(router chi.Router, name string, target config.TargetConfig, serviceProxy *proxy.ReverseProxy) error) {
+	s.updateMu.Lock()
+	defer s.updateMu.Unlock()
+
+	s.mu.Lock()
+	s.cfg = cfg
+	s.notFound = notFound
+	s.register = register
+	targets := make(map[string]config.TargetConfig, len(s.services))
+	for name, svc := range s.services {
+		targets[name] = svc.target
+	}
+	s.mu.Unlock()
+
+	if len(targets) > 0 {
+		s.apply(targets, true)
+	}
+}
+
+// update applies the current set of discovered targets
+func (s *serviceRouter) update(targets map[string]config.TargetConfig) {
+	s.updateMu.Lock()
+	defer s.updateMu.Unlock()
+
+	s.apply(targets, false)
+}
+
+// apply replaces the discovered services, unchanged services are kept
+// unless rebuild is set
+func (s *serviceRouter) apply(targets map[string]config.TargetConfig, rebuild bool) {
+	s.mu.RLock()
+	current, cfg := s.services, s.cfg
+	s.mu.RUnlock()
+
+	next := make(map[string]*discoveredService, len(targets))
+	for name, target := range targets {
+		// statically configured services take precedence
+		if _, static := cfg.Proxy.Targets[name]; static {
+			continue
+		}
+
+		if svc, ok := current[name]; ok && !rebuild && reflect.DeepEqual(svc.target, target) {
+			next[name] = svc
+			continue
+		}
+
+		svc, err := s.build(name, target)
+		if err != nil {
+			s.log.Error("failed to add discovered service", "service", name, "error", err)
+			// keep serving the previous version if there is one
+			if old, ok := current[name]; ok {
+				next[name] = old
+			}
+			continue
+		}
+		next[name] = svc
+		if !rebuild {
+			s.log.Info("discovered service", "service", name, "target", target.URL, "endpoints", len(target.Endpoints)+1)
+		}
+	}
+
+	s.mu.Lock()
+	s.services = next
+	s.mu.Unlock()
+
+	// stop background work of replaced and removed services
+	for name, svc := range current {
+		if next[name] != svc {
+			svc.proxy.Close()
+			if _, ok := next[name]; !ok {
+				s.log.Info("removed discovered service", "service", name)
+			}
+		}
+	}
+}
+
+// build creates the proxy and handler chain of a discovered service
+func (s *serviceRouter) build(name string, target config.TargetConfig) (*discoveredService, error) {
+	s.mu.RLock()
+	cfg, register := s.cfg, s.register
+	s.mu.RUnlock()
+
+	serviceProxy, err := proxy.NewForTarget(&cfg.Proxy, name, target, s.proxyLog)
+	if err != nil {
+		return nil, err
+	}
+
+	router := chi.NewRouter()
+	if err := register(router, name, target, serviceProxy); err != nil {
+		serviceProxy.Close()
+		return nil, err
+	}
+
+	return &discoveredService{
+		target:  target,
+		proxy:   serviceProxy,
+		handler: router,
+	}, nil
+}
+
+// close stops background work of all discovered services
+func (s *serviceRouter) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, svc := range s.services {
+		svc.proxy.Close()
+	}
+}
+
+// newDiscoverySource creates the configured discovery source
+func newDiscoverySource(cfg *config.DiscoveryConfig, log logger.Logger) (discovery.Source, error) {
+	switch cfg.Mode {
+	case "kubernetes":
+		return discovery.NewKubernetes(&cfg.Kubernetes, log)
+	case "consul":
+		return discovery.NewConsul(&cfg.Consul, log)
+	default:
+		return nil, fmt.Errorf("unknown discovery mode %q", cfg.Mode)
+	}
+}
+
+// runDiscovery feeds discovered targets into the service router until ctx is done
+func runDiscovery(ctx context.Context, source discovery.Source, services *serviceRouter, log logger.Logger) {
+	if err := source.Run(ctx, services.update); err != nil {
+		log.Error("service discovery stopped", "error", err)
+	}
+}
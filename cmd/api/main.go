@@ -2,20 +2,34 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"github.com/gateway/template/internal/cache"
 	"github.com/gateway/template/internal/config"
+	"github.com/gateway/template/internal/costreport"
+	"github.com/gateway/template/internal/discovery"
+	"github.com/gateway/template/internal/errreport"
+	"github.com/gateway/template/internal/idempotency"
+	"github.com/gateway/template/internal/metrics"
 	"github.com/gateway/template/internal/middleware"
+	"github.com/gateway/template/internal/problem"
 	"github.com/gateway/template/internal/proxy"
+	"github.com/gateway/template/internal/quota"
+	"github.com/gateway/template/pkg/auth"
 	"github.com/gateway/template/pkg/logger"
-	"github.com/go-chi/chi/v5"
 )
 
+// version is the gateway release version
+const version = "1.0.0"
+
 func main() {
 	if err := run(); err != nil {
 		fmt.Fprintf(os.Stderr, "error: %v\n", err)
@@ -30,12 +44,59 @@ func run() error {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
 
+	// ship logs to central pipelines in addition to stdout
+	sinks := newLogSinks(&cfg.Log.Sink)
+	defer func() {
+		for _, sink := range sinks {
+			if err := sink.Close(); err != nil {
+				fmt.Fprintf(os.Stderr, "failed to close log sink: %v\n", err)
+			}
+		}
+	}()
+
 	// initialize logger
 	logCfg := &logger.Config{
-		Level:         cfg.Log.Level,
-		ComponentName: cfg.Log.ComponentName,
-		EnableStdout:  true,
-		Development:   cfg.Log.Level == "debug",
+		Level:           cfg.Log.Level,
+		ComponentLevels: cfg.Log.ComponentLevels,
+		ComponentName:   cfg.Log.ComponentName,
+		EnableStdout:    cfg.Log.Stdout,
+		Development:     cfg.Log.Level == "debug",
+	}
+	for _, sink := range sinks {
+		logCfg.Sinks = append(logCfg.Sinks, sink)
+	}
+
+	if cfg.Log.File.Path != "" {
+		fileWriter, err := logger.NewFileWriter(&logger.FileConfig{
+			Path:       cfg.Log.File.Path,
+			MaxSizeMB:  cfg.Log.File.MaxSizeMB,
+			MaxAgeDays: cfg.Log.File.MaxAgeDays,
+			MaxBackups: cfg.Log.File.MaxBackups,
+			Compress:   cfg.Log.File.Compress,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to initialize log file: %w", err)
+		}
+		defer fileWriter.Close()
+		logCfg.File = fileWriter
+	}
+
+	if cfg.Log.Syslog.Enabled {
+		tag := cfg.Log.Syslog.Tag
+		if tag == "" {
+			tag = cfg.Log.ComponentName
+		}
+		syslogWriter, err := logger.NewSyslogWriter(&logger.SyslogConfig{
+			Network:  cfg.Log.Syslog.Network,
+			Address:  cfg.Log.Syslog.Address,
+			Facility: cfg.Log.Syslog.Facility,
+			Tag:      tag,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to initialize syslog output: %w", err)
+		}
+		defer syslogWriter.Close()
+		logCfg.Syslog = syslogWriter
 	}
 
 	log, err := logger.NewZapLogger(logCfg)
@@ -49,39 +110,196 @@ func run() error {
 		}
 	}()
 
-	log.Info("api gateway started",
-		"version", "1.0.0",
+	// server lifecycle logs use the "server" component level
+	serverLog := log.ForComponent("server")
+
+	serverLog.Info("api gateway started",
+		"version", version,
 		"host", cfg.Server.Host,
 		"port", cfg.Server.Port,
 		"services", getServiceNames(cfg),
 	)
 
+	// report proxy errors, panics and 5xx bursts to Sentry
+	if cfg.Errors.SentryDSN != "" {
+		sink, err := errreport.NewSentrySink(&errreport.SentryConfig{
+			DSN:         cfg.Errors.SentryDSN,
+			Environment: cfg.Errors.SentryEnvironment,
+			Release:     "api-gateway@" + version,
+			SampleRate:  cfg.Errors.SentrySampleRate,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to initialize error reporting: %w", err)
+		}
+		errreport.SetDefault(sink)
+		defer sink.Flush(5 * time.Second)
+
+		serverLog.Info("error reporting enabled", "environment", cfg.Errors.SentryEnvironment)
+	}
+
+	// reject revoked tokens in every JWT check
+	if cfg.JWT.RevocationStore != "" {
+		store, err := newRevocationStore(&cfg.JWT)
+		if err != nil {
+			return fmt.Errorf("failed to initialize token revocation: %w", err)
+		}
+		auth.SetDefaultRevocationStore(store)
+		if closer, ok := store.(io.Closer); ok {
+			defer closer.Close()
+		}
+
+		serverLog.Info("token revocation enabled", "store", cfg.JWT.RevocationStore)
+	}
+
+	// responses replayed for retries with the same Idempotency-Key
+	idempotencyStore, err := newIdempotencyStore(&cfg.Idempotency)
+	if err != nil {
+		return fmt.Errorf("failed to initialize idempotency store: %w", err)
+	}
+	idempotency.SetDefaultStore(idempotencyStore)
+	if closer, ok := idempotencyStore.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	// responses of cached paths, kept across config reloads
+	cache.SetDefault(cache.New(cfg.Cache.MaxSize))
+
+	// request counters of daily and monthly quotas
+	if cfg.RateLimit.HasQuotas() {
+		quotaStore, err := newQuotaStore(&cfg.RateLimit)
+		if err != nil {
+			return fmt.Errorf("failed to initialize quota store: %w", err)
+		}
+		quota.SetDefaultStore(quotaStore)
+		if closer, ok := quotaStore.(io.Closer); ok {
+			defer closer.Close()
+		}
+
+		serverLog.Info("quotas enabled", "store", cfg.RateLimit.Store)
+	}
+
+	// custom bodies of errors the gateway answers itself
+	if len(cfg.ErrorPages) > 0 {
+		pages, err := problem.ParseTemplates(cfg.ErrorPages)
+		if err != nil {
+			return fmt.Errorf("failed to load error pages: %w", err)
+		}
+		problem.SetTemplates(pages)
+	}
+
 	// create proxy factory for multiple backends
-	proxyFactory, err := proxy.NewFactory(&cfg.Proxy, log)
+	proxyFactory, err := proxy.NewFactory(&cfg.Proxy, log.ForComponent("proxy"))
 	if err != nil {
 		return fmt.Errorf("failed to create proxy factory: %w", err)
 	}
 
-	// create router with middleware
-	router := buildHandler(proxyFactory, cfg, log)
+	// route to services found by service discovery
+	var discovered *serviceRouter
+	var source discovery.Source
+	if cfg.Discovery.Mode != "" {
+		source, err = newDiscoverySource(&cfg.Discovery, log.ForComponent("discovery"))
+		if err != nil {
+			return fmt.Errorf("failed to create service discovery: %w", err)
+		}
+		discovered = newServiceRouter(cfg, log)
+		defer discovered.close()
+	}
+
+	// create router with middleware, its state outlives config reloads
+	state := middleware.NewState()
+	router, err := buildHandler(proxyFactory, cfg, log, discovered, state)
+	if err != nil {
+		return fmt.Errorf("failed to build handler: %w", err)
+	}
+	state.Sweep()
+
+	// apply config changes published to the KV store, the reloader owns
+	// the proxy factory from here on
+	handler := newReloadableHandler(router)
+	reloadCtx, stopReload := context.WithCancel(context.Background())
+	defer stopReload()
+	if cfg.KV.URL != "" {
+		store, err := config.NewKVStore(cfg.KV)
+		if err != nil {
+			return fmt.Errorf("failed to create config store: %w", err)
+		}
+		reloader := &configReloader{
+			store:      store,
+			handler:    handler,
+			discovered: discovered,
+			state:      state,
+			log:        log,
+			serverLog:  serverLog,
+			cfg:        cfg,
+			factory:    proxyFactory,
+		}
+		go reloader.run(reloadCtx)
+		serverLog.Info("watching config for changes", "source", cfg.KV.URL)
+	} else {
+		defer proxyFactory.Close()
+	}
+
+	discoveryCtx, stopDiscovery := context.WithCancel(context.Background())
+	defer stopDiscovery()
+	if source != nil {
+		go runDiscovery(discoveryCtx, source, discovered, serverLog)
+		serverLog.Info("service discovery enabled", "mode", cfg.Discovery.Mode)
+	}
+
+	// export cost attribution reports periodically
+	exportCtx, stopExport := context.WithCancel(context.Background())
+	defer stopExport()
+	if cfg.Cost.ExportDir != "" {
+		go costreport.RunExporter(exportCtx, costreport.Default, cfg.Cost.ExportDir, cfg.Cost.ExportInterval, serverLog)
+		serverLog.Info("cost report export enabled", "dir", cfg.Cost.ExportDir, "interval", cfg.Cost.ExportInterval.String())
+	}
 
 	// create HTTP server
 	addr := fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port)
 	server := &http.Server{
 		Addr:         addr,
-		Handler:      router,
+		Handler:      handler,
 		ReadTimeout:  cfg.Server.ReadTimeout,
 		WriteTimeout: cfg.Server.WriteTimeout,
 		IdleTimeout:  cfg.Server.IdleTimeout,
 	}
+	if server.TLSConfig, err = serverTLSConfig(&cfg.Server.TLS); err != nil {
+		return err
+	}
 
 	// start server in a goroutine
-	serverErrors := make(chan error, 1)
+	serverErrors := make(chan error, 2)
 	go func() {
-		log.Info("server listening", "addr", addr)
+		if cfg.Server.TLS.CertFile != "" {
+			serverLog.Info("server listening", "addr", addr, "tls", true, "client_certs", cfg.Server.TLS.ClientCAFile != "")
+			serverErrors <- server.ListenAndServeTLS(cfg.Server.TLS.CertFile, cfg.Server.TLS.KeyFile)
+			return
+		}
+		serverLog.Info("server listening", "addr", addr)
 		serverErrors <- server.ListenAndServe()
 	}()
 
+	// serve metrics on a listener of their own, kept off the public port
+	var metricsServer *http.Server
+	if cfg.Metrics.Enabled && cfg.Metrics.Port != 0 {
+		mux := http.NewServeMux()
+		mux.Handle(cfg.Metrics.Path, metrics.Default.Handler())
+		metricsAddr := fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Metrics.Port)
+		metricsServer = &http.Server{
+			Addr:         metricsAddr,
+			Handler:      mux,
+			ReadTimeout:  cfg.Server.ReadTimeout,
+			WriteTimeout: cfg.Server.WriteTimeout,
+			IdleTimeout:  cfg.Server.IdleTimeout,
+		}
+		go func() {
+			serverLog.Info("metrics listening", "addr", metricsAddr, "path", cfg.Metrics.Path)
+			if err := metricsServer.ListenAndServe(); err != http.ErrServerClosed {
+				serverErrors <- fmt.Errorf("metrics: %w", err)
+			}
+		}()
+	}
+
 	// wait for interrupt signal or server error
 	shutdown := make(chan os.Signal, 1)
 	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
@@ -90,95 +308,37 @@ func run() error {
 	case err := <-serverErrors:
 		return fmt.Errorf("server error: %w", err)
 	case sig := <-shutdown:
-		log.Info("received shutdown signal", "signal", sig.String())
+		serverLog.Info("received shutdown signal", "signal", sig.String())
 
 		// graceful shutdown with timeout
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
 
 		if err := server.Shutdown(ctx); err != nil {
-			log.Error("failed to gracefully shutdown server", "error", err)
+			serverLog.Error("failed to gracefully shutdown server", "error", err)
 			if err := server.Close(); err != nil {
 				return fmt.Errorf("failed to close server: %w", err)
 			}
 		}
 
-		log.Info("server stopped gracefully")
+		if metricsServer != nil {
+			metricsServer.Close()
+		}
+
+		serverLog.Info("server stopped gracefully")
+
+		// flush the partial reporting period so no traffic goes unattributed
+		if cfg.Cost.ExportDir != "" {
+			stopExport()
+			if _, err := costreport.Export(costreport.Default.Rotate(), cfg.Cost.ExportDir); err != nil {
+				serverLog.Error("failed to export final cost report", "error", err)
+			}
+		}
 	}
 
 	return nil
 }
 
-// buildHandler creates the main HTTP handler with routing and middleware.
-func buildHandler(proxyFactory *proxy.Factory, cfg *config.Config, log logger.Logger) http.Handler {
-	router := chi.NewRouter()
-
-	// global middleware (applies to all routes)
-	router.Use(middleware.Logging(log))
-	router.Use(middleware.CORS(&cfg.CORS))
-
-	// health check endpoint (no authentication required)
-	router.Get("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("OK"))
-	})
-
-	// route requests to different backend services
-	for _, serviceName := range proxyFactory.Services() {
-		serviceProxy, ok := proxyFactory.Get(serviceName)
-		if !ok {
-			continue
-		}
-
-		if serviceName == "default" {
-			// legacy single backend: route everything to default with auth
-			// TODO: Replace with your corporate authentication middleware from common package:
-			// router.Use(common.JWTAuthMiddleware())
-			router.Group(func(r chi.Router) {
-				r.Use(middleware.Auth(&cfg.JWT, log))
-				r.Handle("/*", serviceProxy)
-			})
-
-			log.Info("registered route", "pattern", "/*", "service", serviceName)
-		} else {
-			// multi-backend: route by service prefix with auth
-			// TODO: Replace with your corporate authentication middleware from common package:
-			//
-			// Example corporate middleware usage:
-			// import "yourcompany.com/common/auth"
-			// router.Route("/"+serviceName, func(r chi.Router) {
-			//     r.Use(auth.NewJWTMiddleware(auth.Config{
-			//         SecretKey: cfg.JWT.Secret,
-			//         Issuer:    cfg.JWT.Issuer,
-			//         Audience:  cfg.JWT.Audience,
-			//     }))
-			//     r.Handle("/*", serviceProxy)
-			// })
-
-			router.Route("/"+serviceName, func(r chi.Router) {
-				// skip auth in test mode
-				if os.Getenv("SKIP_AUTH") != "true" {
-					r.Use(middleware.Auth(&cfg.JWT, log))
-				}
-
-				// strip service prefix before forwarding to backend
-				r.Handle("/*", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-					// remove service prefix from path
-					req.URL.Path = chi.URLParam(req, "*")
-					if req.URL.Path == "" {
-						req.URL.Path = "/"
-					}
-					serviceProxy.ServeHTTP(w, req)
-				}))
-			})
-
-			log.Info("registered route", "pattern", "/"+serviceName+"/*", "service", serviceName)
-		}
-	}
-
-	return router
-}
-
 // getServiceNames extracts service names from proxy configuration.
 func getServiceNames(cfg *config.Config) []string {
 	services := make([]string, 0, len(cfg.Proxy.Targets))
@@ -187,3 +347,70 @@ func getServiceNames(cfg *config.Config) []string {
 	}
 	return services
 }
+
+// newLogSinks creates async sinks for the configured log collectors.
+func newLogSinks(cfg *config.LogSinkConfig) []*logger.AsyncSink {
+	sinkCfg := logger.AsyncSinkConfig{
+		BufferSize:    cfg.BufferSize,
+		BatchSize:     cfg.BatchSize,
+		FlushInterval: cfg.FlushInterval,
+		BlockOnFull:   cfg.BlockOnFull,
+	}
+
+	var sinks []*logger.AsyncSink
+	if cfg.HTTPURL != "" {
+		sinks = append(sinks, logger.NewAsyncSink(logger.NewHTTPBatchWriter(cfg.HTTPURL, nil), sinkCfg))
+	}
+	if len(cfg.KafkaBrokers) > 0 {
+		sinks = append(sinks, logger.NewAsyncSink(logger.NewKafkaBatchWriter(cfg.KafkaBrokers, cfg.KafkaTopic), sinkCfg))
+	}
+	return sinks
+}
+
+// serverTLSConfig returns the TLS settings of the server, nil to serve plain
+// HTTP. Client certificates are requested and verified against the client
+// CAs but not required, services with mtls auth reject requests without one.
+func serverTLSConfig(cfg *config.TLSConfig) (*tls.Config, error) {
+	if cfg.CertFile == "" {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in client CA file %s", cfg.ClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return tlsConfig, nil
+}
+
+// newQuotaStore creates the quota store selected in cfg
+func newQuotaStore(cfg *config.RateLimitConfig) (quota.Store, error) {
+	switch cfg.Store {
+	case "", "memory":
+		return quota.NewMemoryStore(), nil
+	case "redis":
+		return quota.NewRedisStore(cfg.RedisURL)
+	default:
+		return nil, fmt.Errorf("unknown quota store %q", cfg.Store)
+	}
+}
+
+// newIdempotencyStore creates the idempotency store selected in cfg
+func newIdempotencyStore(cfg *config.IdempotencyConfig) (idempotency.Store, error) {
+	switch cfg.Store {
+	case "", "memory":
+		return idempotency.NewMemoryStore(), nil
+	case "redis":
+		return idempotency.NewRedisStore(cfg.RedisURL)
+	default:
+		return nil, fmt.Errorf("unknown idempotency store %q", cfg.Store)
+	}
+}
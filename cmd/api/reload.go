@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gateway/template/internal/config"
+	"github.com/gateway/template/internal/middleware"
+	"github.com/gateway/template/internal/problem"
+	"github.com/gateway/template/internal/proxy"
+	"github.com/gateway/template/pkg/logger"
+)
+
+// reloadableHandler serves the most recently built handler, requests in
+// flight finish on the handler they started on
+type reloadableHandler struct {
+	current atomic.Pointer[handlerGeneration]
+}
+
+// handlerGeneration is a handler and the requests it serves
+type handlerGeneration struct {
+	handler  http.Handler
+	inFlight atomic.Int64
+	retired  atomic.Bool
+	drained  chan struct{} // closed when retired and no request is in flight
+	once     sync.Once
+}
+
+func newReloadableHandler(h http.Handler) *reloadableHandler {
+	rh := &reloadableHandler{}
+	rh.current.Store(newHandlerGeneration(h))
+	return rh
+}
+
+func newHandlerGeneration(h http.Handler) *handlerGeneration {
+	return &handlerGeneration{handler: h, drained: make(chan struct{})}
+}
+
+// ServeHTTP implements http.Handler
+func (rh *reloadableHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for {
+		g := rh.current.Load()
+		g.inFlight.Add(1)
+		// a handler replaced before the request was counted may be drained
+		// already, the request goes to the new one
+		if rh.current.Load() != g {
+			g.release()
+			continue
+		}
+		defer g.release()
+		g.handler.ServeHTTP(w, r)
+		return
+	}
+}
+
+// store replaces the handler for new requests. The returned channel is
+// closed once the previous handler has finished its requests in flight.
+func (rh *reloadableHandler) store(h http.Handler) <-chan struct{} {
+	previous := rh.current.Swap(newHandlerGeneration(h))
+	previous.retired.Store(true)
+	if previous.inFlight.Load() == 0 {
+		previous.drain()
+	}
+	return previous.drained
+}
+
+// release ends a request of the generation
+func (g *handlerGeneration) release() {
+	if g.inFlight.Add(-1) == 0 && g.retired.Load() {
+		g.drain()
+	}
+}
+
+func (g *handlerGeneration) drain() {
+	g.once.Do(func() { close(g.drained) })
+}
+
+// configReloader applies configs published to a KV store while the gateway
+// runs, rebuilding proxies and routes while middleware state such as rate
+// limits carries over. Settings used only at startup (server, log outputs,
+// discovery, Sentry, cost report export, the stores of revoked tokens,
+// idempotent responses and quotas, the cache size and the metrics listener)
+// need a restart.
+type configReloader struct {
+	store      config.KVStore
+	handler    *reloadableHandler
+	discovered *serviceRouter
+	state      *middleware.State
+	log        logger.Logger
+	serverLog  logger.Logger
+
+	// only used by the run goroutine
+	cfg     *config.Config
+	factory *proxy.Factory
+}
+
+// run watches the KV store until ctx is done, then stops background work
+// of the current proxies
+func (c *configReloader) run(ctx context.Context) {
+	defer func() { c.factory.Close() }()
+
+	// the config may have changed since it was loaded at startup
+	c.resync(ctx)
+
+	for {
+		err := c.store.Watch(ctx, c.apply)
+		if ctx.Err() != nil {
+			return
+		}
+		c.serverLog.Warn("config watch failed, retrying", "source", c.cfg.KV.URL, "error", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+		c.resync(ctx)
+	}
+}
+
+// resync reads and applies the current config, changes may have been missed
+// while the watch was down
+func (c *configReloader) resync(ctx context.Context) {
+	getCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	data, err := c.store.Get(getCtx)
+	if err != nil {
+		if ctx.Err() == nil {
+			c.serverLog.Warn("failed to read config", "source", c.cfg.KV.URL, "error", err)
+		}
+		return
+	}
+	c.apply(data)
+}
+
+// apply validates a new config and switches to it, invalid configs are
+// rejected and the current config stays in effect
+func (c *configReloader) apply(data []byte) {
+	cfg, err := config.Parse(data, c.cfg.KV.URL)
+	if err == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		err = config.ResolveSecrets(ctx, cfg)
+		cancel()
+	}
+	if err != nil {
+		c.serverLog.Error("rejected config update", "source", c.cfg.KV.URL, "error", err)
+		return
+	}
+	cfg.KV = c.cfg.KV
+
+	if reflect.DeepEqual(cfg, c.cfg) {
+		return
+	}
+
+	// error pages are read before anything is switched, a missing file
+	// rejects the update
+	pagesChanged := !reflect.DeepEqual(cfg.ErrorPages, c.cfg.ErrorPages)
+	var pages map[int]*problem.Template
+	if pagesChanged && len(cfg.ErrorPages) > 0 {
+		pages, err = problem.ParseTemplates(cfg.ErrorPages)
+		if err != nil {
+			c.serverLog.Error("rejected config update", "source", c.cfg.KV.URL, "error", err)
+			return
+		}
+	}
+
+	factory, err := proxy.NewFactory(&cfg.Proxy, logger.ForComponent(c.log, "proxy"))
+	if err != nil {
+		c.serverLog.Error("rejected config update", "source", c.cfg.KV.URL, "error", err)
+		return
+	}
+
+	handler, err := buildHandler(factory, cfg, c.log, c.discovered, c.state)
+	if err != nil {
+		factory.Close()
+		c.serverLog.Error("rejected config update", "source", c.cfg.KV.URL, "error", err)
+		return
+	}
+
+	drained := c.handler.store(handler)
+	if pagesChanged {
+		problem.SetTemplates(pages)
+	}
+	c.state.Sweep()
+	go c.closeWhenDrained(c.factory, drained, drainTimeout(c.cfg))
+	c.applyLogLevels(cfg)
+
+	for _, section := range restartRequired(c.cfg, cfg) {
+		c.serverLog.Warn("config change requires a restart", "section", section)
+	}
+
+	c.cfg, c.factory = cfg, factory
+	c.serverLog.Info("applied config update", "source", cfg.KV.URL, "services", getServiceNames(cfg))
+}
+
+// closeWhenDrained closes the proxies of a replaced handler once its
+// requests in flight have finished, or after timeout for long-lived ones
+// such as WebSockets and event streams
+func (c *configReloader) closeWhenDrained(factory *proxy.Factory, drained <-chan struct{}, timeout time.Duration) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-drained:
+	case <-timer.C:
+		c.serverLog.Warn("closing proxies of the previous config with requests in flight", "waited", timeout.String())
+	}
+	factory.Close()
+}
+
+// drainTimeout returns how long requests of a replaced handler are waited
+// for, the longest a response may take to write
+func drainTimeout(cfg *config.Config) time.Duration {
+	timeout := max(cfg.Server.WriteTimeout, cfg.Proxy.RequestTimeout, cfg.Proxy.Timeout)
+	if timeout <= 0 {
+		return time.Minute
+	}
+	return timeout
+}
+
+// applyLogLevels changes the root and component log levels if they changed
+func (c *configReloader) applyLogLevels(cfg *config.Config) {
+	if lc, ok := c.log.(levelController); ok && cfg.Log.Level != c.cfg.Log.Level {
+		if err := lc.SetLevel(cfg.Log.Level); err != nil {
+			c.serverLog.Error("failed to change log level", "level", cfg.Log.Level, "error", err)
+		}
+	}
+
+	clc, ok := c.log.(componentLevelController)
+	if !ok || reflect.DeepEqual(cfg.Log.ComponentLevels, c.cfg.Log.ComponentLevels) {
+		return
+	}
+	// components no longer listed follow the root level again
+	for component := range c.cfg.Log.ComponentLevels {
+		if _, ok := cfg.Log.ComponentLevels[component]; !ok {
+			_ = clc.SetComponentLevel(component, "")
+		}
+	}
+	for component, level := range cfg.Log.ComponentLevels {
+		if err := clc.SetComponentLevel(component, level); err != nil {
+			c.serverLog.Error("failed to change log level", "component", component, "level", level, "error", err)
+		}
+	}
+}
+
+// restartRequired returns the changed config sections that are only read at startup
+func restartRequired(old, cfg *config.Config) []string {
+	var sections []string
+	if !reflect.DeepEqual(old.Server, cfg.Server) {
+		sections = append(sections, "server")
+	}
+	// log levels and the access log are applied without a restart
+	if old.Log.ComponentName != cfg.Log.ComponentName || old.Log.Stdout != cfg.Log.Stdout ||
+		!reflect.DeepEqual(old.Log.File, cfg.Log.File) || !reflect.DeepEqual(old.Log.Sink, cfg.Log.Sink) ||
+		!reflect.DeepEqual(old.Log.Syslog, cfg.Log.Syslog) {
+		sections = append(sections, "log")
+	}
+	if !reflect.DeepEqual(old.Discovery, cfg.Discovery) {
+		sections = append(sections, "discovery")
+	}
+	// burst detection is rebuilt with the handler
+	if old.Errors.SentryDSN != cfg.Errors.SentryDSN || old.Errors.SentryEnvironment != cfg.Errors.SentryEnvironment ||
+		old.Errors.SentrySampleRate != cfg.Errors.SentrySampleRate {
+		sections = append(sections, "error_reporting")
+	}
+	if !reflect.DeepEqual(old.Cost, cfg.Cost) {
+		sections = append(sections, "cost_report")
+	}
+	if old.JWT.RevocationStore != cfg.JWT.RevocationStore || old.JWT.RedisURL != cfg.JWT.RedisURL {
+		sections = append(sections, "jwt.revocation_store")
+	}
+	if old.Idempotency.Store != cfg.Idempotency.Store || old.Idempotency.RedisURL != cfg.Idempotency.RedisURL {
+		sections = append(sections, "idempotency.store")
+	}
+	// the quota store is only created when quotas are set at startup
+	if old.RateLimit.HasQuotas() != cfg.RateLimit.HasQuotas() ||
+		old.RateLimit.Store != cfg.RateLimit.Store || old.RateLimit.RedisURL != cfg.RateLimit.RedisURL {
+		sections = append(sections, "rate_limit.store")
+	}
+	if old.Cache.MaxSize != cfg.Cache.MaxSize {
+		sections = append(sections, "cache.max_size")
+	}
+	// metrics served by the gateway's listener follow the routes, a
+	// listener of their own is only started at startup
+	if metricsPort(old) != metricsPort(cfg) {
+		sections = append(sections, "metrics")
+	}
+	return sections
+}
+
+// metricsPort returns the port of the metrics listener, 0 without one
+func metricsPort(cfg *config.Config) int {
+	if !cfg.Metrics.Enabled {
+		return 0
+	}
+	return cfg.Metrics.Port
+}
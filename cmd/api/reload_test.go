@@ -0,0 +1,183 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gateway/template/internal/config"
+	"github.com/gateway/template/internal/middleware"
+	"github.com/gateway/template/internal/problem"
+	"github.com/gateway/template/internal/proxy"
+	"github.com/gateway/template/pkg/logger"
+)
+
+func TestReloadableHandlerDrainsPreviousHandler(t *testing.T) {
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-finish
+		w.WriteHeader(http.StatusAccepted)
+	})
+	rh := newReloadableHandler(slow)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	rec := httptest.NewRecorder()
+	go func() {
+		defer wg.Done()
+		rh.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	}()
+	<-started
+
+	drained := rh.store(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	// new requests go to the new handler while the old one is busy
+	next := httptest.NewRecorder()
+	rh.ServeHTTP(next, httptest.NewRequest(http.MethodGet, "/", nil))
+	if next.Code != http.StatusOK {
+		t.Errorf("expected the new handler to answer with 200, got %d", next.Code)
+	}
+	select {
+	case <-drained:
+		t.Fatal("previous handler drained with a request in flight")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(finish)
+	wg.Wait()
+	select {
+	case <-drained:
+	case <-time.After(time.Second):
+		t.Fatal("previous handler not drained after its request finished")
+	}
+	if rec.Code != http.StatusAccepted {
+		t.Errorf("expected the request in flight to finish on the previous handler, got %d", rec.Code)
+	}
+}
+
+func TestReloadableHandlerDrainsIdleHandler(t *testing.T) {
+	rh := newReloadableHandler(http.NotFoundHandler())
+	select {
+	case <-rh.store(http.NotFoundHandler()):
+	default:
+		t.Error("expected an idle handler to be drained right away")
+	}
+}
+
+func TestConfigReloaderAppliesErrorPages(t *testing.T) {
+	page := filepath.Join(t.TempDir(), "401.txt")
+	if err := os.WriteFile(page, []byte("sign in to use {{.Service}}"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { problem.SetTemplates(nil) })
+
+	t.Setenv("JWT_SECRET", "test-secret")
+	t.Setenv("CRM_SERVICE_URL", "http://127.0.0.1:1")
+	cfg, err := config.LoadEnv()
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	log := logger.NewMockLogger()
+	factory, err := proxy.NewFactory(&cfg.Proxy, log)
+	if err != nil {
+		t.Fatalf("failed to create proxy factory: %v", err)
+	}
+	state := middleware.NewState()
+	handler, err := buildHandler(factory, cfg, log, nil, state)
+	if err != nil {
+		t.Fatalf("failed to build handler: %v", err)
+	}
+	reloader := &configReloader{
+		handler:   newReloadableHandler(handler),
+		state:     state,
+		log:       log,
+		serverLog: log,
+		cfg:       cfg,
+		factory:   factory,
+	}
+	t.Cleanup(func() { reloader.factory.Close() })
+
+	body := func() string {
+		rec := httptest.NewRecorder()
+		reloader.handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/crm/contacts", nil))
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("expected 401, got %d", rec.Code)
+		}
+		return rec.Body.String()
+	}
+
+	reloader.apply([]byte("error_pages:\n  401: " + page + "\n"))
+	if got := body(); got != "sign in to use crm" {
+		t.Errorf("expected the error page of the new config, got %q", got)
+	}
+
+	// a missing error page rejects the update and keeps the current pages
+	reloader.apply([]byte("error_pages:\n  401: " + page + ".missing\n"))
+	if got := body(); got != "sign in to use crm" {
+		t.Errorf("expected the error page to be kept, got %q", got)
+	}
+
+	reloader.apply([]byte("{}"))
+	if got := body(); strings.Contains(got, "sign in") {
+		t.Errorf("expected problem details once the error pages are removed, got %q", got)
+	}
+}
+
+func TestRestartRequired(t *testing.T) {
+	base := func() *config.Config {
+		return &config.Config{
+			Server:  config.ServerConfig{Port: 8080},
+			Log:     config.LogConfig{Stdout: true},
+			Metrics: config.MetricsConfig{Enabled: true, Port: 9090},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		change  func(cfg *config.Config)
+		section string
+	}{
+		{"server", func(cfg *config.Config) { cfg.Server.Port = 9000 }, "server"},
+		{"log output", func(cfg *config.Config) { cfg.Log.Stdout = false }, "log"},
+		{"revocation store", func(cfg *config.Config) { cfg.JWT.RevocationStore = "redis" }, "jwt.revocation_store"},
+		{"revocation redis url", func(cfg *config.Config) { cfg.JWT.RedisURL = "redis://other:6379" }, "jwt.revocation_store"},
+		{"idempotency store", func(cfg *config.Config) { cfg.Idempotency.Store = "redis" }, "idempotency.store"},
+		{"idempotency redis url", func(cfg *config.Config) { cfg.Idempotency.RedisURL = "redis://other:6379" }, "idempotency.store"},
+		{"quota store", func(cfg *config.Config) { cfg.RateLimit.Store = "redis" }, "rate_limit.store"},
+		{"quota redis url", func(cfg *config.Config) { cfg.RateLimit.RedisURL = "redis://other:6379" }, "rate_limit.store"},
+		{"quotas added", func(cfg *config.Config) { cfg.RateLimit.Daily = 1000 }, "rate_limit.store"},
+		{"cache size", func(cfg *config.Config) { cfg.Cache.MaxSize = 1 << 20 }, "cache.max_size"},
+		{"metrics disabled", func(cfg *config.Config) { cfg.Metrics.Enabled = false }, "metrics"},
+		{"metrics port", func(cfg *config.Config) { cfg.Metrics.Port = 9191 }, "metrics"},
+		{"metrics on the gateway port", func(cfg *config.Config) { cfg.Metrics.Port = 0 }, "metrics"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := base()
+			tt.change(cfg)
+			if got := restartRequired(base(), cfg); !slices.Equal(got, []string{tt.section}) {
+				t.Errorf("expected sections [%s], got %v", tt.section, got)
+			}
+		})
+	}
+
+	t.Run("metrics on the gateway port enabled", func(t *testing.T) {
+		old, cfg := base(), base()
+		old.Metrics = config.MetricsConfig{}
+		cfg.Metrics = config.MetricsConfig{Enabled: true}
+		if got := restartRequired(old, cfg); len(got) != 0 {
+			t.Errorf("expected metrics on the gateway's port to need no restart, got %v", got)
+		}
+	})
+}
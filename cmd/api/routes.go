@@ -0,0 +1,458 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gateway/template/internal/aggregate"
+	"github.com/gateway/template/internal/cache"
+	"github.com/gateway/template/internal/config"
+	"github.com/gateway/template/internal/costreport"
+	"github.com/gateway/template/internal/errreport"
+	"github.com/gateway/template/internal/idempotency"
+	"github.com/gateway/template/internal/metrics"
+	"github.com/gateway/template/internal/middleware"
+	"github.com/gateway/template/internal/problem"
+	"github.com/gateway/template/internal/proxy"
+	"github.com/gateway/template/internal/quota"
+	"github.com/gateway/template/internal/static"
+	"github.com/gateway/template/pkg/auth"
+	"github.com/gateway/template/pkg/logger"
+	"github.com/go-chi/chi/v5"
+)
+
+// buildHandler creates the main HTTP handler with routing and middleware.
+// Discovered services are routed through discovered when it is not nil,
+// it is reconfigured to use cfg. Middleware continues with the rate limits,
+// concurrency queues and other state kept in state by previous handlers.
+func buildHandler(proxyFactory *proxy.Factory, cfg *config.Config, log logger.Logger, discovered *serviceRouter, state *middleware.State) (http.Handler, error) {
+	router := chi.NewRouter()
+
+	mwLog := logger.ForComponent(log, "middleware")
+	authLog := logger.ForComponent(log, "auth")
+
+	// text access logs share the logger's destinations when it exposes them
+	var accessOutput io.Writer = os.Stdout
+	if o, ok := log.(interface{ Output() io.Writer }); ok {
+		accessOutput = o.Output()
+	}
+
+	accessLog, err := middleware.Logging(mwLog, &cfg.Log.Access, accessOutput)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create access log: %w", err)
+	}
+
+	trustedProxies, err := middleware.ParseTrustedProxies(cfg.Server.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
+	// global middleware (applies to all routes)
+	router.Use(middleware.ClientIP(trustedProxies))
+	router.Use(middleware.RequestID())
+	router.Use(middleware.RequestLogger(log))
+	router.Use(accessLog)
+	router.Use(middleware.ReportErrors(errreport.NewBurstDetector(cfg.Errors.BurstThreshold, cfg.Errors.BurstWindow), mwLog))
+	router.Use(middleware.NormalizePaths(&cfg.Request, mwLog))
+	router.Use(middleware.Redirects(cfg.Redirects))
+	router.Use(middleware.CORS(&cfg.CORS))
+	router.Use(middleware.Decompress(&cfg.Request, mwLog))
+
+	// health check endpoint (no authentication required)
+	router.Get("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+	})
+
+	// metrics endpoint in Prometheus text format (no authentication required),
+	// on the gateway's port unless it has a listener of its own
+	if cfg.Metrics.Enabled && cfg.Metrics.Port == 0 {
+		router.Handle(cfg.Metrics.Path, metrics.Default.Handler())
+	}
+
+	// token debugging for development (no authentication required, the
+	// token under test is the input)
+	if cfg.JWT.DebugEndpoint {
+		debug, err := tokenDebug(&cfg.JWT)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create token debug endpoint: %w", err)
+		}
+		router.Post("/auth/debug", debug)
+		logger.ForComponent(log, "server").Warn("token debug endpoint enabled, never enable it in production", "path", "/auth/debug")
+	}
+
+	// exchange valid or recently expired tokens for new ones
+	if cfg.JWT.RefreshEndpoint {
+		refresh, err := tokenRefresh(&cfg.JWT, authLog)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create token refresh endpoint: %w", err)
+		}
+		router.Post("/auth/refresh", refresh)
+	}
+
+	// exchange bearer tokens for encrypted session cookies for browser apps
+	if cfg.JWT.SessionCookie.Enabled {
+		login, err := sessionLogin(&cfg.JWT, authLog)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create session endpoint: %w", err)
+		}
+		router.Post("/auth/session", login)
+		router.Delete("/auth/session", sessionLogout(&cfg.JWT))
+	}
+
+	// revoke tokens before they expire, when a revocation store is configured
+	var revoke http.HandlerFunc
+	if auth.DefaultRevocationStore() != nil {
+		var err error
+		if revoke, err = revokeTokens(&cfg.JWT, authLog); err != nil {
+			return nil, fmt.Errorf("failed to create token revocation endpoint: %w", err)
+		}
+	}
+
+	// admin endpoints (require a JWT with the admin role), only routed when
+	// enabled so they don't shadow backend paths under the prefix
+	if cfg.Admin.Enabled {
+		router.Route(strings.TrimSuffix(cfg.Admin.Prefix, "/"), func(r chi.Router) {
+			r.Use(middleware.Auth(&cfg.JWT, authLog))
+			r.Use(middleware.RequireRole(cfg.Admin.Role, authLog))
+			r.Get("/cost-report", costreport.Default.Handler().ServeHTTP)
+			if store := quota.DefaultStore(); store != nil {
+				r.Get("/usage", quota.UsageHandler(store).ServeHTTP)
+			}
+
+			if lc, ok := log.(levelController); ok {
+				r.Get("/loglevel", getLogLevel(lc))
+				r.Put("/loglevel", setLogLevel(lc, log))
+			}
+
+			if revoke != nil {
+				r.Post("/revoke", revoke)
+			}
+
+			if store := cache.Default(); store != nil {
+				r.Post("/cache/purge", purgeCache(store, log))
+				r.Post("/cache/warm", warmCache(router, log))
+			}
+		})
+	}
+
+	// global concurrency limit shared by all proxied routes
+	globalLimit := middleware.ConcurrencyLimit("global", cfg.Concurrency.MaxInFlight, &cfg.Concurrency, state, mwLog)
+	bandwidth := middleware.BandwidthLimit(&cfg.Bandwidth, state)
+	rateLimit := middleware.RateLimit(&cfg.RateLimit, quota.DefaultStore(), state, mwLog)
+
+	// route requests to different backend services
+	for _, serviceName := range proxyFactory.Services() {
+		serviceProxy, ok := proxyFactory.Get(serviceName)
+		if !ok {
+			continue
+		}
+
+		if err := registerService(router, serviceName, cfg.Proxy.Targets[serviceName], serviceProxy, cfg, state, globalLimit, rateLimit, bandwidth, log); err != nil {
+			return nil, fmt.Errorf("service %q: %w", serviceName, err)
+		}
+	}
+
+	// aggregate routes call the services through the router, passing their
+	// middleware
+	aggregateLog := logger.ForComponent(log, "aggregate")
+	for _, route := range cfg.Aggregation.Routes {
+		router.Get(route.Path, aggregate.Handler(route, &cfg.Aggregation, router, aggregateLog).ServeHTTP)
+	}
+
+	// the frontend is served under its prefix, at the root for paths no
+	// other route matches
+	var notFound http.Handler
+	if cfg.Static.Dir != "" {
+		frontend, err := static.Handler(&cfg.Static)
+		if err != nil {
+			return nil, err
+		}
+		if prefix := strings.TrimSuffix(cfg.Static.Prefix, "/"); prefix != "" {
+			router.Handle(prefix, frontend)
+			router.Handle(prefix+"/*", frontend)
+		} else {
+			router.NotFound(frontend.ServeHTTP)
+			notFound = frontend
+		}
+	}
+
+	// discovered services are matched after the static ones
+	if discovered != nil {
+		discovered.configure(cfg, notFound, func(r chi.Router, name string, target config.TargetConfig, serviceProxy *proxy.ReverseProxy) error {
+			return registerService(r, name, target, serviceProxy, cfg, state, globalLimit, rateLimit, bandwidth, log)
+		})
+		router.Handle("/*", discovered)
+	}
+
+	return router, nil
+}
+
+// registerService mounts a backend service on the router.
+//
+// The legacy "default" service is mounted at the root and receives paths
+// unchanged, all other services are mounted under "/<name>" and the prefix
+// is stripped before routing; the proxy puts it back before forwarding if
+// the service keeps or replaces it. Apart from that, every service gets the
+// same middleware chain and per-service options.
+func registerService(
+	router chi.Router,
+	serviceName string,
+	target config.TargetConfig,
+	serviceProxy *proxy.ReverseProxy,
+	cfg *config.Config,
+	state *middleware.State,
+	globalLimit func(http.Handler) http.Handler,
+	rateLimit func(http.Handler) http.Handler,
+	bandwidth func(http.Handler) http.Handler,
+	log logger.Logger,
+) error {
+	serverLog := logger.ForComponent(log, "server")
+	mwLog := logger.ForComponent(log, "middleware")
+	authLog := logger.ForComponent(log, "auth")
+
+	// optionally validate requests against the service's OpenAPI spec
+	var serviceHandler http.Handler = serviceProxy
+	if target.OpenAPISpec != "" {
+		validate, err := middleware.OpenAPIValidation(target.OpenAPISpec, serviceName, mwLog)
+		if err != nil {
+			return err
+		}
+		serviceHandler = validate(serviceProxy)
+		serverLog.Info("enabled openapi validation", "service", serviceName, "spec", target.OpenAPISpec)
+	}
+
+	// per-service concurrency limit, falling back to the default
+	serviceLimit := target.MaxInFlight
+	if serviceLimit == 0 {
+		serviceLimit = cfg.Concurrency.ServiceMaxInFlight
+	}
+	var limit func(http.Handler) http.Handler
+	if target.AdaptiveConcurrency.Algorithm != "" {
+		limit = middleware.AdaptiveConcurrencyLimit(serviceName, serviceLimit, &target.AdaptiveConcurrency, &cfg.Concurrency, state, mwLog)
+	} else {
+		limit = middleware.ConcurrencyLimit(serviceName, serviceLimit, &cfg.Concurrency, state, mwLog)
+	}
+	serviceRate := middleware.ServiceRateLimit(serviceName, target.RateLimit, state, mwLog)
+
+	// time for the whole request in the gateway, falling back to the default
+	requestTimeout := target.RequestTimeout
+	if requestTimeout == 0 {
+		requestTimeout = cfg.Proxy.RequestTimeout
+	}
+
+	// authentication of the service's routes, skipped in test mode except
+	// for the legacy default backend, which was always authenticated
+	var authenticate func(http.Handler) http.Handler
+	if os.Getenv("SKIP_AUTH") != "true" || serviceName == config.DefaultTargetName {
+		switch target.Auth {
+		case "none":
+			// anonymous service, e.g. static content
+		case "api-key":
+			authenticate = middleware.APIKeyAuth(serviceName, &target.APIKey, authLog)
+		case "mtls":
+			authenticate = middleware.ClientCertAuth(serviceName, &target.MTLS, authLog)
+		case "hmac":
+			authenticate = middleware.HMACAuth(serviceName, &target.HMAC, state, authLog)
+		case "basic":
+			basic, err := middleware.BasicAuth(serviceName, &target.Basic, authLog)
+			if err != nil {
+				return err
+			}
+			authenticate = basic
+		default:
+			authenticate = middleware.Auth(&cfg.JWT, authLog)
+		}
+
+		// public paths of the service need no credentials
+		if authenticate != nil {
+			authenticate = middleware.ExceptPaths(target.PublicPaths, authenticate)
+		}
+	}
+
+	// tokens accepted only once on sensitive paths, checked after authentication
+	var singleUse func(http.Handler) http.Handler
+	if authenticate != nil && len(target.SingleUsePaths) > 0 {
+		store, ok := auth.DefaultRevocationStore().(auth.TokenUseStore)
+		if !ok {
+			return fmt.Errorf("single-use paths require a revocation store")
+		}
+		singleUse = middleware.SingleUseTokens(serviceName, target.SingleUsePaths, store, cfg.JWT.Leeway, cfg.JWT.Expiration, authLog)
+		singleUse = middleware.ExceptPaths(target.PublicPaths, singleUse)
+	}
+
+	prefix := servicePrefix(serviceName)
+
+	routes := func(r chi.Router) {
+		r.Use(middleware.Annotate(serviceName, target.Labels))
+		r.Use(middleware.RequestTimeout(serviceName, requestTimeout, target.RequestTimeouts, mwLog))
+
+		// TODO: Replace with your corporate authentication middleware from common package:
+		//
+		// Example corporate middleware usage:
+		// import "yourcompany.com/common/auth"
+		// r.Use(auth.NewJWTMiddleware(auth.Config{
+		//     SecretKey: cfg.JWT.Secret,
+		//     Issuer:    cfg.JWT.Issuer,
+		//     Audience:  cfg.JWT.Audience,
+		// }))
+
+		if authenticate != nil {
+			r.Use(authenticate)
+		}
+		if singleUse != nil {
+			r.Use(singleUse)
+		}
+		// after authentication, so clients can be limited and throttled by
+		// user, and clients over their limit take none of the service's
+		r.Use(rateLimit, serviceRate, bandwidth)
+		// requests waiting for a slot are prioritized by their class, which
+		// may select users
+		r.Use(globalLimit, limit)
+		r.Use(middleware.FaultInjection(serviceName, &cfg.Fault, target.Fault, mwLog))
+		if len(target.GraphQL.Paths) > 0 {
+			r.Use(middleware.GraphQL(serviceName, &target.GraphQL, mwLog))
+		}
+		if len(target.IdempotencyPaths) > 0 {
+			// a request in progress holds its key at most as long as the proxy waits for it
+			lock := target.Timeout
+			if lock == 0 {
+				lock = cfg.Proxy.Timeout
+			}
+			r.Use(middleware.Idempotency(serviceName, target.IdempotencyPaths, idempotency.DefaultStore(),
+				cfg.Idempotency.TTL, lock+time.Minute, cfg.Idempotency.MaxBodySize, mwLog))
+		}
+		if len(target.Cache.Paths) > 0 {
+			r.Use(middleware.Cache(serviceName, &target.Cache, cache.Default(), cfg.Cache.MaxEntrySize, cfg.Cache.TagHeader))
+		}
+		if len(target.CoalescePaths) > 0 {
+			r.Use(middleware.Coalesce(serviceName, target.CoalescePaths))
+		}
+
+		if prefix == "" {
+			r.Handle("/*", serviceHandler)
+			return
+		}
+
+		// strip service prefix before forwarding to backend
+		r.Handle("/*", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			// remove service prefix from path, chi matches the escaped
+			// path of paths that have one, e.g. with %2F
+			rest := chi.URLParam(req, "*")
+			req.URL.Path = rest
+			if req.URL.RawPath != "" {
+				if path, err := url.PathUnescape(rest); err == nil {
+					req.URL.Path, req.URL.RawPath = "/"+path, "/"+rest
+				}
+			}
+			if req.URL.Path == "" {
+				req.URL.Path = "/"
+			}
+			serviceHandler.ServeHTTP(w, req)
+		}))
+	}
+
+	if prefix == "" {
+		router.Group(routes)
+	} else {
+		router.Route(prefix, routes)
+	}
+
+	serverLog.Info("registered route", "pattern", prefix+"/*", "service", serviceName)
+	return nil
+}
+
+// servicePrefix returns the path prefix a service is mounted under.
+// The legacy default service is mounted at the root.
+func servicePrefix(serviceName string) string {
+	if serviceName == config.DefaultTargetName {
+		return ""
+	}
+	return "/" + serviceName
+}
+
+// levelController is implemented by loggers whose level can change at runtime
+type levelController interface {
+	GetLevel() string
+	SetLevel(level string) error
+}
+
+// componentLevelController is implemented by loggers with per-component levels
+type componentLevelController interface {
+	ComponentLevels() map[string]string
+	SetComponentLevel(component, level string) error
+}
+
+// logLevelBody is the request and response body of the /admin/loglevel endpoint.
+// In requests, Component selects a single component; an empty Level then
+// makes it follow the root level again.
+type logLevelBody struct {
+	Level      string            `json:"level"`
+	Component  string            `json:"component,omitempty"`
+	Components map[string]string `json:"components,omitempty"`
+}
+
+// currentLogLevels returns the root and per-component log levels
+func currentLogLevels(lc levelController) logLevelBody {
+	body := logLevelBody{Level: lc.GetLevel()}
+	if clc, ok := lc.(componentLevelController); ok {
+		body.Components = clc.ComponentLevels()
+	}
+	return body
+}
+
+// getLogLevel returns a handler reporting the current log levels
+func getLogLevel(lc levelController) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(currentLogLevels(lc))
+	}
+}
+
+// setLogLevel returns a handler switching the root or a component log level without a restart
+func setLogLevel(lc levelController, log logger.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body logLevelBody
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			problem.Write(w, r, http.StatusBadRequest, "invalid request body")
+			return
+		}
+
+		if body.Component != "" {
+			clc, ok := lc.(componentLevelController)
+			if !ok {
+				problem.Write(w, r, http.StatusBadRequest, "per-component log levels are not supported")
+				return
+			}
+
+			previous := clc.ComponentLevels()[body.Component]
+			if err := clc.SetComponentLevel(body.Component, body.Level); err != nil {
+				problem.Write(w, r, http.StatusBadRequest, "invalid log level, expected debug, info, warn or error")
+				return
+			}
+
+			log.Warn("component log level changed",
+				"log_component", body.Component,
+				"from", previous,
+				"to", clc.ComponentLevels()[body.Component],
+			)
+		} else {
+			previous := lc.GetLevel()
+			if err := lc.SetLevel(body.Level); err != nil {
+				problem.Write(w, r, http.StatusBadRequest, "invalid log level, expected debug, info, warn or error")
+				return
+			}
+
+			log.Warn("log level changed", "from", previous, "to", lc.GetLevel())
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(currentLogLevels(lc))
+	}
+}
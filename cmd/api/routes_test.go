@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gateway/template/internal/config"
+	"github.com/gateway/template/internal/middleware"
+	"github.com/gateway/template/internal/proxy"
+	"github.com/gateway/template/pkg/logger"
+)
+
+// newTestGateway builds the gateway's handler from env, with the crm
+// service forwarding to upstream
+func newTestGateway(t *testing.T, upstream http.Handler, env map[string]string) http.Handler {
+	t.Helper()
+	backend := httptest.NewServer(upstream)
+	t.Cleanup(backend.Close)
+
+	t.Setenv("JWT_SECRET", "test-secret")
+	t.Setenv("CRM_SERVICE_URL", backend.URL)
+	for name, value := range env {
+		t.Setenv(name, value)
+	}
+	cfg, err := config.LoadEnv()
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	log := logger.NewMockLogger()
+	factory, err := proxy.NewFactory(&cfg.Proxy, log)
+	if err != nil {
+		t.Fatalf("failed to create proxy factory: %v", err)
+	}
+	t.Cleanup(factory.Close)
+	handler, err := buildHandler(factory, cfg, log, nil, middleware.NewState())
+	if err != nil {
+		t.Fatalf("failed to build handler: %v", err)
+	}
+	return handler
+}
+
+func TestPublicPathsRejectEncodedTraversal(t *testing.T) {
+	var forwarded []string
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = append(forwarded, r.URL.EscapedPath())
+	})
+	h := newTestGateway(t, upstream, map[string]string{"CRM_SERVICE_PUBLIC_PATHS": "/crm/public/*"})
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/crm/public/catalog", http.StatusOK},
+		{"/crm/public/a%2Fb", http.StatusOK},
+		{"/crm/admin", http.StatusUnauthorized},
+		{"/crm/public%2Fcatalog", http.StatusUnauthorized},
+		{"/crm/public/../admin", http.StatusUnauthorized},
+		{"/crm/public%2F..%2Fadmin", http.StatusBadRequest},
+		{"/crm/public/..%2fadmin", http.StatusBadRequest},
+		{"/crm/public/%2E%2E%2Fadmin", http.StatusBadRequest},
+		{"/crm/public/x%2F.%2F..%2F..%2Fadmin", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.path, tt.status, rec.Code)
+		}
+	}
+	if len(forwarded) != 2 || forwarded[0] != "/public/catalog" || forwarded[1] != "/public/a%2Fb" {
+		t.Errorf("expected only the public paths to be forwarded, got %v", forwarded)
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gateway/template/pkg/auth"
+)
+
+// benchWorker is what a bench worker measured
+type benchWorker struct {
+	latencies []time.Duration // of requests that got a response
+	statuses  map[int]int
+	errors    map[string]int
+}
+
+// runBench sends requests to a route from concurrent workers for a duration
+// or a number of requests and prints the latency distribution
+func runBench(args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	jwtCfg := addJWTFlags(fs)
+	method := fs.String("method", http.MethodGet, "request method")
+	concurrency := fs.Int("c", 10, "number of concurrent workers, each with its own connection")
+	requests := fs.Int("n", 0, "total number of requests, 0 to run for -d")
+	duration := fs.Duration("d", 10*time.Second, "how long to run unless -n is set")
+	timeout := fs.Duration("timeout", 10*time.Second, "timeout of each request")
+	body := fs.String("body", "", "request body, @file to read it from a file")
+	subject := fs.String("sub", "", "mint a JWT for this subject and send it as bearer token")
+	roles := fs.String("roles", "", "comma-separated roles of minted JWTs")
+	users := fs.Int("users", 1, "with -sub, number of distinct subjects <sub>-1..<sub>-N to mint JWTs for, e.g. to spread per-user rate limits")
+	header := make(http.Header)
+	fs.Func("header", `request header as "Name: value", repeatable`, func(s string) error {
+		name, value, ok := strings.Cut(s, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return fmt.Errorf(`expected "Name: value"`)
+		}
+		header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+		return nil
+	})
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: gatewayctl bench [flags] <url>")
+	}
+	target, err := url.Parse(fs.Arg(0))
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return fmt.Errorf("invalid URL %q, expected an absolute http or https URL", fs.Arg(0))
+	}
+	if *concurrency < 1 {
+		return fmt.Errorf("-c must be at least 1")
+	}
+	if *requests < 0 || *requests == 0 && *duration <= 0 {
+		return fmt.Errorf("-n or -d must be positive")
+	}
+	if *users < 1 {
+		return fmt.Errorf("-users must be at least 1")
+	}
+
+	payload := []byte(*body)
+	if file, ok := strings.CutPrefix(*body, "@"); ok {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read body: %w", err)
+		}
+		payload = data
+	}
+
+	var tokens []string
+	if *subject != "" {
+		manager, err := jwtCfg.manager()
+		if err != nil {
+			return err
+		}
+		for i := 1; i <= *users; i++ {
+			claims := &auth.Claims{UserID: *subject}
+			if *users > 1 {
+				claims.UserID = fmt.Sprintf("%s-%d", *subject, i)
+			}
+			if *roles != "" {
+				for _, role := range strings.Split(*roles, ",") {
+					claims.Roles = append(claims.Roles, strings.TrimSpace(role))
+				}
+			}
+			token, err := manager.GenerateTokenWithClaims(claims)
+			if err != nil {
+				return fmt.Errorf("failed to generate token: %w", err)
+			}
+			tokens = append(tokens, token)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *requests == 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+	}
+
+	client := &http.Client{
+		Timeout: *timeout,
+		Transport: &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			MaxIdleConnsPerHost: *concurrency,
+			ForceAttemptHTTP2:   true,
+		},
+		// the route itself is measured, not where it redirects to
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	if *requests > 0 {
+		fmt.Fprintf(os.Stderr, "sending %d requests to %s %s with %d workers\n", *requests, *method, target, *concurrency)
+	} else {
+		fmt.Fprintf(os.Stderr, "sending requests to %s %s for %s with %d workers\n", *method, target, *duration, *concurrency)
+	}
+
+	var sent atomic.Int64
+	workers := make([]*benchWorker, *concurrency)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for w := range workers {
+		worker := &benchWorker{statuses: make(map[int]int), errors: make(map[string]int)}
+		workers[w] = worker
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				n := sent.Add(1)
+				if *requests > 0 && n > int64(*requests) || ctx.Err() != nil {
+					return
+				}
+				token := ""
+				if len(tokens) > 0 {
+					token = tokens[int(n-1)%len(tokens)]
+				}
+				worker.send(ctx, client, *method, target.String(), header, payload, token)
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	printBenchReport(workers, elapsed)
+	return nil
+}
+
+// send sends one request and records its status and latency. Requests cut
+// short by the end of the run are not recorded.
+func (w *benchWorker) send(ctx context.Context, client *http.Client, method, target string, header http.Header, body []byte, token string) {
+	req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+	if err != nil {
+		w.errors[err.Error()]++
+		return
+	}
+	req.Header = header.Clone()
+	if len(body) == 0 {
+		req.Body = http.NoBody
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err == nil {
+		_, err = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	latency := time.Since(start)
+	if ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		w.errors[err.Error()]++
+		return
+	}
+	w.statuses[resp.StatusCode]++
+	w.latencies = append(w.latencies, latency)
+}
+
+// printBenchReport prints the throughput, status counts, errors and latency
+// percentiles measured by workers
+func printBenchReport(workers []*benchWorker, elapsed time.Duration) {
+	var latencies []time.Duration
+	statuses := make(map[int]int)
+	failures := make(map[string]int)
+	failed := 0
+	for _, w := range workers {
+		latencies = append(latencies, w.latencies...)
+		for status, n := range w.statuses {
+			statuses[status] += n
+		}
+		for err, n := range w.errors {
+			failures[err] += n
+			failed += n
+		}
+	}
+	total := len(latencies) + failed
+
+	fmt.Printf("requests:  %d in %s, %.1f/s\n", total, benchRound(elapsed), float64(total)/elapsed.Seconds())
+	codes := make([]int, 0, len(statuses))
+	for status := range statuses {
+		codes = append(codes, status)
+	}
+	sort.Ints(codes)
+	for _, status := range codes {
+		fmt.Printf("  %d:     %d\n", status, statuses[status])
+	}
+	if failed > 0 {
+		fmt.Printf("  failed:  %d\n", failed)
+		messages := make([]string, 0, len(failures))
+		for err := range failures {
+			messages = append(messages, err)
+		}
+		sort.Strings(messages)
+		for _, err := range messages {
+			fmt.Printf("    %dx %s\n", failures[err], err)
+		}
+	}
+	if len(latencies) == 0 {
+		return
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	var sum time.Duration
+	for _, latency := range latencies {
+		sum += latency
+	}
+	fmt.Println("latency:")
+	fmt.Printf("  mean     %s\n", benchRound(sum/time.Duration(len(latencies))))
+	fmt.Printf("  min      %s\n", benchRound(latencies[0]))
+	for _, p := range []float64{50, 90, 95, 99, 99.9} {
+		i := int(float64(len(latencies))*p/100+0.5) - 1
+		i = max(0, min(i, len(latencies)-1))
+		fmt.Printf("  %-8s %s\n", fmt.Sprintf("p%g", p), benchRound(latencies[i]))
+	}
+	fmt.Printf("  max      %s\n", benchRound(latencies[len(latencies)-1]))
+}
+
+// benchRound rounds d for printing
+func benchRound(d time.Duration) time.Duration {
+	if d < time.Millisecond {
+		return d.Round(time.Microsecond)
+	}
+	return d.Round(10 * time.Microsecond)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// command is a gatewayctl subcommand
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+// commands lists all available subcommands
+var commands = []command{
+	{
+		name:  "migrate-config",
+		usage: "convert the current environment configuration to a YAML config file",
+		run:   runMigrateConfig,
+	},
+	{
+		name:  "validate",
+		usage: "validate the configuration and print it with secrets masked",
+		run:   runValidate,
+	},
+	{
+		name:  "token",
+		usage: "mint test JWTs (token mint) or decode and verify one (token inspect)",
+		run:   runToken,
+	},
+	{
+		name:  "bench",
+		usage: "measure the latency of a route under concurrent load",
+		run:   runBench,
+	},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		printUsage()
+		os.Exit(2)
+	}
+
+	name := os.Args[1]
+	for _, cmd := range commands {
+		if cmd.name == name {
+			if err := cmd.run(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	if name != "help" && name != "-h" && name != "--help" {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+	}
+	printUsage()
+	os.Exit(2)
+}
+
+// printUsage prints the list of available commands
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "Usage: gatewayctl <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-16s %s\n", cmd.name, cmd.usage)
+	}
+}
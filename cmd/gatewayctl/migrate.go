@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/gateway/template/internal/config"
+)
+
+// secretPlaceholder keeps the JWT secret out of the generated file,
+// config.LoadFile expands it from the environment at startup
+const secretPlaceholder = "${JWT_SECRET}"
+
+// runMigrateConfig reads the environment-based configuration (including the
+// legacy PROXY_TARGET_URL and *_SERVICE_URL forms) and writes an equivalent
+// YAML config file.
+func runMigrateConfig(args []string) error {
+	fs := flag.NewFlagSet("migrate-config", flag.ContinueOnError)
+	output := fs.String("o", "", "output file (default: stdout)")
+	inlineSecrets := fs.Bool("inline-secrets", false, "write JWT_SECRET into the file instead of a ${JWT_SECRET} reference")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := config.LoadEnv()
+	if err != nil {
+		return fmt.Errorf("failed to load environment configuration: %w", err)
+	}
+
+	if !*inlineSecrets {
+		cfg.JWT.Secret = secretPlaceholder
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("# generated by gatewayctl migrate-config\n")
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(cfg); err != nil {
+		return fmt.Errorf("failed to encode config: %w", err)
+	}
+	if err := enc.Close(); err != nil {
+		return fmt.Errorf("failed to encode config: %w", err)
+	}
+
+	// make sure the generated file round-trips to the same configuration
+	if err := verifyMigratedConfig(buf.Bytes()); err != nil {
+		return err
+	}
+
+	if *output == "" {
+		_, err := os.Stdout.Write(buf.Bytes())
+		return err
+	}
+
+	if err := os.WriteFile(*output, buf.Bytes(), 0o600); err != nil {
+		return fmt.Errorf("failed to write %q: %w", *output, err)
+	}
+
+	fmt.Fprintf(os.Stderr, "wrote %s\n", *output)
+	return nil
+}
+
+// verifyMigratedConfig loads the generated YAML and validates it
+func verifyMigratedConfig(data []byte) error {
+	tmp, err := os.CreateTemp("", "gateway-config-*.yaml")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write temp file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write temp file: %w", err)
+	}
+
+	if _, err := config.LoadFile(tmp.Name()); err != nil {
+		return fmt.Errorf("generated config is invalid: %w", err)
+	}
+	return nil
+}
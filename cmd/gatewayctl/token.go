@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/gateway/template/internal/config"
+	"github.com/gateway/template/internal/middleware"
+	"github.com/gateway/template/pkg/auth"
+)
+
+// runToken mints test tokens and inspects existing ones with the gateway's
+// JWT settings
+func runToken(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: gatewayctl token <mint|inspect> [flags]")
+	}
+
+	switch args[0] {
+	case "mint":
+		return runTokenMint(args[1:])
+	case "inspect":
+		return runTokenInspect(args[1:])
+	default:
+		return fmt.Errorf("unknown token command %q, expected mint or inspect", args[0])
+	}
+}
+
+// jwtFlags are the flags selecting the JWT settings, by default the
+// gateway's own configuration is used
+type jwtFlags struct {
+	secret   *string
+	issuer   *string
+	audience *string
+	keyID    *string
+}
+
+// addJWTFlags registers the JWT settings flags on fs
+func addJWTFlags(fs *flag.FlagSet) jwtFlags {
+	return jwtFlags{
+		secret:   fs.String("secret", "", "signing secret (default: the gateway config's JWT secret)"),
+		issuer:   fs.String("issuer", "", "issuer (default: the gateway config's issuer)"),
+		audience: fs.String("audience", "", "audience (default: the gateway config's audience)"),
+		keyID:    fs.String("kid", "", "key ID of the secret (default: the gateway config's key ID)"),
+	}
+}
+
+// manager creates a JWT manager from the flags, loading the gateway config
+// unless a secret is passed
+func (f jwtFlags) manager() (*auth.Manager, error) {
+	cfg := &auth.Config{Secret: *f.secret}
+	if cfg.Secret == "" {
+		gw, err := config.Load()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load config (pass -secret to skip it): %w", err)
+		}
+		if gw.JWT.TokenFormat == "paseto" {
+			return nil, fmt.Errorf("the gateway is configured for PASETO tokens, pass -secret to use JWTs")
+		}
+		cfg = middleware.AuthConfig(&gw.JWT)
+	}
+	if *f.issuer != "" {
+		cfg.Issuer = *f.issuer
+	}
+	if *f.audience != "" {
+		cfg.Audience = *f.audience
+	}
+	if *f.keyID != "" {
+		cfg.KeyID = *f.keyID
+	}
+	return auth.NewManager(cfg)
+}
+
+// runTokenMint prints a signed token with the given claims
+func runTokenMint(args []string) error {
+	fs := flag.NewFlagSet("token mint", flag.ContinueOnError)
+	jwtCfg := addJWTFlags(fs)
+	subject := fs.String("sub", "", "subject (user ID), required")
+	username := fs.String("username", "", "username claim")
+	email := fs.String("email", "", "email claim")
+	roles := fs.String("roles", "", "comma-separated roles")
+	ttl := fs.Duration("ttl", 0, "token lifetime (default: the configured expiration)")
+	metadata := make(map[string]interface{})
+	fs.Func("claim", "metadata claim as key=value, repeatable", func(s string) error {
+		key, value, ok := strings.Cut(s, "=")
+		if !ok || key == "" {
+			return fmt.Errorf("expected key=value")
+		}
+		metadata[key] = value
+		return nil
+	})
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *subject == "" {
+		return fmt.Errorf("-sub is required")
+	}
+
+	manager, err := jwtCfg.manager()
+	if err != nil {
+		return err
+	}
+
+	claims := &auth.Claims{
+		UserID:   *subject,
+		Username: *username,
+		Email:    *email,
+	}
+	if *roles != "" {
+		for _, role := range strings.Split(*roles, ",") {
+			claims.Roles = append(claims.Roles, strings.TrimSpace(role))
+		}
+	}
+	if len(metadata) > 0 {
+		claims.Metadata = metadata
+	}
+	if *ttl > 0 {
+		claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(*ttl))
+	}
+
+	token, err := manager.GenerateTokenWithClaims(claims)
+	if err != nil {
+		return fmt.Errorf("failed to generate token: %w", err)
+	}
+
+	fmt.Println(token)
+	return nil
+}
+
+// runTokenInspect decodes a token, prints its header and claims and
+// verifies it against the JWT settings
+func runTokenInspect(args []string) error {
+	fs := flag.NewFlagSet("token inspect", flag.ContinueOnError)
+	jwtCfg := addJWTFlags(fs)
+	noVerify := fs.Bool("no-verify", false, "only decode the token, don't verify it")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	// the token is the argument, or read from stdin so it stays out of the shell history
+	var tokenString string
+	if fs.NArg() > 0 {
+		tokenString = fs.Arg(0)
+	} else {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return fmt.Errorf("failed to read token from stdin: %w", err)
+		}
+		tokenString = line
+	}
+	tokenString = strings.TrimPrefix(strings.TrimSpace(tokenString), "Bearer ")
+
+	token, _, err := jwt.NewParser().ParseUnverified(tokenString, jwt.MapClaims{})
+	if err != nil {
+		return fmt.Errorf("failed to decode token: %w", err)
+	}
+
+	out, err := json.MarshalIndent(map[string]interface{}{
+		"header": token.Header,
+		"claims": token.Claims,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+
+	if exp, err := token.Claims.GetExpirationTime(); err == nil && exp != nil {
+		state := "expires"
+		if exp.Before(time.Now()) {
+			state = "expired"
+		}
+		fmt.Fprintf(os.Stderr, "%s at %s\n", state, exp.Local().Format(time.RFC3339))
+	}
+
+	if *noVerify {
+		return nil
+	}
+
+	manager, err := jwtCfg.manager()
+	if err != nil {
+		return err
+	}
+	if _, err := manager.ValidateToken(tokenString); err != nil {
+		return fmt.Errorf("token is not valid: %w", err)
+	}
+
+	fmt.Fprintln(os.Stderr, "token is valid")
+	return nil
+}
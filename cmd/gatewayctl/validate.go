@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/gateway/template/internal/config"
+)
+
+// Masks replacing secret values and URL credentials in the printed config,
+// the URL mask matches url.URL.Redacted
+const (
+	secretMask   = "********"
+	userInfoMask = "xxxxx"
+)
+
+// runValidate loads and validates the full configuration the gateway would
+// start with, optionally checks that the targets are reachable, and prints
+// the effective config with secrets masked.
+func runValidate(args []string) error {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	file := fs.String("config", "", "YAML config file to validate (default: CONFIG_FILE, CONFIG_KV_URL or the environment)")
+	reachable := fs.Bool("check-reachability", false, "check that every target URL accepts connections")
+	timeout := fs.Duration("timeout", 5*time.Second, "timeout for each reachability check")
+	quiet := fs.Bool("q", false, "don't print the effective config")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := loadForValidation(*file)
+	if err != nil {
+		return err
+	}
+
+	if err := checkTargetURLs(cfg); err != nil {
+		return err
+	}
+
+	if *reachable {
+		if err := checkReachability(cfg, *timeout); err != nil {
+			return err
+		}
+	}
+
+	if !*quiet {
+		maskSecrets(cfg)
+
+		var buf bytes.Buffer
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		if err := enc.Encode(cfg); err != nil {
+			return fmt.Errorf("failed to encode config: %w", err)
+		}
+		if err := enc.Close(); err != nil {
+			return fmt.Errorf("failed to encode config: %w", err)
+		}
+		if _, err := os.Stdout.Write(buf.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintln(os.Stderr, "config is valid")
+	return nil
+}
+
+// loadForValidation loads the config from file, or from the same sources as
+// the gateway when file is empty, including secret references
+func loadForValidation(file string) (*config.Config, error) {
+	if file == "" {
+		return config.Load()
+	}
+
+	cfg, err := config.LoadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := config.ResolveSecrets(ctx, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// checkTargetURLs checks that every target URL is an absolute http(s) URL,
+// a Unix socket URL or mock, which the gateway itself does not verify until
+// the first request
+func checkTargetURLs(cfg *config.Config) error {
+	for _, name := range targetNames(cfg) {
+		for _, raw := range targetURLs(cfg.Proxy.Targets[name]) {
+			u, err := url.Parse(raw)
+			if err != nil {
+				return fmt.Errorf("proxy target %q: invalid URL %q: %w", name, raw, err)
+			}
+			if u.Scheme == "unix" && u.Host == "" && u.Path != "" || raw == config.MockURL {
+				continue
+			}
+			if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("proxy target %q: URL %q must be an absolute http or https URL or a unix:// socket path or mock", name, raw)
+			}
+		}
+	}
+	return nil
+}
+
+// checkReachability dials every target URL and reports the unreachable ones
+func checkReachability(cfg *config.Config, timeout time.Duration) error {
+	failed := 0
+	for _, name := range targetNames(cfg) {
+		for _, raw := range targetURLs(cfg.Proxy.Targets[name]) {
+			if raw == config.MockURL {
+				continue
+			}
+			u, _ := url.Parse(raw)
+			network, addr := "tcp", u.Host
+			if u.Scheme == "unix" {
+				network, addr = "unix", u.Path
+			} else if u.Port() == "" {
+				port := "80"
+				if u.Scheme == "https" {
+					port = "443"
+				}
+				addr = net.JoinHostPort(u.Hostname(), port)
+			}
+
+			conn, err := net.DialTimeout(network, addr, timeout)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "unreachable: %s %s: %v\n", name, maskURL(raw), err)
+				failed++
+				continue
+			}
+			conn.Close()
+			fmt.Fprintf(os.Stderr, "reachable: %s %s\n", name, maskURL(raw))
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d target URL(s) unreachable", failed)
+	}
+	return nil
+}
+
+// targetNames returns the configured target names in a stable order
+func targetNames(cfg *config.Config) []string {
+	names := make([]string, 0, len(cfg.Proxy.Targets))
+	for name := range cfg.Proxy.Targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// targetURLs returns the URL, additional endpoints, backups, route and
+// version URLs of a target
+func targetURLs(target config.TargetConfig) []string {
+	urls := append(append([]string{target.URL}, target.Endpoints...), target.Backups...)
+	for _, rule := range target.Routes {
+		urls = append(urls, rule.URL)
+	}
+	versions := make([]string, 0, len(target.Versions.URLs))
+	for version := range target.Versions.URLs {
+		versions = append(versions, version)
+	}
+	sort.Strings(versions)
+	for _, version := range versions {
+		urls = append(urls, target.Versions.URLs[version])
+	}
+	return urls
+}
+
+// maskSecrets replaces secrets and URL credentials in cfg
+func maskSecrets(cfg *config.Config) {
+	if cfg.JWT.Secret != "" {
+		cfg.JWT.Secret = secretMask
+	}
+	maskKeys(cfg.JWT.Keys)
+	if cfg.JWT.SessionCookie.Secret != "" {
+		cfg.JWT.SessionCookie.Secret = secretMask
+	}
+	for i := range cfg.JWT.TrustedIssuers {
+		if cfg.JWT.TrustedIssuers[i].Secret != "" {
+			cfg.JWT.TrustedIssuers[i].Secret = secretMask
+		}
+		maskKeys(cfg.JWT.TrustedIssuers[i].Keys)
+	}
+	if cfg.Discovery.Consul.Token != "" {
+		cfg.Discovery.Consul.Token = secretMask
+	}
+	// the DSN key is the user part of the URL
+	cfg.Errors.SentryDSN = maskURL(cfg.Errors.SentryDSN)
+	cfg.Log.Sink.HTTPURL = maskURL(cfg.Log.Sink.HTTPURL)
+	cfg.JWT.RedisURL = maskURL(cfg.JWT.RedisURL)
+	cfg.Idempotency.RedisURL = maskURL(cfg.Idempotency.RedisURL)
+	cfg.Discovery.Consul.Address = maskURL(cfg.Discovery.Consul.Address)
+
+	for name, target := range cfg.Proxy.Targets {
+		target.URL = maskURL(target.URL)
+		endpoints := make([]string, len(target.Endpoints))
+		for i, endpoint := range target.Endpoints {
+			endpoints[i] = maskURL(endpoint)
+		}
+		target.Endpoints = endpoints
+		if target.Backups != nil {
+			backups := make([]string, len(target.Backups))
+			for i, backup := range target.Backups {
+				backups[i] = maskURL(backup)
+			}
+			target.Backups = backups
+		}
+		if target.Weights != nil {
+			weights := make(map[string]int, len(target.Weights))
+			for raw, weight := range target.Weights {
+				weights[maskURL(raw)] = weight
+			}
+			target.Weights = weights
+		}
+		if target.Routes != nil {
+			routes := make([]config.RouteRule, len(target.Routes))
+			for i, rule := range target.Routes {
+				rule.URL = maskURL(rule.URL)
+				routes[i] = rule
+			}
+			target.Routes = routes
+		}
+		if target.Versions.URLs != nil {
+			urls := make(map[string]string, len(target.Versions.URLs))
+			for version, raw := range target.Versions.URLs {
+				urls[version] = maskURL(raw)
+			}
+			target.Versions.URLs = urls
+		}
+		if target.APIKey.Keys != nil {
+			keys := make(map[string]string, len(target.APIKey.Keys))
+			for caller := range target.APIKey.Keys {
+				keys[caller] = secretMask
+			}
+			target.APIKey.Keys = keys
+		}
+		if target.HMAC.Keys != nil {
+			keys := make(map[string]string, len(target.HMAC.Keys))
+			for keyID := range target.HMAC.Keys {
+				keys[keyID] = secretMask
+			}
+			target.HMAC.Keys = keys
+		}
+		if target.Basic.Users != nil {
+			users := make(map[string]string, len(target.Basic.Users))
+			for user := range target.Basic.Users {
+				users[user] = secretMask
+			}
+			target.Basic.Users = users
+		}
+		cfg.Proxy.Targets[name] = target
+	}
+}
+
+// maskKeys replaces the secrets of a key ID to secret map
+func maskKeys(keys map[string]string) {
+	for kid := range keys {
+		keys[kid] = secretMask
+	}
+}
+
+// maskURL replaces the user info of a URL, values that are not URLs are
+// returned unchanged
+func maskURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.User == nil {
+		return raw
+	}
+	u.User = url.User(userInfoMask)
+	return u.String()
+}
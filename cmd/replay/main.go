@@ -0,0 +1,273 @@
+// Command replay sends recorded traffic through a running gateway and
+// reports the requests whose status or latency differ from the recording,
+// to validate a config change before rolling it out. It reads the
+// recording files of services in record mode and HAR files.
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// result is the outcome of replaying a request
+type result struct {
+	done    bool
+	status  int
+	latency time.Duration
+	err     error
+}
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(2)
+		}
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// run replays the traffic of the files in args and prints the report
+func run(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: replay [flags] <recording or .har file>...")
+		fmt.Fprintln(fs.Output())
+		fs.PrintDefaults()
+	}
+	gateway := fs.String("gateway", "http://localhost:8080", "base URL of the gateway to replay through")
+	rate := fs.Float64("rate", 10, "requests per second, 0 for as fast as -concurrency allows")
+	concurrency := fs.Int("concurrency", 4, "maximum number of requests in flight")
+	timeout := fs.Duration("timeout", 30*time.Second, "timeout of each request")
+	service := fs.String("service", "", "replay only the recorded requests of this service")
+	slower := fs.Duration("slower", 0, "report requests slower than recorded by more than this, 0 to only compare statuses")
+	verbose := fs.Bool("v", false, "print every request, not only those that differ")
+	header := make(http.Header)
+	fs.Func("header", `header added to every request as "Name: value", repeatable, e.g. for credentials redacted from recordings`, func(s string) error {
+		name, value, ok := strings.Cut(s, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return fmt.Errorf(`expected "Name: value"`)
+		}
+		header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+		return nil
+	})
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+	if *rate < 0 {
+		return fmt.Errorf("-rate must not be negative")
+	}
+	if *concurrency < 1 {
+		return fmt.Errorf("-concurrency must be at least 1")
+	}
+
+	var requests []request
+	for _, path := range fs.Args() {
+		loaded, err := loadTraffic(path, *service)
+		if err != nil {
+			return err
+		}
+		requests = append(requests, loaded...)
+	}
+	if len(requests) == 0 {
+		return fmt.Errorf("no requests to replay")
+	}
+	for i := range requests {
+		for name, values := range header {
+			requests[i].header[name] = values
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	client := &http.Client{
+		Timeout: *timeout,
+		// redirects are compared like any other status
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	start := time.Now()
+	results := replay(ctx, client, strings.TrimSuffix(*gateway, "/"), requests, *rate, *concurrency)
+	elapsed := time.Since(start)
+
+	if diffs := report(os.Stdout, requests, results, elapsed, *slower, *verbose); diffs > 0 {
+		return fmt.Errorf("%d of %d requests differ from the recording", diffs, len(requests))
+	}
+	return nil
+}
+
+// replay sends requests to the gateway at base in order, at most rate per
+// second and concurrency at a time. Requests not sent before ctx is done
+// are left out of the results.
+func replay(ctx context.Context, client *http.Client, base string, requests []request, rate float64, concurrency int) []result {
+	results := make([]result, len(requests))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = send(ctx, client, base, &requests[i])
+			}
+		}()
+	}
+
+	var tick <-chan time.Time
+	if rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / rate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+dispatch:
+	for i := range requests {
+		if i > 0 && tick != nil {
+			select {
+			case <-tick:
+			case <-ctx.Done():
+				break dispatch
+			}
+		}
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+// send sends r to the gateway at base and reads the whole response. The
+// result is not done if ctx ended first.
+func send(ctx context.Context, client *http.Client, base string, r *request) result {
+	req, err := http.NewRequestWithContext(ctx, r.method, base+r.uri, bytes.NewReader(r.body))
+	if err != nil {
+		return result{done: true, err: err}
+	}
+	req.Header = r.header.Clone()
+	if len(r.body) == 0 {
+		req.Body = http.NoBody
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil && ctx.Err() != nil {
+		// interrupted, not a difference
+		return result{}
+	}
+	if err != nil {
+		return result{done: true, err: err, latency: time.Since(start)}
+	}
+	_, err = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return result{done: true, status: resp.StatusCode, latency: time.Since(start), err: err}
+}
+
+// report prints the requests that differ from their recording, or all of
+// them if verbose, followed by a summary, and returns the number that
+// differ. A request differs if it failed, got another status, or was
+// slower than recorded by more than slower if it is set.
+func report(w io.Writer, requests []request, results []result, elapsed, slower time.Duration, verbose bool) int {
+	var diffs, sent, statusDiffs, slowDiffs, failed int
+	var recorded, replayed []time.Duration
+	for i, res := range results {
+		if !res.done {
+			continue
+		}
+		sent++
+		r := &requests[i]
+		var diff string
+		switch {
+		case res.err != nil:
+			failed++
+			diff = "error: " + res.err.Error()
+		case r.status != 0 && res.status != r.status:
+			statusDiffs++
+			diff = fmt.Sprintf("status %d, recorded %d", res.status, r.status)
+		case slower > 0 && res.latency-r.latency > slower:
+			slowDiffs++
+			diff = fmt.Sprintf("%s slower than recorded", round(res.latency-r.latency))
+		}
+		if diff != "" {
+			diffs++
+		}
+		if res.err == nil {
+			replayed = append(replayed, res.latency)
+			if r.latency > 0 {
+				recorded = append(recorded, r.latency)
+			}
+		}
+
+		if diff != "" || verbose {
+			line := fmt.Sprintf("%s %s: %d in %s (recorded %d in %s)",
+				r.method, r.uri, res.status, round(res.latency), r.status, round(r.latency))
+			if res.err != nil {
+				line = fmt.Sprintf("%s %s: failed after %s (recorded %d in %s)",
+					r.method, r.uri, round(res.latency), r.status, round(r.latency))
+			}
+			if diff != "" {
+				line += " DIFF " + diff
+			}
+			fmt.Fprintln(w, line)
+		}
+	}
+
+	if diffs > 0 || verbose {
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "replayed %d of %d requests in %s (%.1f/s)\n",
+		sent, len(requests), round(elapsed), float64(sent)/elapsed.Seconds())
+	fmt.Fprintf(w, "  %d same, %d other status, %d slower, %d failed\n",
+		sent-diffs, statusDiffs, slowDiffs, failed)
+	if len(replayed) > 0 {
+		fmt.Fprintf(w, "latency   %10s %10s\n", "recorded", "replayed")
+		for _, p := range []float64{50, 90, 99, 100} {
+			name := fmt.Sprintf("p%g", p)
+			if p == 100 {
+				name = "max"
+			}
+			fmt.Fprintf(w, "  %-7s %10s %10s\n", name, percentile(recorded, p), percentile(replayed, p))
+		}
+	}
+	return diffs
+}
+
+// percentile returns the p-th percentile of latencies, sorting them, or "-"
+// if there are none
+func percentile(latencies []time.Duration, p float64) string {
+	if len(latencies) == 0 {
+		return "-"
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	i := int(float64(len(latencies))*p/100+0.5) - 1
+	i = max(0, min(i, len(latencies)-1))
+	return round(latencies[i]).String()
+}
+
+// round rounds d for printing
+func round(d time.Duration) time.Duration {
+	if d < time.Millisecond {
+		return d.Round(time.Microsecond)
+	}
+	return d.Round(time.Millisecond)
+}
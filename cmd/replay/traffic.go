@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gateway/template/internal/recording"
+)
+
+// request is a request to replay and what it got when it was recorded
+type request struct {
+	method  string
+	uri     string // path and query
+	header  http.Header
+	body    []byte
+	status  int           // recorded status
+	latency time.Duration // recorded latency
+}
+
+// skippedHeaders are not replayed: hop-by-hop headers, headers the client
+// computes, and headers the gateway adds to forwarded requests
+var skippedHeaders = map[string]bool{
+	"Connection":          true,
+	"Keep-Alive":          true,
+	"Proxy-Connection":    true,
+	"Te":                  true,
+	"Trailer":             true,
+	"Transfer-Encoding":   true,
+	"Upgrade":             true,
+	"Host":                true,
+	"Content-Length":      true,
+	"Forwarded":           true,
+	"X-Forwarded-For":     true,
+	"X-Forwarded-Host":    true,
+	"X-Forwarded-Prefix":  true,
+	"X-Forwarded-Proto":   true,
+	"X-Real-Ip":           true,
+	"X-Request-Id":        true,
+	"Proxy-Authorization": true,
+}
+
+// loadTraffic loads the requests of a recording file or, by its .har
+// extension, a HAR file. Recorded requests of other services than service,
+// if set, and health checks are left out.
+func loadTraffic(path, service string) ([]request, error) {
+	if strings.EqualFold(filepath.Ext(path), ".har") {
+		return loadHAR(path)
+	}
+
+	exchanges, err := recording.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var requests []request
+	for _, e := range exchanges {
+		if e.GatewayURI == "" || service != "" && e.Service != service {
+			continue
+		}
+		body, err := e.Request.BodyBytes()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		requests = append(requests, request{
+			method:  e.Request.Method,
+			uri:     e.GatewayURI,
+			header:  replayedHeader(e.Request.Header),
+			body:    body,
+			status:  e.Response.Status,
+			latency: time.Duration(e.DurationMs) * time.Millisecond,
+		})
+	}
+	return requests, nil
+}
+
+// replayedHeader returns the headers of a recorded request to replay,
+// leaving out skipped and redacted ones
+func replayedHeader(recorded http.Header) http.Header {
+	header := make(http.Header)
+	for name, values := range recorded {
+		name = http.CanonicalHeaderKey(name)
+		if skippedHeaders[name] || strings.HasPrefix(name, ":") {
+			continue
+		}
+		for _, value := range values {
+			if value != recording.Redacted {
+				header.Add(name, value)
+			}
+		}
+	}
+	return header
+}
+
+// harFile is the part of an HTTP Archive replayed
+type harFile struct {
+	Log struct {
+		Entries []struct {
+			Time    float64 `json:"time"` // milliseconds
+			Request struct {
+				Method   string      `json:"method"`
+				URL      string      `json:"url"`
+				Headers  []harHeader `json:"headers"`
+				PostData *struct {
+					Text string `json:"text"`
+				} `json:"postData"`
+			} `json:"request"`
+			Response struct {
+				Status int `json:"status"`
+			} `json:"response"`
+		} `json:"entries"`
+	} `json:"log"`
+}
+
+// harHeader is a header of a HAR request
+type harHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// loadHAR loads the requests of a HAR file, e.g. exported from a browser
+func loadHAR(path string) ([]request, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read HAR file: %w", err)
+	}
+	var har harFile
+	if err := json.Unmarshal(data, &har); err != nil {
+		return nil, fmt.Errorf("invalid HAR file %s: %w", path, err)
+	}
+
+	requests := make([]request, 0, len(har.Log.Entries))
+	for i, entry := range har.Log.Entries {
+		u, err := url.Parse(entry.Request.URL)
+		if err != nil {
+			return nil, fmt.Errorf("%s: entry %d: invalid URL: %w", path, i+1, err)
+		}
+		recorded := make(http.Header)
+		for _, h := range entry.Request.Headers {
+			recorded.Add(h.Name, h.Value)
+		}
+		r := request{
+			method:  entry.Request.Method,
+			uri:     u.RequestURI(),
+			header:  replayedHeader(recorded),
+			status:  entry.Response.Status,
+			latency: time.Duration(entry.Time * float64(time.Millisecond)),
+		}
+		if entry.Request.PostData != nil {
+			r.body = []byte(entry.Request.PostData.Text)
+		}
+		requests = append(requests, r)
+	}
+	return requests, nil
+}
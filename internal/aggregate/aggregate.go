@@ -0,0 +1,217 @@
+// Package aggregate serves aggregate routes, gateway paths answered by
+// calling several services in parallel and merging their JSON responses
+// into one, e.g. a dashboard combining crm, billing and notifications.
+package aggregate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gateway/template/internal/config"
+	"github.com/gateway/template/internal/metrics"
+	"github.com/gateway/template/internal/problem"
+	"github.com/gateway/template/pkg/logger"
+	"github.com/go-chi/chi/v5"
+)
+
+// maxCallBody is the largest response body of a call merged into the
+// aggregate response, larger ones fail the call
+const maxCallBody = 10 << 20
+
+var callFailures = metrics.Default.Counter(
+	"gateway_aggregate_call_failures_total",
+	"Number of failed calls of aggregate routes.",
+	"route", "call",
+)
+
+// callError describes a failed call in the errors member of partial
+// responses
+type callError struct {
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// call is the outcome of a call of an aggregate route
+type call struct {
+	name string
+	body json.RawMessage // nil if the call failed
+	err  *callError
+}
+
+// Handler returns the handler of an aggregate route. Its calls are sent to
+// gateway, the gateway's router, as GET requests carrying the headers of
+// the client's request, so each passes the middleware and authentication
+// of its service. Calls answering with a status other than 2xx or a body
+// other than JSON fail.
+func Handler(route config.AggregateRoute, cfg *config.AggregationConfig, gateway http.Handler, log logger.Logger) http.Handler {
+	onError := route.OnError
+	if onError == "" {
+		onError = cfg.OnError
+	}
+	timeout := route.Timeout
+	if timeout == 0 {
+		timeout = cfg.Timeout
+	}
+	names := make([]string, 0, len(route.Calls))
+	for name := range route.Calls {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls := make([]call, len(names))
+		var wg sync.WaitGroup
+		for i, name := range names {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				calls[i] = send(r, name, route.Calls[name], timeout, gateway)
+			}()
+		}
+		wg.Wait()
+
+		merged := make(map[string]json.RawMessage, len(calls)+1)
+		errs := make(map[string]*callError)
+		for _, c := range calls {
+			if c.err == nil {
+				merged[c.name] = c.body
+				continue
+			}
+
+			callFailures.Inc(route.Path, c.name)
+			log.Warn("aggregate call failed",
+				"route", route.Path,
+				"call", c.name,
+				"status", c.err.Status,
+				"detail", c.err.Detail,
+			)
+			if onError != "partial" || slices.Contains(route.Required, c.name) {
+				fail(w, r, c)
+				return
+			}
+			merged[c.name] = json.RawMessage("null")
+			errs[c.name] = c.err
+		}
+		if len(errs) == len(calls) {
+			problem.Write(w, r, http.StatusBadGateway, "all calls of the aggregate route failed")
+			return
+		}
+		if len(errs) > 0 {
+			encoded, _ := json.Marshal(errs)
+			merged["errors"] = encoded
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(merged)
+	})
+}
+
+// send calls the gateway path of a call for the client's request r
+func send(r *http.Request, name, target string, timeout time.Duration, gateway http.Handler) call {
+	u, err := url.Parse(target)
+	if err != nil {
+		return call{name: name, err: &callError{Status: http.StatusBadGateway, Detail: err.Error()}}
+	}
+
+	// without the route context of r, the router routes the call afresh
+	ctx := context.WithValue(r.Context(), chi.RouteCtxKey, nil)
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	req := r.Clone(ctx)
+	req.Method = http.MethodGet
+	req.URL = r.URL.ResolveReference(u)
+	req.RequestURI = req.URL.RequestURI()
+	req.Body = http.NoBody
+	req.ContentLength = 0
+	req.Header.Del("Content-Length")
+	req.Header.Del("Content-Type")
+	req.Header.Del("Content-Encoding")
+
+	rec := newRecorder()
+	gateway.ServeHTTP(rec, req)
+
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		return call{name: name, err: &callError{Status: http.StatusGatewayTimeout, Detail: "call timed out"}}
+	case rec.status < 200 || rec.status > 299:
+		return call{name: name, err: &callError{Status: rec.status, Detail: detailOf(rec)}}
+	case rec.overflow:
+		return call{name: name, err: &callError{Status: http.StatusBadGateway, Detail: "response too large"}}
+	}
+	mediaType, _, _ := mime.ParseMediaType(rec.header.Get("Content-Type"))
+	if mediaType != "application/json" || !json.Valid(rec.body) {
+		return call{name: name, err: &callError{Status: http.StatusBadGateway, Detail: "response is not JSON"}}
+	}
+	return call{name: name, body: rec.body}
+}
+
+// detailOf returns the detail of a problem details response
+func detailOf(rec *recorder) string {
+	var details struct {
+		Detail string `json:"detail"`
+	}
+	if json.Unmarshal(rec.body, &details) != nil {
+		return ""
+	}
+	return details.Detail
+}
+
+// fail answers an aggregate request whose call c failed. Clients lacking
+// the credentials or permissions for a call get its 401 or 403, so they can
+// authenticate, and timed out calls a 504, otherwise the call's failure is a
+// bad gateway.
+func fail(w http.ResponseWriter, r *http.Request, c call) {
+	status := http.StatusBadGateway
+	switch c.err.Status {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusGatewayTimeout:
+		status = c.err.Status
+	}
+	problem.Write(w, r, status, fmt.Sprintf("call %q of the aggregate route failed with status %d", c.name, c.err.Status))
+}
+
+// recorder is the ResponseWriter of a call, keeping the response in memory
+type recorder struct {
+	header   http.Header
+	status   int
+	body     []byte
+	overflow bool
+}
+
+func newRecorder() *recorder {
+	return &recorder{header: make(http.Header)}
+}
+
+func (rec *recorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *recorder) WriteHeader(code int) {
+	if rec.status == 0 {
+		rec.status = code
+	}
+}
+
+func (rec *recorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	if len(rec.body)+len(b) > maxCallBody {
+		rec.overflow = true
+		rec.body = nil
+	}
+	if !rec.overflow {
+		rec.body = append(rec.body, b...)
+	}
+	return len(b), nil
+}
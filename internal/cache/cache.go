@@ -0,0 +1,240 @@
+// Package cache keeps upstream responses in memory so the gateway can answer
+// repeated requests without forwarding them. The cache is bounded by the
+// size of its entries and evicts the least recently used ones first.
+package cache
+
+import (
+	"container/list"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gateway/template/internal/metrics"
+)
+
+var (
+	cacheSize = metrics.Default.Gauge(
+		"gateway_cache_size_bytes",
+		"Size of the responses in the response cache.",
+	)
+	cacheEntries = metrics.Default.Gauge(
+		"gateway_cache_entries",
+		"Number of responses in the response cache.",
+	)
+	cacheEvictions = metrics.Default.Counter(
+		"gateway_cache_evictions_total",
+		"Number of responses evicted from the response cache to make room for others.",
+	)
+)
+
+// entryOverhead is added to the size of every entry for the bookkeeping
+// around it
+const entryOverhead = 256
+
+// Entry is a cached response
+type Entry struct {
+	Status  int
+	Header  http.Header
+	Body    []byte
+	Stored  time.Time // when the response was received
+	Expires time.Time // until when it is fresh
+
+	// StaleWhileRevalidate and StaleIfError are how long after Expires the
+	// response may be served while it is refreshed and when the upstream
+	// fails
+	StaleWhileRevalidate time.Duration
+	StaleIfError         time.Duration
+
+	Path string   // gateway path of the request, for purging
+	Tags []string // tags the upstream gave the response, for purging
+
+	// Vary lists the request headers the response varies on. Such responses
+	// are stored under a key of their values, the entry holding only Vary.
+	Vary []string
+}
+
+// Fresh reports whether the entry can be served without asking the upstream
+func (e *Entry) Fresh(now time.Time) bool {
+	return now.Before(e.Expires)
+}
+
+// Revalidating reports whether the expired entry can be served while it is
+// refreshed
+func (e *Entry) Revalidating(now time.Time) bool {
+	return now.Before(e.Expires.Add(e.StaleWhileRevalidate))
+}
+
+// ServableOnError reports whether the expired entry can be served when the
+// upstream fails
+func (e *Entry) ServableOnError(now time.Time) bool {
+	return now.Before(e.Expires.Add(e.StaleIfError))
+}
+
+// usable reports whether the entry can still be served in some way
+func (e *Entry) usable(now time.Time) bool {
+	return now.Before(e.Expires.Add(max(e.StaleWhileRevalidate, e.StaleIfError)))
+}
+
+// size is how much memory the entry is accounted for under key
+func (e *Entry) size(key string) int64 {
+	n := len(key) + len(e.Body) + len(e.Path) + entryOverhead
+	for _, name := range e.Vary {
+		n += len(name)
+	}
+	for _, tag := range e.Tags {
+		n += len(tag)
+	}
+	for name, values := range e.Header {
+		n += len(name)
+		for _, value := range values {
+			n += len(value)
+		}
+	}
+	return int64(n)
+}
+
+// item is an entry in the LRU list
+type item struct {
+	key   string
+	entry *Entry
+	size  int64
+}
+
+// Cache is an LRU cache of responses bounded by their total size. It is safe
+// for concurrent use.
+type Cache struct {
+	mu      sync.Mutex
+	maxSize int64
+	size    int64
+	lru     *list.List // most recently used first
+	items   map[string]*list.Element
+}
+
+// New creates a cache holding at most maxSize bytes of responses
+func New(maxSize int64) *Cache {
+	return &Cache{maxSize: maxSize, lru: list.New(), items: make(map[string]*list.Element)}
+}
+
+var (
+	defaultMu    sync.RWMutex
+	defaultCache *Cache
+)
+
+// SetDefault sets the process-wide cache of services with cache paths
+func SetDefault(c *Cache) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultCache = c
+}
+
+// Default returns the process-wide cache, if any
+func Default() *Cache {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultCache
+}
+
+// Get returns the entry of key, marking it recently used. Expired entries
+// that can still be served stale are returned too, callers decide whether
+// to use them; the others are removed.
+func (c *Cache) Get(key string) (*Entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*item).entry
+	if !entry.usable(time.Now()) {
+		c.remove(elem)
+		c.report()
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return entry, true
+}
+
+// Set stores entry under key, evicting the least recently used entries
+// until it fits. It reports false for entries larger than the whole cache,
+// which are not stored.
+func (c *Cache) Set(key string, entry *Entry) bool {
+	size := entry.size(key)
+	if size > c.maxSize {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[key]; ok {
+		c.remove(elem)
+	}
+	for c.size+size > c.maxSize {
+		c.remove(c.lru.Back())
+		cacheEvictions.Inc()
+	}
+	c.items[key] = c.lru.PushFront(&item{key: key, entry: entry, size: size})
+	c.size += size
+	c.report()
+	return true
+}
+
+// Delete removes the entry of key, reporting whether there was one
+func (c *Cache) Delete(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		return false
+	}
+	c.remove(elem)
+	c.report()
+	return true
+}
+
+// Purge removes the entries matching match, returning how many there were
+func (c *Cache) Purge(match func(*Entry) bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	purged := 0
+	for elem := c.lru.Front(); elem != nil; {
+		next := elem.Next()
+		if match(elem.Value.(*item).entry) {
+			c.remove(elem)
+			purged++
+		}
+		elem = next
+	}
+	c.report()
+	return purged
+}
+
+// Len returns the number of entries
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// Size returns the size of the entries in bytes
+func (c *Cache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+// remove removes an element, c.mu must be held
+func (c *Cache) remove(elem *list.Element) {
+	it := c.lru.Remove(elem).(*item)
+	delete(c.items, it.key)
+	c.size -= it.size
+}
+
+// report updates the size metrics, c.mu must be held
+func (c *Cache) report() {
+	cacheSize.Set(float64(c.size))
+	cacheEntries.Set(float64(c.lru.Len()))
+}
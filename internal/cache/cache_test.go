@@ -0,0 +1,96 @@
+package cache
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func newEntry(body string) *Entry {
+	return &Entry{Status: 200, Body: []byte(body), Expires: time.Now().Add(time.Minute)}
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	entrySize := newEntry(strings.Repeat("x", 100)).size("a")
+	c := New(3 * entrySize)
+
+	for _, key := range []string{"a", "b", "c"} {
+		if !c.Set(key, newEntry(strings.Repeat("x", 100))) {
+			t.Fatalf("expected %s to be stored", key)
+		}
+	}
+	// a is used, b becomes the least recently used entry
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("expected a to be cached")
+	}
+	evictions := cacheEvictions.Value()
+	c.Set("d", newEntry(strings.Repeat("x", 100)))
+
+	if _, ok := c.Get("b"); ok {
+		t.Error("expected b to be evicted")
+	}
+	for _, key := range []string{"a", "c", "d"} {
+		if _, ok := c.Get(key); !ok {
+			t.Errorf("expected %s to be kept", key)
+		}
+	}
+	if c.Len() != 3 || c.Size() != 3*entrySize {
+		t.Errorf("expected 3 entries of %d bytes, got %d entries of %d bytes", 3*entrySize, c.Len(), c.Size())
+	}
+	if n := cacheEvictions.Value() - evictions; n != 1 {
+		t.Errorf("expected 1 eviction to be counted, got %v", n)
+	}
+}
+
+func TestCacheStaysWithinItsSize(t *testing.T) {
+	c := New(4096)
+	for i := range 100 {
+		c.Set(strings.Repeat("k", i+1), newEntry(strings.Repeat("x", i*10)))
+		if c.Size() > 4096 {
+			t.Fatalf("cache grew to %d bytes", c.Size())
+		}
+	}
+
+	if c.Set("large", newEntry(strings.Repeat("x", 4096))) {
+		t.Error("expected an entry larger than the cache not to be stored")
+	}
+	if _, ok := c.Get("large"); ok {
+		t.Error("expected the large entry not to be cached")
+	}
+}
+
+func TestCacheReplacesAndDeletes(t *testing.T) {
+	c := New(1 << 20)
+	c.Set("a", newEntry("first"))
+	c.Set("a", newEntry("second"))
+	if entry, _ := c.Get("a"); string(entry.Body) != "second" || c.Len() != 1 {
+		t.Errorf("expected a to be replaced, got %q with %d entries", entry.Body, c.Len())
+	}
+	if !c.Delete("a") || c.Delete("a") {
+		t.Error("expected a to be deleted once")
+	}
+	if c.Len() != 0 || c.Size() != 0 {
+		t.Errorf("expected an empty cache, got %d entries of %d bytes", c.Len(), c.Size())
+	}
+}
+
+func TestCacheDropsEntriesPastTheirStaleWindows(t *testing.T) {
+	c := New(1 << 20)
+	expired := time.Now().Add(-time.Minute)
+	c.Set("expired", &Entry{Status: 200, Expires: expired})
+	c.Set("revalidating", &Entry{Status: 200, Expires: expired, StaleWhileRevalidate: time.Hour})
+	c.Set("on error", &Entry{Status: 200, Expires: expired, StaleWhileRevalidate: time.Second, StaleIfError: time.Hour})
+
+	if _, ok := c.Get("expired"); ok {
+		t.Error("expected an expired entry without stale windows to be dropped")
+	}
+	if entry, ok := c.Get("revalidating"); !ok || entry.Fresh(time.Now()) || !entry.Revalidating(time.Now()) {
+		t.Error("expected a stale entry to be returned while it can be revalidated")
+	}
+	if entry, ok := c.Get("on error"); !ok || entry.Revalidating(time.Now()) || !entry.ServableOnError(time.Now()) {
+		t.Error("expected a stale entry to be returned while it can be served on errors")
+	}
+	if c.Len() != 2 {
+		t.Errorf("expected 2 entries left, got %d", c.Len())
+	}
+}
@@ -0,0 +1,91 @@
+package cache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Directives are the Cache-Control directives of a message by lowercase
+// name, with their value or empty
+type Directives map[string]string
+
+// ParseCacheControl parses the Cache-Control headers of a message
+func ParseCacheControl(header http.Header) Directives {
+	directives := make(Directives)
+	for _, value := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			if name == "" {
+				continue
+			}
+			directives[strings.ToLower(name)] = strings.Trim(arg, `"`)
+		}
+	}
+	return directives
+}
+
+// Has reports whether the directive is set
+func (d Directives) Has(name string) bool {
+	_, ok := d[name]
+	return ok
+}
+
+// Seconds returns the value of a directive in seconds, such as max-age,
+// reporting false if it is not set or not a number
+func (d Directives) Seconds(name string) (time.Duration, bool) {
+	value, ok := d[name]
+	if !ok {
+		return 0, false
+	}
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+// Shareable reports whether a shared cache may keep a response at all: not
+// with no-store, or with private, which is for a single user
+func Shareable(header http.Header) bool {
+	d := ParseCacheControl(header)
+	return !d.Has("no-store") && !d.Has("private")
+}
+
+// Storable reports whether a shared cache may store a response: only if it
+// is Shareable and has no no-cache, which requires asking the upstream for
+// every request
+func Storable(header http.Header) bool {
+	return Shareable(header) && !ParseCacheControl(header).Has("no-cache")
+}
+
+// Lifetime returns how long a response received at now stays fresh by its
+// headers: s-maxage, max-age or Expires, less the Age it already had
+// upstream. It reports false if none of them is set.
+func Lifetime(header http.Header, now time.Time) (time.Duration, bool) {
+	d := ParseCacheControl(header)
+	lifetime, ok := d.Seconds("s-maxage")
+	if !ok {
+		lifetime, ok = d.Seconds("max-age")
+	}
+	if !ok && header.Get("Expires") != "" {
+		// invalid dates such as 0 mean already expired
+		ok = true
+		if expires, err := http.ParseTime(header.Get("Expires")); err == nil {
+			date, err := http.ParseTime(header.Get("Date"))
+			if err != nil {
+				date = now
+			}
+			lifetime = expires.Sub(date)
+		}
+	}
+	if !ok {
+		return 0, false
+	}
+
+	if age, err := strconv.ParseInt(header.Get("Age"), 10, 64); err == nil && age > 0 {
+		lifetime -= time.Duration(age) * time.Second
+	}
+	return max(lifetime, 0), true
+}
@@ -0,0 +1,90 @@
+package cache
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestStorable(t *testing.T) {
+	tests := []struct {
+		cacheControl string
+		want         bool
+	}{
+		{"", true},
+		{"public, max-age=60", true},
+		{"no-store", false},
+		{"private, max-age=60", false},
+		{"No-Cache", false},
+		{`no-cache="Set-Cookie"`, false},
+	}
+	for _, tt := range tests {
+		header := http.Header{}
+		if tt.cacheControl != "" {
+			header.Set("Cache-Control", tt.cacheControl)
+		}
+		if got := Storable(header); got != tt.want {
+			t.Errorf("Storable(%q) = %v, want %v", tt.cacheControl, got, tt.want)
+		}
+	}
+}
+
+func TestShareable(t *testing.T) {
+	tests := []struct {
+		cacheControl string
+		want         bool
+	}{
+		{"", true},
+		{"no-cache", true},
+		{"max-age=0", true},
+		{"No-Store", false},
+		{"public, private", false},
+	}
+	for _, tt := range tests {
+		header := http.Header{}
+		if tt.cacheControl != "" {
+			header.Set("Cache-Control", tt.cacheControl)
+		}
+		if got := Shareable(header); got != tt.want {
+			t.Errorf("Shareable(%q) = %v, want %v", tt.cacheControl, got, tt.want)
+		}
+	}
+}
+
+func TestLifetime(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	date := now.Add(-10 * time.Second).Format(http.TimeFormat)
+	tests := []struct {
+		name   string
+		header http.Header
+		want   time.Duration
+		ok     bool
+	}{
+		{"none", http.Header{}, 0, false},
+		{"max-age", http.Header{"Cache-Control": {"public, max-age=60"}}, time.Minute, true},
+		{"s-maxage first", http.Header{"Cache-Control": {"max-age=60, s-maxage=300"}}, 5 * time.Minute, true},
+		{"quoted", http.Header{"Cache-Control": {`max-age="30"`}}, 30 * time.Second, true},
+		{"invalid max-age", http.Header{"Cache-Control": {"max-age=soon"}}, 0, false},
+		{"age", http.Header{"Cache-Control": {"max-age=60"}, "Age": {"45"}}, 15 * time.Second, true},
+		{"older than max-age", http.Header{"Cache-Control": {"max-age=60"}, "Age": {"90"}}, 0, true},
+		{"expires after date", http.Header{
+			"Date":    {date},
+			"Expires": {now.Add(50 * time.Second).Format(http.TimeFormat)},
+		}, time.Minute, true},
+		{"expires without date", http.Header{"Expires": {now.Add(time.Minute).Format(http.TimeFormat)}}, time.Minute, true},
+		{"max-age over expires", http.Header{
+			"Cache-Control": {"max-age=5"},
+			"Expires":       {now.Add(time.Hour).Format(http.TimeFormat)},
+		}, 5 * time.Second, true},
+		{"invalid expires", http.Header{"Expires": {"0"}}, 0, true},
+		{"expires in the past", http.Header{"Expires": {now.Add(-time.Hour).Format(http.TimeFormat)}}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := Lifetime(tt.header, now)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("Lifetime() = %s, %v, want %s, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
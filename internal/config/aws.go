@@ -0,0 +1,366 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// AWS endpoints used to find credentials when none are set in the
+// environment, variables so tests can serve them
+var (
+	ecsCredentialsHost = "http://169.254.170.2"
+	imdsAddress        = "http://169.254.169.254"
+)
+
+// maxAWSResponseSize caps the AWS responses read, credentials, metadata and
+// secrets are far smaller
+const maxAWSResponseSize = 1 << 20
+
+// awsCredentials are the keys requests are signed with
+type awsCredentials struct {
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	SessionToken    string `json:"Token"`
+}
+
+// awsClient calls AWS JSON APIs signed with Signature Version 4. It talks
+// to the HTTP APIs directly, like the Consul and etcd clients, so no AWS SDK
+// is needed.
+type awsClient struct {
+	region   string
+	creds    awsCredentials
+	endpoint string // AWS_ENDPOINT_URL override, e.g. for LocalStack
+	client   *http.Client
+}
+
+// newAWSClient finds the region and credentials the way the AWS SDKs do:
+// environment variables, then an EKS web identity token (IRSA), then the
+// ECS task role, then the EC2 instance role.
+func newAWSClient(ctx context.Context) (*awsClient, error) {
+	a := &awsClient{
+		region:   getEnv("AWS_REGION", os.Getenv("AWS_DEFAULT_REGION")),
+		endpoint: strings.TrimSuffix(os.Getenv("AWS_ENDPOINT_URL"), "/"),
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+
+	if a.region == "" {
+		region, err := a.imdsGet(ctx, "/latest/meta-data/placement/region")
+		if err != nil {
+			return nil, fmt.Errorf("AWS_REGION is not set and the EC2 instance region is unavailable: %w", err)
+		}
+		a.region = region
+	}
+
+	creds, err := a.loadCredentials(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find AWS credentials: %w", err)
+	}
+	a.creds = creds
+	return a, nil
+}
+
+// loadCredentials returns the first credentials found in the chain
+func (a *awsClient) loadCredentials(ctx context.Context) (awsCredentials, error) {
+	if id := os.Getenv("AWS_ACCESS_KEY_ID"); id != "" {
+		return awsCredentials{
+			AccessKeyID:     id,
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}, nil
+	}
+
+	if tokenFile := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"); tokenFile != "" {
+		return a.assumeRoleWithWebIdentity(ctx, tokenFile)
+	}
+
+	if uri := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); uri != "" {
+		return a.containerCredentials(ctx, ecsCredentialsHost+uri)
+	}
+	if uri := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI"); uri != "" {
+		return a.containerCredentials(ctx, uri)
+	}
+
+	role, err := a.imdsGet(ctx, "/latest/meta-data/iam/security-credentials/")
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("no credentials in the environment and no EC2 instance role: %w", err)
+	}
+	data, err := a.imdsGet(ctx, "/latest/meta-data/iam/security-credentials/"+strings.TrimSpace(role))
+	if err != nil {
+		return awsCredentials{}, err
+	}
+
+	var creds awsCredentials
+	if err := json.Unmarshal([]byte(data), &creds); err != nil {
+		return awsCredentials{}, fmt.Errorf("failed to decode instance role credentials: %w", err)
+	}
+	return creds, nil
+}
+
+// assumeRoleWithWebIdentity exchanges the web identity token for role
+// credentials, the call itself is not signed
+func (a *awsClient) assumeRoleWithWebIdentity(ctx context.Context, tokenFile string) (awsCredentials, error) {
+	token, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("failed to read web identity token: %w", err)
+	}
+
+	sessionName := getEnv("AWS_ROLE_SESSION_NAME", fmt.Sprintf("api-gateway-%d", time.Now().Unix()))
+	params := url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"Version":          {"2011-06-15"},
+		"RoleArn":          {os.Getenv("AWS_ROLE_ARN")},
+		"RoleSessionName":  {sessionName},
+		"WebIdentityToken": {strings.TrimSpace(string(token))},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.serviceEndpoint("sts", a.region),
+		strings.NewReader(params.Encode()))
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("STS request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return awsCredentials{}, fmt.Errorf("STS returned status %d", resp.StatusCode)
+	}
+
+	var out struct {
+		Credentials struct {
+			AccessKeyID     string `xml:"AccessKeyId"`
+			SecretAccessKey string `xml:"SecretAccessKey"`
+			SessionToken    string `xml:"SessionToken"`
+		} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+	}
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, maxAWSResponseSize)).Decode(&out); err != nil {
+		return awsCredentials{}, fmt.Errorf("failed to decode STS response: %w", err)
+	}
+	return awsCredentials{
+		AccessKeyID:     out.Credentials.AccessKeyID,
+		SecretAccessKey: out.Credentials.SecretAccessKey,
+		SessionToken:    out.Credentials.SessionToken,
+	}, nil
+}
+
+// containerCredentials reads the ECS task role (or EKS Pod Identity) credentials
+func (a *awsClient) containerCredentials(ctx context.Context, endpoint string) (awsCredentials, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+
+	auth := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN")
+	if path := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return awsCredentials{}, fmt.Errorf("failed to read container authorization token: %w", err)
+		}
+		auth = strings.TrimSpace(string(data))
+	}
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("container credentials request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return awsCredentials{}, fmt.Errorf("container credentials endpoint returned status %d", resp.StatusCode)
+	}
+
+	var creds awsCredentials
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxAWSResponseSize)).Decode(&creds); err != nil {
+		return awsCredentials{}, fmt.Errorf("failed to decode container credentials: %w", err)
+	}
+	return creds, nil
+}
+
+// imdsGet reads an EC2 instance metadata path using an IMDSv2 session token
+func (a *awsClient) imdsGet(ctx context.Context, path string) (string, error) {
+	// the metadata service is local, don't wait long when it is not there
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, imdsAddress+"/latest/api/token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "60")
+	token, err := a.readBody(req)
+	if err != nil {
+		return "", err
+	}
+
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet, imdsAddress+path, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token", token)
+	return a.readBody(req)
+}
+
+// readBody sends an instance metadata request and returns the response body
+func (a *awsClient) readBody(req *http.Request) (string, error) {
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("instance metadata request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("instance metadata service returned status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxAWSResponseSize+1))
+	if err != nil {
+		return "", err
+	}
+	if len(data) > maxAWSResponseSize {
+		return "", fmt.Errorf("instance metadata response exceeds %d bytes", maxAWSResponseSize)
+	}
+	return string(data), nil
+}
+
+// call invokes a JSON API action, e.g. secretsmanager.GetSecretValue, in
+// the given region
+func (a *awsClient) call(ctx context.Context, service, region, target string, in, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.serviceEndpoint(service, region), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", target)
+	a.sign(req, body, service, region, time.Now().UTC())
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s request failed: %w", service, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		_ = json.NewDecoder(io.LimitReader(resp.Body, maxAWSResponseSize)).Decode(&apiErr)
+		// the type may be prefixed with a namespace, e.g. com.amazon...#ResourceNotFoundException
+		if i := strings.LastIndex(apiErr.Type, "#"); i >= 0 {
+			apiErr.Type = apiErr.Type[i+1:]
+		}
+		return fmt.Errorf("%s returned status %d: %s %s", service, resp.StatusCode, apiErr.Type, apiErr.Message)
+	}
+
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxAWSResponseSize)).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode %s response: %w", service, err)
+	}
+	return nil
+}
+
+// serviceEndpoint returns the regional endpoint URL of a service
+func (a *awsClient) serviceEndpoint(service, region string) string {
+	if a.endpoint != "" {
+		return a.endpoint + "/"
+	}
+	if region == "" {
+		return "https://" + service + ".amazonaws.com/"
+	}
+	return "https://" + service + "." + region + ".amazonaws.com/"
+}
+
+// sign adds a Signature Version 4 Authorization header to req
+func (a *awsClient) sign(req *http.Request, body []byte, service, region string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := amzDate[:8]
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if a.creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", a.creds.SessionToken)
+	}
+
+	// sign the host and all X-Amz-* headers, sorted by lowercase name
+	headers := map[string]string{"host": req.URL.Host}
+	names := []string{"host"}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if lower == "content-type" || strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+			names = append(names, lower)
+		}
+	}
+	slices.Sort(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		sha256Hex(body),
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := signingKey(a.creds.SecretAccessKey, date, region, service)
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		a.creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// signingKey derives the Signature Version 4 key of a day, region and service
+func signingKey(secret, date, region, service string) []byte {
+	key := hmacSHA256([]byte("AWS4"+secret), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	return hmacSHA256(key, "aws4_request")
+}
+
+// sha256Hex returns the hex encoded SHA-256 hash of data
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data with key
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,320 @@
+package config
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clearAWSEnv unsets the environment variables the credential chain reads
+func clearAWSEnv(t *testing.T) {
+	t.Helper()
+	for _, name := range []string{
+		"AWS_REGION", "AWS_DEFAULT_REGION", "AWS_ENDPOINT_URL",
+		"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN",
+		"AWS_WEB_IDENTITY_TOKEN_FILE", "AWS_ROLE_ARN", "AWS_ROLE_SESSION_NAME",
+		"AWS_CONTAINER_CREDENTIALS_RELATIVE_URI", "AWS_CONTAINER_CREDENTIALS_FULL_URI",
+		"AWS_CONTAINER_AUTHORIZATION_TOKEN", "AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE",
+	} {
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+}
+
+// TestAWSSign checks requests against the AWS Signature Version 4 test suite
+func TestAWSSign(t *testing.T) {
+	a := &awsClient{creds: awsCredentials{
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	}}
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+
+	tests := []struct {
+		name          string
+		method        string
+		body          string
+		contentType   string
+		signedHeaders string
+		signature     string
+	}{
+		{
+			name:          "get-vanilla",
+			method:        http.MethodGet,
+			signedHeaders: "host;x-amz-date",
+			signature:     "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			name:          "post-vanilla",
+			method:        http.MethodPost,
+			signedHeaders: "host;x-amz-date",
+			signature:     "5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b",
+		},
+		{
+			name:          "post-x-www-form-urlencoded",
+			method:        http.MethodPost,
+			body:          "Param1=value1",
+			contentType:   "application/x-www-form-urlencoded",
+			signedHeaders: "content-type;host;x-amz-date",
+			signature:     "ff11897932ad3f4e8b18135d722051e5ac45fc38421b1da7b9d196a0fe09473a",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "https://example.amazonaws.com/", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			a.sign(req, []byte(tt.body), "service", "us-east-1", now)
+
+			want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+				"SignedHeaders=" + tt.signedHeaders + ", Signature=" + tt.signature
+			if got := req.Header.Get("Authorization"); got != want {
+				t.Errorf("expected Authorization %q, got %q", want, got)
+			}
+			if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+				t.Errorf("expected X-Amz-Date 20150830T123600Z, got %q", got)
+			}
+		})
+	}
+}
+
+func TestAWSSignSessionToken(t *testing.T) {
+	a := &awsClient{creds: awsCredentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret", SessionToken: "session"}}
+	req := httptest.NewRequest(http.MethodPost, "https://sts.amazonaws.com/", nil)
+	a.sign(req, nil, "sts", "us-east-1", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+
+	if got := req.Header.Get("X-Amz-Security-Token"); got != "session" {
+		t.Errorf("expected the session token header, got %q", got)
+	}
+	if got := req.Header.Get("Authorization"); !strings.Contains(got, "SignedHeaders=host;x-amz-date;x-amz-security-token,") {
+		t.Errorf("expected the session token to be signed, got %q", got)
+	}
+}
+
+// TestAWSSigningKey checks the key derivation example of the AWS docs
+func TestAWSSigningKey(t *testing.T) {
+	key := signingKey("wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "20120215", "us-east-1", "iam")
+	want := "f4780e2d9f65fa895f9c67b32ce1baf0b0d8a43505a000a1a9e090d414db404d"
+	if got := hex.EncodeToString(key); got != want {
+		t.Errorf("expected signing key %s, got %s", want, got)
+	}
+}
+
+func TestAWSCredentialsFromEnv(t *testing.T) {
+	clearAWSEnv(t)
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_SESSION_TOKEN", "session")
+
+	a := &awsClient{client: http.DefaultClient}
+	creds, err := a.loadCredentials(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := awsCredentials{AccessKeyID: "AKID", SecretAccessKey: "secret", SessionToken: "session"}
+	if creds != want {
+		t.Errorf("expected %+v, got %+v", want, creds)
+	}
+}
+
+func TestAWSCredentialsFromWebIdentity(t *testing.T) {
+	clearAWSEnv(t)
+	sts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("failed to parse STS request: %v", err)
+		}
+		if r.Method != http.MethodPost || r.Form.Get("Action") != "AssumeRoleWithWebIdentity" ||
+			r.Form.Get("RoleArn") != "arn:aws:iam::123456789012:role/gateway" ||
+			r.Form.Get("RoleSessionName") != "gateway" || r.Form.Get("WebIdentityToken") != "web-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, `<AssumeRoleWithWebIdentityResponse>
+  <AssumeRoleWithWebIdentityResult>
+    <Credentials>
+      <AccessKeyId>ASIAWEB</AccessKeyId>
+      <SecretAccessKey>web-secret</SecretAccessKey>
+      <SessionToken>web-session</SessionToken>
+    </Credentials>
+  </AssumeRoleWithWebIdentityResult>
+</AssumeRoleWithWebIdentityResponse>`)
+	}))
+	defer sts.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("web-token\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AWS_WEB_IDENTITY_TOKEN_FILE", tokenFile)
+	t.Setenv("AWS_ROLE_ARN", "arn:aws:iam::123456789012:role/gateway")
+	t.Setenv("AWS_ROLE_SESSION_NAME", "gateway")
+
+	a := &awsClient{region: "us-east-1", endpoint: sts.URL, client: sts.Client()}
+	creds, err := a.loadCredentials(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := awsCredentials{AccessKeyID: "ASIAWEB", SecretAccessKey: "web-secret", SessionToken: "web-session"}
+	if creds != want {
+		t.Errorf("expected %+v, got %+v", want, creds)
+	}
+
+	t.Setenv("AWS_ROLE_ARN", "arn:aws:iam::123456789012:role/other")
+	if _, err := a.loadCredentials(context.Background()); err == nil {
+		t.Error("expected an error when STS rejects the token")
+	}
+}
+
+func TestAWSCredentialsFromContainer(t *testing.T) {
+	container := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/credentials/task" || r.Header.Get("Authorization") != "pod-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"AccessKeyId":"ASIATASK","SecretAccessKey":"task-secret","Token":"task-session","Expiration":"2030-01-01T00:00:00Z"}`)
+	}))
+	defer container.Close()
+	want := awsCredentials{AccessKeyID: "ASIATASK", SecretAccessKey: "task-secret", SessionToken: "task-session"}
+
+	t.Run("ECS relative URI", func(t *testing.T) {
+		clearAWSEnv(t)
+		host := ecsCredentialsHost
+		ecsCredentialsHost = container.URL
+		defer func() { ecsCredentialsHost = host }()
+		t.Setenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI", "/v2/credentials/task")
+		t.Setenv("AWS_CONTAINER_AUTHORIZATION_TOKEN", "pod-token")
+
+		a := &awsClient{client: container.Client()}
+		creds, err := a.loadCredentials(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if creds != want {
+			t.Errorf("expected %+v, got %+v", want, creds)
+		}
+	})
+
+	t.Run("EKS Pod Identity full URI", func(t *testing.T) {
+		clearAWSEnv(t)
+		tokenFile := filepath.Join(t.TempDir(), "token")
+		if err := os.WriteFile(tokenFile, []byte("pod-token\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv("AWS_CONTAINER_CREDENTIALS_FULL_URI", container.URL+"/v2/credentials/task")
+		t.Setenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE", tokenFile)
+
+		a := &awsClient{client: container.Client()}
+		creds, err := a.loadCredentials(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if creds != want {
+			t.Errorf("expected %+v, got %+v", want, creds)
+		}
+	})
+
+	t.Run("rejected token", func(t *testing.T) {
+		clearAWSEnv(t)
+		t.Setenv("AWS_CONTAINER_CREDENTIALS_FULL_URI", container.URL+"/v2/credentials/task")
+		t.Setenv("AWS_CONTAINER_AUTHORIZATION_TOKEN", "wrong")
+
+		a := &awsClient{client: container.Client()}
+		if _, err := a.loadCredentials(context.Background()); err == nil {
+			t.Error("expected an error when the endpoint rejects the token")
+		}
+	})
+}
+
+// imdsHandler serves the IMDSv2 region and instance role credentials,
+// paths are answered only with the session token
+func imdsHandler(role string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/latest/api/token" {
+			if r.Method != http.MethodPut || r.Header.Get("X-aws-ec2-metadata-token-ttl-seconds") == "" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			fmt.Fprint(w, "imds-token")
+			return
+		}
+		if r.Header.Get("X-aws-ec2-metadata-token") != "imds-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/latest/meta-data/placement/region":
+			fmt.Fprint(w, "eu-west-1")
+		case "/latest/meta-data/iam/security-credentials/":
+			fmt.Fprint(w, role+"\n")
+		case "/latest/meta-data/iam/security-credentials/" + role:
+			fmt.Fprint(w, `{"Code":"Success","AccessKeyId":"ASIAEC2","SecretAccessKey":"ec2-secret","Token":"ec2-session"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+}
+
+// newIMDS points the instance metadata address at handler
+func newIMDS(t *testing.T, handler http.Handler) {
+	t.Helper()
+	imds := httptest.NewServer(handler)
+	t.Cleanup(imds.Close)
+
+	address := imdsAddress
+	imdsAddress = imds.URL
+	t.Cleanup(func() { imdsAddress = address })
+}
+
+func TestAWSCredentialsFromIMDS(t *testing.T) {
+	clearAWSEnv(t)
+	newIMDS(t, imdsHandler("gateway-role"))
+
+	a, err := newAWSClient(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if a.region != "eu-west-1" {
+		t.Errorf("expected the instance region eu-west-1, got %q", a.region)
+	}
+	want := awsCredentials{AccessKeyID: "ASIAEC2", SecretAccessKey: "ec2-secret", SessionToken: "ec2-session"}
+	if a.creds != want {
+		t.Errorf("expected %+v, got %+v", want, a.creds)
+	}
+}
+
+func TestAWSCredentialsFromIMDSWithoutRole(t *testing.T) {
+	clearAWSEnv(t)
+	t.Setenv("AWS_REGION", "us-east-1")
+	newIMDS(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/latest/api/token" {
+			fmt.Fprint(w, "imds-token")
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+
+	if _, err := newAWSClient(context.Background()); err == nil {
+		t.Error("expected an error without an instance role")
+	}
+}
+
+func TestAWSReadBodyLimit(t *testing.T) {
+	clearAWSEnv(t)
+	t.Setenv("AWS_REGION", "us-east-1")
+	newIMDS(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("a", maxAWSResponseSize+1)))
+	}))
+
+	_, err := newAWSClient(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "exceeds") {
+		t.Errorf("expected an oversized metadata response to be rejected, got %v", err)
+	}
+}
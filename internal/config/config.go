@@ -1,129 +1,1972 @@
 package config
 
 import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net/netip"
+	"net/url"
 	"os"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
 )
 
+// DefaultTargetName is the name of the target created from the legacy
+// single-backend PROXY_TARGET_URL. It is served at the root path.
+const DefaultTargetName = "default"
+
+// MockURL declares a target as a mock, answering requests with the canned
+// responses of its configuration instead of forwarding them
+const MockURL = "mock"
+
 // Config holds all application configuration.
 type Config struct {
-	Server ServerConfig
-	CORS   CORSConfig
-	JWT    JWTConfig
-	Proxy  ProxyConfig
-	Log    LogConfig
+	Server      ServerConfig         `yaml:"server"`
+	CORS        CORSConfig           `yaml:"cors"`
+	JWT         JWTConfig            `yaml:"jwt"`
+	Proxy       ProxyConfig          `yaml:"proxy"`
+	Log         LogConfig            `yaml:"log"`
+	Request     RequestConfig        `yaml:"request"`
+	Admin       AdminConfig          `yaml:"admin"`
+	Metrics     MetricsConfig        `yaml:"metrics"`
+	Cost        CostReportConfig     `yaml:"cost_report"`
+	Concurrency ConcurrencyConfig    `yaml:"concurrency"`
+	Fault       FaultInjectionConfig `yaml:"fault_injection"`
+	Errors      ErrorReportingConfig `yaml:"error_reporting"`
+	Discovery   DiscoveryConfig      `yaml:"discovery"`
+	Idempotency IdempotencyConfig    `yaml:"idempotency"`
+	Cache       CacheConfig          `yaml:"cache"`
+	Bandwidth   BandwidthConfig      `yaml:"bandwidth"`
+	RateLimit   RateLimitConfig      `yaml:"rate_limit"`
+	Aggregation AggregationConfig    `yaml:"aggregation"`
+	Static      StaticConfig         `yaml:"static"`
+	Redirects   []RedirectRule       `yaml:"redirects"`
+	// ErrorPages holds the template files of errors the gateway answers
+	// itself by status, e.g. 502 when an upstream is unreachable
+	ErrorPages map[int]string `yaml:"error_pages"`
+
+	// KV is where the config was loaded from, it cannot be set in the config itself
+	KV KVConfig `yaml:"-"`
 }
 
 // ServerConfig holds server-specific configuration.
 type ServerConfig struct {
-	Host         string
-	Port         int
-	ReadTimeout  time.Duration
-	WriteTimeout time.Duration
-	IdleTimeout  time.Duration
+	Host         string        `yaml:"host"`
+	Port         int           `yaml:"port"`
+	ReadTimeout  time.Duration `yaml:"read_timeout"`
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+	IdleTimeout  time.Duration `yaml:"idle_timeout"`
+	TLS          TLSConfig     `yaml:"tls"`
+
+	// TrustedProxies lists the IP addresses and CIDR ranges of proxies in
+	// front of the gateway. X-Forwarded-For and related headers are only
+	// honored on connections from them, other clients could forge them.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+}
+
+// TLSConfig holds the gateway's HTTPS settings, plain HTTP is served
+// without a certificate.
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+
+	// ClientCAFile holds the CAs verifying client certificates of services
+	// with mtls auth. Certificates are requested but not required, so other
+	// services stay reachable without one.
+	ClientCAFile string `yaml:"client_ca_file"`
 }
 
 // CORSConfig holds CORS-specific configuration.
 type CORSConfig struct {
-	AllowedOrigins   []string
-	AllowedMethods   []string
-	AllowedHeaders   []string
-	AllowCredentials bool
-	MaxAge           int
+	AllowedOrigins   []string `yaml:"allowed_origins"`
+	AllowedMethods   []string `yaml:"allowed_methods"`
+	AllowedHeaders   []string `yaml:"allowed_headers"`
+	AllowCredentials bool     `yaml:"allow_credentials"`
+	MaxAge           int      `yaml:"max_age"`
 }
 
 // JWTConfig holds JWT-specific configuration.
 type JWTConfig struct {
-	Secret     string
-	Issuer     string
-	Audience   string
-	Expiration time.Duration
+	Secret     string        `yaml:"secret"`
+	Issuer     string        `yaml:"issuer"`
+	Audience   string        `yaml:"audience"`
+	Expiration time.Duration `yaml:"expiration"`
+	Leeway     time.Duration `yaml:"leeway"` // clock skew tolerated when checking exp, nbf and iat
+
+	// KeyID is the kid header of tokens signed with Secret, Keys are older
+	// secrets by kid that still verify tokens during a key rotation
+	KeyID string            `yaml:"key_id"`
+	Keys  map[string]string `yaml:"keys"`
+
+	// DebugEndpoint serves /auth/debug, which explains why a token is
+	// rejected. For development only, never enable it in production.
+	DebugEndpoint bool `yaml:"debug_endpoint"`
+
+	RefreshEndpoint bool          `yaml:"refresh_endpoint"` // serve /auth/refresh
+	RefreshWindow   time.Duration `yaml:"refresh_window"`   // how long after expiry a token can be refreshed, 0 for no limit
+
+	// RevocationStore keeps revoked tokens: "memory", "redis" or empty to
+	// disable revocation checks
+	RevocationStore string `yaml:"revocation_store"`
+	RedisURL        string `yaml:"redis_url"` // redis://[:password@]host:port[/db] for the redis store
+
+	// TokenFormat is "jwt" (default) or "paseto" for PASETO v4.public tokens
+	// verified with PasetoPublicKeys, the JWT secrets are unused then
+	TokenFormat      string   `yaml:"token_format"`
+	PasetoPublicKeys []string `yaml:"paseto_public_keys"` // hex-encoded Ed25519 public keys
+
+	// TrustedIssuers are accepted in addition to Issuer, each verified with
+	// its own secret, e.g. a legacy identity provider during a migration
+	TrustedIssuers []TrustedIssuerConfig `yaml:"trusted_issuers"`
+
+	// SessionCookie serves /auth/session, which exchanges a bearer token for
+	// an encrypted HttpOnly cookie accepted in place of the Authorization
+	// header, so browser apps need not keep tokens in JavaScript
+	SessionCookie SessionCookieConfig `yaml:"session_cookie"`
+}
+
+// SessionCookieConfig holds the settings of encrypted session cookies.
+type SessionCookieConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	Secret   string `yaml:"secret"` // encryption secret, at least 32 characters
+	Name     string `yaml:"name"`
+	Domain   string `yaml:"domain"`    // empty for the gateway host only
+	Secure   bool   `yaml:"secure"`    // only send the cookie over HTTPS
+	SameSite string `yaml:"same_site"` // strict, lax or none
+
+	// CSRFCookieName is the cookie holding the session's CSRF token, readable
+	// by JavaScript so it can be echoed in the X-CSRF-Token header
+	CSRFCookieName string `yaml:"csrf_cookie_name"`
+	// CSRFExemptPaths need no CSRF token, exact paths or prefixes ending in /*
+	CSRFExemptPaths []string `yaml:"csrf_exempt_paths"`
+}
+
+// TrustedIssuerConfig is another JWT issuer whose tokens are accepted.
+type TrustedIssuerConfig struct {
+	Issuer    string            `yaml:"issuer"`
+	Secret    string            `yaml:"secret"`
+	Keys      map[string]string `yaml:"keys"`      // secrets by kid, for issuers that rotate keys
+	Audiences []string          `yaml:"audiences"` // accepted audiences, empty accepts the gateway's audience
+}
+
+// ProxyConfig holds proxy-specific configuration.
+type ProxyConfig struct {
+	Targets map[string]TargetConfig `yaml:"targets"`
+	Timeout time.Duration           `yaml:"timeout"`
+	Backoff BackoffConfig           `yaml:"backoff"`
+	Pool    PoolConfig              `yaml:"pool"`
+
+	// DNSRefresh re-resolves upstream hostnames this often and rotates
+	// connections when their addresses change, 0 disables it
+	DNSRefresh time.Duration `yaml:"dns_refresh"`
+
+	// MaxResponseSize is the largest upstream response body in bytes passed
+	// to clients, 0 is unlimited
+	MaxResponseSize int64 `yaml:"max_response_size"`
+
+	// RequestTimeout is the time a request may take in the gateway, from
+	// authentication to the end of the response, 0 disables it
+	RequestTimeout time.Duration `yaml:"request_timeout"`
+
+	// DeadlineHeader tells upstreams the time left of the request in this
+	// header, e.g. X-Request-Timeout in milliseconds or grpc-timeout in the
+	// gRPC format, empty sends none
+	DeadlineHeader string `yaml:"deadline_header"`
+}
+
+// PoolConfig holds upstream connection pool settings. Each target gets its
+// own http.Transport built from these settings.
+type PoolConfig struct {
+	MaxIdleConns        int           `yaml:"max_idle_conns"`          // max idle connections per target
+	MaxIdleConnsPerHost int           `yaml:"max_idle_conns_per_host"` // max idle connections per upstream host
+	MaxConnsPerHost     int           `yaml:"max_conns_per_host"`      // max connections per upstream host, 0 is unlimited
+	IdleConnTimeout     time.Duration `yaml:"idle_conn_timeout"`       // how long idle connections are kept
+	TLSHandshakeTimeout time.Duration `yaml:"tls_handshake_timeout"`
+	DialTimeout         time.Duration `yaml:"dial_timeout"`
+	KeepAlive           time.Duration `yaml:"keep_alive"` // TCP keep-alive period
+
+	ExpectContinueTimeout time.Duration `yaml:"expect_continue_timeout"` // wait for 100 Continue before sending a body, 0 sends it at once
+	ResponseHeaderTimeout time.Duration `yaml:"response_header_timeout"` // wait for response headers after sending a request, 0 waits up to the proxy timeout
+}
+
+// BackoffConfig holds adaptive backoff configuration applied when a
+// backend signals overload with 429 or 503 responses.
+type BackoffConfig struct {
+	Enabled      bool          `yaml:"enabled"`
+	DefaultDelay time.Duration `yaml:"default_delay"` // used when Retry-After is missing or invalid
+	MaxDelay     time.Duration `yaml:"max_delay"`     // upper bound for a single backoff window
+	MaxWait      time.Duration `yaml:"max_wait"`      // how long a request may queue before being rejected
+}
+
+// TargetConfig holds configuration for a single proxy target.
+type TargetConfig struct {
+	URL          string            `yaml:"url"`
+	Endpoints    []string          `yaml:"endpoints,omitempty"` // additional upstream URLs, requests are balanced across URL and these
+	Backups      []string          `yaml:"backups,omitempty"`   // upstream URLs only used while URL and all endpoints are unhealthy
+	Weights      map[string]int    `yaml:"weights,omitempty"`   // relative share of requests by upstream URL, 1 for URLs not listed
+	Balancer     string            `yaml:"balancer,omitempty"`  // round-robin (default), least-connections or least-latency
+	Affinity     AffinityConfig    `yaml:"affinity,omitempty"`
+	HealthCheck  HealthCheckConfig `yaml:"health_check,omitempty"`
+	Outliers     OutlierConfig     `yaml:"outliers,omitempty"`
+	SlowStart    time.Duration     `yaml:"slow_start,omitempty"`   // time upstreams ramp up to their share of requests after becoming healthy again, 0 disables
+	OpenAPISpec  string            `yaml:"openapi_spec,omitempty"` // optional path to an OpenAPI 3 spec used for request validation
+	Labels       RouteLabels       `yaml:"labels,omitempty"`
+	MaxInFlight  int               `yaml:"max_in_flight,omitempty"`  // per-service concurrency limit, 0 uses the default
+	Timeout      time.Duration     `yaml:"timeout,omitempty"`        // per-service proxy timeout, 0 uses the proxy timeout
+	MaxConns     int               `yaml:"max_conns,omitempty"`      // per-service MaxConnsPerHost override, 0 uses the pool default
+	MaxIdleConns int               `yaml:"max_idle_conns,omitempty"` // per-service MaxIdleConnsPerHost override, 0 uses the pool default
+	Transport    TransportConfig   `yaml:"transport,omitempty"`
+	WebSocket    WebSocketConfig   `yaml:"websocket,omitempty"`
+	DNSRefresh   time.Duration     `yaml:"dns_refresh,omitempty"` // re-resolve the upstream host this often, 0 uses the proxy default
+	Fault        FaultConfig       `yaml:"fault,omitempty"`
+	Auth         string            `yaml:"auth,omitempty"` // jwt (default), api-key, hmac, basic, mtls or none
+	APIKey       APIKeyConfig      `yaml:"api_key,omitempty"`
+	HMAC         HMACConfig        `yaml:"hmac,omitempty"`
+	Basic        BasicAuthConfig   `yaml:"basic,omitempty"`
+	MTLS         MTLSConfig        `yaml:"mtls,omitempty"`
+
+	// MaxResponseSize is the largest response body in bytes, 0 uses the
+	// proxy default. BufferResponses reads responses completely before
+	// sending them, so oversized ones fail cleanly instead of being cut off.
+	MaxResponseSize int64 `yaml:"max_response_size,omitempty"`
+	BufferResponses bool  `yaml:"buffer_responses,omitempty"`
+
+	// ForwardedHeader sends the standard RFC 7239 Forwarded header to the
+	// upstreams alongside the X-Forwarded headers
+	ForwardedHeader bool `yaml:"forwarded_header,omitempty"`
+
+	// PreserveHost sends the Host header of the client's request to the
+	// upstreams instead of their own host, for backends resolving tenants
+	// or virtual hosts by Host
+	PreserveHost bool `yaml:"preserve_host,omitempty"`
+
+	// RewriteLocation adds the path prefix stripped from requests, e.g.
+	// /crm, to Location headers of redirects to the upstream's own paths
+	RewriteLocation bool `yaml:"rewrite_location,omitempty"`
+
+	// RewriteBodyURLs replaces absolute URLs of the upstreams in JSON and
+	// HTML response bodies, e.g. links to http://crm:9001/contacts/5, by the
+	// gateway's public URL
+	RewriteBodyURLs bool `yaml:"rewrite_body_urls,omitempty"`
+
+	// PrefixMode controls the service prefix, e.g. /crm, of forwarded paths:
+	// strip (default) removes it, keep forwards paths verbatim and replace
+	// forwards them under ReplacePrefix instead, e.g. /api/v1
+	PrefixMode    string `yaml:"prefix_mode,omitempty"`
+	ReplacePrefix string `yaml:"replace_prefix,omitempty"`
+
+	// ResponseBody edits the JSON response bodies of the service
+	ResponseBody ResponseBodyConfig `yaml:"response_body,omitempty"`
+
+	// RequestTemplates rebuild the JSON bodies of matching requests in the
+	// shape the upstream expects
+	RequestTemplates []RequestTemplate `yaml:"request_templates,omitempty"`
+
+	// PublicPaths are served without authentication, gateway paths or
+	// prefixes ending in /*, e.g. /crm/public/*
+	PublicPaths []string `yaml:"public_paths,omitempty"`
+
+	// SingleUsePaths accept each JWT only once, gateway paths or prefixes
+	// ending in /*, e.g. /billing/payments/*
+	SingleUsePaths []string `yaml:"single_use_paths,omitempty"`
+
+	// IdempotencyPaths replay the stored response to POST, PUT and PATCH
+	// requests retried with the same Idempotency-Key header, gateway paths
+	// or prefixes ending in /*, e.g. /billing/payments/*
+	IdempotencyPaths []string `yaml:"idempotency_paths,omitempty"`
+
+	// CoalescePaths collapse identical concurrent GET requests into one
+	// upstream request, gateway paths or prefixes ending in /*
+	CoalescePaths []string `yaml:"coalesce_paths,omitempty"`
+
+	// Cache answers repeated GET requests from cached responses
+	Cache TargetCacheConfig `yaml:"cache,omitempty"`
+
+	// GraphQL limits the GraphQL operations sent to the service
+	GraphQL GraphQLConfig `yaml:"graphql,omitempty"`
+
+	// Routes send matching requests to other upstreams than URL
+	Routes []RouteRule `yaml:"routes,omitempty"`
+
+	// Versions route the API versions of the service to their own upstreams
+	Versions VersionConfig `yaml:"versions,omitempty"`
+
+	// RateLimit caps the requests forwarded to the service by all clients
+	// together, for backends that only take so much
+	RateLimit ServiceRateLimitConfig `yaml:"rate_limit,omitempty"`
+
+	// DeadlineHeader replaces the proxy's deadline header for the service,
+	// none disables it
+	DeadlineHeader string `yaml:"deadline_header,omitempty"`
+
+	// RequestTimeout replaces the proxy's request timeout for the service,
+	// RequestTimeouts for gateway paths or prefixes ending in /*, the most
+	// specific one applying
+	RequestTimeout  time.Duration            `yaml:"request_timeout,omitempty"`
+	RequestTimeouts map[string]time.Duration `yaml:"request_timeouts,omitempty"`
+
+	// AdaptiveConcurrency adjusts the service's concurrency limit to the
+	// latency of its upstream, shedding load as soon as it degrades
+	AdaptiveConcurrency AdaptiveConcurrencyConfig `yaml:"adaptive_concurrency,omitempty"`
+
+	// Recording records the exchanges with the service's upstreams to a
+	// file, or answers requests from such a file instead of the upstreams
+	Recording RecordingConfig `yaml:"recording,omitempty"`
+
+	// Mock holds the canned responses of a target whose URL is mock, the
+	// first one matching a request answering it
+	Mock []MockResponse `yaml:"mock,omitempty"`
+}
+
+// validateWeights checks that weights are given for upstreams of the target
+// and that at least one of them receives traffic
+func (t *TargetConfig) validateWeights() error {
+	if len(t.Weights) == 0 {
+		return nil
+	}
+
+	total := 0
+	for _, u := range append([]string{t.URL}, t.Endpoints...) {
+		weight, ok := t.Weights[u]
+		if !ok {
+			weight = 1
+		}
+		total += weight
+	}
+	for u, weight := range t.Weights {
+		if weight < 0 {
+			return fmt.Errorf("weight of %s must not be negative", u)
+		}
+		if u != t.URL && !slices.Contains(t.Endpoints, u) && !slices.Contains(t.Backups, u) {
+			return fmt.Errorf("weight given for %s, which is neither its URL, an endpoint nor a backup", u)
+		}
+	}
+	if total == 0 {
+		return fmt.Errorf("weights must not all be 0")
+	}
+	return nil
+}
+
+// validate checks that fields are paths without empty names and that
+// renamed fields are given a name, not a path
+func (b *ResponseBodyConfig) validate() error {
+	for _, field := range b.RemoveFields {
+		if slices.Contains(strings.Split(field, "."), "") {
+			return fmt.Errorf("invalid response field %q", field)
+		}
+	}
+	for field, to := range b.RenameFields {
+		if slices.Contains(strings.Split(field, "."), "") {
+			return fmt.Errorf("invalid response field %q", field)
+		}
+		if to == "" || strings.Contains(to, ".") {
+			return fmt.Errorf("response field %q must be renamed to a name without dots", field)
+		}
+	}
+	return nil
+}
+
+// TransportConfig overrides the connection behavior of the pool settings
+// for a service's upstreams, e.g. for backends mishandling keep-alive or
+// HTTP/2.
+type TransportConfig struct {
+	DisableKeepAlives     bool          `yaml:"disable_keep_alives,omitempty"`     // open a new connection for every request
+	DisableHTTP2          bool          `yaml:"disable_http2,omitempty"`           // only speak HTTP/1.1, also to TLS upstreams offering HTTP/2
+	ExpectContinueTimeout time.Duration `yaml:"expect_continue_timeout,omitempty"` // 0 uses the pool default
+	ResponseHeaderTimeout time.Duration `yaml:"response_header_timeout,omitempty"` // 0 uses the pool default
+}
+
+// WebSocketConfig limits the WebSocket connections proxied to a service,
+// so a single backend cannot hold all of the gateway's connections.
+type WebSocketConfig struct {
+	MaxConnections int           `yaml:"max_connections,omitempty"` // open connections to the service, 0 is unlimited
+	IdleTimeout    time.Duration `yaml:"idle_timeout,omitempty"`    // close connections without traffic in either direction for this long, 0 disables
+	MaxDuration    time.Duration `yaml:"max_duration,omitempty"`    // close connections open for this long, 0 disables
+}
+
+// ResponseBodyConfig edits the JSON response bodies of a service, e.g. to
+// strip or rename deprecated fields at the edge without a backend release.
+// Fields are dot-separated paths from the root, e.g. user.ssn, and apply to
+// every element of arrays on the way.
+type ResponseBodyConfig struct {
+	RemoveFields []string          `yaml:"remove_fields,omitempty"` // fields deleted from responses
+	RenameFields map[string]string `yaml:"rename_fields,omitempty"` // new name by field, e.g. user.fullName: name
+}
+
+// GraphQLConfig limits the GraphQL operations sent to a service's GraphQL
+// endpoints, so a single query cannot exhaust the backend. Operations are
+// parsed and checked before they are forwarded.
+type GraphQLConfig struct {
+	Paths                []string `yaml:"paths,omitempty"`                 // GraphQL endpoints, gateway paths or prefixes ending in /*, empty disables the checks
+	MaxDepth             int      `yaml:"max_depth,omitempty"`             // deepest nesting of fields, 0 is unlimited
+	MaxComplexity        int      `yaml:"max_complexity,omitempty"`        // fields selected by a request, 0 is unlimited
+	DisableIntrospection bool     `yaml:"disable_introspection,omitempty"` // reject queries for the schema, e.g. in production
+}
+
+// HealthCheckConfig actively checks the health of a service's upstreams.
+// Unhealthy upstreams receive no requests until they pass again, unless all
+// upstreams of the service are unhealthy.
+type HealthCheckConfig struct {
+	Path               string        `yaml:"path,omitempty"`                // path requested on each upstream, empty disables health checks
+	Interval           time.Duration `yaml:"interval,omitempty"`            // time between checks, 0 uses 10s
+	Timeout            time.Duration `yaml:"timeout,omitempty"`             // time a check may take, 0 uses 2s
+	UnhealthyThreshold int           `yaml:"unhealthy_threshold,omitempty"` // consecutive failed checks marking an upstream unhealthy, 0 uses 2
+	HealthyThreshold   int           `yaml:"healthy_threshold,omitempty"`   // consecutive passed checks marking it healthy again, 0 uses 1
+}
+
+// OutlierConfig ejects upstreams of a service from balancing for a while
+// when the responses to proxied requests show they fail or are slow. At
+// least one of ConsecutiveErrors and MaxLatency enables it.
+type OutlierConfig struct {
+	ConsecutiveErrors  int           `yaml:"consecutive_errors,omitempty"`   // failed requests or 5xx responses in a row ejecting an upstream
+	MaxLatency         time.Duration `yaml:"max_latency,omitempty"`          // average time to response headers ejecting an upstream
+	EjectionTime       time.Duration `yaml:"ejection_time,omitempty"`        // time an upstream stays ejected, 0 uses 30s
+	MaxEjectionPercent int           `yaml:"max_ejection_percent,omitempty"` // share of upstreams that may be ejected at once, 0 uses 50
+}
+
+// AffinityConfig keeps sending a client to the same upstream of a service by
+// consistent hashing, for backends holding sessions or caches in memory.
+// Requests without the hashed value are balanced as usual.
+type AffinityConfig struct {
+	By     string `yaml:"by,omitempty"`     // ip, cookie or sub (the authenticated user), empty disables affinity
+	Cookie string `yaml:"cookie,omitempty"` // cookie issued by the gateway to hash by, empty uses gateway_affinity
+}
+
+// VersionConfig routes API versions to upstreams. A request's version is
+// the first path segment below the service prefix, e.g. /crm/v2/users, or
+// else the version in a vendor media type of its Accept header, e.g.
+// application/vnd.api.v2+json.
+type VersionConfig struct {
+	URLs    map[string]string `yaml:"urls,omitempty"`    // upstreams by version, e.g. v1: http://crm-legacy:9001
+	Default string            `yaml:"default,omitempty"` // version of requests naming none, empty uses the service URL
+	Strip   bool              `yaml:"strip,omitempty"`   // remove the version segment from the forwarded path
+}
+
+// RouteRule sends the requests of a service matching its methods and path
+// to another upstream, e.g. only GET /crm/reports/* to a reporting backend.
+type RouteRule struct {
+	Methods []string `yaml:"methods,omitempty"` // empty matches every method
+
+	// Path is a gateway path template such as /crm/reports/* or
+	// /crm/users/{id}/orders, Regex a regular expression the whole gateway
+	// path must match instead
+	Path  string `yaml:"path,omitempty"`
+	Regex string `yaml:"regex,omitempty"`
+
+	URL      string `yaml:"url"`
+	Priority int    `yaml:"priority,omitempty"` // higher priorities are checked first, equal ones most specific path first
+}
+
+// APIKeyConfig holds the static API keys of callers authenticating with a
+// key header.
+type APIKeyConfig struct {
+	Keys   map[string]string `yaml:"keys,omitempty"`   // keys by caller name
+	Header string            `yaml:"header,omitempty"` // header carrying the key, empty uses X-API-Key
+}
+
+// MTLSConfig restricts the client certificates accepted by services with
+// mtls auth.
+type MTLSConfig struct {
+	// Subjects are the accepted common names or DNS names of client
+	// certificates, empty accepts every certificate issued by the client CAs
+	Subjects []string `yaml:"subjects,omitempty"`
+}
+
+// validate checks a route rule
+func (r *RouteRule) validate() error {
+	if !isUpstreamURL(r.URL) {
+		return fmt.Errorf("url must be an absolute URL, got %q", r.URL)
+	}
+	switch {
+	case r.Path != "" && r.Regex != "":
+		return fmt.Errorf("path and regex are mutually exclusive")
+	case r.Regex != "":
+		if _, err := regexp.Compile(r.Regex); err != nil {
+			return fmt.Errorf("invalid regex: %w", err)
+		}
+	case !strings.HasPrefix(r.Path, "/"):
+		return fmt.Errorf("path must start with /, got %q", r.Path)
+	}
+	for _, method := range r.Methods {
+		if method == "" {
+			return fmt.Errorf("methods must not be empty")
+		}
+	}
+	return nil
+}
+
+// RequestTemplate rebuilds the JSON bodies of matching requests from a
+// template, e.g. to adapt the payloads of legacy clients to a new backend
+// contract. The template is a JSON document whose strings of the form
+// "$field" or "$parent.field" are replaced by that field of the request
+// body, "$" by the whole body; fields missing from the body are left out
+// and strings starting with "$$" lose one $.
+type RequestTemplate struct {
+	Methods []string `yaml:"methods,omitempty"` // empty matches every method
+	Path    string   `yaml:"path"`              // gateway path template such as /crm/contacts/*
+
+	// IfField only rebuilds bodies having this field, e.g. one only the old
+	// payload shape has, so clients sending the new shape are not affected
+	IfField string `yaml:"if_field,omitempty"`
+
+	// Template is the JSON template, File a file to read it from instead
+	Template string `yaml:"template,omitempty"`
+	File     string `yaml:"file,omitempty"`
+}
+
+// MockResponse is a canned response of a mock target. Body is a Go
+// text/template rendered with the request's .Method, .Path, path template
+// .Params, .Query, .Header, .Body and its decoded JSON body as .JSON.
+type MockResponse struct {
+	Methods []string          `yaml:"methods,omitempty"` // empty matches every method
+	Path    string            `yaml:"path"`              // gateway path template such as /crm/contacts/{id}
+	Status  int               `yaml:"status,omitempty"`  // 200 if 0
+	Headers map[string]string `yaml:"headers,omitempty"` // Content-Type defaults to JSON for JSON bodies, else text
+	Latency time.Duration     `yaml:"latency,omitempty"` // time taken to answer
+
+	// Body is the body template, File a file to read it from instead
+	Body string `yaml:"body,omitempty"`
+	File string `yaml:"file,omitempty"`
+}
+
+func (m *MockResponse) validate() error {
+	if !strings.HasPrefix(m.Path, "/") {
+		return fmt.Errorf("path must start with /, got %q", m.Path)
+	}
+	for _, method := range m.Methods {
+		if method == "" {
+			return fmt.Errorf("methods must not be empty")
+		}
+	}
+	if m.Status != 0 && (m.Status < 200 || m.Status > 599) {
+		return fmt.Errorf("status must be between 200 and 599, got %d", m.Status)
+	}
+	if m.Latency < 0 {
+		return fmt.Errorf("latency must not be negative")
+	}
+	if m.Body != "" && m.File != "" {
+		return fmt.Errorf("body and file are mutually exclusive")
+	}
+	return nil
+}
+
+func (t *RequestTemplate) validate() error {
+	if !strings.HasPrefix(t.Path, "/") {
+		return fmt.Errorf("path must start with /, got %q", t.Path)
+	}
+	for _, method := range t.Methods {
+		if method == "" {
+			return fmt.Errorf("methods must not be empty")
+		}
+	}
+	switch {
+	case t.Template != "" && t.File != "":
+		return fmt.Errorf("template and file are mutually exclusive")
+	case t.File != "":
+	case t.Template == "":
+		return fmt.Errorf("template or file is required")
+	case !json.Valid([]byte(t.Template)):
+		return fmt.Errorf("template is not valid JSON")
+	}
+	return nil
+}
+
+// isUpstreamURL reports whether raw is an absolute URL or a Unix socket URL
+// such as unix:///var/run/crm.sock
+func isUpstreamURL(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	if u.Scheme == "unix" {
+		return u.Host == "" && u.Path != ""
+	}
+	return u.Scheme != "" && u.Host != ""
+}
+
+// HMACConfig holds the shared secrets of callers authenticating with HMAC
+// request signatures.
+type HMACConfig struct {
+	Keys   map[string]string `yaml:"keys,omitempty"`   // secrets by key ID (X-Key-Id)
+	Window time.Duration     `yaml:"window,omitempty"` // accepted timestamp skew and replay window, 0 uses 5m
+}
+
+// BasicAuthConfig holds the users of HTTP basic auth, from an htpasswd file
+// and inline user to hash entries.
+type BasicAuthConfig struct {
+	HtpasswdFile string            `yaml:"htpasswd_file,omitempty"`
+	Users        map[string]string `yaml:"users,omitempty"` // htpasswd hashes by user name
+	Realm        string            `yaml:"realm,omitempty"` // empty uses the service name
+}
+
+// FaultConfig holds per-service fault injection settings for chaos testing.
+type FaultConfig struct {
+	Delay       time.Duration `yaml:"delay,omitempty"`        // latency added to affected requests
+	DelayRate   float64       `yaml:"delay_rate,omitempty"`   // fraction of requests delayed (0-1)
+	ErrorRate   float64       `yaml:"error_rate,omitempty"`   // fraction of requests failed (0-1)
+	ErrorStatus int           `yaml:"error_status,omitempty"` // status code for injected errors
+}
+
+// RouteLabels holds business annotations attached to a route's metrics and logs.
+type RouteLabels struct {
+	Team string `yaml:"team,omitempty"` // owning team
+	Tier string `yaml:"tier,omitempty"` // criticality tier
+	Area string `yaml:"area,omitempty"` // product area
+}
+
+// LogConfig holds logging-specific configuration.
+type LogConfig struct {
+	Level           string            `yaml:"level"`
+	ComponentLevels map[string]string `yaml:"component_levels"` // per component overrides of Level
+	ComponentName   string            `yaml:"component_name"`
+	Stdout          bool              `yaml:"stdout"` // write logs to stdout
+	File            LogFileConfig     `yaml:"file"`
+	Access          AccessLogConfig   `yaml:"access"`
+	Sink            LogSinkConfig     `yaml:"sink"`
+	Syslog          SyslogConfig      `yaml:"syslog"`
+}
+
+// SyslogConfig holds syslog (RFC 5424) output configuration.
+type SyslogConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	Network  string `yaml:"network"`  // udp, tcp, unix, empty for the local socket
+	Address  string `yaml:"address"`  // host:port or socket path
+	Facility string `yaml:"facility"` // kern, user, daemon, auth, local0..local7
+	Tag      string `yaml:"tag"`      // APP-NAME, defaults to the component name
+}
+
+// LogFileConfig holds file logging configuration.
+type LogFileConfig struct {
+	Path       string `yaml:"path"`         // log file path, empty disables file logging
+	MaxSizeMB  int    `yaml:"max_size_mb"`  // rotate when the file reaches this size
+	MaxAgeDays int    `yaml:"max_age_days"` // delete rotated files older than this, 0 keeps them
+	MaxBackups int    `yaml:"max_backups"`  // max rotated files kept, 0 keeps all
+	Compress   bool   `yaml:"compress"`     // gzip rotated files
+}
+
+// LogSinkConfig holds settings for shipping logs to a central pipeline.
+type LogSinkConfig struct {
+	HTTPURL       string        `yaml:"http_url"`       // HTTP collector receiving NDJSON batches
+	KafkaBrokers  []string      `yaml:"kafka_brokers"`  // Kafka brokers
+	KafkaTopic    string        `yaml:"kafka_topic"`    // Kafka topic
+	BufferSize    int           `yaml:"buffer_size"`    // max entries waiting for delivery
+	BatchSize     int           `yaml:"batch_size"`     // max entries per batch
+	FlushInterval time.Duration `yaml:"flush_interval"` // max delay before a batch is sent
+	BlockOnFull   bool          `yaml:"block_on_full"`  // block instead of dropping entries when the buffer is full
+}
+
+// AccessLogConfig holds access log format configuration.
+type AccessLogConfig struct {
+	Format          string             `yaml:"format"`            // json, combined, template
+	Fields          []string           `yaml:"fields"`            // fields included in the json format
+	Template        string             `yaml:"template"`          // text/template used by the template format
+	SampleRate      float64            `yaml:"sample_rate"`       // fraction of successful requests logged (0-1)
+	SampleRules     map[string]float64 `yaml:"sample_rules"`      // per path prefix sample rates, longest prefix wins
+	AlwaysLogStatus int                `yaml:"always_log_status"` // responses with this status or above are always logged
+}
+
+// RequestConfig holds request body handling configuration.
+type RequestConfig struct {
+	GzipMode            string `yaml:"gzip_mode"`             // passthrough, decompress, reject
+	MaxDecompressedSize int64  `yaml:"max_decompressed_size"` // max decompressed body size in bytes
+
+	// request paths are normalized before routing and authentication, so
+	// e.g. /public/../admin cannot slip past rules matching path prefixes
+	NormalizePaths bool   `yaml:"normalize_paths"` // collapse // and resolve . and .. segments
+	EncodedSlashes string `yaml:"encoded_slashes"` // %2F in paths: keep, decode or reject
+	TrailingSlash  string `yaml:"trailing_slash"`  // pass, strip or redirect
+}
+
+// AdminConfig holds admin endpoint configuration.
+type AdminConfig struct {
+	Enabled bool   `yaml:"enabled"` // admin endpoints are only routed when enabled
+	Prefix  string `yaml:"prefix"`  // path prefix of admin endpoints, e.g. /admin
+	Role    string `yaml:"role"`    // JWT role required to access admin endpoints
+}
+
+// MetricsConfig holds the Prometheus metrics endpoint configuration.
+type MetricsConfig struct {
+	Enabled bool   `yaml:"enabled"` // metrics are only served when enabled
+	Path    string `yaml:"path"`    // path of the endpoint, e.g. /metrics
+	Port    int    `yaml:"port"`    // serve on a listener of its own instead of the gateway's, 0 disables
+}
+
+// CostReportConfig holds cost attribution report configuration.
+type CostReportConfig struct {
+	ExportDir      string        `yaml:"export_dir"`      // directory for periodic report files, empty disables export
+	ExportInterval time.Duration `yaml:"export_interval"` // length of a reporting period
+}
+
+// ConcurrencyConfig holds in-flight request limits used for load shedding.
+type ConcurrencyConfig struct {
+	MaxInFlight        int           `yaml:"max_in_flight"`         // global limit across all services, 0 disables
+	ServiceMaxInFlight int           `yaml:"service_max_in_flight"` // default per-service limit, 0 disables
+	QueueTimeout       time.Duration `yaml:"queue_timeout"`         // how long a request waits for a free slot
+
+	// QueueSize bounds the requests waiting for a slot of each limit, 0 is
+	// unbounded. Waiting requests get free slots by the priority of their
+	// class, and a full queue sheds the lowest priority ones first.
+	QueueSize int             `yaml:"queue_size"`
+	Classes   []PriorityClass `yaml:"classes"` // the first matching class applies, others have priority 0
+}
+
+// PriorityClass gives requests matching all of its selectors a priority
+// when waiting for a concurrency slot, e.g. interactive traffic over
+// exports
+type PriorityClass struct {
+	Name     string            `yaml:"name"`
+	Priority int               `yaml:"priority"`         // higher is served first, may be negative
+	Paths    []string          `yaml:"paths,omitempty"`  // gateway paths or prefixes ending in /*
+	Roles    []string          `yaml:"roles,omitempty"`  // JWT roles, one of which the user must have
+	Claims   map[string]string `yaml:"claims,omitempty"` // JWT metadata claims and their values
 }
 
-// ProxyConfig holds proxy-specific configuration.
-type ProxyConfig struct {
-	Targets map[string]TargetConfig
-	Timeout time.Duration
-}
+// AdaptiveConcurrencyConfig holds an adaptive concurrency limit of a
+// service, replacing its fixed MaxInFlight. Algorithm aimd cuts the limit
+// when responses are slower than Latency or fail and raises it by one
+// otherwise, gradient follows the ratio of the long-term to the current
+// latency. The limit starts at MaxLimit.
+type AdaptiveConcurrencyConfig struct {
+	Algorithm string        `yaml:"algorithm"`           // aimd or gradient, empty disables
+	MinLimit  int           `yaml:"min_limit,omitempty"` // 0 uses 1
+	MaxLimit  int           `yaml:"max_limit,omitempty"` // 0 uses the fixed limit of the service, or 1000
+	Latency   time.Duration `yaml:"latency,omitempty"`   // slowest healthy response for aimd
+}
+
+// validate checks the algorithm and that the limits are consistent
+func (a *AdaptiveConcurrencyConfig) validate() error {
+	switch a.Algorithm {
+	case "":
+		return nil
+	case "aimd":
+		if a.Latency <= 0 {
+			return fmt.Errorf("aimd requires a latency")
+		}
+	case "gradient":
+	default:
+		return fmt.Errorf("algorithm must be one of aimd, gradient")
+	}
+	if a.MinLimit < 0 || a.MaxLimit < 0 || a.Latency < 0 {
+		return fmt.Errorf("limits and latency must not be negative")
+	}
+	if a.MaxLimit > 0 && a.MinLimit > a.MaxLimit {
+		return fmt.Errorf("min limit must not exceed max limit")
+	}
+	return nil
+}
+
+// FaultInjectionConfig holds global fault injection switches.
+type FaultInjectionConfig struct {
+	Enabled       bool `yaml:"enabled"`        // master switch, per-service faults are ignored when false
+	RequireHeader bool `yaml:"require_header"` // only inject into requests carrying the opt-in header
+}
+
+// ErrorReportingConfig holds error reporting (Sentry) configuration.
+type ErrorReportingConfig struct {
+	SentryDSN         string        `yaml:"sentry_dsn"` // empty disables Sentry reporting
+	SentryEnvironment string        `yaml:"sentry_environment"`
+	SentrySampleRate  float64       `yaml:"sentry_sample_rate"`
+	BurstThreshold    int           `yaml:"burst_threshold"` // 5xx responses per window that count as a burst, 0 disables
+	BurstWindow       time.Duration `yaml:"burst_window"`
+}
+
+// IdempotencyConfig holds the store of responses replayed for requests
+// retried with the same Idempotency-Key on a service's idempotency paths.
+type IdempotencyConfig struct {
+	Store    string        `yaml:"store"`     // memory (default) or redis
+	RedisURL string        `yaml:"redis_url"` // redis://[:password@]host:port[/db] for the redis store
+	TTL      time.Duration `yaml:"ttl"`       // how long responses are replayed
+
+	// MaxBodySize caps the request bodies read to fingerprint a request,
+	// larger ones are rejected with 413
+	MaxBodySize int64 `yaml:"max_body_size"`
+}
+
+// CacheConfig holds the response cache shared by services with cache paths.
+type CacheConfig struct {
+	MaxSize      int64  `yaml:"max_size"`       // bytes of responses kept, least recently used ones are evicted
+	MaxEntrySize int64  `yaml:"max_entry_size"` // largest response body cached
+	TagHeader    string `yaml:"tag_header"`     // response header listing the tags responses can be purged by
+}
+
+// TargetCacheConfig holds the response caching of a service. Responses are
+// cached as long as their Cache-Control max-age or s-maxage or their Expires
+// header allow, and not at all with no-store, no-cache or private.
+type TargetCacheConfig struct {
+	Paths []string      `yaml:"paths,omitempty"` // cached gateway paths or prefixes ending in /*, empty disables caching
+	TTL   time.Duration `yaml:"ttl,omitempty"`   // how long responses without caching headers are cached, 0 does not cache them
+
+	// TTLs replace TTL for gateway paths or prefixes ending in /*, the most
+	// specific one applying
+	TTLs map[string]time.Duration `yaml:"ttls,omitempty"`
+
+	// Overrides cache the responses of gateway paths or prefixes ending in
+	// /* for a fixed time whatever their caching headers say, except
+	// no-store and private, the most specific one applying
+	Overrides map[string]time.Duration `yaml:"overrides,omitempty"`
+
+	// StaleWhileRevalidate and StaleIfError are how long after they expired
+	// responses are served while they are refreshed in the background and
+	// when the upstream fails with a 5xx, unless their Cache-Control sets
+	// its own
+	StaleWhileRevalidate time.Duration `yaml:"stale_while_revalidate,omitempty"`
+	StaleIfError         time.Duration `yaml:"stale_if_error,omitempty"`
+
+	// NegativeTTL is how long 404 and 5xx responses are cached, 0 does not
+	// cache them. They are never served stale.
+	NegativeTTL time.Duration `yaml:"negative_ttl,omitempty"`
+
+	// KeyHeaders and KeyClaims (JWT metadata claims, e.g. tenant) must be
+	// equal for requests to share a response, besides those it varies on
+	KeyHeaders []string `yaml:"key_headers,omitempty"`
+	KeyClaims  []string `yaml:"key_claims,omitempty"`
+
+	BypassAuthorization bool     `yaml:"bypass_authorization,omitempty"` // forward requests with an Authorization header without caching
+	BypassQuery         []string `yaml:"bypass_query,omitempty"`         // query parameters whose presence forwards requests without caching
+
+	// RequestNoCache is what requests with Cache-Control: no-cache or
+	// Pragma: no-cache do: ignore (default) is answered from the cache,
+	// refresh is forwarded and its response cached, bypass is forwarded
+	// without caching
+	RequestNoCache string `yaml:"request_no_cache,omitempty"`
+}
+
+// validate checks the response caching of a service
+func (c *TargetCacheConfig) validate() error {
+	if len(c.Paths) == 0 {
+		return nil
+	}
+	for _, path := range c.Paths {
+		if !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("paths must start with /, got %q", path)
+		}
+	}
+	if c.TTL < 0 {
+		return fmt.Errorf("ttl must not be negative")
+	}
+	if c.StaleWhileRevalidate < 0 || c.StaleIfError < 0 {
+		return fmt.Errorf("stale windows must not be negative")
+	}
+	if c.NegativeTTL < 0 {
+		return fmt.Errorf("negative ttl must not be negative")
+	}
+	for path, ttl := range c.TTLs {
+		if !strings.HasPrefix(path, "/") || ttl < 0 {
+			return fmt.Errorf("ttls must be gateway paths with a TTL that is not negative, got %s=%s", path, ttl)
+		}
+	}
+	for path, ttl := range c.Overrides {
+		if !strings.HasPrefix(path, "/") || ttl <= 0 {
+			return fmt.Errorf("overrides must be gateway paths with a positive TTL, got %s=%s", path, ttl)
+		}
+	}
+	switch c.RequestNoCache {
+	case "", "ignore", "refresh", "bypass":
+	default:
+		return fmt.Errorf("request no-cache must be one of ignore, refresh, bypass")
+	}
+	return nil
+}
+
+// BandwidthConfig holds per-client throttling of request and response
+// bodies, shared by all services.
+type BandwidthConfig struct {
+	Rate  int64  `yaml:"rate"`  // bytes per second per client and direction, 0 disables throttling
+	Burst int64  `yaml:"burst"` // bytes transferred at full speed before throttling, 0 uses Rate
+	By    string `yaml:"by"`    // ip (default) or user, requests without a user are throttled by IP
+}
+
+// RateLimitConfig holds per-client request rate limits and quotas shared by
+// all services. Authenticated clients are limited by user, anonymous ones,
+// e.g. on public paths, by IP.
+type RateLimitConfig struct {
+	Rate      float64         `yaml:"rate"`       // requests per second per client, 0 is unlimited
+	Burst     int             `yaml:"burst"`      // requests allowed at once, 0 uses Rate rounded up
+	PlanClaim string          `yaml:"plan_claim"` // metadata claim naming the user's plan, e.g. plan
+	Headers   bool            `yaml:"headers"`    // send X-RateLimit-* and RateLimit-* headers with every response
+	Tiers     []RateLimitTier `yaml:"tiers"`      // limits of users by role or plan, the first matching one applies
+
+	// Algorithm enforces Rate and Burst: token_bucket (default), gcra,
+	// fixed_window, sliding_window or sliding_log, the windows lasting
+	// Burst / Rate seconds
+	Algorithm string `yaml:"algorithm"`
+
+	// Daily and Monthly cap the requests per client in a UTC day or month,
+	// counted in Store, memory or redis to share them between instances
+	Daily    int64  `yaml:"daily"`
+	Monthly  int64  `yaml:"monthly"`
+	Store    string `yaml:"store"`
+	RedisURL string `yaml:"redis_url"`
+}
+
+// ServiceRateLimitConfig holds the total request rate a service accepts,
+// whichever clients send the requests
+type ServiceRateLimitConfig struct {
+	Rate      float64 `yaml:"rate"`                // requests per second, 0 is unlimited
+	Burst     int     `yaml:"burst,omitempty"`     // requests allowed at once, 0 uses Rate rounded up
+	Algorithm string  `yaml:"algorithm,omitempty"` // as in RateLimitConfig, token_bucket if empty
+}
+
+// RecordingConfig holds the record-and-replay mode of a service. Recorded
+// secrets are replaced by REDACTED: the values of the Authorization,
+// Proxy-Authorization, Cookie, Set-Cookie and X-Api-Key headers and of the
+// listed headers, query parameters and JSON body fields.
+type RecordingConfig struct {
+	Mode          string   `yaml:"mode"`                     // record or replay, empty disables
+	File          string   `yaml:"file"`                     // JSON Lines file of recorded exchanges
+	RedactHeaders []string `yaml:"redact_headers,omitempty"` // further headers to redact
+	RedactQuery   []string `yaml:"redact_query,omitempty"`   // query parameters to redact
+	RedactFields  []string `yaml:"redact_fields,omitempty"`  // JSON body fields to redact at any depth
+	ReplayLatency bool     `yaml:"replay_latency,omitempty"` // replayed responses take as long as recorded
+}
+
+// validate checks the mode and that a file is given
+func (r *RecordingConfig) validate() error {
+	switch r.Mode {
+	case "":
+		return nil
+	case "record", "replay":
+	default:
+		return fmt.Errorf("unknown recording mode %q, must be record or replay", r.Mode)
+	}
+	if r.File == "" {
+		return fmt.Errorf("recording file is required in %s mode", r.Mode)
+	}
+	return nil
+}
+
+// RateLimitTier holds the limits of users with one of its roles or plans,
+// e.g. higher quotas for premium clients. Its limits replace the default
+// ones, 0 is unlimited.
+type RateLimitTier struct {
+	Name    string   `yaml:"name"`
+	Roles   []string `yaml:"roles,omitempty"`
+	Plans   []string `yaml:"plans,omitempty"`
+	Rate    float64  `yaml:"rate"`
+	Burst   int      `yaml:"burst,omitempty"`
+	Daily   int64    `yaml:"daily,omitempty"`
+	Monthly int64    `yaml:"monthly,omitempty"`
+
+	// Algorithm enforces the tier's rate, the default algorithm if empty
+	Algorithm string `yaml:"algorithm,omitempty"`
+}
+
+// HasQuotas reports whether daily or monthly quotas are set, by default or
+// in a tier
+func (c *RateLimitConfig) HasQuotas() bool {
+	if c.Daily > 0 || c.Monthly > 0 {
+		return true
+	}
+	for _, tier := range c.Tiers {
+		if tier.Daily > 0 || tier.Monthly > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// AggregationConfig holds aggregate routes, gateway paths answered by
+// calling several services in parallel and merging their JSON responses.
+type AggregationConfig struct {
+	OnError string           `yaml:"on_error"` // fail (default) or partial, for routes not setting their own
+	Timeout time.Duration    `yaml:"timeout"`  // time a call may take, 0 leaves it to the service's proxy timeout
+	Routes  []AggregateRoute `yaml:"routes"`
+}
+
+// AggregateRoute answers GET requests to Path with a JSON object holding
+// the response of each call under its name, e.g. {"crm": ..., "billing": ...}.
+// Calls go through the gateway like client requests, with the client's
+// credentials.
+type AggregateRoute struct {
+	Path  string            `yaml:"path"`  // gateway path, e.g. /dashboard
+	Calls map[string]string `yaml:"calls"` // gateway path with optional query by name, e.g. crm: /crm/summary
+
+	// OnError decides the response when calls fail: fail answers with an
+	// error, partial with the successful calls, the failed ones null and
+	// listed under "errors". Failed Required calls always fail the response.
+	OnError  string        `yaml:"on_error,omitempty"`
+	Required []string      `yaml:"required,omitempty"`
+	Timeout  time.Duration `yaml:"timeout,omitempty"` // 0 uses the aggregation timeout
+}
+
+// validate checks a route against the paths of all aggregate routes, which
+// calls must not request
+func (r *AggregateRoute) validate(routes []AggregateRoute) error {
+	if !strings.HasPrefix(r.Path, "/") {
+		return fmt.Errorf("path must start with /, got %q", r.Path)
+	}
+	if len(r.Calls) == 0 {
+		return fmt.Errorf("at least one call is required")
+	}
+	duplicates := 0
+	for _, other := range routes {
+		if other.Path == r.Path {
+			duplicates++
+		}
+	}
+	if duplicates > 1 {
+		return fmt.Errorf("path %s is used by more than one route", r.Path)
+	}
+	for name, call := range r.Calls {
+		if name == "" || name == "errors" {
+			return fmt.Errorf("invalid call name %q", name)
+		}
+		u, err := url.Parse(call)
+		if err != nil || !strings.HasPrefix(u.Path, "/") || u.Host != "" {
+			return fmt.Errorf("call %q must be a gateway path starting with /, got %q", name, call)
+		}
+		for _, other := range routes {
+			if u.Path == other.Path {
+				return fmt.Errorf("call %q must not request the aggregate route %s", name, other.Path)
+			}
+		}
+	}
+	for _, name := range r.Required {
+		if _, ok := r.Calls[name]; !ok {
+			return fmt.Errorf("required call %q is not a call of the route", name)
+		}
+	}
+	switch r.OnError {
+	case "", "fail", "partial":
+	default:
+		return fmt.Errorf("on_error must be one of fail, partial")
+	}
+	if r.Timeout < 0 {
+		return fmt.Errorf("timeout must not be negative")
+	}
+	return nil
+}
+
+// RedirectRule redirects requests to a host and path before they are
+// routed, e.g. for a domain migration or legacy URLs.
+type RedirectRule struct {
+	Host   string `yaml:"host,omitempty"`   // request host without port, empty matches every host
+	Path   string `yaml:"path"`             // exact path or prefix ending in /*
+	To     string `yaml:"to"`               // path or absolute URL, a trailing /* is replaced by the rest of the path
+	Status int    `yaml:"status,omitempty"` // 301, 302, 303, 307 or 308, 0 uses 301
+}
+
+// validate checks the paths and status of a redirect rule
+func (r *RedirectRule) validate() error {
+	if strings.ContainsAny(r.Host, "/:") {
+		return fmt.Errorf("host must be a host name without port, got %q", r.Host)
+	}
+	if !strings.HasPrefix(r.Path, "/") || strings.Contains(strings.TrimSuffix(r.Path, "/*"), "*") {
+		return fmt.Errorf("path must be a path or prefix ending in /*, got %q", r.Path)
+	}
+	if r.To == "" {
+		return fmt.Errorf("redirect target is required")
+	}
+	if strings.HasSuffix(r.To, "/*") && !strings.HasSuffix(r.Path, "/*") {
+		return fmt.Errorf("target %s ending in /* requires a path ending in /*", r.To)
+	}
+	if u, err := url.Parse(r.To); err != nil || u.Host == "" && !strings.HasPrefix(r.To, "/") {
+		return fmt.Errorf("target must be a path or absolute URL, got %q", r.To)
+	}
+	switch r.Status {
+	case 0, 301, 302, 303, 307, 308:
+	default:
+		return fmt.Errorf("status must be one of 301, 302, 303, 307, 308")
+	}
+	return nil
+}
+
+// StaticConfig holds the static file handler serving a frontend bundle from
+// the gateway's origin, next to the API.
+type StaticConfig struct {
+	Dir          string `yaml:"dir"`           // directory served, empty disables the handler
+	Prefix       string `yaml:"prefix"`        // path prefix, / serves files at paths no other route matches
+	Index        string `yaml:"index"`         // index file of directories and the SPA fallback
+	SPAFallback  bool   `yaml:"spa_fallback"`  // serve the index for missing paths without a file extension
+	CacheControl string `yaml:"cache_control"` // Cache-Control of files other than index files
+}
+
+// DiscoveryConfig holds service discovery configuration. Discovered services
+// are routed like statically configured ones under "/<name>".
+type DiscoveryConfig struct {
+	Mode       string                    `yaml:"mode"` // empty disables discovery, kubernetes or consul
+	Kubernetes KubernetesDiscoveryConfig `yaml:"kubernetes"`
+	Consul     ConsulDiscoveryConfig     `yaml:"consul"`
+}
+
+// KubernetesDiscoveryConfig holds Kubernetes service discovery configuration.
+type KubernetesDiscoveryConfig struct {
+	Namespace     string `yaml:"namespace"`      // empty uses the gateway's own namespace
+	LabelSelector string `yaml:"label_selector"` // selects the Services to route to
+	PortName      string `yaml:"port_name"`      // Service port to use, empty uses the first port
+	APIServer     string `yaml:"api_server"`     // empty uses the in-cluster API server
+	TokenFile     string `yaml:"token_file"`     // empty uses the in-cluster service account token
+}
+
+// ConsulDiscoveryConfig holds Consul service discovery configuration.
+type ConsulDiscoveryConfig struct {
+	Address    string   `yaml:"address"`    // Consul HTTP API address
+	Token      string   `yaml:"token"`      // ACL token, empty for none
+	Datacenter string   `yaml:"datacenter"` // empty uses the agent's datacenter
+	Services   []string `yaml:"services"`   // Consul services to route to
+	Tag        string   `yaml:"tag"`        // only instances with this tag, empty for all
+}
+
+// KVConfig holds the location of a config stored in a KV store.
+type KVConfig struct {
+	URL   string // e.g. consul://consul:8500/gateway/config or etcd://etcd:2379/gateway/config
+	Token string // ACL token, empty for none
+}
+
+// Load loads configuration from a KV store, a YAML file or from environment variables.
+// It attempts to load from .env file first, then falls back to system environment.
+// If CONFIG_KV_URL or CONFIG_FILE is set, the YAML config is loaded on top of the
+// environment values. secretsmanager:// and ssm:// references are resolved last.
+func Load() (*Config, error) {
+	// try to load .env file, ignore error if it doesn't exist
+	_ = godotenv.Load()
+
+	var cfg *Config
+	var err error
+	if kvURL := os.Getenv("CONFIG_KV_URL"); kvURL != "" {
+		cfg, err = LoadKV(KVConfig{URL: kvURL, Token: os.Getenv("CONFIG_KV_TOKEN")})
+	} else if path := os.Getenv("CONFIG_FILE"); path != "" {
+		cfg, err = LoadFile(path)
+	} else {
+		cfg, err = LoadEnv()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := ResolveSecrets(ctx, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// LoadEnv loads configuration from environment variables only.
+func LoadEnv() (*Config, error) {
+	// try to load .env file, ignore error if it doesn't exist
+	_ = godotenv.Load()
+
+	cfg := fromEnv()
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("config validation failed: %w", err)
+	}
+
+	return cfg, nil
+}
+
+// LoadFile loads configuration from a YAML file.
+// Values not set in the file keep their environment or default values,
+// and ${VAR} references in the file are expanded from the environment.
+func LoadFile(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	return Parse(data, path)
+}
+
+// Parse builds configuration from YAML data read from source (a file path or
+// KV store URL). Values not set in the data keep their environment or default
+// values, and ${VAR} references are expanded from the environment.
+func Parse(data []byte, source string) (*Config, error) {
+	cfg := fromEnv()
+
+	decoder := yaml.NewDecoder(strings.NewReader(os.ExpandEnv(string(data))))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse config %q: %w", source, err)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("config validation failed: %w", err)
+	}
+
+	return cfg, nil
+}
+
+// fromEnv builds configuration from environment variables and defaults.
+func fromEnv() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Host:         getEnv("SERVER_HOST", "0.0.0.0"),
+			Port:         getEnvAsInt("SERVER_PORT", 8080),
+			ReadTimeout:  getEnvAsDuration("SERVER_READ_TIMEOUT", 15*time.Second),
+			WriteTimeout: getEnvAsDuration("SERVER_WRITE_TIMEOUT", 15*time.Second),
+			IdleTimeout:  getEnvAsDuration("SERVER_IDLE_TIMEOUT", 60*time.Second),
+			TLS: TLSConfig{
+				CertFile:     getEnv("SERVER_TLS_CERT_FILE", ""),
+				KeyFile:      getEnv("SERVER_TLS_KEY_FILE", ""),
+				ClientCAFile: getEnv("SERVER_TLS_CLIENT_CA_FILE", ""),
+			},
+			TrustedProxies: getEnvAsSlice("TRUSTED_PROXIES", nil),
+		},
+		CORS: CORSConfig{
+			AllowedOrigins:   getEnvAsSlice("CORS_ALLOWED_ORIGINS", []string{"*"}),
+			AllowedMethods:   getEnvAsSlice("CORS_ALLOWED_METHODS", []string{"GET", "POST", "PUT", "DELETE", "OPTIONS", "PATCH"}),
+			AllowedHeaders:   getEnvAsSlice("CORS_ALLOWED_HEADERS", []string{"Content-Type", "Authorization"}),
+			AllowCredentials: getEnvAsBool("CORS_ALLOW_CREDENTIALS", true),
+			MaxAge:           getEnvAsInt("CORS_MAX_AGE", 3600),
+		},
+		JWT: JWTConfig{
+			Secret:           getEnv("JWT_SECRET", ""),
+			Issuer:           getEnv("JWT_ISSUER", "api-gateway"),
+			Audience:         getEnv("JWT_AUDIENCE", "api-gateway"),
+			Expiration:       getEnvAsDuration("JWT_EXPIRATION", 24*time.Hour),
+			Leeway:           getEnvAsDuration("JWT_LEEWAY", 0),
+			KeyID:            getEnv("JWT_KEY_ID", ""),
+			Keys:             getEnvAsMap("JWT_KEYS"),
+			DebugEndpoint:    getEnvAsBool("JWT_DEBUG_ENDPOINT", false),
+			RefreshEndpoint:  getEnvAsBool("JWT_REFRESH_ENDPOINT", false),
+			RefreshWindow:    getEnvAsDuration("JWT_REFRESH_WINDOW", 1*time.Hour),
+			RevocationStore:  getEnv("JWT_REVOCATION_STORE", ""),
+			RedisURL:         getEnv("JWT_REVOCATION_REDIS_URL", ""),
+			TrustedIssuers:   loadTrustedIssuers(),
+			TokenFormat:      getEnv("JWT_TOKEN_FORMAT", "jwt"),
+			PasetoPublicKeys: getEnvAsSlice("JWT_PASETO_PUBLIC_KEYS", nil),
+			SessionCookie: SessionCookieConfig{
+				Enabled:  getEnvAsBool("JWT_SESSION_COOKIE", false),
+				Secret:   getEnv("JWT_SESSION_SECRET", ""),
+				Name:     getEnv("JWT_SESSION_COOKIE_NAME", "gateway_session"),
+				Domain:   getEnv("JWT_SESSION_COOKIE_DOMAIN", ""),
+				Secure:   getEnvAsBool("JWT_SESSION_COOKIE_SECURE", true),
+				SameSite: getEnv("JWT_SESSION_COOKIE_SAMESITE", "lax"),
+
+				CSRFCookieName:  getEnv("JWT_SESSION_CSRF_COOKIE_NAME", "gateway_csrf"),
+				CSRFExemptPaths: getEnvAsSlice("JWT_SESSION_CSRF_EXEMPT_PATHS", nil),
+			},
+		},
+		Proxy: ProxyConfig{
+			Targets: loadProxyTargets(),
+			Timeout: getEnvAsDuration("PROXY_TIMEOUT", 30*time.Second),
+			Backoff: BackoffConfig{
+				Enabled:      getEnvAsBool("PROXY_BACKOFF_ENABLED", false),
+				DefaultDelay: getEnvAsDuration("PROXY_BACKOFF_DEFAULT_DELAY", 1*time.Second),
+				MaxDelay:     getEnvAsDuration("PROXY_BACKOFF_MAX_DELAY", 60*time.Second),
+				MaxWait:      getEnvAsDuration("PROXY_BACKOFF_MAX_WAIT", 0),
+			},
+			DNSRefresh:      getEnvAsDuration("PROXY_DNS_REFRESH", 0),
+			RequestTimeout:  getEnvAsDuration("PROXY_REQUEST_TIMEOUT", 0),
+			DeadlineHeader:  getEnv("PROXY_DEADLINE_HEADER", ""),
+			MaxResponseSize: getEnvAsInt64("PROXY_MAX_RESPONSE_SIZE", 0),
+			Pool: PoolConfig{
+				MaxIdleConns:        getEnvAsInt("PROXY_MAX_IDLE_CONNS", 100),
+				MaxIdleConnsPerHost: getEnvAsInt("PROXY_MAX_IDLE_CONNS_PER_HOST", 32),
+				MaxConnsPerHost:     getEnvAsInt("PROXY_MAX_CONNS_PER_HOST", 0),
+				IdleConnTimeout:     getEnvAsDuration("PROXY_IDLE_CONN_TIMEOUT", 90*time.Second),
+				TLSHandshakeTimeout: getEnvAsDuration("PROXY_TLS_HANDSHAKE_TIMEOUT", 10*time.Second),
+				DialTimeout:         getEnvAsDuration("PROXY_DIAL_TIMEOUT", 30*time.Second),
+				KeepAlive:           getEnvAsDuration("PROXY_KEEP_ALIVE", 30*time.Second),
+
+				ExpectContinueTimeout: getEnvAsDuration("PROXY_EXPECT_CONTINUE_TIMEOUT", 1*time.Second),
+				ResponseHeaderTimeout: getEnvAsDuration("PROXY_RESPONSE_HEADER_TIMEOUT", 0),
+			},
+		},
+		Log: LogConfig{
+			Level:           strings.ToLower(getEnv("LOG_LEVEL", "info")),
+			ComponentLevels: loadComponentLevels(),
+			ComponentName:   getEnv("LOG_COMPONENT_NAME", "api-gateway"),
+			Stdout:          getEnvAsBool("LOG_STDOUT", true),
+			File: LogFileConfig{
+				Path:       getEnv("LOG_FILE_PATH", ""),
+				MaxSizeMB:  getEnvAsInt("LOG_FILE_MAX_SIZE_MB", 100),
+				MaxAgeDays: getEnvAsInt("LOG_FILE_MAX_AGE_DAYS", 7),
+				MaxBackups: getEnvAsInt("LOG_FILE_MAX_BACKUPS", 10),
+				Compress:   getEnvAsBool("LOG_FILE_COMPRESS", true),
+			},
+			Access: AccessLogConfig{
+				Format:          strings.ToLower(getEnv("ACCESS_LOG_FORMAT", "json")),
+				Fields:          getEnvAsSlice("ACCESS_LOG_FIELDS", nil),
+				Template:        getEnv("ACCESS_LOG_TEMPLATE", ""),
+				SampleRate:      getEnvAsFloat("ACCESS_LOG_SAMPLE_RATE", 1),
+				SampleRules:     getEnvAsFloatMap("ACCESS_LOG_SAMPLE_RULES"),
+				AlwaysLogStatus: getEnvAsInt("ACCESS_LOG_ALWAYS_LOG_STATUS", 400),
+			},
+			Sink: LogSinkConfig{
+				HTTPURL:       getEnv("LOG_SINK_HTTP_URL", ""),
+				KafkaBrokers:  getEnvAsSlice("LOG_SINK_KAFKA_BROKERS", nil),
+				KafkaTopic:    getEnv("LOG_SINK_KAFKA_TOPIC", ""),
+				BufferSize:    getEnvAsInt("LOG_SINK_BUFFER_SIZE", 10000),
+				BatchSize:     getEnvAsInt("LOG_SINK_BATCH_SIZE", 500),
+				FlushInterval: getEnvAsDuration("LOG_SINK_FLUSH_INTERVAL", 1*time.Second),
+				BlockOnFull:   getEnvAsBool("LOG_SINK_BLOCK_ON_FULL", false),
+			},
+			Syslog: SyslogConfig{
+				Enabled:  getEnvAsBool("LOG_SYSLOG_ENABLED", false),
+				Network:  strings.ToLower(getEnv("LOG_SYSLOG_NETWORK", "")),
+				Address:  getEnv("LOG_SYSLOG_ADDRESS", ""),
+				Facility: strings.ToLower(getEnv("LOG_SYSLOG_FACILITY", "local0")),
+				Tag:      getEnv("LOG_SYSLOG_TAG", ""),
+			},
+		},
+		Request: RequestConfig{
+			GzipMode:            strings.ToLower(getEnv("REQUEST_GZIP_MODE", "passthrough")),
+			MaxDecompressedSize: getEnvAsInt64("REQUEST_MAX_DECOMPRESSED_SIZE", 10<<20),
+			NormalizePaths:      getEnvAsBool("REQUEST_NORMALIZE_PATHS", true),
+			EncodedSlashes:      strings.ToLower(getEnv("REQUEST_ENCODED_SLASHES", "keep")),
+			TrailingSlash:       strings.ToLower(getEnv("REQUEST_TRAILING_SLASH", "pass")),
+		},
+		Admin: AdminConfig{
+			Enabled: getEnvAsBool("ADMIN_ENABLED", false),
+			Prefix:  getEnv("ADMIN_PREFIX", "/admin"),
+			Role:    getEnv("ADMIN_ROLE", "admin"),
+		},
+		Metrics: MetricsConfig{
+			Enabled: getEnvAsBool("METRICS_ENABLED", false),
+			Path:    getEnv("METRICS_PATH", "/metrics"),
+			Port:    getEnvAsInt("METRICS_PORT", 0),
+		},
+		Cost: CostReportConfig{
+			ExportDir:      getEnv("COST_REPORT_EXPORT_DIR", ""),
+			ExportInterval: getEnvAsDuration("COST_REPORT_INTERVAL", 24*time.Hour),
+		},
+		Concurrency: ConcurrencyConfig{
+			MaxInFlight:        getEnvAsInt("CONCURRENCY_MAX_IN_FLIGHT", 0),
+			ServiceMaxInFlight: getEnvAsInt("CONCURRENCY_SERVICE_MAX_IN_FLIGHT", 0),
+			QueueTimeout:       getEnvAsDuration("CONCURRENCY_QUEUE_TIMEOUT", 100*time.Millisecond),
+			QueueSize:          getEnvAsInt("CONCURRENCY_QUEUE_SIZE", 0),
+			Classes:            loadPriorityClasses(),
+		},
+		Fault: FaultInjectionConfig{
+			Enabled:       getEnvAsBool("FAULT_INJECTION_ENABLED", false),
+			RequireHeader: getEnvAsBool("FAULT_INJECTION_REQUIRE_HEADER", true),
+		},
+		Errors: ErrorReportingConfig{
+			SentryDSN:         getEnv("SENTRY_DSN", ""),
+			SentryEnvironment: getEnv("SENTRY_ENVIRONMENT", "production"),
+			SentrySampleRate:  getEnvAsFloat("SENTRY_SAMPLE_RATE", 1),
+			BurstThreshold:    getEnvAsInt("ERROR_BURST_THRESHOLD", 50),
+			BurstWindow:       getEnvAsDuration("ERROR_BURST_WINDOW", time.Minute),
+		},
+		Discovery: DiscoveryConfig{
+			Mode: strings.ToLower(getEnv("DISCOVERY_MODE", "")),
+			Kubernetes: KubernetesDiscoveryConfig{
+				Namespace:     getEnv("K8S_DISCOVERY_NAMESPACE", ""),
+				LabelSelector: getEnv("K8S_DISCOVERY_LABEL_SELECTOR", ""),
+				PortName:      getEnv("K8S_DISCOVERY_PORT_NAME", ""),
+				APIServer:     getEnv("K8S_DISCOVERY_API_SERVER", ""),
+				TokenFile:     getEnv("K8S_DISCOVERY_TOKEN_FILE", ""),
+			},
+			Consul: ConsulDiscoveryConfig{
+				Address:    getEnv("CONSUL_HTTP_ADDR", "http://127.0.0.1:8500"),
+				Token:      getEnv("CONSUL_HTTP_TOKEN", ""),
+				Datacenter: getEnv("CONSUL_DISCOVERY_DATACENTER", ""),
+				Services:   getEnvAsSlice("CONSUL_DISCOVERY_SERVICES", nil),
+				Tag:        getEnv("CONSUL_DISCOVERY_TAG", ""),
+			},
+		},
+		Idempotency: IdempotencyConfig{
+			Store:    getEnv("IDEMPOTENCY_STORE", "memory"),
+			RedisURL: getEnv("IDEMPOTENCY_REDIS_URL", ""),
+			TTL:      getEnvAsDuration("IDEMPOTENCY_TTL", 24*time.Hour),
+
+			MaxBodySize: getEnvAsInt64("IDEMPOTENCY_MAX_BODY_SIZE", 10<<20),
+		},
+		Cache: CacheConfig{
+			MaxSize:      getEnvAsInt64("CACHE_MAX_SIZE", 64<<20),
+			MaxEntrySize: getEnvAsInt64("CACHE_MAX_ENTRY_SIZE", 1<<20),
+			TagHeader:    getEnv("CACHE_TAG_HEADER", "Surrogate-Key"),
+		},
+		RateLimit: RateLimitConfig{
+			Rate:      getEnvAsFloat("RATE_LIMIT_RATE", 0),
+			Burst:     getEnvAsInt("RATE_LIMIT_BURST", 0),
+			PlanClaim: getEnv("RATE_LIMIT_PLAN_CLAIM", "plan"),
+			Headers:   getEnvAsBool("RATE_LIMIT_HEADERS", true),
+			Tiers:     loadRateLimitTiers(),
+			Algorithm: getEnv("RATE_LIMIT_ALGORITHM", "token_bucket"),
+			Daily:     getEnvAsInt64("RATE_LIMIT_DAILY", 0),
+			Monthly:   getEnvAsInt64("RATE_LIMIT_MONTHLY", 0),
+			Store:     getEnv("RATE_LIMIT_STORE", "memory"),
+			RedisURL:  getEnv("RATE_LIMIT_REDIS_URL", ""),
+		},
+		Bandwidth: BandwidthConfig{
+			Rate:  getEnvAsInt64("BANDWIDTH_CLIENT_RATE", 0),
+			Burst: getEnvAsInt64("BANDWIDTH_CLIENT_BURST", 0),
+			By:    strings.ToLower(getEnv("BANDWIDTH_CLIENT_BY", "ip")),
+		},
+		Aggregation: AggregationConfig{
+			OnError: getEnv("AGGREGATION_ON_ERROR", "fail"),
+			Timeout: getEnvAsDuration("AGGREGATION_TIMEOUT", 0),
+			Routes:  loadAggregateRoutes(),
+		},
+		Redirects:  loadRedirectRules(),
+		ErrorPages: loadErrorPages(),
+		Static: StaticConfig{
+			Dir:          getEnv("STATIC_DIR", ""),
+			Prefix:       getEnv("STATIC_PREFIX", "/"),
+			Index:        getEnv("STATIC_INDEX", "index.html"),
+			SPAFallback:  getEnvAsBool("STATIC_SPA_FALLBACK", false),
+			CacheControl: getEnv("STATIC_CACHE_CONTROL", ""),
+		},
+	}
+}
+
+// Validate checks if the configuration is valid.
+func (c *Config) Validate() error {
+	switch c.JWT.TokenFormat {
+	case "", "jwt":
+		if c.JWT.Secret == "" {
+			return fmt.Errorf("JWT_SECRET is required")
+		}
+	case "paseto":
+		if len(c.JWT.PasetoPublicKeys) == 0 {
+			return fmt.Errorf("JWT_PASETO_PUBLIC_KEYS is required for the paseto token format")
+		}
+		for _, key := range c.JWT.PasetoPublicKeys {
+			if b, err := hex.DecodeString(key); err != nil || len(b) != 32 {
+				return fmt.Errorf("JWT_PASETO_PUBLIC_KEYS must be hex-encoded 32-byte Ed25519 public keys")
+			}
+		}
+		if c.JWT.DebugEndpoint || c.JWT.RefreshEndpoint {
+			return fmt.Errorf("JWT_DEBUG_ENDPOINT and JWT_REFRESH_ENDPOINT require the jwt token format")
+		}
+	default:
+		return fmt.Errorf("JWT_TOKEN_FORMAT must be one of jwt, paseto")
+	}
+
+	if _, ok := c.JWT.Keys[c.JWT.KeyID]; ok && c.JWT.KeyID != "" {
+		return fmt.Errorf("JWT_KEYS must not contain the key ID of JWT_SECRET (%s)", c.JWT.KeyID)
+	}
+	for kid, key := range c.JWT.Keys {
+		if kid == "" || key == "" {
+			return fmt.Errorf("JWT_KEYS entries must be key_id=secret")
+		}
+	}
+
+	seen := map[string]bool{c.JWT.Issuer: true}
+	for i, trusted := range c.JWT.TrustedIssuers {
+		if trusted.Issuer == "" || (trusted.Secret == "" && len(trusted.Keys) == 0) {
+			return fmt.Errorf("trusted JWT issuer %d requires an issuer and a secret or keys", i+1)
+		}
+		if seen[trusted.Issuer] {
+			return fmt.Errorf("JWT issuer %q is configured more than once", trusted.Issuer)
+		}
+		seen[trusted.Issuer] = true
+	}
+
+	if c.JWT.Leeway < 0 {
+		return fmt.Errorf("JWT_LEEWAY must not be negative")
+	}
+
+	if c.JWT.RefreshWindow < 0 {
+		return fmt.Errorf("JWT_REFRESH_WINDOW must not be negative")
+	}
+
+	if session := c.JWT.SessionCookie; session.Enabled {
+		if len(session.Secret) < 32 {
+			return fmt.Errorf("JWT_SESSION_SECRET of at least 32 characters is required when JWT_SESSION_COOKIE is enabled")
+		}
+		if session.Name == "" || session.CSRFCookieName == "" || session.Name == session.CSRFCookieName {
+			return fmt.Errorf("JWT_SESSION_COOKIE_NAME and JWT_SESSION_CSRF_COOKIE_NAME must be set and differ")
+		}
+		for _, path := range session.CSRFExemptPaths {
+			if !strings.HasPrefix(path, "/") {
+				return fmt.Errorf("JWT_SESSION_CSRF_EXEMPT_PATHS entries must start with /, got %q", path)
+			}
+		}
+		switch session.SameSite {
+		case "strict", "lax":
+		case "none":
+			if !session.Secure {
+				return fmt.Errorf("JWT_SESSION_COOKIE_SAMESITE none requires JWT_SESSION_COOKIE_SECURE")
+			}
+		default:
+			return fmt.Errorf("JWT_SESSION_COOKIE_SAMESITE must be one of strict, lax, none")
+		}
+	}
+
+	switch c.JWT.RevocationStore {
+	case "", "memory":
+	case "redis":
+		if c.JWT.RedisURL == "" {
+			return fmt.Errorf("JWT_REVOCATION_REDIS_URL is required when JWT_REVOCATION_STORE is redis")
+		}
+	default:
+		return fmt.Errorf("JWT_REVOCATION_STORE must be one of memory, redis")
+	}
+
+	switch c.Idempotency.Store {
+	case "", "memory":
+	case "redis":
+		if c.Idempotency.RedisURL == "" {
+			return fmt.Errorf("IDEMPOTENCY_REDIS_URL is required when IDEMPOTENCY_STORE is redis")
+		}
+	default:
+		return fmt.Errorf("IDEMPOTENCY_STORE must be one of memory, redis")
+	}
+
+	switch c.Discovery.Mode {
+	case "":
+		if len(c.Proxy.Targets) == 0 {
+			return fmt.Errorf("at least one proxy target is required")
+		}
+	case "kubernetes", "consul":
+		// discovered services are mounted under their name, which the
+		// legacy default service mounted at the root would shadow
+		if _, ok := c.Proxy.Targets[DefaultTargetName]; ok {
+			return fmt.Errorf("service discovery cannot be combined with PROXY_TARGET_URL")
+		}
+	default:
+		return fmt.Errorf("DISCOVERY_MODE must be empty, kubernetes or consul")
+	}
+	if c.Discovery.Mode == "consul" && len(c.Discovery.Consul.Services) == 0 {
+		return fmt.Errorf("CONSUL_DISCOVERY_SERVICES is required for consul discovery")
+	}
+
+	for name, target := range c.Proxy.Targets {
+		if target.URL == "" {
+			return fmt.Errorf("proxy target %q URL is required", name)
+		}
+		for _, endpoint := range append(target.Endpoints, target.Backups...) {
+			if endpoint == "" {
+				return fmt.Errorf("proxy target %q has an empty endpoint URL", name)
+			}
+		}
+		if len(target.Backups) > 0 && target.HealthCheck.Path == "" {
+			return fmt.Errorf("proxy target %q: backups require a health check path", name)
+		}
+		if hc := target.HealthCheck; hc.Path != "" && !strings.HasPrefix(hc.Path, "/") {
+			return fmt.Errorf("proxy target %q: health check path must start with /", name)
+		}
+		if len(target.IdempotencyPaths) > 0 && c.Idempotency.TTL <= 0 {
+			return fmt.Errorf("proxy target %q: idempotency paths require a positive IDEMPOTENCY_TTL", name)
+		}
+		if len(target.IdempotencyPaths) > 0 && c.Idempotency.MaxBodySize <= 0 {
+			return fmt.Errorf("proxy target %q: idempotency paths require a positive IDEMPOTENCY_MAX_BODY_SIZE", name)
+		}
+		if target.SlowStart < 0 {
+			return fmt.Errorf("proxy target %q: slow start must not be negative", name)
+		}
+		if percent := target.Outliers.MaxEjectionPercent; percent < 0 || percent > 100 {
+			return fmt.Errorf("proxy target %q: max ejection percent must be between 0 and 100", name)
+		}
+		if err := target.validateWeights(); err != nil {
+			return fmt.Errorf("proxy target %q: %w", name, err)
+		}
+		switch target.Balancer {
+		case "", "round-robin", "least-connections", "least-latency":
+		default:
+			return fmt.Errorf("proxy target %q: balancer must be one of round-robin, least-connections, least-latency", name)
+		}
+		switch target.Affinity.By {
+		case "", "ip", "cookie", "sub":
+		default:
+			return fmt.Errorf("proxy target %q: affinity must be one of ip, cookie, sub", name)
+		}
+		if err := target.ResponseBody.validate(); err != nil {
+			return fmt.Errorf("proxy target %q: %w", name, err)
+		}
+		if gql := target.GraphQL; gql.MaxDepth < 0 || gql.MaxComplexity < 0 {
+			return fmt.Errorf("proxy target %q: graphql limits must not be negative", name)
+		}
+		if ws := target.WebSocket; ws.MaxConnections < 0 || ws.IdleTimeout < 0 || ws.MaxDuration < 0 {
+			return fmt.Errorf("proxy target %q: websocket limits must not be negative", name)
+		}
+		switch target.PrefixMode {
+		case "", "strip", "keep":
+			if target.ReplacePrefix != "" {
+				return fmt.Errorf("proxy target %q: replace_prefix requires the replace prefix mode", name)
+			}
+		case "replace":
+			if !strings.HasPrefix(target.ReplacePrefix, "/") {
+				return fmt.Errorf("proxy target %q: replace prefix mode requires a replace_prefix starting with /", name)
+			}
+		default:
+			return fmt.Errorf("proxy target %q: prefix mode must be one of strip, keep, replace", name)
+		}
+	}
+
+	if c.Server.Port < 1 || c.Server.Port > 65535 {
+		return fmt.Errorf("SERVER_PORT must be between 1 and 65535")
+	}
+
+	if tls := c.Server.TLS; (tls.CertFile == "") != (tls.KeyFile == "") {
+		return fmt.Errorf("SERVER_TLS_CERT_FILE and SERVER_TLS_KEY_FILE must be set together")
+	} else if tls.ClientCAFile != "" && tls.CertFile == "" {
+		return fmt.Errorf("SERVER_TLS_CLIENT_CA_FILE requires SERVER_TLS_CERT_FILE and SERVER_TLS_KEY_FILE")
+	}
+	for _, proxy := range c.Server.TrustedProxies {
+		if _, err := netip.ParsePrefix(proxy); err != nil {
+			if _, err := netip.ParseAddr(proxy); err != nil {
+				return fmt.Errorf("TRUSTED_PROXIES entries must be IP addresses or CIDR ranges, got %q", proxy)
+			}
+		}
+	}
+
+	if !isValidLogLevel(c.Log.Level) {
+		return fmt.Errorf("LOG_LEVEL must be one of debug, info, warn, error")
+	}
+	for component, level := range c.Log.ComponentLevels {
+		if !isValidLogLevel(level) {
+			return fmt.Errorf("log level %q for component %q must be one of debug, info, warn, error", level, component)
+		}
+	}
+
+	switch c.Log.Access.Format {
+	case "", "json", "combined":
+	case "template":
+		if c.Log.Access.Template == "" {
+			return fmt.Errorf("ACCESS_LOG_TEMPLATE is required for the template access log format")
+		}
+	default:
+		return fmt.Errorf("ACCESS_LOG_FORMAT must be one of json, combined, template")
+	}
+
+	if c.Log.Access.SampleRate < 0 || c.Log.Access.SampleRate > 1 {
+		return fmt.Errorf("ACCESS_LOG_SAMPLE_RATE must be between 0 and 1")
+	}
+	for prefix, rate := range c.Log.Access.SampleRules {
+		if rate < 0 || rate > 1 {
+			return fmt.Errorf("access log sample rate for %q must be between 0 and 1", prefix)
+		}
+	}
+
+	if len(c.Log.Sink.KafkaBrokers) > 0 && c.Log.Sink.KafkaTopic == "" {
+		return fmt.Errorf("LOG_SINK_KAFKA_TOPIC is required when LOG_SINK_KAFKA_BROKERS is set")
+	}
+	if c.Log.Sink.HTTPURL != "" || len(c.Log.Sink.KafkaBrokers) > 0 {
+		if c.Log.Sink.BufferSize <= 0 || c.Log.Sink.BatchSize <= 0 {
+			return fmt.Errorf("LOG_SINK_BUFFER_SIZE and LOG_SINK_BATCH_SIZE must be positive")
+		}
+	}
+
+	if c.Log.File.Path != "" {
+		if c.Log.File.MaxSizeMB <= 0 {
+			return fmt.Errorf("LOG_FILE_MAX_SIZE_MB must be positive")
+		}
+		if c.Log.File.MaxAgeDays < 0 || c.Log.File.MaxBackups < 0 {
+			return fmt.Errorf("LOG_FILE_MAX_AGE_DAYS and LOG_FILE_MAX_BACKUPS must not be negative")
+		}
+	}
+
+	if c.Log.Syslog.Enabled {
+		switch c.Log.Syslog.Network {
+		case "":
+		case "udp", "tcp", "unix", "unixgram":
+			if c.Log.Syslog.Address == "" {
+				return fmt.Errorf("LOG_SYSLOG_ADDRESS is required when LOG_SYSLOG_NETWORK is set")
+			}
+		default:
+			return fmt.Errorf("LOG_SYSLOG_NETWORK must be one of udp, tcp, unix, unixgram")
+		}
+	}
+
+	switch c.Request.GzipMode {
+	case "", "passthrough", "decompress", "reject":
+	default:
+		return fmt.Errorf("REQUEST_GZIP_MODE must be one of passthrough, decompress, reject")
+	}
+
+	if c.Request.GzipMode == "decompress" && c.Request.MaxDecompressedSize <= 0 {
+		return fmt.Errorf("REQUEST_MAX_DECOMPRESSED_SIZE must be positive")
+	}
+
+	switch c.Request.EncodedSlashes {
+	case "", "keep", "decode", "reject":
+	default:
+		return fmt.Errorf("REQUEST_ENCODED_SLASHES must be one of keep, decode, reject")
+	}
+
+	switch c.Request.TrailingSlash {
+	case "", "pass", "strip", "redirect":
+	default:
+		return fmt.Errorf("REQUEST_TRAILING_SLASH must be one of pass, strip, redirect")
+	}
+
+	if c.Proxy.MaxResponseSize < 0 {
+		return fmt.Errorf("PROXY_MAX_RESPONSE_SIZE must not be negative")
+	}
+
+	if c.Bandwidth.Rate < 0 || c.Bandwidth.Burst < 0 {
+		return fmt.Errorf("BANDWIDTH_CLIENT_RATE and BANDWIDTH_CLIENT_BURST must not be negative")
+	}
+	switch c.Bandwidth.By {
+	case "", "ip", "user":
+	default:
+		return fmt.Errorf("BANDWIDTH_CLIENT_BY must be one of ip, user")
+	}
 
-// TargetConfig holds configuration for a single proxy target.
-type TargetConfig struct {
-	URL string
-}
+	if c.RateLimit.Rate < 0 || c.RateLimit.Burst < 0 {
+		return fmt.Errorf("RATE_LIMIT_RATE and RATE_LIMIT_BURST must not be negative")
+	}
+	if c.RateLimit.Daily < 0 || c.RateLimit.Monthly < 0 {
+		return fmt.Errorf("RATE_LIMIT_DAILY and RATE_LIMIT_MONTHLY must not be negative")
+	}
+	if !validRateAlgorithm(c.RateLimit.Algorithm) {
+		return fmt.Errorf("RATE_LIMIT_ALGORITHM must be one of token_bucket, gcra, fixed_window, sliding_window, sliding_log")
+	}
+	for i, tier := range c.RateLimit.Tiers {
+		switch {
+		case tier.Name == "" || tier.Name == "default":
+			return fmt.Errorf("rate limit tier %d: name must be set and not default", i+1)
+		case len(tier.Roles) == 0 && len(tier.Plans) == 0:
+			return fmt.Errorf("rate limit tier %s: at least one role or plan is required", tier.Name)
+		case tier.Rate < 0 || tier.Burst < 0 || tier.Daily < 0 || tier.Monthly < 0:
+			return fmt.Errorf("rate limit tier %s: limits must not be negative", tier.Name)
+		case tier.Algorithm != "" && !validRateAlgorithm(tier.Algorithm):
+			return fmt.Errorf("rate limit tier %s: unknown algorithm %q", tier.Name, tier.Algorithm)
+		}
+	}
+	switch c.RateLimit.Store {
+	case "", "memory":
+	case "redis":
+		if c.RateLimit.RedisURL == "" {
+			return fmt.Errorf("RATE_LIMIT_REDIS_URL is required for the redis quota store")
+		}
+	default:
+		return fmt.Errorf("RATE_LIMIT_STORE must be one of memory, redis")
+	}
 
-// LogConfig holds logging-specific configuration.
-type LogConfig struct {
-	Level         string
-	ComponentName string
-}
+	switch c.Aggregation.OnError {
+	case "", "fail", "partial":
+	default:
+		return fmt.Errorf("AGGREGATION_ON_ERROR must be one of fail, partial")
+	}
+	if c.Aggregation.Timeout < 0 {
+		return fmt.Errorf("AGGREGATION_TIMEOUT must not be negative")
+	}
+	for i, route := range c.Aggregation.Routes {
+		if err := route.validate(c.Aggregation.Routes); err != nil {
+			return fmt.Errorf("aggregate route %d: %w", i+1, err)
+		}
+	}
 
-// Load loads configuration from environment variables.
-// It attempts to load from .env file first, then falls back to system environment.
-func Load() (*Config, error) {
-	// try to load .env file, ignore error if it doesn't exist
-	_ = godotenv.Load()
+	for status, file := range c.ErrorPages {
+		if status < 400 || status > 599 {
+			return fmt.Errorf("ERROR_PAGES status must be between 400 and 599, got %d", status)
+		}
+		if file == "" {
+			return fmt.Errorf("ERROR_PAGES template file for status %d is required", status)
+		}
+	}
 
-	cfg := &Config{
-		Server: ServerConfig{
-			Host:         getEnv("SERVER_HOST", "0.0.0.0"),
-			Port:         getEnvAsInt("SERVER_PORT", 8080),
-			ReadTimeout:  getEnvAsDuration("SERVER_READ_TIMEOUT", 15*time.Second),
-			WriteTimeout: getEnvAsDuration("SERVER_WRITE_TIMEOUT", 15*time.Second),
-			IdleTimeout:  getEnvAsDuration("SERVER_IDLE_TIMEOUT", 60*time.Second),
-		},
-		CORS: CORSConfig{
-			AllowedOrigins:   getEnvAsSlice("CORS_ALLOWED_ORIGINS", []string{"*"}),
-			AllowedMethods:   getEnvAsSlice("CORS_ALLOWED_METHODS", []string{"GET", "POST", "PUT", "DELETE", "OPTIONS", "PATCH"}),
-			AllowedHeaders:   getEnvAsSlice("CORS_ALLOWED_HEADERS", []string{"Content-Type", "Authorization"}),
-			AllowCredentials: getEnvAsBool("CORS_ALLOW_CREDENTIALS", true),
-			MaxAge:           getEnvAsInt("CORS_MAX_AGE", 3600),
-		},
-		JWT: JWTConfig{
-			Secret:     getEnv("JWT_SECRET", ""),
-			Issuer:     getEnv("JWT_ISSUER", "api-gateway"),
-			Audience:   getEnv("JWT_AUDIENCE", "api-gateway"),
-			Expiration: getEnvAsDuration("JWT_EXPIRATION", 24*time.Hour),
-		},
-		Proxy: ProxyConfig{
-			Targets: loadProxyTargets(),
-			Timeout: getEnvAsDuration("PROXY_TIMEOUT", 30*time.Second),
-		},
-		Log: LogConfig{
-			Level:         getEnv("LOG_LEVEL", "info"),
-			ComponentName: getEnv("LOG_COMPONENT_NAME", "api-gateway"),
-		},
+	for i, rule := range c.Redirects {
+		if err := rule.validate(); err != nil {
+			return fmt.Errorf("redirect rule %d: %w", i+1, err)
+		}
 	}
 
-	if err := cfg.Validate(); err != nil {
-		return nil, fmt.Errorf("config validation failed: %w", err)
+	if c.Static.Dir != "" {
+		if !strings.HasPrefix(c.Static.Prefix, "/") {
+			return fmt.Errorf("STATIC_PREFIX must start with /")
+		}
+		if c.Static.Index == "" || strings.ContainsAny(c.Static.Index, "/\\") {
+			return fmt.Errorf("STATIC_INDEX must be a file name")
+		}
+		// services are mounted under their name, the default one at the root
+		segment, _, _ := strings.Cut(strings.Trim(c.Static.Prefix, "/"), "/")
+		for name := range c.Proxy.Targets {
+			if name == segment || segment == "" && name == DefaultTargetName {
+				return fmt.Errorf("STATIC_PREFIX %s overlaps the routes of service %q", c.Static.Prefix, name)
+			}
+		}
 	}
 
-	return cfg, nil
-}
+	if c.Admin.Enabled {
+		if !strings.HasPrefix(c.Admin.Prefix, "/") || strings.Trim(c.Admin.Prefix, "/") == "" {
+			return fmt.Errorf("ADMIN_PREFIX must be a path below /, e.g. /admin")
+		}
+		segment, _, _ := strings.Cut(strings.Trim(c.Admin.Prefix, "/"), "/")
+		if _, ok := c.Proxy.Targets[segment]; ok {
+			return fmt.Errorf("ADMIN_PREFIX %s overlaps the routes of service %q", c.Admin.Prefix, segment)
+		}
+	}
 
-// Validate checks if the configuration is valid.
-func (c *Config) Validate() error {
-	if c.JWT.Secret == "" {
-		return fmt.Errorf("JWT_SECRET is required")
+	if c.Metrics.Enabled {
+		if !strings.HasPrefix(c.Metrics.Path, "/") || strings.Trim(c.Metrics.Path, "/") == "" {
+			return fmt.Errorf("METRICS_PATH must be a path below /, e.g. /metrics")
+		}
+		if c.Metrics.Port < 0 || c.Metrics.Port > 65535 {
+			return fmt.Errorf("METRICS_PORT must be between 0 and 65535")
+		}
+		if c.Metrics.Port == c.Server.Port {
+			return fmt.Errorf("METRICS_PORT must differ from SERVER_PORT, use 0 to serve metrics on the gateway's port")
+		}
+		// on the gateway's port the endpoint shares the routes of services
+		segment, _, _ := strings.Cut(strings.Trim(c.Metrics.Path, "/"), "/")
+		if _, ok := c.Proxy.Targets[segment]; ok && c.Metrics.Port == 0 {
+			return fmt.Errorf("METRICS_PATH %s overlaps the routes of service %q", c.Metrics.Path, segment)
+		}
 	}
 
-	if len(c.Proxy.Targets) == 0 {
-		return fmt.Errorf("at least one proxy target is required")
+	if c.Proxy.RequestTimeout < 0 {
+		return fmt.Errorf("PROXY_REQUEST_TIMEOUT must not be negative")
+	}
+	if !validHeaderName(c.Proxy.DeadlineHeader) {
+		return fmt.Errorf("PROXY_DEADLINE_HEADER must be a header name, got %q", c.Proxy.DeadlineHeader)
+	}
+
+	if c.Concurrency.MaxInFlight < 0 || c.Concurrency.ServiceMaxInFlight < 0 {
+		return fmt.Errorf("concurrency limits must not be negative")
+	}
+	if c.Concurrency.QueueSize < 0 {
+		return fmt.Errorf("CONCURRENCY_QUEUE_SIZE must not be negative")
+	}
+	for i, class := range c.Concurrency.Classes {
+		switch {
+		case class.Name == "":
+			return fmt.Errorf("concurrency class %d: name must be set", i+1)
+		case len(class.Paths) == 0 && len(class.Roles) == 0 && len(class.Claims) == 0:
+			return fmt.Errorf("concurrency class %s: priority and at least one path, role or claim selector are required", class.Name)
+		}
+		for _, path := range class.Paths {
+			if !strings.HasPrefix(path, "/") {
+				return fmt.Errorf("concurrency class %s: path %q must start with /", class.Name, path)
+			}
+		}
 	}
 
 	for name, target := range c.Proxy.Targets {
-		if target.URL == "" {
-			return fmt.Errorf("proxy target %q URL is required", name)
+		if err := target.Fault.validate(); err != nil {
+			return fmt.Errorf("proxy target %q: %w", name, err)
+		}
+		if target.MaxConns < 0 || target.MaxIdleConns < 0 {
+			return fmt.Errorf("proxy target %q: connection limits must not be negative", name)
+		}
+		if target.RateLimit.Rate < 0 || target.RateLimit.Burst < 0 {
+			return fmt.Errorf("proxy target %q: rate limit must not be negative", name)
+		}
+		if !validRateAlgorithm(target.RateLimit.Algorithm) {
+			return fmt.Errorf("proxy target %q: unknown rate limit algorithm %q", name, target.RateLimit.Algorithm)
+		}
+		if !validHeaderName(target.DeadlineHeader) {
+			return fmt.Errorf("proxy target %q: deadline header must be a header name, got %q", name, target.DeadlineHeader)
+		}
+		if target.RequestTimeout < 0 {
+			return fmt.Errorf("proxy target %q: request timeout must not be negative", name)
+		}
+		for path, timeout := range target.RequestTimeouts {
+			if !strings.HasPrefix(path, "/") || timeout <= 0 {
+				return fmt.Errorf("proxy target %q: request timeouts must be gateway paths with a positive timeout, got %s=%s", name, path, timeout)
+			}
+		}
+		if err := target.Cache.validate(); err != nil {
+			return fmt.Errorf("proxy target %q: cache: %w", name, err)
+		}
+		if len(target.Cache.Paths) > 0 && (c.Cache.MaxSize <= 0 || c.Cache.MaxEntrySize <= 0) {
+			return fmt.Errorf("proxy target %q: cache paths require a positive CACHE_MAX_SIZE and CACHE_MAX_ENTRY_SIZE", name)
+		}
+		if err := target.AdaptiveConcurrency.validate(); err != nil {
+			return fmt.Errorf("proxy target %q: adaptive concurrency: %w", name, err)
+		}
+		if err := target.Recording.validate(); err != nil {
+			return fmt.Errorf("proxy target %q: %w", name, err)
+		}
+		if target.Transport.ExpectContinueTimeout < 0 || target.Transport.ResponseHeaderTimeout < 0 {
+			return fmt.Errorf("proxy target %q: transport timeouts must not be negative", name)
+		}
+		if target.MaxResponseSize < 0 {
+			return fmt.Errorf("proxy target %q: max response size must not be negative", name)
+		}
+		if target.BufferResponses && target.MaxResponseSize == 0 && c.Proxy.MaxResponseSize == 0 {
+			return fmt.Errorf("proxy target %q: buffering responses requires a max response size", name)
+		}
+		switch target.Auth {
+		case "", "jwt", "none":
+		case "api-key":
+			if len(target.APIKey.Keys) == 0 {
+				return fmt.Errorf("proxy target %q: api-key auth requires at least one key", name)
+			}
+			for caller, key := range target.APIKey.Keys {
+				if caller == "" || key == "" {
+					return fmt.Errorf("proxy target %q: api keys must be name=key", name)
+				}
+			}
+		case "mtls":
+			if c.Server.TLS.ClientCAFile == "" {
+				return fmt.Errorf("proxy target %q: mtls auth requires SERVER_TLS_CLIENT_CA_FILE", name)
+			}
+		case "hmac":
+			if len(target.HMAC.Keys) == 0 {
+				return fmt.Errorf("proxy target %q: hmac auth requires at least one key", name)
+			}
+			for keyID, secret := range target.HMAC.Keys {
+				if keyID == "" || secret == "" {
+					return fmt.Errorf("proxy target %q: hmac keys must be key_id=secret", name)
+				}
+			}
+		case "basic":
+			if target.Basic.HtpasswdFile == "" && len(target.Basic.Users) == 0 {
+				return fmt.Errorf("proxy target %q: basic auth requires an htpasswd file or users", name)
+			}
+		default:
+			return fmt.Errorf("proxy target %q: auth must be one of jwt, api-key, hmac, basic, mtls, none", name)
+		}
+		for i, rule := range target.Routes {
+			if err := rule.validate(); err != nil {
+				return fmt.Errorf("proxy target %q: route %d: %w", name, i+1, err)
+			}
+		}
+		if len(target.Mock) > 0 && target.URL != MockURL {
+			return fmt.Errorf("proxy target %q: mock responses require the URL %s", name, MockURL)
+		}
+		for i, mock := range target.Mock {
+			if err := mock.validate(); err != nil {
+				return fmt.Errorf("proxy target %q: mock response %d: %w", name, i+1, err)
+			}
+		}
+		for i, template := range target.RequestTemplates {
+			if err := template.validate(); err != nil {
+				return fmt.Errorf("proxy target %q: request template %d: %w", name, i+1, err)
+			}
+		}
+		for version, raw := range target.Versions.URLs {
+			if version == "" || strings.Contains(version, "/") {
+				return fmt.Errorf("proxy target %q: invalid API version %q", name, version)
+			}
+			if !isUpstreamURL(raw) {
+				return fmt.Errorf("proxy target %q: version %s URL must be an absolute URL, got %q", name, version, raw)
+			}
+		}
+		if v := target.Versions.Default; v != "" {
+			if _, ok := target.Versions.URLs[v]; !ok {
+				return fmt.Errorf("proxy target %q: default version %q has no URL", name, v)
+			}
+		}
+		for _, path := range target.PublicPaths {
+			if !strings.HasPrefix(path, "/") {
+				return fmt.Errorf("proxy target %q: public paths must start with /, got %q", name, path)
+			}
+		}
+		if len(target.SingleUsePaths) > 0 {
+			// other auth methods have no token ID to mark as used, every
+			// request to a single-use path would be rejected
+			if target.Auth != "" && target.Auth != "jwt" {
+				return fmt.Errorf("proxy target %q: single-use paths require jwt auth, not %s", name, target.Auth)
+			}
+			if c.JWT.RevocationStore == "" {
+				return fmt.Errorf("proxy target %q: single-use paths require JWT_REVOCATION_STORE", name)
+			}
+			for _, path := range target.SingleUsePaths {
+				if !strings.HasPrefix(path, "/") {
+					return fmt.Errorf("proxy target %q: single-use paths must start with /, got %q", name, path)
+				}
+			}
 		}
 	}
 
-	if c.Server.Port < 1 || c.Server.Port > 65535 {
-		return fmt.Errorf("SERVER_PORT must be between 1 and 65535")
+	pool := c.Proxy.Pool
+	if pool.MaxIdleConns < 0 || pool.MaxIdleConnsPerHost < 0 || pool.MaxConnsPerHost < 0 {
+		return fmt.Errorf("proxy connection pool limits must not be negative")
+	}
+
+	if c.Errors.SentrySampleRate < 0 || c.Errors.SentrySampleRate > 1 {
+		return fmt.Errorf("SENTRY_SAMPLE_RATE must be between 0 and 1")
+	}
+
+	if c.Cost.ExportDir != "" && c.Cost.ExportInterval <= 0 {
+		return fmt.Errorf("COST_REPORT_INTERVAL must be positive")
 	}
 
 	return nil
@@ -152,6 +1995,34 @@ func getEnvAsInt(key string, fallback int) int {
 	return value
 }
 
+// getEnvAsInt64 retrieves the value of the environment variable as a 64-bit integer.
+// If the variable is not present or cannot be parsed, it returns the fallback value.
+func getEnvAsInt64(key string, fallback int64) int64 {
+	valueStr := os.Getenv(key)
+	if valueStr == "" {
+		return fallback
+	}
+	value, err := strconv.ParseInt(valueStr, 10, 64)
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// getEnvAsFloat retrieves the value of the environment variable as a float.
+// If the variable is not present or cannot be parsed, it returns the fallback value.
+func getEnvAsFloat(key string, fallback float64) float64 {
+	valueStr := os.Getenv(key)
+	if valueStr == "" {
+		return fallback
+	}
+	value, err := strconv.ParseFloat(valueStr, 64)
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
 // getEnvAsBool retrieves the value of the environment variable as a boolean.
 // If the variable is not present or cannot be parsed, it returns the fallback value.
 func getEnvAsBool(key string, fallback bool) bool {
@@ -201,6 +2072,94 @@ func getEnvAsSlice(key string, fallback []string) []string {
 	return result
 }
 
+// getEnvAsMap retrieves the value of the environment variable as a string map.
+// The value is expected to be comma-separated key=value pairs, values may
+// contain '='. If the variable is not present, it returns nil.
+func getEnvAsMap(key string) map[string]string {
+	valueStr := os.Getenv(key)
+	if valueStr == "" {
+		return nil
+	}
+	result := make(map[string]string)
+	for _, pair := range strings.Split(valueStr, ",") {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok {
+			continue
+		}
+		result[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return result
+}
+
+// getEnvAsIntMap retrieves the value of the environment variable as a map of
+// integers. The value is expected to be comma-separated key=value pairs.
+// Pairs that cannot be parsed are skipped.
+func getEnvAsIntMap(key string) map[string]int {
+	valueStr := os.Getenv(key)
+	if valueStr == "" {
+		return nil
+	}
+	result := make(map[string]int)
+	for _, pair := range strings.Split(valueStr, ",") {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok {
+			continue
+		}
+		value, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			continue
+		}
+		result[strings.TrimSpace(k)] = value
+	}
+	return result
+}
+
+// getEnvAsDurationMap retrieves the value of the environment variable as a
+// map of durations. The value is expected to be comma-separated key=value
+// pairs. Pairs that cannot be parsed are skipped.
+func getEnvAsDurationMap(key string) map[string]time.Duration {
+	valueStr := os.Getenv(key)
+	if valueStr == "" {
+		return nil
+	}
+	result := make(map[string]time.Duration)
+	for _, pair := range strings.Split(valueStr, ",") {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok {
+			continue
+		}
+		value, err := time.ParseDuration(strings.TrimSpace(v))
+		if err != nil {
+			continue
+		}
+		result[strings.TrimSpace(k)] = value
+	}
+	return result
+}
+
+// getEnvAsFloatMap retrieves the value of the environment variable as a map of
+// floats. The value is expected to be comma-separated key=value pairs.
+// Pairs that cannot be parsed are skipped.
+func getEnvAsFloatMap(key string) map[string]float64 {
+	valueStr := os.Getenv(key)
+	if valueStr == "" {
+		return nil
+	}
+	result := make(map[string]float64)
+	for _, pair := range strings.Split(valueStr, ",") {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok {
+			continue
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			continue
+		}
+		result[strings.TrimSpace(k)] = value
+	}
+	return result
+}
+
 // loadProxyTargets loads proxy targets from environment variables.
 // Supports two formats:
 // 1. Legacy: PROXY_TARGET_URL (single backend)
@@ -210,7 +2169,7 @@ func loadProxyTargets() map[string]TargetConfig {
 
 	// check for legacy single target format
 	if legacyURL := os.Getenv("PROXY_TARGET_URL"); legacyURL != "" {
-		targets["default"] = TargetConfig{URL: legacyURL}
+		targets[DefaultTargetName] = loadTargetConfig(legacyURL, "PROXY_TARGET")
 		return targets
 	}
 
@@ -221,9 +2180,465 @@ func loadProxyTargets() map[string]TargetConfig {
 	for _, name := range serviceNames {
 		envKey := name + "_SERVICE_URL"
 		if url := os.Getenv(envKey); url != "" {
-			targets[strings.ToLower(name)] = TargetConfig{URL: url}
+			targets[strings.ToLower(name)] = loadTargetConfig(url, name+"_SERVICE")
 		}
 	}
 
 	return targets
 }
+
+// loadTrustedIssuers loads the issuers named in JWT_TRUSTED_ISSUERS, each
+// configured with JWT_TRUSTED_<NAME>_ISSUER, _SECRET and _AUDIENCES.
+func loadTrustedIssuers() []TrustedIssuerConfig {
+	var issuers []TrustedIssuerConfig
+	for _, name := range getEnvAsSlice("JWT_TRUSTED_ISSUERS", nil) {
+		prefix := "JWT_TRUSTED_" + strings.ToUpper(name)
+		issuers = append(issuers, TrustedIssuerConfig{
+			Issuer:    os.Getenv(prefix + "_ISSUER"),
+			Secret:    os.Getenv(prefix + "_SECRET"),
+			Keys:      getEnvAsMap(prefix + "_KEYS"),
+			Audiences: getEnvAsSlice(prefix+"_AUDIENCES", nil),
+		})
+	}
+	return issuers
+}
+
+// LogComponents are the subsystems whose log level can be set independently
+// with LOG_LEVEL_<COMPONENT>.
+var LogComponents = []string{"server", "proxy", "middleware", "auth"}
+
+// loadComponentLevels loads per-component log levels (e.g. LOG_LEVEL_PROXY=debug).
+func loadComponentLevels() map[string]string {
+	levels := make(map[string]string)
+	for _, component := range LogComponents {
+		if level := os.Getenv("LOG_LEVEL_" + strings.ToUpper(component)); level != "" {
+			levels[component] = strings.ToLower(level)
+		}
+	}
+	return levels
+}
+
+// loadTargetConfig loads the per-service settings of a target from environment
+// variables using the given prefix (e.g. CRM_SERVICE or PROXY_TARGET).
+func loadTargetConfig(url, prefix string) TargetConfig {
+	return TargetConfig{
+		URL:       url,
+		Endpoints: getEnvAsSlice(prefix+"_ENDPOINTS", nil),
+		Backups:   getEnvAsSlice(prefix+"_BACKUPS", nil),
+		Weights:   getEnvAsIntMap(prefix + "_WEIGHTS"),
+		Balancer:  os.Getenv(prefix + "_BALANCER"),
+		HealthCheck: HealthCheckConfig{
+			Path:               os.Getenv(prefix + "_HEALTH_CHECK_PATH"),
+			Interval:           getEnvAsDuration(prefix+"_HEALTH_CHECK_INTERVAL", 0),
+			Timeout:            getEnvAsDuration(prefix+"_HEALTH_CHECK_TIMEOUT", 0),
+			UnhealthyThreshold: getEnvAsInt(prefix+"_HEALTH_CHECK_UNHEALTHY_THRESHOLD", 0),
+			HealthyThreshold:   getEnvAsInt(prefix+"_HEALTH_CHECK_HEALTHY_THRESHOLD", 0),
+		},
+		Outliers: OutlierConfig{
+			ConsecutiveErrors:  getEnvAsInt(prefix+"_OUTLIER_CONSECUTIVE_ERRORS", 0),
+			MaxLatency:         getEnvAsDuration(prefix+"_OUTLIER_MAX_LATENCY", 0),
+			EjectionTime:       getEnvAsDuration(prefix+"_OUTLIER_EJECTION_TIME", 0),
+			MaxEjectionPercent: getEnvAsInt(prefix+"_OUTLIER_MAX_EJECTION_PERCENT", 0),
+		},
+		SlowStart: getEnvAsDuration(prefix+"_SLOW_START", 0),
+		Affinity: AffinityConfig{
+			By:     os.Getenv(prefix + "_AFFINITY"),
+			Cookie: os.Getenv(prefix + "_AFFINITY_COOKIE"),
+		},
+		OpenAPISpec:  os.Getenv(prefix + "_OPENAPI_SPEC"),
+		Labels:       loadRouteLabels(prefix),
+		MaxInFlight:  getEnvAsInt(prefix+"_MAX_IN_FLIGHT", 0),
+		Timeout:      getEnvAsDuration(prefix+"_TIMEOUT", 0),
+		MaxConns:     getEnvAsInt(prefix+"_MAX_CONNS", 0),
+		MaxIdleConns: getEnvAsInt(prefix+"_MAX_IDLE_CONNS", 0),
+		Transport: TransportConfig{
+			DisableKeepAlives:     getEnvAsBool(prefix+"_DISABLE_KEEP_ALIVES", false),
+			DisableHTTP2:          getEnvAsBool(prefix+"_DISABLE_HTTP2", false),
+			ExpectContinueTimeout: getEnvAsDuration(prefix+"_EXPECT_CONTINUE_TIMEOUT", 0),
+			ResponseHeaderTimeout: getEnvAsDuration(prefix+"_RESPONSE_HEADER_TIMEOUT", 0),
+		},
+		WebSocket: WebSocketConfig{
+			MaxConnections: getEnvAsInt(prefix+"_WS_MAX_CONNECTIONS", 0),
+			IdleTimeout:    getEnvAsDuration(prefix+"_WS_IDLE_TIMEOUT", 0),
+			MaxDuration:    getEnvAsDuration(prefix+"_WS_MAX_DURATION", 0),
+		},
+		DNSRefresh: getEnvAsDuration(prefix+"_DNS_REFRESH", 0),
+		Fault:      loadFaultConfig(prefix),
+		Auth:       os.Getenv(prefix + "_AUTH"),
+		APIKey: APIKeyConfig{
+			Keys:   getEnvAsMap(prefix + "_API_KEYS"),
+			Header: os.Getenv(prefix + "_API_KEY_HEADER"),
+		},
+		HMAC: HMACConfig{
+			Keys:   getEnvAsMap(prefix + "_HMAC_KEYS"),
+			Window: getEnvAsDuration(prefix+"_HMAC_WINDOW", 0),
+		},
+		Basic: BasicAuthConfig{
+			HtpasswdFile: os.Getenv(prefix + "_BASIC_HTPASSWD"),
+			Users:        getEnvAsMap(prefix + "_BASIC_USERS"),
+			Realm:        os.Getenv(prefix + "_BASIC_REALM"),
+		},
+		MTLS: MTLSConfig{
+			Subjects: getEnvAsSlice(prefix+"_MTLS_SUBJECTS", nil),
+		},
+		MaxResponseSize:  getEnvAsInt64(prefix+"_MAX_RESPONSE_SIZE", 0),
+		BufferResponses:  getEnvAsBool(prefix+"_BUFFER_RESPONSES", false),
+		ForwardedHeader:  getEnvAsBool(prefix+"_FORWARDED_HEADER", false),
+		PreserveHost:     getEnvAsBool(prefix+"_PRESERVE_HOST", false),
+		RewriteLocation:  getEnvAsBool(prefix+"_REWRITE_LOCATION", false),
+		PrefixMode:       os.Getenv(prefix + "_PREFIX_MODE"),
+		ReplacePrefix:    os.Getenv(prefix + "_REPLACE_PREFIX"),
+		PublicPaths:      getEnvAsSlice(prefix+"_PUBLIC_PATHS", nil),
+		SingleUsePaths:   getEnvAsSlice(prefix+"_SINGLE_USE_PATHS", nil),
+		CoalescePaths:    getEnvAsSlice(prefix+"_COALESCE_PATHS", nil),
+		IdempotencyPaths: getEnvAsSlice(prefix+"_IDEMPOTENCY_PATHS", nil),
+		ResponseBody: ResponseBodyConfig{
+			RemoveFields: getEnvAsSlice(prefix+"_RESPONSE_REMOVE_FIELDS", nil),
+			RenameFields: getEnvAsMap(prefix + "_RESPONSE_RENAME_FIELDS"),
+		},
+		Cache: TargetCacheConfig{
+			Paths:     getEnvAsSlice(prefix+"_CACHE_PATHS", nil),
+			TTL:       getEnvAsDuration(prefix+"_CACHE_TTL", 0),
+			TTLs:      getEnvAsDurationMap(prefix + "_CACHE_TTLS"),
+			Overrides: getEnvAsDurationMap(prefix + "_CACHE_OVERRIDES"),
+
+			StaleWhileRevalidate: getEnvAsDuration(prefix+"_CACHE_STALE_WHILE_REVALIDATE", 0),
+			StaleIfError:         getEnvAsDuration(prefix+"_CACHE_STALE_IF_ERROR", 0),
+			NegativeTTL:          getEnvAsDuration(prefix+"_CACHE_NEGATIVE_TTL", 0),
+
+			KeyHeaders: getEnvAsSlice(prefix+"_CACHE_KEY_HEADERS", nil),
+			KeyClaims:  getEnvAsSlice(prefix+"_CACHE_KEY_CLAIMS", nil),
+
+			BypassAuthorization: getEnvAsBool(prefix+"_CACHE_BYPASS_AUTHORIZATION", false),
+			BypassQuery:         getEnvAsSlice(prefix+"_CACHE_BYPASS_QUERY", nil),
+			RequestNoCache:      os.Getenv(prefix + "_CACHE_REQUEST_NO_CACHE"),
+		},
+		GraphQL: GraphQLConfig{
+			Paths:                getEnvAsSlice(prefix+"_GRAPHQL_PATHS", nil),
+			MaxDepth:             getEnvAsInt(prefix+"_GRAPHQL_MAX_DEPTH", 0),
+			MaxComplexity:        getEnvAsInt(prefix+"_GRAPHQL_MAX_COMPLEXITY", 0),
+			DisableIntrospection: getEnvAsBool(prefix+"_GRAPHQL_DISABLE_INTROSPECTION", false),
+		},
+		RequestTemplates: loadRequestTemplates(prefix),
+		Routes:           loadRouteRules(prefix),
+		Versions: VersionConfig{
+			URLs:    getEnvAsMap(prefix + "_VERSIONS"),
+			Default: os.Getenv(prefix + "_DEFAULT_VERSION"),
+			Strip:   getEnvAsBool(prefix+"_STRIP_VERSION", false),
+		},
+		DeadlineHeader:  os.Getenv(prefix + "_DEADLINE_HEADER"),
+		RequestTimeout:  getEnvAsDuration(prefix+"_REQUEST_TIMEOUT", 0),
+		RequestTimeouts: getEnvAsDurationMap(prefix + "_REQUEST_TIMEOUTS"),
+		AdaptiveConcurrency: AdaptiveConcurrencyConfig{
+			Algorithm: os.Getenv(prefix + "_ADAPTIVE_CONCURRENCY"),
+			MinLimit:  getEnvAsInt(prefix+"_ADAPTIVE_MIN_LIMIT", 0),
+			MaxLimit:  getEnvAsInt(prefix+"_ADAPTIVE_MAX_LIMIT", 0),
+			Latency:   getEnvAsDuration(prefix+"_ADAPTIVE_LATENCY", 0),
+		},
+		RateLimit: ServiceRateLimitConfig{
+			Rate:      getEnvAsFloat(prefix+"_RATE_LIMIT", 0),
+			Burst:     getEnvAsInt(prefix+"_RATE_LIMIT_BURST", 0),
+			Algorithm: os.Getenv(prefix + "_RATE_LIMIT_ALGORITHM"),
+		},
+		Mock: loadMockResponses(prefix),
+		Recording: RecordingConfig{
+			Mode:          os.Getenv(prefix + "_RECORDING"),
+			File:          os.Getenv(prefix + "_RECORDING_FILE"),
+			RedactHeaders: getEnvAsSlice(prefix+"_RECORDING_REDACT_HEADERS", nil),
+			RedactQuery:   getEnvAsSlice(prefix+"_RECORDING_REDACT_QUERY", nil),
+			RedactFields:  getEnvAsSlice(prefix+"_RECORDING_REDACT_FIELDS", nil),
+			ReplayLatency: getEnvAsBool(prefix+"_RECORDING_REPLAY_LATENCY", false),
+		},
+	}
+}
+
+// loadRouteRules loads route rules from an environment variable such as
+// CRM_SERVICE_ROUTES="GET|HEAD /crm/reports/* http://reporting:8080", with
+// comma-separated rules of methods (* for any), path template and URL
+func loadRouteRules(prefix string) []RouteRule {
+	var rules []RouteRule
+	for _, entry := range getEnvAsSlice(prefix+"_ROUTES", nil) {
+		fields := strings.Fields(entry)
+		if len(fields) != 3 {
+			// kept invalid so Validate reports it
+			rules = append(rules, RouteRule{Path: entry})
+			continue
+		}
+		rule := RouteRule{Path: fields[1], URL: fields[2]}
+		if fields[0] != "*" {
+			rule.Methods = strings.Split(fields[0], "|")
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// validHeaderName reports whether name can be sent as a header name, or
+// is empty
+func validHeaderName(name string) bool {
+	return !strings.ContainsAny(name, " \t\r\n:")
+}
+
+// validRateAlgorithm reports whether name is a rate limit algorithm, the
+// default one if empty
+func validRateAlgorithm(name string) bool {
+	switch name {
+	case "", "token_bucket", "gcra", "fixed_window", "sliding_window", "sliding_log":
+		return true
+	}
+	return false
+}
+
+// loadPriorityClasses loads concurrency classes from CONCURRENCY_CLASSES,
+// e.g. "health 100 path=/crm/health, export -10 path=/reports/export/*
+// claim=plan:batch", with comma-separated classes of name, priority and
+// path=, role= or claim= selectors of |-separated values
+func loadPriorityClasses() []PriorityClass {
+	var classes []PriorityClass
+	for _, entry := range getEnvAsSlice("CONCURRENCY_CLASSES", nil) {
+		fields := strings.Fields(entry)
+		class := PriorityClass{Name: fields[0]}
+		valid := len(fields) > 2
+		if len(fields) > 1 {
+			priority, err := strconv.Atoi(fields[1])
+			class.Priority, valid = priority, valid && err == nil
+		}
+		for _, selector := range fields[min(len(fields), 2):] {
+			kind, values, _ := strings.Cut(selector, "=")
+			switch kind {
+			case "path":
+				class.Paths = append(class.Paths, strings.Split(values, "|")...)
+			case "role":
+				class.Roles = append(class.Roles, strings.Split(values, "|")...)
+			case "claim":
+				if class.Claims == nil {
+					class.Claims = make(map[string]string)
+				}
+				for _, claim := range strings.Split(values, "|") {
+					key, value, _ := strings.Cut(claim, ":")
+					class.Claims[key] = value
+				}
+			default:
+				valid = false
+			}
+		}
+		// malformed classes are kept without selectors so Validate reports them
+		if !valid {
+			class = PriorityClass{Name: class.Name}
+		}
+		classes = append(classes, class)
+	}
+	return classes
+}
+
+// loadRateLimitTiers loads rate limit tiers from RATE_LIMIT_TIERS, e.g.
+// "premium 100 200 plan=premium|enterprise daily=100000, internal 0 0
+// role=service", with comma-separated tiers of name, rate, burst, role= or
+// plan= selectors of |-separated values, daily= or monthly= quotas and an
+// algorithm=
+func loadRateLimitTiers() []RateLimitTier {
+	var tiers []RateLimitTier
+	for _, entry := range getEnvAsSlice("RATE_LIMIT_TIERS", nil) {
+		fields := strings.Fields(entry)
+		// malformed tiers are kept invalid so Validate reports them
+		tier := RateLimitTier{Name: fields[0], Rate: -1}
+		if len(fields) > 2 {
+			if rate, err := strconv.ParseFloat(fields[1], 64); err == nil {
+				tier.Rate = rate
+			}
+			if tier.Burst, _ = strconv.Atoi(fields[2]); fields[2] != strconv.Itoa(tier.Burst) {
+				tier.Burst = -1
+			}
+		}
+		for _, selector := range fields[min(len(fields), 3):] {
+			kind, values, _ := strings.Cut(selector, "=")
+			switch kind {
+			case "role":
+				tier.Roles = append(tier.Roles, strings.Split(values, "|")...)
+			case "plan":
+				tier.Plans = append(tier.Plans, strings.Split(values, "|")...)
+			case "daily", "monthly":
+				quota, err := strconv.ParseInt(values, 10, 64)
+				if err != nil {
+					quota = -1
+				}
+				if kind == "daily" {
+					tier.Daily = quota
+				} else {
+					tier.Monthly = quota
+				}
+			case "algorithm":
+				tier.Algorithm = values
+			default:
+				tier.Rate = -1
+			}
+		}
+		tiers = append(tiers, tier)
+	}
+	return tiers
+}
+
+// loadAggregateRoutes loads aggregate routes from AGGREGATION_ROUTES, e.g.
+// "/dashboard crm=/crm/summary billing=/billing/balance", with
+// comma-separated routes of gateway path and name=path calls
+func loadAggregateRoutes() []AggregateRoute {
+	var routes []AggregateRoute
+	for _, entry := range getEnvAsSlice("AGGREGATION_ROUTES", nil) {
+		fields := strings.Fields(entry)
+		route := AggregateRoute{Path: fields[0], Calls: make(map[string]string)}
+		for _, call := range fields[1:] {
+			// malformed calls are kept invalid so Validate reports them
+			name, path, _ := strings.Cut(call, "=")
+			route.Calls[name] = path
+		}
+		routes = append(routes, route)
+	}
+	return routes
+}
+
+// loadErrorPages loads error templates from ERROR_PAGES, e.g.
+// "502=./errors/502.html,504=./errors/504.json"
+func loadErrorPages() map[int]string {
+	var pages map[int]string
+	for code, file := range getEnvAsMap("ERROR_PAGES") {
+		if pages == nil {
+			pages = make(map[int]string)
+		}
+		// an invalid status is kept as 0 so Validate reports it
+		status, _ := strconv.Atoi(code)
+		pages[status] = file
+	}
+	return pages
+}
+
+// loadRedirectRules loads redirect rules from REDIRECTS, e.g.
+// "old.example.com/* https://new.example.com/* 308, /legacy/* /crm/*", with
+// comma-separated rules of the source, a path optionally preceded by a
+// host, the target and an optional status
+func loadRedirectRules() []RedirectRule {
+	var rules []RedirectRule
+	for _, entry := range getEnvAsSlice("REDIRECTS", nil) {
+		fields := strings.Fields(entry)
+		var rule RedirectRule
+		// a malformed source is kept as the path so Validate reports it
+		if i := strings.Index(fields[0], "/"); i > 0 {
+			rule.Host, rule.Path = fields[0][:i], fields[0][i:]
+		} else {
+			rule.Path = fields[0]
+		}
+		if len(fields) > 1 {
+			rule.To = fields[1]
+		}
+		if len(fields) > 2 {
+			// an invalid status is kept invalid so Validate reports it
+			if rule.Status, _ = strconv.Atoi(fields[2]); rule.Status == 0 {
+				rule.Status = -1
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// loadRequestTemplates loads request templates from an environment variable
+// such as CRM_SERVICE_REQUEST_TEMPLATES="POST /crm/contacts ./contact.json
+// first_name", with comma-separated templates of methods (* for any), path
+// template, template file and optionally the field bodies must have
+func loadRequestTemplates(prefix string) []RequestTemplate {
+	var templates []RequestTemplate
+	for _, entry := range getEnvAsSlice(prefix+"_REQUEST_TEMPLATES", nil) {
+		fields := strings.Fields(entry)
+		if len(fields) != 3 && len(fields) != 4 {
+			// kept invalid so Validate reports it
+			templates = append(templates, RequestTemplate{Path: entry})
+			continue
+		}
+		template := RequestTemplate{Path: fields[1], File: fields[2]}
+		if fields[0] != "*" {
+			template.Methods = strings.Split(fields[0], "|")
+		}
+		if len(fields) == 4 {
+			template.IfField = fields[3]
+		}
+		templates = append(templates, template)
+	}
+	return templates
+}
+
+// loadMockResponses loads mock responses from an environment variable such
+// as CRM_SERVICE_MOCK_RESPONSES="GET /crm/contacts/{id} 200 ./mocks/contact.json 50ms",
+// with comma-separated responses of methods (* for any), path template,
+// status, body template file and an optional latency
+func loadMockResponses(prefix string) []MockResponse {
+	var responses []MockResponse
+	for _, entry := range getEnvAsSlice(prefix+"_MOCK_RESPONSES", nil) {
+		fields := strings.Fields(entry)
+		if len(fields) != 4 && len(fields) != 5 {
+			// kept invalid so Validate reports it
+			responses = append(responses, MockResponse{Path: entry})
+			continue
+		}
+		response := MockResponse{Path: fields[1], File: fields[3]}
+		if fields[0] != "*" {
+			response.Methods = strings.Split(fields[0], "|")
+		}
+		status, err := strconv.Atoi(fields[2])
+		if err != nil {
+			status = -1
+		}
+		response.Status = status
+		if len(fields) == 5 {
+			if response.Latency, err = time.ParseDuration(fields[4]); err != nil {
+				response.Latency = -1
+			}
+		}
+		responses = append(responses, response)
+	}
+	return responses
+}
+
+// loadRouteLabels loads business labels for a route from environment variables
+// using the given prefix (e.g. CRM_SERVICE_TEAM, CRM_SERVICE_TIER, CRM_SERVICE_AREA).
+func loadRouteLabels(prefix string) RouteLabels {
+	return RouteLabels{
+		Team: os.Getenv(prefix + "_TEAM"),
+		Tier: os.Getenv(prefix + "_TIER"),
+		Area: os.Getenv(prefix + "_AREA"),
+	}
+}
+
+// loadFaultConfig loads fault injection settings for a route from environment
+// variables using the given prefix (e.g. CRM_SERVICE_FAULT_DELAY).
+func loadFaultConfig(prefix string) FaultConfig {
+	return FaultConfig{
+		Delay:       getEnvAsDuration(prefix+"_FAULT_DELAY", 0),
+		DelayRate:   getEnvAsFloat(prefix+"_FAULT_DELAY_RATE", 1),
+		ErrorRate:   getEnvAsFloat(prefix+"_FAULT_ERROR_RATE", 0),
+		ErrorStatus: getEnvAsInt(prefix+"_FAULT_ERROR_STATUS", 500),
+	}
+}
+
+// isValidLogLevel reports whether level is a supported log level, empty means the default
+func isValidLogLevel(level string) bool {
+	switch level {
+	case "", "debug", "info", "warn", "error":
+		return true
+	}
+	return false
+}
+
+// IsZero reports whether no fault is configured, so the migrate tool omits it
+func (f FaultConfig) IsZero() bool {
+	return f.Delay <= 0 && f.ErrorRate <= 0
+}
+
+// validate checks that fault injection settings are usable
+func (f FaultConfig) validate() error {
+	if f.DelayRate < 0 || f.DelayRate > 1 || f.ErrorRate < 0 || f.ErrorRate > 1 {
+		return fmt.Errorf("fault rates must be between 0 and 1")
+	}
+	if f.ErrorRate > 0 && (f.ErrorStatus < 400 || f.ErrorStatus > 599) {
+		return fmt.Errorf("fault error status must be between 400 and 599")
+	}
+	return nil
+}
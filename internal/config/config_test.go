@@ -1,7 +1,11 @@
 package config
 
 import (
+	"context"
+	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -49,10 +53,14 @@ func TestLoadMultipleBackends(t *testing.T) {
 	os.Setenv("JWT_SECRET", "test-secret")
 	os.Setenv("CRM_SERVICE_URL", "http://crm:9001")
 	os.Setenv("CBS_SERVICE_URL", "http://cbs:9002")
+	os.Setenv("CRM_SERVICE_TEAM", "customer-platform")
+	os.Setenv("CRM_SERVICE_TIER", "tier-1")
 	defer func() {
 		os.Unsetenv("JWT_SECRET")
 		os.Unsetenv("CRM_SERVICE_URL")
 		os.Unsetenv("CBS_SERVICE_URL")
+		os.Unsetenv("CRM_SERVICE_TEAM")
+		os.Unsetenv("CRM_SERVICE_TIER")
 	}()
 
 	cfg, err := Load()
@@ -71,6 +79,9 @@ func TestLoadMultipleBackends(t *testing.T) {
 	if crmTarget.URL != "http://crm:9001" {
 		t.Errorf("expected crm target URL to be 'http://crm:9001', got '%s'", crmTarget.URL)
 	}
+	if crmTarget.Labels.Team != "customer-platform" || crmTarget.Labels.Tier != "tier-1" {
+		t.Errorf("expected crm labels to be loaded, got %+v", crmTarget.Labels)
+	}
 
 	cbsTarget, ok := cfg.Proxy.Targets["cbs"]
 	if !ok {
@@ -81,6 +92,140 @@ func TestLoadMultipleBackends(t *testing.T) {
 	}
 }
 
+func TestLoadComponentLevels(t *testing.T) {
+	os.Setenv("LOG_LEVEL_PROXY", "DEBUG")
+	defer os.Unsetenv("LOG_LEVEL_PROXY")
+
+	levels := loadComponentLevels()
+	if len(levels) != 1 || levels["proxy"] != "debug" {
+		t.Errorf("expected only proxy level 'debug', got %v", levels)
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	os.Setenv("TEST_JWT_SECRET", "file-secret")
+	defer os.Unsetenv("TEST_JWT_SECRET")
+
+	path := filepath.Join(t.TempDir(), "gateway.yaml")
+	data := `
+server:
+  port: 9090
+jwt:
+  secret: ${TEST_JWT_SECRET}
+proxy:
+  timeout: 5s
+  targets:
+    crm:
+      url: http://crm:9001
+      labels:
+        team: customer-platform
+`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+
+	cfg, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile() failed: %v", err)
+	}
+
+	if cfg.Server.Port != 9090 {
+		t.Errorf("expected server port to be 9090, got %d", cfg.Server.Port)
+	}
+	if cfg.JWT.Secret != "file-secret" {
+		t.Errorf("expected JWT secret to be expanded from env, got '%s'", cfg.JWT.Secret)
+	}
+	if cfg.Proxy.Timeout != 5*time.Second {
+		t.Errorf("expected proxy timeout to be 5s, got %v", cfg.Proxy.Timeout)
+	}
+	if cfg.Proxy.Targets["crm"].Labels.Team != "customer-platform" {
+		t.Errorf("expected crm team label to be loaded, got %+v", cfg.Proxy.Targets["crm"].Labels)
+	}
+
+	// values not in the file keep their defaults
+	if cfg.Server.Host != "0.0.0.0" {
+		t.Errorf("expected default server host, got '%s'", cfg.Server.Host)
+	}
+}
+
+func TestLoadFileUnknownField(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gateway.yaml")
+	if err := os.WriteFile(path, []byte("server:\n  prot: 9090\n"), 0o600); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+
+	if _, err := LoadFile(path); err == nil {
+		t.Error("expected error for unknown field")
+	}
+}
+
+func TestNewKVStore(t *testing.T) {
+	tests := []struct {
+		url     string
+		wantErr bool
+	}{
+		{url: "consul://consul:8500/gateway/config"},
+		{url: "etcd+https://etcd:2379/gateway/config"},
+		{url: "consul://consul:8500/", wantErr: true},
+		{url: "redis://redis:6379/gateway", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			_, err := NewKVStore(KVConfig{URL: tt.url})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewKVStore() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestResolveSecretRefs(t *testing.T) {
+	cfg := &Config{
+		JWT: JWTConfig{Secret: "secretsmanager://prod/gateway#jwt_secret"},
+		Proxy: ProxyConfig{
+			Targets: map[string]TargetConfig{
+				"crm": {URL: "ssm:///gateway/crm-url", Endpoints: []string{"ssm:///gateway/crm-url"}},
+				"cbs": {URL: "http://cbs:9002"},
+			},
+		},
+		KV: KVConfig{Token: "ssm:///gateway/kv-token"},
+	}
+
+	lookups := 0
+	lookup := func(_ context.Context, ref string) (string, error) {
+		lookups++
+		switch ref {
+		case "secretsmanager://prod/gateway#jwt_secret":
+			return "resolved-secret", nil
+		case "ssm:///gateway/crm-url":
+			return "http://crm:9001", nil
+		}
+		return "", fmt.Errorf("unexpected reference %q", ref)
+	}
+
+	if err := resolveSecretRefs(context.Background(), cfg, lookup); err != nil {
+		t.Fatalf("resolveSecretRefs() failed: %v", err)
+	}
+
+	if cfg.JWT.Secret != "resolved-secret" {
+		t.Errorf("expected JWT secret to be resolved, got '%s'", cfg.JWT.Secret)
+	}
+	crm := cfg.Proxy.Targets["crm"]
+	if crm.URL != "http://crm:9001" || crm.Endpoints[0] != "http://crm:9001" {
+		t.Errorf("expected crm URLs to be resolved, got %+v", crm)
+	}
+	if cfg.Proxy.Targets["cbs"].URL != "http://cbs:9002" {
+		t.Errorf("expected plain values to be kept, got '%s'", cfg.Proxy.Targets["cbs"].URL)
+	}
+	if cfg.KV.Token != "ssm:///gateway/kv-token" {
+		t.Errorf("expected fields outside the YAML config to be kept, got '%s'", cfg.KV.Token)
+	}
+	if lookups != 2 {
+		t.Errorf("expected each reference to be looked up once, got %d lookups", lookups)
+	}
+}
+
 func TestValidate(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -88,63 +233,693 @@ func TestValidate(t *testing.T) {
 		wantErr bool
 	}{
 		{
-			name: "valid config",
+			name: "valid config",
+			config: &Config{
+				JWT: JWTConfig{Secret: "secret"},
+				Proxy: ProxyConfig{
+					Targets: map[string]TargetConfig{
+						"default": {URL: "http://localhost:9000"},
+					},
+				},
+				Server: ServerConfig{Port: 8080},
+			},
+			wantErr: false,
+		},
+		{
+			name: "valid multi-backend config",
+			config: &Config{
+				JWT: JWTConfig{Secret: "secret"},
+				Proxy: ProxyConfig{
+					Targets: map[string]TargetConfig{
+						"crm": {URL: "http://crm:9001"},
+						"cbs": {URL: "http://cbs:9002"},
+					},
+				},
+				Server: ServerConfig{Port: 8080},
+			},
+			wantErr: false,
+		},
+		{
+			name: "missing JWT secret",
+			config: &Config{
+				JWT: JWTConfig{Secret: ""},
+				Proxy: ProxyConfig{
+					Targets: map[string]TargetConfig{
+						"default": {URL: "http://localhost:9000"},
+					},
+				},
+				Server: ServerConfig{Port: 8080},
+			},
+			wantErr: true,
+		},
+		{
+			name: "no proxy targets",
+			config: &Config{
+				JWT: JWTConfig{Secret: "secret"},
+				Proxy: ProxyConfig{
+					Targets: map[string]TargetConfig{},
+				},
+				Server: ServerConfig{Port: 8080},
+			},
+			wantErr: true,
+		},
+		{
+			name: "service discovery without static targets",
+			config: &Config{
+				JWT:       JWTConfig{Secret: "secret"},
+				Server:    ServerConfig{Port: 8080},
+				Discovery: DiscoveryConfig{Mode: "kubernetes"},
+			},
+			wantErr: false,
+		},
+		{
+			name: "consul discovery without services",
+			config: &Config{
+				JWT:       JWTConfig{Secret: "secret"},
+				Server:    ServerConfig{Port: 8080},
+				Discovery: DiscoveryConfig{Mode: "consul"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "empty target URL",
+			config: &Config{
+				JWT: JWTConfig{Secret: "secret"},
+				Proxy: ProxyConfig{
+					Targets: map[string]TargetConfig{
+						"crm": {URL: ""},
+					},
+				},
+				Server: ServerConfig{Port: 8080},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid gzip mode",
+			config: &Config{
+				JWT: JWTConfig{Secret: "secret"},
+				Proxy: ProxyConfig{
+					Targets: map[string]TargetConfig{
+						"default": {URL: "http://localhost:9000"},
+					},
+				},
+				Server:  ServerConfig{Port: 8080},
+				Request: RequestConfig{GzipMode: "inflate"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "session cookie with short secret",
+			config: &Config{
+				JWT: JWTConfig{
+					Secret:        "secret",
+					SessionCookie: SessionCookieConfig{Enabled: true, Secret: "short", Name: "session", SameSite: "lax"},
+				},
+				Proxy: ProxyConfig{
+					Targets: map[string]TargetConfig{
+						"default": {URL: "http://localhost:9000"},
+					},
+				},
+				Server: ServerConfig{Port: 8080},
+			},
+			wantErr: true,
+		},
+		{
+			name: "relative CSRF exempt path",
+			config: &Config{
+				JWT: JWTConfig{
+					Secret: "secret",
+					SessionCookie: SessionCookieConfig{
+						Enabled:         true,
+						Secret:          "0123456789abcdef0123456789abcdef",
+						Name:            "session",
+						CSRFCookieName:  "csrf",
+						SameSite:        "lax",
+						CSRFExemptPaths: []string{"crm/webhooks/*"},
+					},
+				},
+				Proxy: ProxyConfig{
+					Targets: map[string]TargetConfig{
+						"default": {URL: "http://localhost:9000"},
+					},
+				},
+				Server: ServerConfig{Port: 8080},
+			},
+			wantErr: true,
+		},
+		{
+			name: "single-use paths without revocation store",
+			config: &Config{
+				JWT: JWTConfig{Secret: "secret"},
+				Proxy: ProxyConfig{
+					Targets: map[string]TargetConfig{
+						"billing": {URL: "http://localhost:9000", SingleUsePaths: []string{"/billing/payments/*"}},
+					},
+				},
+				Server: ServerConfig{Port: 8080},
+			},
+			wantErr: true,
+		},
+		{
+			name: "mtls auth without client CA",
+			config: &Config{
+				JWT: JWTConfig{Secret: "secret"},
+				Proxy: ProxyConfig{
+					Targets: map[string]TargetConfig{
+						"billing": {URL: "http://localhost:9000", Auth: "mtls"},
+					},
+				},
+				Server: ServerConfig{Port: 8080},
+			},
+			wantErr: true,
+		},
+		{
+			name: "weight of unknown upstream",
+			config: &Config{
+				JWT: JWTConfig{Secret: "secret"},
+				Proxy: ProxyConfig{
+					Targets: map[string]TargetConfig{
+						"crm": {
+							URL:       "http://localhost:9000",
+							Endpoints: []string{"http://localhost:9001"},
+							Weights:   map[string]int{"http://localhost:9002": 3},
+						},
+					},
+				},
+				Server: ServerConfig{Port: 8080},
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown balancer",
+			config: &Config{
+				JWT: JWTConfig{Secret: "secret"},
+				Proxy: ProxyConfig{
+					Targets: map[string]TargetConfig{
+						"crm": {URL: "http://localhost:9000", Balancer: "random"},
+					},
+				},
+				Server: ServerConfig{Port: 8080},
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown affinity",
+			config: &Config{
+				JWT: JWTConfig{Secret: "secret"},
+				Proxy: ProxyConfig{
+					Targets: map[string]TargetConfig{
+						"crm": {URL: "http://localhost:9000", Affinity: AffinityConfig{By: "header"}},
+					},
+				},
+				Server: ServerConfig{Port: 8080},
+			},
+			wantErr: true,
+		},
+		{
+			name: "backups without health check",
+			config: &Config{
+				JWT: JWTConfig{Secret: "secret"},
+				Proxy: ProxyConfig{
+					Targets: map[string]TargetConfig{
+						"crm": {URL: "http://localhost:9000", Backups: []string{"http://localhost:9001"}},
+					},
+				},
+				Server: ServerConfig{Port: 8080},
+			},
+			wantErr: true,
+		},
+		{
+			name: "max ejection percent above 100",
+			config: &Config{
+				JWT: JWTConfig{Secret: "secret"},
+				Proxy: ProxyConfig{
+					Targets: map[string]TargetConfig{
+						"crm": {URL: "http://localhost:9000", Outliers: OutlierConfig{ConsecutiveErrors: 5, MaxEjectionPercent: 150}},
+					},
+				},
+				Server: ServerConfig{Port: 8080},
+			},
+			wantErr: true,
+		},
+		{
+			name: "idempotency paths without TTL",
+			config: &Config{
+				JWT: JWTConfig{Secret: "secret"},
+				Proxy: ProxyConfig{
+					Targets: map[string]TargetConfig{
+						"billing": {URL: "http://localhost:9000", IdempotencyPaths: []string{"/billing/payments/*"}},
+					},
+				},
+				Server: ServerConfig{Port: 8080},
+			},
+			wantErr: true,
+		},
+		{
+			name: "idempotency paths without max body size",
+			config: &Config{
+				JWT: JWTConfig{Secret: "secret"},
+				Proxy: ProxyConfig{
+					Targets: map[string]TargetConfig{
+						"billing": {URL: "http://localhost:9000", IdempotencyPaths: []string{"/billing/payments/*"}},
+					},
+				},
+				Server:      ServerConfig{Port: 8080},
+				Idempotency: IdempotencyConfig{TTL: time.Hour},
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown cache request no-cache policy",
+			config: &Config{
+				JWT: JWTConfig{Secret: "secret"},
+				Proxy: ProxyConfig{
+					Targets: map[string]TargetConfig{
+						"crm": {URL: "http://crm:9001", Cache: TargetCacheConfig{Paths: []string{"/crm/catalog/*"}, RequestNoCache: "revalidate"}},
+					},
+				},
+				Server: ServerConfig{Port: 8080},
+				Cache:  CacheConfig{MaxSize: 1 << 20, MaxEntrySize: 1 << 10},
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative cache TTL",
+			config: &Config{
+				JWT: JWTConfig{Secret: "secret"},
+				Proxy: ProxyConfig{
+					Targets: map[string]TargetConfig{
+						"crm": {URL: "http://crm:9001", Cache: TargetCacheConfig{Paths: []string{"/crm/catalog/*"}, TTL: -time.Second}},
+					},
+				},
+				Server: ServerConfig{Port: 8080},
+				Cache:  CacheConfig{MaxSize: 1 << 20, MaxEntrySize: 1 << 10},
+			},
+			wantErr: true,
+		},
+		{
+			name: "cache override without TTL",
+			config: &Config{
+				JWT: JWTConfig{Secret: "secret"},
+				Proxy: ProxyConfig{
+					Targets: map[string]TargetConfig{
+						"crm": {URL: "http://crm:9001", Cache: TargetCacheConfig{
+							Paths:     []string{"/crm/catalog/*"},
+							Overrides: map[string]time.Duration{"/crm/catalog/*": 0},
+						}},
+					},
+				},
+				Server: ServerConfig{Port: 8080},
+				Cache:  CacheConfig{MaxSize: 1 << 20, MaxEntrySize: 1 << 10},
+			},
+			wantErr: true,
+		},
+		{
+			name: "cache paths without cache size",
+			config: &Config{
+				JWT: JWTConfig{Secret: "secret"},
+				Proxy: ProxyConfig{
+					Targets: map[string]TargetConfig{
+						"crm": {URL: "http://crm:9001", Cache: TargetCacheConfig{Paths: []string{"/crm/catalog/*"}, TTL: time.Minute}},
+					},
+				},
+				Server: ServerConfig{Port: 8080},
+			},
+			wantErr: true,
+		},
+		{
+			name: "buffered responses without max response size",
+			config: &Config{
+				JWT: JWTConfig{Secret: "secret"},
+				Proxy: ProxyConfig{
+					Targets: map[string]TargetConfig{
+						"crm": {URL: "http://localhost:9000", BufferResponses: true},
+					},
+				},
+				Server: ServerConfig{Port: 8080},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid bandwidth client key",
+			config: &Config{
+				JWT: JWTConfig{Secret: "secret"},
+				Proxy: ProxyConfig{
+					Targets: map[string]TargetConfig{
+						"crm": {URL: "http://localhost:9000"},
+					},
+				},
+				Server:    ServerConfig{Port: 8080},
+				Bandwidth: BandwidthConfig{Rate: 1 << 20, By: "token"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative slow start",
+			config: &Config{
+				JWT: JWTConfig{Secret: "secret"},
+				Proxy: ProxyConfig{
+					Targets: map[string]TargetConfig{
+						"crm": {URL: "http://localhost:9000", SlowStart: -time.Second},
+					},
+				},
+				Server: ServerConfig{Port: 8080},
+			},
+			wantErr: true,
+		},
+		{
+			name: "unix socket route URL",
+			config: &Config{
+				JWT: JWTConfig{Secret: "secret"},
+				Proxy: ProxyConfig{
+					Targets: map[string]TargetConfig{
+						"crm": {URL: "http://localhost:9000", Routes: []RouteRule{
+							{Path: "/crm/reports/*", URL: "unix:///var/run/reports.sock"},
+						}},
+					},
+				},
+				Server: ServerConfig{Port: 8080},
+			},
+			wantErr: false,
+		},
+		{
+			name: "default API version without URL",
+			config: &Config{
+				JWT: JWTConfig{Secret: "secret"},
+				Proxy: ProxyConfig{
+					Targets: map[string]TargetConfig{
+						"crm": {
+							URL: "http://localhost:9000",
+							Versions: VersionConfig{
+								URLs:    map[string]string{"v2": "http://localhost:9002"},
+								Default: "v1",
+							},
+						},
+					},
+				},
+				Server: ServerConfig{Port: 8080},
+			},
+			wantErr: true,
+		},
+		{
+			name: "redis revocation store without URL",
+			config: &Config{
+				JWT: JWTConfig{Secret: "secret", RevocationStore: "redis"},
+				Proxy: ProxyConfig{
+					Targets: map[string]TargetConfig{
+						"default": {URL: "http://localhost:9000"},
+					},
+				},
+				Server: ServerConfig{Port: 8080},
+			},
+			wantErr: true,
+		},
+		{
+			name: "trusted issuer without secret",
+			config: &Config{
+				JWT: JWTConfig{
+					Secret:         "secret",
+					TrustedIssuers: []TrustedIssuerConfig{{Issuer: "legacy-idp"}},
+				},
+				Proxy: ProxyConfig{
+					Targets: map[string]TargetConfig{
+						"default": {URL: "http://localhost:9000"},
+					},
+				},
+				Server: ServerConfig{Port: 8080},
+			},
+			wantErr: true,
+		},
+		{
+			name: "hmac auth without keys",
+			config: &Config{
+				JWT: JWTConfig{Secret: "secret"},
+				Proxy: ProxyConfig{
+					Targets: map[string]TargetConfig{
+						"billing": {URL: "http://billing:9003", Auth: "hmac"},
+					},
+				},
+				Server: ServerConfig{Port: 8080},
+			},
+			wantErr: true,
+		},
+		{
+			name: "basic auth without users",
+			config: &Config{
+				JWT: JWTConfig{Secret: "secret"},
+				Proxy: ProxyConfig{
+					Targets: map[string]TargetConfig{
+						"grafana": {URL: "http://grafana:3000", Auth: "basic"},
+					},
+				},
+				Server: ServerConfig{Port: 8080},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid component log level",
+			config: &Config{
+				JWT: JWTConfig{Secret: "secret"},
+				Proxy: ProxyConfig{
+					Targets: map[string]TargetConfig{
+						"default": {URL: "http://localhost:9000"},
+					},
+				},
+				Server: ServerConfig{Port: 8080},
+				Log:    LogConfig{ComponentLevels: map[string]string{"proxy": "verbose"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid port",
+			config: &Config{
+				JWT: JWTConfig{Secret: "secret"},
+				Proxy: ProxyConfig{
+					Targets: map[string]TargetConfig{
+						"default": {URL: "http://localhost:9000"},
+					},
+				},
+				Server: ServerConfig{Port: 70000},
+			},
+			wantErr: true,
+		},
+		{
+			name: "replace prefix mode without prefix",
 			config: &Config{
 				JWT: JWTConfig{Secret: "secret"},
 				Proxy: ProxyConfig{
 					Targets: map[string]TargetConfig{
-						"default": {URL: "http://localhost:9000"},
+						"crm": {URL: "http://crm:9001", PrefixMode: "replace"},
 					},
 				},
 				Server: ServerConfig{Port: 8080},
 			},
-			wantErr: false,
+			wantErr: true,
 		},
 		{
-			name: "valid multi-backend config",
+			name: "request template without template",
+			config: &Config{
+				JWT: JWTConfig{Secret: "secret"},
+				Proxy: ProxyConfig{
+					Targets: map[string]TargetConfig{
+						"crm": {URL: "http://crm:9001", RequestTemplates: []RequestTemplate{{Path: "/crm/contacts"}}},
+					},
+				},
+				Server: ServerConfig{Port: 8080},
+			},
+			wantErr: true,
+		},
+		{
+			name: "response field renamed to path",
+			config: &Config{
+				JWT: JWTConfig{Secret: "secret"},
+				Proxy: ProxyConfig{
+					Targets: map[string]TargetConfig{
+						"crm": {URL: "http://crm:9001", ResponseBody: ResponseBodyConfig{RenameFields: map[string]string{"owner.fullName": "owner.name"}}},
+					},
+				},
+				Server: ServerConfig{Port: 8080},
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative graphql max depth",
+			config: &Config{
+				JWT: JWTConfig{Secret: "secret"},
+				Proxy: ProxyConfig{
+					Targets: map[string]TargetConfig{
+						"catalog": {URL: "http://catalog:9001", GraphQL: GraphQLConfig{Paths: []string{"/catalog/graphql"}, MaxDepth: -1}},
+					},
+				},
+				Server: ServerConfig{Port: 8080},
+			},
+			wantErr: true,
+		},
+		{
+			name: "aggregate call requesting an aggregate route",
 			config: &Config{
 				JWT: JWTConfig{Secret: "secret"},
 				Proxy: ProxyConfig{
 					Targets: map[string]TargetConfig{
 						"crm": {URL: "http://crm:9001"},
-						"cbs": {URL: "http://cbs:9002"},
 					},
 				},
 				Server: ServerConfig{Port: 8080},
+				Aggregation: AggregationConfig{Routes: []AggregateRoute{
+					{Path: "/dashboard", Calls: map[string]string{"crm": "/crm/summary", "self": "/dashboard"}},
+				}},
 			},
-			wantErr: false,
+			wantErr: true,
 		},
 		{
-			name: "missing JWT secret",
+			name: "static prefix overlapping a service",
 			config: &Config{
-				JWT: JWTConfig{Secret: ""},
+				JWT: JWTConfig{Secret: "secret"},
+				Proxy: ProxyConfig{
+					Targets: map[string]TargetConfig{
+						"crm": {URL: "http://crm:9001"},
+					},
+				},
+				Server: ServerConfig{Port: 8080},
+				Static: StaticConfig{Dir: "./web", Prefix: "/crm/ui", Index: "index.html"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "admin prefix overlapping a service",
+			config: &Config{
+				JWT: JWTConfig{Secret: "secret"},
+				Proxy: ProxyConfig{
+					Targets: map[string]TargetConfig{
+						"crm": {URL: "http://crm:9001"},
+					},
+				},
+				Server: ServerConfig{Port: 8080},
+				Admin:  AdminConfig{Enabled: true, Prefix: "/crm/admin", Role: "admin"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "admin prefix at the root",
+			config: &Config{
+				JWT: JWTConfig{Secret: "secret"},
 				Proxy: ProxyConfig{
 					Targets: map[string]TargetConfig{
 						"default": {URL: "http://localhost:9000"},
 					},
 				},
 				Server: ServerConfig{Port: 8080},
+				Admin:  AdminConfig{Enabled: true, Prefix: "/", Role: "admin"},
 			},
 			wantErr: true,
 		},
 		{
-			name: "no proxy targets",
+			name: "metrics path overlapping a service",
 			config: &Config{
 				JWT: JWTConfig{Secret: "secret"},
 				Proxy: ProxyConfig{
-					Targets: map[string]TargetConfig{},
+					Targets: map[string]TargetConfig{
+						"crm": {URL: "http://crm:9001"},
+					},
+				},
+				Server:  ServerConfig{Port: 8080},
+				Metrics: MetricsConfig{Enabled: true, Path: "/crm/metrics"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "metrics port of the gateway",
+			config: &Config{
+				JWT: JWTConfig{Secret: "secret"},
+				Proxy: ProxyConfig{
+					Targets: map[string]TargetConfig{
+						"default": {URL: "http://localhost:9000"},
+					},
+				},
+				Server:  ServerConfig{Port: 8080},
+				Metrics: MetricsConfig{Enabled: true, Path: "/metrics", Port: 8080},
+			},
+			wantErr: true,
+		},
+		{
+			name: "redirect keeping the rest of an exact path",
+			config: &Config{
+				JWT: JWTConfig{Secret: "secret"},
+				Proxy: ProxyConfig{
+					Targets: map[string]TargetConfig{
+						"crm": {URL: "http://crm:9001"},
+					},
+				},
+				Server:    ServerConfig{Port: 8080},
+				Redirects: []RedirectRule{{Path: "/v1/customers", To: "/crm/customers/*"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "error page for a success status",
+			config: &Config{
+				JWT: JWTConfig{Secret: "secret"},
+				Proxy: ProxyConfig{
+					Targets: map[string]TargetConfig{
+						"crm": {URL: "http://crm:9001"},
+					},
+				},
+				Server:     ServerConfig{Port: 8080},
+				ErrorPages: map[int]string{200: "./errors/200.html"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "rate limit tier without roles or plans",
+			config: &Config{
+				JWT: JWTConfig{Secret: "secret"},
+				Proxy: ProxyConfig{
+					Targets: map[string]TargetConfig{
+						"crm": {URL: "http://crm:9001"},
+					},
+				},
+				Server:    ServerConfig{Port: 8080},
+				RateLimit: RateLimitConfig{Rate: 10, Tiers: []RateLimitTier{{Name: "premium", Rate: 100}}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "redis quota store without url",
+			config: &Config{
+				JWT: JWTConfig{Secret: "secret"},
+				Proxy: ProxyConfig{
+					Targets: map[string]TargetConfig{
+						"crm": {URL: "http://crm:9001"},
+					},
+				},
+				Server:    ServerConfig{Port: 8080},
+				RateLimit: RateLimitConfig{Monthly: 100000, Store: "redis"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown rate limit tier algorithm",
+			config: &Config{
+				JWT: JWTConfig{Secret: "secret"},
+				Proxy: ProxyConfig{
+					Targets: map[string]TargetConfig{
+						"crm": {URL: "http://crm:9001"},
+					},
 				},
 				Server: ServerConfig{Port: 8080},
+				RateLimit: RateLimitConfig{Rate: 10, Tiers: []RateLimitTier{
+					{Name: "premium", Plans: []string{"premium"}, Rate: 100, Algorithm: "leaky_bucket"},
+				}},
 			},
 			wantErr: true,
 		},
 		{
-			name: "empty target URL",
+			name: "negative service rate limit",
 			config: &Config{
 				JWT: JWTConfig{Secret: "secret"},
 				Proxy: ProxyConfig{
 					Targets: map[string]TargetConfig{
-						"crm": {URL: ""},
+						"cbs": {URL: "http://cbs:8080", RateLimit: ServiceRateLimitConfig{Rate: -50}},
 					},
 				},
 				Server: ServerConfig{Port: 8080},
@@ -152,7 +927,87 @@ func TestValidate(t *testing.T) {
 			wantErr: true,
 		},
 		{
-			name: "invalid port",
+			name: "concurrency class without selectors",
+			config: &Config{
+				JWT: JWTConfig{Secret: "secret"},
+				Proxy: ProxyConfig{
+					Targets: map[string]TargetConfig{
+						"crm": {URL: "http://crm:9001"},
+					},
+				},
+				Server:      ServerConfig{Port: 8080},
+				Concurrency: ConcurrencyConfig{ServiceMaxInFlight: 100, Classes: []PriorityClass{{Name: "export", Priority: -10}}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "aimd adaptive concurrency without latency",
+			config: &Config{
+				JWT: JWTConfig{Secret: "secret"},
+				Proxy: ProxyConfig{
+					Targets: map[string]TargetConfig{
+						"crm": {URL: "http://crm:9001", AdaptiveConcurrency: AdaptiveConcurrencyConfig{Algorithm: "aimd"}},
+					},
+				},
+				Server: ServerConfig{Port: 8080},
+			},
+			wantErr: true,
+		},
+		{
+			name: "request timeout for a relative path",
+			config: &Config{
+				JWT: JWTConfig{Secret: "secret"},
+				Proxy: ProxyConfig{
+					Targets: map[string]TargetConfig{
+						"billing": {URL: "http://billing:8080", RequestTimeouts: map[string]time.Duration{"reports/*": time.Minute}},
+					},
+				},
+				Server: ServerConfig{Port: 8080},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid deadline header",
+			config: &Config{
+				JWT: JWTConfig{Secret: "secret"},
+				Proxy: ProxyConfig{
+					Targets: map[string]TargetConfig{
+						"crm": {URL: "http://crm:9001"},
+					},
+					DeadlineHeader: "X-Request-Timeout: ms",
+				},
+				Server: ServerConfig{Port: 8080},
+			},
+			wantErr: true,
+		},
+		{
+			name: "recording without file",
+			config: &Config{
+				JWT: JWTConfig{Secret: "secret"},
+				Proxy: ProxyConfig{
+					Targets: map[string]TargetConfig{
+						"crm": {URL: "http://crm:9001", Recording: RecordingConfig{Mode: "replay"}},
+					},
+				},
+				Server: ServerConfig{Port: 8080},
+			},
+			wantErr: true,
+		},
+		{
+			name: "mock responses without mock URL",
+			config: &Config{
+				JWT: JWTConfig{Secret: "secret"},
+				Proxy: ProxyConfig{
+					Targets: map[string]TargetConfig{
+						"crm": {URL: "http://crm:9001", Mock: []MockResponse{{Path: "/crm/contacts"}}},
+					},
+				},
+				Server: ServerConfig{Port: 8080},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid trailing slash policy",
 			config: &Config{
 				JWT: JWTConfig{Secret: "secret"},
 				Proxy: ProxyConfig{
@@ -160,7 +1015,21 @@ func TestValidate(t *testing.T) {
 						"default": {URL: "http://localhost:9000"},
 					},
 				},
-				Server: ServerConfig{Port: 70000},
+				Server:  ServerConfig{Port: 8080},
+				Request: RequestConfig{TrailingSlash: "add"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid trusted proxy",
+			config: &Config{
+				JWT: JWTConfig{Secret: "secret"},
+				Proxy: ProxyConfig{
+					Targets: map[string]TargetConfig{
+						"default": {URL: "http://localhost:9000"},
+					},
+				},
+				Server: ServerConfig{Port: 8080, TrustedProxies: []string{"10.0.0.0/8", "lb.internal"}},
 			},
 			wantErr: true,
 		},
@@ -176,6 +1045,37 @@ func TestValidate(t *testing.T) {
 	}
 }
 
+func TestValidateSingleUsePathsRequireJWT(t *testing.T) {
+	targets := map[string]TargetConfig{
+		"api-key": {Auth: "api-key", APIKey: APIKeyConfig{Keys: map[string]string{"partner": "key"}}},
+		"hmac":    {Auth: "hmac", HMAC: HMACConfig{Keys: map[string]string{"partner": "secret"}}},
+		"basic":   {Auth: "basic", Basic: BasicAuthConfig{Users: map[string]string{"admin": "$2y$10$hash"}}},
+		"mtls":    {Auth: "mtls"},
+		"none":    {Auth: "none"},
+	}
+
+	for auth, target := range targets {
+		t.Run(auth, func(t *testing.T) {
+			target.URL = "http://localhost:9000"
+			cfg := &Config{
+				JWT:    JWTConfig{Secret: "secret", RevocationStore: "memory"},
+				Server: ServerConfig{Port: 8080, TLS: TLSConfig{CertFile: "cert.pem", KeyFile: "key.pem", ClientCAFile: "ca.pem"}},
+				Proxy:  ProxyConfig{Targets: map[string]TargetConfig{"billing": target}},
+			}
+			if err := cfg.Validate(); err != nil {
+				t.Fatalf("expected the %s service to be valid, got %v", auth, err)
+			}
+
+			target.SingleUsePaths = []string{"/billing/payments/*"}
+			cfg.Proxy.Targets["billing"] = target
+			err := cfg.Validate()
+			if err == nil || !strings.Contains(err.Error(), "single-use paths require jwt auth") {
+				t.Errorf("expected single-use paths to be rejected with %s auth, got %v", auth, err)
+			}
+		})
+	}
+}
+
 func TestGetEnvAsDuration(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -281,3 +1181,67 @@ func TestGetEnvAsSlice(t *testing.T) {
 		})
 	}
 }
+
+func TestGetEnvAsFloatMap(t *testing.T) {
+	os.Setenv("TEST_FLOAT_MAP", "/health=0, /crm=0.01,invalid,/cbs=x")
+	defer os.Unsetenv("TEST_FLOAT_MAP")
+
+	result := getEnvAsFloatMap("TEST_FLOAT_MAP")
+	if len(result) != 2 {
+		t.Fatalf("getEnvAsFloatMap() length = %d, expected 2", len(result))
+	}
+	if result["/health"] != 0 {
+		t.Errorf("getEnvAsFloatMap()[/health] = %v, expected 0", result["/health"])
+	}
+	if result["/crm"] != 0.01 {
+		t.Errorf("getEnvAsFloatMap()[/crm] = %v, expected 0.01", result["/crm"])
+	}
+}
+
+func TestGetEnvAsIntMap(t *testing.T) {
+	os.Setenv("TEST_INT_MAP", "http://crm-2:9001=4, http://crm-3:9001=0,invalid,http://crm-4:9001=x")
+	defer os.Unsetenv("TEST_INT_MAP")
+
+	result := getEnvAsIntMap("TEST_INT_MAP")
+	if len(result) != 2 {
+		t.Fatalf("getEnvAsIntMap() length = %d, expected 2", len(result))
+	}
+	if result["http://crm-2:9001"] != 4 {
+		t.Errorf("getEnvAsIntMap()[http://crm-2:9001] = %d, expected 4", result["http://crm-2:9001"])
+	}
+	if w, ok := result["http://crm-3:9001"]; !ok || w != 0 {
+		t.Errorf("getEnvAsIntMap()[http://crm-3:9001] = %d, %v, expected 0, true", w, ok)
+	}
+}
+
+func TestGetEnvAsMap(t *testing.T) {
+	os.Setenv("TEST_MAP", "v1=old-secret, v2=c2VjcmV0==,invalid")
+	defer os.Unsetenv("TEST_MAP")
+
+	result := getEnvAsMap("TEST_MAP")
+	if len(result) != 2 {
+		t.Fatalf("getEnvAsMap() length = %d, expected 2", len(result))
+	}
+	if result["v1"] != "old-secret" {
+		t.Errorf("getEnvAsMap()[v1] = %q, expected 'old-secret'", result["v1"])
+	}
+	if result["v2"] != "c2VjcmV0==" {
+		t.Errorf("getEnvAsMap()[v2] = %q, expected 'c2VjcmV0=='", result["v2"])
+	}
+}
+
+func TestLoadRouteRules(t *testing.T) {
+	os.Setenv("TEST_SERVICE_ROUTES", "GET|HEAD /crm/reports/* http://reporting:8080, * /crm/users/{id} http://users:8080")
+	defer os.Unsetenv("TEST_SERVICE_ROUTES")
+
+	rules := loadRouteRules("TEST_SERVICE")
+	if len(rules) != 2 {
+		t.Fatalf("loadRouteRules() length = %d, expected 2", len(rules))
+	}
+	if len(rules[0].Methods) != 2 || rules[0].Path != "/crm/reports/*" || rules[0].URL != "http://reporting:8080" {
+		t.Errorf("loadRouteRules()[0] = %+v", rules[0])
+	}
+	if rules[1].Methods != nil || rules[1].Path != "/crm/users/{id}" {
+		t.Errorf("loadRouteRules()[1] = %+v, expected any method", rules[1])
+	}
+}
@@ -0,0 +1,314 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// KVStore holds the gateway config as a YAML document under a single key,
+// so a fleet of gateways can be managed centrally.
+type KVStore interface {
+	// Get returns the current value of the key
+	Get(ctx context.Context) ([]byte, error)
+	// Watch calls onChange with every new value of the key written after the
+	// last Get or change, until ctx is done or the watch fails. Deleting the
+	// key is not a change, the last config stays in effect. After a failure,
+	// call Get before watching again.
+	Watch(ctx context.Context, onChange func(data []byte)) error
+}
+
+// kvWait is how long a watch request waits for changes
+const kvWait = 5 * time.Minute
+
+// LoadKV loads configuration from a KV store on top of the environment values.
+func LoadKV(kv KVConfig) (*Config, error) {
+	store, err := NewKVStore(kv)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	data, err := store.Get(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config from %s: %w", kv.URL, err)
+	}
+
+	cfg, err := Parse(data, kv.URL)
+	if err != nil {
+		return nil, err
+	}
+	cfg.KV = kv
+	return cfg, nil
+}
+
+// NewKVStore creates a client for the key named by the URL path, e.g.
+// consul://consul:8500/gateway/config or etcd://etcd:2379/gateway/config.
+// Append +https to the scheme (consul+https://) to use TLS.
+func NewKVStore(kv KVConfig) (KVStore, error) {
+	u, err := url.Parse(kv.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CONFIG_KV_URL: %w", err)
+	}
+
+	key := strings.TrimPrefix(u.Path, "/")
+	if u.Host == "" || key == "" {
+		return nil, fmt.Errorf("CONFIG_KV_URL must be <store>://<host:port>/<key>")
+	}
+
+	kind, scheme, _ := strings.Cut(u.Scheme, "+")
+	if scheme == "" {
+		scheme = "http"
+	}
+	address := scheme + "://" + u.Host
+	client := &http.Client{Timeout: kvWait + 30*time.Second}
+
+	switch kind {
+	case "consul":
+		return &consulKV{address: address, key: key, token: kv.Token, client: client}, nil
+	case "etcd":
+		return &etcdKV{address: address, key: key, token: kv.Token, client: client}, nil
+	default:
+		return nil, fmt.Errorf("CONFIG_KV_URL scheme must be consul or etcd")
+	}
+}
+
+// consulKV reads a key from the Consul KV store, watched with blocking queries
+type consulKV struct {
+	address string
+	key     string
+	token   string
+	client  *http.Client
+	index   uint64 // X-Consul-Index of the last value
+}
+
+// Get implements KVStore
+func (c *consulKV) Get(ctx context.Context) ([]byte, error) {
+	data, index, err := c.read(ctx, 0)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, fmt.Errorf("key %q not found", c.key)
+	}
+	c.index = index
+	return data, nil
+}
+
+// Watch implements KVStore
+func (c *consulKV) Watch(ctx context.Context, onChange func(data []byte)) error {
+	for {
+		data, index, err := c.read(ctx, c.index)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		// blocking queries also return when they time out without a change,
+		// and the index can go backwards, e.g. after a snapshot restore
+		changed := index != c.index
+		if index < c.index {
+			index = 0
+		}
+		c.index = index
+
+		if changed && data != nil {
+			onChange(data)
+		}
+	}
+}
+
+// read returns the raw value of the key, nil if it does not exist, waiting
+// for a change after index if it is not zero
+func (c *consulKV) read(ctx context.Context, index uint64) ([]byte, uint64, error) {
+	params := url.Values{"raw": {""}}
+	if index > 0 {
+		params.Set("index", strconv.FormatUint(index, 10))
+		params.Set("wait", kvWait.String())
+	}
+	endpoint := fmt.Sprintf("%s/v1/kv/%s?%s", c.address, c.key, params.Encode())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if c.token != "" {
+		req.Header.Set("X-Consul-Token", c.token)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("consul API request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	next, _ := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, next, nil
+	default:
+		return nil, 0, fmt.Errorf("consul API returned status %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read consul response: %w", err)
+	}
+	return data, next, nil
+}
+
+// etcdKV reads a key from etcd through its v3 JSON gateway
+type etcdKV struct {
+	address  string
+	key      string
+	token    string
+	client   *http.Client
+	revision int64 // revision the last value was read at
+}
+
+// etcdKeyValue is a key-value pair, int64 values are encoded as strings
+type etcdKeyValue struct {
+	Value       []byte `json:"value"`
+	ModRevision int64  `json:"mod_revision,string"`
+}
+
+// etcdHeader is the response header of every etcd call
+type etcdHeader struct {
+	Revision int64 `json:"revision,string"`
+}
+
+// Get implements KVStore
+func (e *etcdKV) Get(ctx context.Context) ([]byte, error) {
+	var resp struct {
+		Header etcdHeader     `json:"header"`
+		Kvs    []etcdKeyValue `json:"kvs"`
+	}
+	if err := e.post(ctx, "/v3/kv/range", map[string]any{"key": []byte(e.key)}, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, fmt.Errorf("key %q not found", e.key)
+	}
+	e.revision = resp.Header.Revision
+	return resp.Kvs[0].Value, nil
+}
+
+// Watch implements KVStore
+func (e *etcdKV) Watch(ctx context.Context, onChange func(data []byte)) error {
+	body, err := json.Marshal(map[string]any{
+		"create_request": map[string]any{
+			"key":            []byte(e.key),
+			"start_revision": strconv.FormatInt(e.revision+1, 10),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := e.request(ctx, "/v3/watch", body)
+	if err != nil {
+		return err
+	}
+
+	// the watch stream has no overall timeout, it ends with ctx
+	client := *e.client
+	client.Timeout = 0
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return fmt.Errorf("etcd watch request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("etcd API returned status %d", resp.StatusCode)
+	}
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var msg struct {
+			Result struct {
+				Canceled     bool   `json:"canceled"`
+				CancelReason string `json:"cancel_reason"`
+				Events       []struct {
+					Type string       `json:"type"` // PUT is omitted as the zero value
+					Kv   etcdKeyValue `json:"kv"`
+				} `json:"events"`
+			} `json:"result"`
+		}
+		if err := decoder.Decode(&msg); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("etcd watch stream failed: %w", err)
+		}
+
+		if msg.Result.Canceled {
+			// typically the start revision was compacted, the caller
+			// has to Get the current value before watching again
+			return fmt.Errorf("etcd watch canceled: %s", msg.Result.CancelReason)
+		}
+
+		for _, event := range msg.Result.Events {
+			e.revision = event.Kv.ModRevision
+			if event.Type == "DELETE" {
+				continue
+			}
+			onChange(event.Kv.Value)
+		}
+	}
+}
+
+// post calls an etcd JSON gateway endpoint
+func (e *etcdKV) post(ctx context.Context, path string, in, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	req, err := e.request(ctx, path, body)
+	if err != nil {
+		return err
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("etcd API request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("etcd API returned status %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode etcd response: %w", err)
+	}
+	return nil
+}
+
+// request creates an authenticated etcd JSON gateway request
+func (e *etcdKV) request(ctx context.Context, path string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.address+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.token != "" {
+		req.Header.Set("Authorization", e.token)
+	}
+	return req, nil
+}
@@ -0,0 +1,187 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Secret reference prefixes. A config value such as
+// secretsmanager://prod/gateway#jwt_secret or ssm:///gateway/prod/jwt-secret
+// is replaced with the referenced value when the config is loaded.
+const (
+	secretsManagerPrefix = "secretsmanager://"
+	ssmPrefix            = "ssm://"
+)
+
+// secretLookup returns the value of a secret reference
+type secretLookup func(ctx context.Context, ref string) (string, error)
+
+// ResolveSecrets replaces AWS Secrets Manager and SSM Parameter Store
+// references in string config values with the values they point to, then
+// validates the result. AWS credentials and region come from the default
+// chain (environment, shared config, ECS task or EC2 instance role, IRSA)
+// and are only loaded when a reference is present.
+func ResolveSecrets(ctx context.Context, cfg *Config) error {
+	if err := resolveSecretRefs(ctx, cfg, newAWSSecretLookup()); err != nil {
+		return err
+	}
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("config validation failed: %w", err)
+	}
+	return nil
+}
+
+// resolveSecretRefs replaces every secret reference in cfg using lookup,
+// each distinct reference is looked up once
+func resolveSecretRefs(ctx context.Context, cfg *Config, lookup secretLookup) error {
+	resolved := make(map[string]string)
+	return replaceStrings(reflect.ValueOf(cfg).Elem(), func(value string) (string, error) {
+		if !isSecretRef(value) {
+			return value, nil
+		}
+		if secret, ok := resolved[value]; ok {
+			return secret, nil
+		}
+		secret, err := lookup(ctx, value)
+		if err != nil {
+			return "", fmt.Errorf("failed to resolve %q: %w", value, err)
+		}
+		resolved[value] = secret
+		return secret, nil
+	})
+}
+
+// isSecretRef reports whether value is a secret reference
+func isSecretRef(value string) bool {
+	return strings.HasPrefix(value, secretsManagerPrefix) || strings.HasPrefix(value, ssmPrefix)
+}
+
+// replaceStrings calls replace for every string in v, including strings in
+// nested structs, slices and map values, and stores the result. Fields that
+// are not part of the YAML config (yaml:"-") are left alone.
+func replaceStrings(v reflect.Value, replace func(string) (string, error)) error {
+	switch v.Kind() {
+	case reflect.String:
+		s, err := replace(v.String())
+		if err != nil {
+			return err
+		}
+		v.SetString(s)
+	case reflect.Pointer:
+		if !v.IsNil() {
+			return replaceStrings(v.Elem(), replace)
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := range t.NumField() {
+			field := t.Field(i)
+			if !field.IsExported() || field.Tag.Get("yaml") == "-" {
+				continue
+			}
+			if err := replaceStrings(v.Field(i), replace); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		for i := range v.Len() {
+			if err := replaceStrings(v.Index(i), replace); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		// map values are not addressable, replace a copy and store it back
+		for _, key := range v.MapKeys() {
+			value := reflect.New(v.Type().Elem()).Elem()
+			value.Set(v.MapIndex(key))
+			if err := replaceStrings(value, replace); err != nil {
+				return err
+			}
+			v.SetMapIndex(key, value)
+		}
+	}
+	return nil
+}
+
+// newAWSSecretLookup returns a lookup reading Secrets Manager secrets and
+// SSM parameters, the AWS client is created on first use
+func newAWSSecretLookup() secretLookup {
+	var client *awsClient
+
+	return func(ctx context.Context, ref string) (string, error) {
+		if client == nil {
+			c, err := newAWSClient(ctx)
+			if err != nil {
+				return "", err
+			}
+			client = c
+		}
+
+		if name, ok := strings.CutPrefix(ref, ssmPrefix); ok {
+			return client.getParameter(ctx, name)
+		}
+		return client.getSecret(ctx, strings.TrimPrefix(ref, secretsManagerPrefix))
+	}
+}
+
+// getSecret reads a Secrets Manager secret by name or ARN. A #key suffix
+// selects a field of a JSON secret, e.g. prod/gateway#jwt_secret.
+func (a *awsClient) getSecret(ctx context.Context, ref string) (string, error) {
+	id, key, hasKey := strings.Cut(ref, "#")
+	if id == "" {
+		return "", fmt.Errorf("secret name is required")
+	}
+
+	// secrets are read from the region in their ARN
+	region := a.region
+	if arn := strings.Split(id, ":"); len(arn) > 3 && arn[0] == "arn" {
+		region = arn[3]
+	}
+
+	var out struct {
+		SecretString *string `json:"SecretString"`
+	}
+	in := map[string]string{"SecretId": id}
+	if err := a.call(ctx, "secretsmanager", region, "secretsmanager.GetSecretValue", in, &out); err != nil {
+		return "", err
+	}
+	if out.SecretString == nil {
+		return "", fmt.Errorf("secret %q has no string value", id)
+	}
+	if !hasKey {
+		return *out.SecretString, nil
+	}
+
+	var fields map[string]any
+	if err := json.Unmarshal([]byte(*out.SecretString), &fields); err != nil {
+		return "", fmt.Errorf("secret %q is not a JSON object: %w", id, err)
+	}
+	value, ok := fields[key]
+	if !ok {
+		return "", fmt.Errorf("secret %q has no key %q", id, key)
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprint(value), nil
+}
+
+// getParameter reads an SSM parameter, SecureString parameters are decrypted
+func (a *awsClient) getParameter(ctx context.Context, name string) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("parameter name is required")
+	}
+
+	var out struct {
+		Parameter struct {
+			Value string `json:"Value"`
+		} `json:"Parameter"`
+	}
+	in := map[string]any{"Name": name, "WithDecryption": true}
+	if err := a.call(ctx, "ssm", a.region, "AmazonSSM.GetParameter", in, &out); err != nil {
+		return "", err
+	}
+	return out.Parameter.Value, nil
+}
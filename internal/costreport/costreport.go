@@ -0,0 +1,256 @@
+package costreport
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gateway/template/pkg/logger"
+)
+
+// Default is the process-wide tracker fed by the route annotation middleware.
+var Default = NewTracker()
+
+// unassignedTeam is reported for routes without an owning team label
+const unassignedTeam = "unassigned"
+
+// Usage holds traffic volume attributed to a team and service.
+type Usage struct {
+	Team          string `json:"team"`
+	Service       string `json:"service"`
+	Requests      int64  `json:"requests"`
+	RequestBytes  int64  `json:"request_bytes"`  // bytes received from clients and sent upstream
+	ResponseBytes int64  `json:"response_bytes"` // bytes received from upstream and sent to clients
+}
+
+// TeamTotal holds traffic volume summed over all services of a team.
+type TeamTotal struct {
+	Team          string `json:"team"`
+	Requests      int64  `json:"requests"`
+	RequestBytes  int64  `json:"request_bytes"`
+	ResponseBytes int64  `json:"response_bytes"`
+}
+
+// Report is a cost attribution report for a single period.
+type Report struct {
+	PeriodStart time.Time   `json:"period_start"`
+	PeriodEnd   time.Time   `json:"period_end"`
+	Teams       []TeamTotal `json:"teams"`
+	Services    []Usage     `json:"services"`
+}
+
+// Tracker accumulates per-team and per-service traffic for cost attribution.
+type Tracker struct {
+	mu    sync.Mutex
+	start time.Time
+	usage map[string]*Usage
+}
+
+// NewTracker creates a new tracker starting a period now.
+func NewTracker() *Tracker {
+	return &Tracker{
+		start: time.Now(),
+		usage: make(map[string]*Usage),
+	}
+}
+
+// Record attributes a single request to the given team and service.
+func (t *Tracker) Record(team, service string, requestBytes, responseBytes int64) {
+	if team == "" {
+		team = unassignedTeam
+	}
+	key := team + "/" + service
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	u, ok := t.usage[key]
+	if !ok {
+		u = &Usage{Team: team, Service: service}
+		t.usage[key] = u
+	}
+	u.Requests++
+	u.RequestBytes += requestBytes
+	u.ResponseBytes += responseBytes
+}
+
+// Snapshot returns the report for the current period without resetting it.
+func (t *Tracker) Snapshot() *Report {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.buildReport(time.Now())
+}
+
+// Rotate returns the report for the current period and starts a new one.
+func (t *Tracker) Rotate() *Report {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	report := t.buildReport(now)
+	t.start = now
+	t.usage = make(map[string]*Usage)
+	return report
+}
+
+// buildReport assembles a report, the caller must hold t.mu
+func (t *Tracker) buildReport(now time.Time) *Report {
+	report := &Report{
+		PeriodStart: t.start,
+		PeriodEnd:   now,
+		Services:    make([]Usage, 0, len(t.usage)),
+	}
+
+	teams := make(map[string]*TeamTotal)
+	for _, u := range t.usage {
+		report.Services = append(report.Services, *u)
+
+		total, ok := teams[u.Team]
+		if !ok {
+			total = &TeamTotal{Team: u.Team}
+			teams[u.Team] = total
+		}
+		total.Requests += u.Requests
+		total.RequestBytes += u.RequestBytes
+		total.ResponseBytes += u.ResponseBytes
+	}
+
+	report.Teams = make([]TeamTotal, 0, len(teams))
+	for _, total := range teams {
+		report.Teams = append(report.Teams, *total)
+	}
+
+	sort.Slice(report.Services, func(i, j int) bool {
+		if report.Services[i].Team != report.Services[j].Team {
+			return report.Services[i].Team < report.Services[j].Team
+		}
+		return report.Services[i].Service < report.Services[j].Service
+	})
+	sort.Slice(report.Teams, func(i, j int) bool {
+		return report.Teams[i].Team < report.Teams[j].Team
+	})
+
+	return report
+}
+
+// WriteJSON writes the report as indented JSON.
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteCSV writes the per-service usage rows as CSV.
+func (r *Report) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+
+	if err := cw.Write([]string{"period_start", "period_end", "team", "service", "requests", "request_bytes", "response_bytes"}); err != nil {
+		return err
+	}
+
+	start := r.PeriodStart.UTC().Format(time.RFC3339)
+	end := r.PeriodEnd.UTC().Format(time.RFC3339)
+	for _, u := range r.Services {
+		row := []string{
+			start,
+			end,
+			u.Team,
+			u.Service,
+			strconv.FormatInt(u.Requests, 10),
+			strconv.FormatInt(u.RequestBytes, 10),
+			strconv.FormatInt(u.ResponseBytes, 10),
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// Handler returns an http.Handler serving the current period's report.
+// Use ?format=csv to get CSV instead of JSON.
+func (t *Tracker) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := t.Snapshot()
+
+		if r.URL.Query().Get("format") == "csv" {
+			w.Header().Set("Content-Type", "text/csv")
+			_ = report.WriteCSV(w)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = report.WriteJSON(w)
+	})
+}
+
+// Export writes the report to dir as both JSON and CSV files named after the period end.
+func Export(report *Report, dir string) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create report directory: %w", err)
+	}
+
+	base := filepath.Join(dir, "cost-report-"+report.PeriodEnd.UTC().Format("20060102T150405Z"))
+
+	if err := writeFile(base+".json", report.WriteJSON); err != nil {
+		return "", err
+	}
+	if err := writeFile(base+".csv", report.WriteCSV); err != nil {
+		return "", err
+	}
+
+	return base, nil
+}
+
+// writeFile creates path and fills it with write
+func writeFile(path string, write func(io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create %q: %w", path, err)
+	}
+
+	if err := write(f); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write %q: %w", path, err)
+	}
+
+	return f.Close()
+}
+
+// RunExporter periodically rotates the tracker and exports each finished
+// period to dir until ctx is cancelled.
+func RunExporter(ctx context.Context, t *Tracker, dir string, interval time.Duration, log logger.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			report := t.Rotate()
+			base, err := Export(report, dir)
+			if err != nil {
+				log.Error("failed to export cost report", "error", err)
+				continue
+			}
+			log.Info("exported cost report",
+				"file", base,
+				"teams", len(report.Teams),
+				"services", len(report.Services),
+			)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gateway/template/internal/config"
+	"github.com/gateway/template/pkg/logger"
+)
+
+// consulWait is how long a blocking query waits for changes
+const consulWait = 5 * time.Minute
+
+// Consul discovers targets from the healthy instances of Consul services.
+// Each service is watched with blocking queries and its passing instances
+// become the endpoints of a single target, balanced by the proxy.
+type Consul struct {
+	cfg     *config.ConsulDiscoveryConfig
+	address string
+	client  *http.Client
+	log     logger.Logger
+
+	// mu serializes updates, services are watched concurrently
+	mu      sync.Mutex
+	targets map[string]config.TargetConfig
+}
+
+// NewConsul creates a Consul discovery source.
+func NewConsul(cfg *config.ConsulDiscoveryConfig, log logger.Logger) (*Consul, error) {
+	address := cfg.Address
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+	if _, err := url.Parse(address); err != nil {
+		return nil, fmt.Errorf("invalid consul address %q: %w", cfg.Address, err)
+	}
+
+	return &Consul{
+		cfg:     cfg,
+		address: strings.TrimRight(address, "/"),
+		// blocking queries are held open by Consul for up to consulWait
+		client:  &http.Client{Timeout: consulWait + 30*time.Second},
+		log:     log,
+		targets: make(map[string]config.TargetConfig),
+	}, nil
+}
+
+// consulServiceEntry is the subset of a health service entry used for discovery
+type consulServiceEntry struct {
+	Node struct {
+		Address string `json:"Address"`
+	} `json:"Node"`
+	Service struct {
+		Address string            `json:"Address"`
+		Port    int               `json:"Port"`
+		Meta    map[string]string `json:"Meta"`
+		Weights struct {
+			Passing int `json:"Passing"`
+		} `json:"Weights"`
+	} `json:"Service"`
+}
+
+// Run watches every configured service until ctx is done
+func (c *Consul) Run(ctx context.Context, update UpdateFunc) error {
+	var wg sync.WaitGroup
+	for _, service := range c.cfg.Services {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.watch(ctx, service, update)
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// watch follows the healthy instances of a service with blocking queries
+func (c *Consul) watch(ctx context.Context, service string, update UpdateFunc) {
+	var index uint64
+	for {
+		entries, next, err := c.health(ctx, service, index)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			c.log.Warn("consul discovery failed, retrying", "service", service, "error", err)
+			index = 0
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(5 * time.Second):
+			}
+			continue
+		}
+
+		// the index can go backwards, e.g. after a snapshot restore
+		if next < index {
+			next = 0
+		}
+		index = next
+
+		c.apply(service, entries, update)
+	}
+}
+
+// health runs a blocking query for the passing instances of a service and
+// returns them with the index to wait on next
+func (c *Consul) health(ctx context.Context, service string, index uint64) ([]consulServiceEntry, uint64, error) {
+	params := url.Values{"passing": {"true"}}
+	if c.cfg.Tag != "" {
+		params.Set("tag", c.cfg.Tag)
+	}
+	if c.cfg.Datacenter != "" {
+		params.Set("dc", c.cfg.Datacenter)
+	}
+	if index > 0 {
+		params.Set("index", strconv.FormatUint(index, 10))
+		params.Set("wait", consulWait.String())
+	}
+	endpoint := fmt.Sprintf("%s/v1/health/service/%s?%s", c.address, url.PathEscape(service), params.Encode())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if c.cfg.Token != "" {
+		req.Header.Set("X-Consul-Token", c.cfg.Token)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("consul API request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("consul API returned status %d", resp.StatusCode)
+	}
+
+	var entries []consulServiceEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode health entries: %w", err)
+	}
+
+	next, _ := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	return entries, next, nil
+}
+
+// apply replaces the target of a service and publishes all targets
+func (c *Consul) apply(service string, entries []consulServiceEntry, update UpdateFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	name := strings.ToLower(service)
+	target, ok := consulTarget(entries)
+	if !ok {
+		if _, existed := c.targets[name]; existed {
+			c.log.Warn("no healthy instances of consul service", "service", service)
+		}
+		delete(c.targets, name)
+	} else {
+		c.targets[name] = target
+	}
+
+	targets := make(map[string]config.TargetConfig, len(c.targets))
+	for n, t := range c.targets {
+		targets[n] = t
+	}
+	update(targets)
+}
+
+// consulTarget converts the healthy instances of a service to a target,
+// it reports false if there are none
+func consulTarget(entries []consulServiceEntry) (config.TargetConfig, bool) {
+	if len(entries) == 0 {
+		return config.TargetConfig{}, false
+	}
+
+	// sorted so an unchanged set of instances yields an identical target
+	urls := make([]string, 0, len(entries))
+	var weights map[string]int
+	for _, entry := range entries {
+		host := entry.Service.Address
+		if host == "" {
+			host = entry.Node.Address
+		}
+
+		scheme := entry.Service.Meta[AnnotationScheme]
+		if scheme == "" {
+			scheme = "http"
+		}
+		u := fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(host, strconv.Itoa(entry.Service.Port)))
+		urls = append(urls, u)
+
+		// instances registered with a passing weight other than the default 1
+		if w := entry.Service.Weights.Passing; w > 0 && w != 1 {
+			if weights == nil {
+				weights = make(map[string]int)
+			}
+			weights[u] = w
+		}
+	}
+	sort.Strings(urls)
+
+	return config.TargetConfig{
+		URL:       urls[0],
+		Endpoints: urls[1:],
+		Weights:   weights,
+		Labels:    routeLabels(entries[0].Service.Meta),
+	}, true
+}
@@ -0,0 +1,43 @@
+// Package discovery finds proxy targets at runtime from service registries,
+// so the gateway tracks backends without restarts or env var changes.
+package discovery
+
+import (
+	"context"
+
+	"github.com/gateway/template/internal/config"
+)
+
+// UpdateFunc receives the complete set of discovered targets by service name
+// every time it changes.
+type UpdateFunc func(targets map[string]config.TargetConfig)
+
+// Source discovers proxy targets.
+type Source interface {
+	// Run watches the registry until ctx is done, calling update with the
+	// current targets on every change. Transient errors are retried.
+	Run(ctx context.Context, update UpdateFunc) error
+}
+
+// Annotations (Kubernetes) and metadata keys (Consul) read by discovery sources.
+const (
+	// AnnotationServiceName overrides the service name, which is also the route prefix
+	AnnotationServiceName = "gateway/service-name"
+	// AnnotationScheme is the upstream scheme, http by default
+	AnnotationScheme = "gateway/scheme"
+	// AnnotationPort selects the port by name or number
+	AnnotationPort = "gateway/port"
+	// AnnotationTeam, AnnotationTier and AnnotationArea set the route labels
+	AnnotationTeam = "gateway/team"
+	AnnotationTier = "gateway/tier"
+	AnnotationArea = "gateway/area"
+)
+
+// routeLabels reads route labels from annotations or metadata
+func routeLabels(meta map[string]string) config.RouteLabels {
+	return config.RouteLabels{
+		Team: meta[AnnotationTeam],
+		Tier: meta[AnnotationTier],
+		Area: meta[AnnotationArea],
+	}
+}
@@ -0,0 +1,302 @@
+package discovery
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gateway/template/internal/config"
+	"github.com/gateway/template/pkg/logger"
+)
+
+// in-cluster service account files
+const (
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+	tokenFile         = serviceAccountDir + "/token"
+	caFile            = serviceAccountDir + "/ca.crt"
+	namespaceFile     = serviceAccountDir + "/namespace"
+)
+
+// Kubernetes discovers targets from Services matching a label selector.
+// Each Service becomes a target routed to its cluster DNS name, so
+// kube-proxy keeps balancing across the Service's endpoints.
+type Kubernetes struct {
+	cfg       *config.KubernetesDiscoveryConfig
+	apiServer string
+	namespace string
+	client    *http.Client
+	log       logger.Logger
+}
+
+// NewKubernetes creates a Kubernetes discovery source using the in-cluster
+// service account.
+func NewKubernetes(cfg *config.KubernetesDiscoveryConfig, log logger.Logger) (*Kubernetes, error) {
+	apiServer := cfg.APIServer
+	if apiServer == "" {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return nil, fmt.Errorf("not running in a Kubernetes cluster and no API server configured")
+		}
+		apiServer = "https://" + net.JoinHostPort(host, port)
+	}
+
+	namespace := cfg.Namespace
+	if namespace == "" {
+		data, err := os.ReadFile(namespaceFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read namespace: %w", err)
+		}
+		namespace = strings.TrimSpace(string(data))
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if ca, err := os.ReadFile(caFile); err == nil {
+		pool := x509.NewCertPool()
+		pool.AppendCertsFromPEM(ca)
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+
+	return &Kubernetes{
+		cfg:       cfg,
+		apiServer: strings.TrimRight(apiServer, "/"),
+		namespace: namespace,
+		client:    &http.Client{Transport: transport},
+		log:       log,
+	}, nil
+}
+
+// k8sService is the subset of a Service object used for discovery
+type k8sService struct {
+	Metadata struct {
+		Name            string            `json:"name"`
+		Namespace       string            `json:"namespace"`
+		ResourceVersion string            `json:"resourceVersion"`
+		Annotations     map[string]string `json:"annotations"`
+	} `json:"metadata"`
+	Spec struct {
+		Ports []struct {
+			Name string `json:"name"`
+			Port int    `json:"port"`
+		} `json:"ports"`
+	} `json:"spec"`
+}
+
+// k8sServiceList is a Service list response
+type k8sServiceList struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Items []k8sService `json:"items"`
+}
+
+// k8sWatchEvent is a single event of a watch stream
+type k8sWatchEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+// errResourceExpired means the watch must restart with a fresh list
+var errResourceExpired = fmt.Errorf("resource version expired")
+
+// Run lists and watches Services until ctx is done
+func (k *Kubernetes) Run(ctx context.Context, update UpdateFunc) error {
+	for {
+		err := k.listAndWatch(ctx, update)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil && err != errResourceExpired {
+			k.log.Warn("kubernetes discovery failed, retrying", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+// listAndWatch lists Services once, then applies watch events until the stream ends
+func (k *Kubernetes) listAndWatch(ctx context.Context, update UpdateFunc) error {
+	list, err := k.list(ctx)
+	if err != nil {
+		return err
+	}
+
+	// services by Service name, targets are derived from them
+	services := make(map[string]k8sService, len(list.Items))
+	for _, svc := range list.Items {
+		services[svc.Metadata.Name] = svc
+	}
+	update(k.targets(services))
+
+	resourceVersion := list.Metadata.ResourceVersion
+	for {
+		params := url.Values{
+			"watch":               {"true"},
+			"resourceVersion":     {resourceVersion},
+			"allowWatchBookmarks": {"true"},
+			"timeoutSeconds":      {"300"},
+		}
+		resp, err := k.get(ctx, params)
+		if err != nil {
+			return err
+		}
+
+		resourceVersion, err = k.applyEvents(resp, services, resourceVersion, update)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// list returns the Services matching the label selector
+func (k *Kubernetes) list(ctx context.Context) (*k8sServiceList, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	resp, err := k.get(ctx, url.Values{})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var list k8sServiceList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("failed to decode service list: %w", err)
+	}
+	return &list, nil
+}
+
+// applyEvents reads watch events until the stream ends and returns the last resource version
+func (k *Kubernetes) applyEvents(resp *http.Response, services map[string]k8sService, resourceVersion string, update UpdateFunc) (string, error) {
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+
+	for scanner.Scan() {
+		var event k8sWatchEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return resourceVersion, fmt.Errorf("failed to decode watch event: %w", err)
+		}
+
+		if event.Type == "ERROR" {
+			// typically 410 Gone, the resource version is too old
+			return resourceVersion, errResourceExpired
+		}
+
+		var svc k8sService
+		if err := json.Unmarshal(event.Object, &svc); err != nil {
+			return resourceVersion, fmt.Errorf("failed to decode watched service: %w", err)
+		}
+		resourceVersion = svc.Metadata.ResourceVersion
+
+		switch event.Type {
+		case "ADDED", "MODIFIED":
+			services[svc.Metadata.Name] = svc
+		case "DELETED":
+			delete(services, svc.Metadata.Name)
+		default:
+			// BOOKMARK only advances the resource version
+			continue
+		}
+		update(k.targets(services))
+	}
+
+	return resourceVersion, scanner.Err()
+}
+
+// get requests the Services of the namespace matching the label selector
+func (k *Kubernetes) get(ctx context.Context, params url.Values) (*http.Response, error) {
+	if k.cfg.LabelSelector != "" {
+		params.Set("labelSelector", k.cfg.LabelSelector)
+	}
+	endpoint := fmt.Sprintf("%s/api/v1/namespaces/%s/services?%s", k.apiServer, url.PathEscape(k.namespace), params.Encode())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	// the token is re-read on every request because it is rotated
+	if token, err := os.ReadFile(k.tokenFile()); err == nil {
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("kubernetes API request failed: %w", err)
+	}
+	if resp.StatusCode == http.StatusGone {
+		resp.Body.Close()
+		return nil, errResourceExpired
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("kubernetes API returned status %d", resp.StatusCode)
+	}
+	return resp, nil
+}
+
+// tokenFile returns the service account token path
+func (k *Kubernetes) tokenFile() string {
+	if k.cfg.TokenFile != "" {
+		return k.cfg.TokenFile
+	}
+	return tokenFile
+}
+
+// targets converts Services to proxy targets
+func (k *Kubernetes) targets(services map[string]k8sService) map[string]config.TargetConfig {
+	targets := make(map[string]config.TargetConfig, len(services))
+	for _, svc := range services {
+		name := strings.ToLower(svc.Metadata.Name)
+		if override := svc.Metadata.Annotations[AnnotationServiceName]; override != "" {
+			name = strings.ToLower(override)
+		}
+
+		port, ok := k.port(svc)
+		if !ok {
+			k.log.Warn("skipping discovered service without a matching port", "service", svc.Metadata.Name)
+			continue
+		}
+
+		scheme := svc.Metadata.Annotations[AnnotationScheme]
+		if scheme == "" {
+			scheme = "http"
+		}
+
+		targets[name] = config.TargetConfig{
+			URL:    fmt.Sprintf("%s://%s.%s.svc:%d", scheme, svc.Metadata.Name, svc.Metadata.Namespace, port),
+			Labels: routeLabels(svc.Metadata.Annotations),
+		}
+	}
+	return targets
+}
+
+// port selects the Service port by annotation, configured port name, or the first port
+func (k *Kubernetes) port(svc k8sService) (int, bool) {
+	want := svc.Metadata.Annotations[AnnotationPort]
+	if want == "" {
+		want = k.cfg.PortName
+	}
+
+	for _, p := range svc.Spec.Ports {
+		if want == "" || p.Name == want || strconv.Itoa(p.Port) == want {
+			return p.Port, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,76 @@
+package errreport
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// BurstDetector reports bursts of 5xx responses per service. A burst is
+// reported once when threshold 5xx responses are seen within one window.
+type BurstDetector struct {
+	threshold int
+	window    time.Duration
+
+	mu      sync.Mutex
+	windows map[string]*burstWindow
+}
+
+// burstWindow counts 5xx responses of a service in the current window
+type burstWindow struct {
+	start    time.Time
+	count    int
+	reported bool
+}
+
+// NewBurstDetector creates a detector, a threshold <= 0 disables detection
+func NewBurstDetector(threshold int, window time.Duration) *BurstDetector {
+	return &BurstDetector{
+		threshold: threshold,
+		window:    window,
+		windows:   make(map[string]*burstWindow),
+	}
+}
+
+// Observe records a response status and captures an event when a burst starts
+func (d *BurstDetector) Observe(service string, status int, r *http.Request) {
+	if d == nil || d.threshold <= 0 || status < 500 {
+		return
+	}
+
+	now := time.Now()
+
+	d.mu.Lock()
+	w, ok := d.windows[service]
+	if !ok || now.Sub(w.start) > d.window {
+		w = &burstWindow{start: now}
+		d.windows[service] = w
+	}
+	w.count++
+	report := w.count >= d.threshold && !w.reported
+	if report {
+		w.reported = true
+	}
+	count := w.count
+	d.mu.Unlock()
+
+	if !report {
+		return
+	}
+
+	Capture(&Event{
+		Message: fmt.Sprintf("burst of %d 5xx responses within %s", count, d.window),
+		Level:   LevelWarning,
+		Service: service,
+		Request: r,
+		Tags: map[string]string{
+			"kind": "5xx_burst",
+		},
+		Extra: map[string]interface{}{
+			"threshold":   d.threshold,
+			"window":      d.window.String(),
+			"last_status": status,
+		},
+	})
+}
@@ -0,0 +1,84 @@
+package errreport
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gateway/template/internal/requestid"
+)
+
+// Level is the severity of a captured event
+type Level string
+
+const (
+	// LevelWarning is used for degradations such as 5xx bursts
+	LevelWarning Level = "warning"
+	// LevelError is used for proxy errors
+	LevelError Level = "error"
+	// LevelFatal is used for recovered panics
+	LevelFatal Level = "fatal"
+)
+
+// Event describes an error captured by the gateway
+type Event struct {
+	Message string
+	Err     error
+	Level   Level
+	Service string
+	Request *http.Request
+	Tags    map[string]string
+	Extra   map[string]interface{}
+}
+
+// Sink receives captured events, e.g. to forward them to Sentry
+type Sink interface {
+	// Capture records an event, it must not block the request path
+	Capture(event *Event)
+	// Flush waits until buffered events are delivered or the timeout expires
+	Flush(timeout time.Duration) bool
+}
+
+var (
+	mu          sync.RWMutex
+	defaultSink Sink = noopSink{}
+)
+
+// SetDefault replaces the process-wide sink used by Capture
+func SetDefault(sink Sink) {
+	mu.Lock()
+	defer mu.Unlock()
+	defaultSink = sink
+}
+
+// Default returns the process-wide sink
+func Default() Sink {
+	mu.RLock()
+	defer mu.RUnlock()
+	return defaultSink
+}
+
+// Capture sends an event to the process-wide sink
+func Capture(event *Event) {
+	if event.Level == "" {
+		event.Level = LevelError
+	}
+	Default().Capture(event)
+}
+
+// RequestID returns the request ID of the event's request, if any
+func (e *Event) RequestID() string {
+	if e.Request == nil {
+		return ""
+	}
+	return requestid.FromContext(e.Request.Context())
+}
+
+// noopSink discards all events
+type noopSink struct{}
+
+// Capture discards the event
+func (noopSink) Capture(*Event) {}
+
+// Flush returns immediately
+func (noopSink) Flush(time.Duration) bool { return true }
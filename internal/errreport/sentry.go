@@ -0,0 +1,72 @@
+package errreport
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+)
+
+// SentryConfig holds Sentry client settings
+type SentryConfig struct {
+	DSN         string
+	Environment string
+	Release     string
+	SampleRate  float64
+}
+
+// SentrySink forwards events to Sentry
+type SentrySink struct {
+	hub *sentry.Hub
+}
+
+// NewSentrySink creates a sink reporting to the Sentry project behind cfg.DSN
+func NewSentrySink(cfg *SentryConfig) (*SentrySink, error) {
+	client, err := sentry.NewClient(sentry.ClientOptions{
+		Dsn:         cfg.DSN,
+		Environment: cfg.Environment,
+		Release:     cfg.Release,
+		SampleRate:  cfg.SampleRate,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create sentry client: %w", err)
+	}
+
+	return &SentrySink{
+		hub: sentry.NewHub(client, sentry.NewScope()),
+	}, nil
+}
+
+// Capture sends the event to Sentry with the request context attached
+func (s *SentrySink) Capture(event *Event) {
+	s.hub.WithScope(func(scope *sentry.Scope) {
+		scope.SetLevel(sentry.Level(event.Level))
+
+		if event.Service != "" {
+			scope.SetTag("service", event.Service)
+		}
+		if id := event.RequestID(); id != "" {
+			scope.SetTag("request_id", id)
+		}
+		scope.SetTags(event.Tags)
+		scope.SetExtras(event.Extra)
+
+		if event.Request != nil {
+			scope.SetRequest(event.Request)
+		}
+
+		err := event.Err
+		if err == nil {
+			err = errors.New(event.Message)
+		} else if event.Message != "" {
+			err = fmt.Errorf("%s: %w", event.Message, err)
+		}
+		s.hub.CaptureException(err)
+	})
+}
+
+// Flush waits for queued events to be sent
+func (s *SentrySink) Flush(timeout time.Duration) bool {
+	return s.hub.Flush(timeout)
+}
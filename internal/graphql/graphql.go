@@ -0,0 +1,126 @@
+// Package graphql analyzes GraphQL documents sent to services behind the
+// gateway, so operations too deep or too large for a backend, or asking for
+// its schema, can be rejected before they are forwarded.
+package graphql
+
+import (
+	"errors"
+	"fmt"
+)
+
+// maxNesting bounds the nesting of selection sets, lists and objects the
+// parser descends into, whatever the configured depth limit
+const maxNesting = 512
+
+// maxComplexity is the complexity reported for documents selecting more
+// fields, fragments spreading fragments repeatedly multiply quickly
+const maxComplexity = 1 << 30
+
+// errFragmentCycle is returned for fragments spreading themselves
+var errFragmentCycle = errors.New("fragment spreads form a cycle")
+
+// Stats describes the operations of a document
+type Stats struct {
+	// Depth is the deepest nesting of fields of any operation, with
+	// fragments expanded, e.g. 3 for { user { posts { title } } }
+	Depth int
+	// Complexity is the number of fields the operations select, with
+	// fragments expanded where they are spread
+	Complexity int
+	// Introspection reports whether the schema is queried, with the
+	// __schema or __type fields. __typename is not counted.
+	Introspection bool
+}
+
+// selection is a field, inline fragment or fragment spread
+type selection struct {
+	field    string // field name, empty for fragments
+	spread   string // name of the spread fragment
+	children []selection
+}
+
+// document holds the selection sets of a document's operations and
+// fragments by name
+type document struct {
+	operations [][]selection
+	fragments  map[string][]selection
+}
+
+// Analyze parses an executable GraphQL document and measures its
+// operations. Documents with syntax errors, type system definitions or
+// spreads of undefined or cyclic fragments are rejected.
+func Analyze(query string) (Stats, error) {
+	p := &parser{lex: lexer{src: query}}
+	doc, err := p.parseDocument()
+	if err != nil {
+		return Stats{}, err
+	}
+
+	a := &analyzer{doc: doc, fragments: make(map[string]*Stats)}
+	var stats Stats
+	for _, operation := range doc.operations {
+		s, err := a.measure(operation)
+		if err != nil {
+			return Stats{}, err
+		}
+		stats.Depth = max(stats.Depth, s.Depth)
+		stats.Complexity = min(stats.Complexity+s.Complexity, maxComplexity)
+		stats.Introspection = stats.Introspection || s.Introspection
+	}
+	return stats, nil
+}
+
+// analyzer measures selection sets, remembering the measures of fragments
+// so a fragment spread many times is walked once
+type analyzer struct {
+	doc       *document
+	fragments map[string]*Stats // nil while a fragment is being measured
+}
+
+func (a *analyzer) measure(selections []selection) (Stats, error) {
+	var stats Stats
+	for _, sel := range selections {
+		var s Stats
+		var err error
+		if sel.spread != "" {
+			s, err = a.measureFragment(sel.spread)
+		} else {
+			s, err = a.measure(sel.children)
+		}
+		if err != nil {
+			return Stats{}, err
+		}
+		if sel.field != "" {
+			s.Depth++
+			s.Complexity++
+			if sel.field == "__schema" || sel.field == "__type" {
+				s.Introspection = true
+			}
+		}
+		stats.Depth = max(stats.Depth, s.Depth)
+		stats.Complexity = min(stats.Complexity+s.Complexity, maxComplexity)
+		stats.Introspection = stats.Introspection || s.Introspection
+	}
+	return stats, nil
+}
+
+func (a *analyzer) measureFragment(name string) (Stats, error) {
+	if s, ok := a.fragments[name]; ok {
+		if s == nil {
+			return Stats{}, errFragmentCycle
+		}
+		return *s, nil
+	}
+	selections, ok := a.doc.fragments[name]
+	if !ok {
+		return Stats{}, fmt.Errorf("unknown fragment %q", name)
+	}
+
+	a.fragments[name] = nil
+	s, err := a.measure(selections)
+	if err != nil {
+		return Stats{}, err
+	}
+	a.fragments[name] = &s
+	return s, nil
+}
@@ -0,0 +1,367 @@
+package graphql
+
+import "fmt"
+
+// parser is a recursive descent parser of executable GraphQL documents,
+// keeping only the selections the analysis needs
+type parser struct {
+	lex     lexer
+	tok     token
+	nesting int
+}
+
+func (p *parser) advance() error {
+	tok, err := p.lex.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+// peek reports whether the current token is the punctuator or name v
+func (p *parser) peek(v string) bool {
+	return (p.tok.kind == tokenPunct || p.tok.kind == tokenName) && p.tok.value == v
+}
+
+// skip advances past the punctuator or name v if it is the current token
+func (p *parser) skip(v string) (bool, error) {
+	if !p.peek(v) {
+		return false, nil
+	}
+	return true, p.advance()
+}
+
+func (p *parser) expect(v string) error {
+	if !p.peek(v) {
+		return p.unexpected()
+	}
+	return p.advance()
+}
+
+func (p *parser) name() (string, error) {
+	if p.tok.kind != tokenName {
+		return "", p.unexpected()
+	}
+	name := p.tok.value
+	return name, p.advance()
+}
+
+func (p *parser) unexpected() error {
+	if p.tok.kind == tokenEOF {
+		return fmt.Errorf("unexpected end of document")
+	}
+	return fmt.Errorf("unexpected %q at offset %d", p.tok.value, p.tok.pos)
+}
+
+// enter descends into a nested selection set, list or object
+func (p *parser) enter() error {
+	p.nesting++
+	if p.nesting > maxNesting {
+		return fmt.Errorf("document nested deeper than %d levels", maxNesting)
+	}
+	return nil
+}
+
+func (p *parser) leave() {
+	p.nesting--
+}
+
+func (p *parser) parseDocument() (*document, error) {
+	doc := &document{fragments: make(map[string][]selection)}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	if p.tok.kind == tokenEOF {
+		return nil, fmt.Errorf("document contains no operation")
+	}
+
+	for p.tok.kind != tokenEOF {
+		switch {
+		case p.peek("{"):
+			selections, err := p.parseSelectionSet()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, selections)
+		case p.peek("query"), p.peek("mutation"), p.peek("subscription"):
+			selections, err := p.parseOperation()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, selections)
+		case p.peek("fragment"):
+			name, selections, err := p.parseFragment()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := doc.fragments[name]; ok {
+				return nil, fmt.Errorf("fragment %q defined more than once", name)
+			}
+			doc.fragments[name] = selections
+		default:
+			return nil, p.unexpected()
+		}
+	}
+	if len(doc.operations) == 0 {
+		return nil, fmt.Errorf("document contains no operation")
+	}
+	return doc, nil
+}
+
+// parseOperation parses e.g. query Name($id: ID!) @directive { ... }
+func (p *parser) parseOperation() ([]selection, error) {
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	if p.tok.kind == tokenName {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+	if ok, err := p.skip("("); err != nil {
+		return nil, err
+	} else if ok {
+		for !p.peek(")") {
+			if err := p.parseVariableDefinition(); err != nil {
+				return nil, err
+			}
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.parseDirectives(); err != nil {
+		return nil, err
+	}
+	return p.parseSelectionSet()
+}
+
+// parseVariableDefinition parses e.g. $first: Int = 10 @directive
+func (p *parser) parseVariableDefinition() error {
+	if err := p.expect("$"); err != nil {
+		return err
+	}
+	if _, err := p.name(); err != nil {
+		return err
+	}
+	if err := p.expect(":"); err != nil {
+		return err
+	}
+	if err := p.parseType(); err != nil {
+		return err
+	}
+	if ok, err := p.skip("="); err != nil {
+		return err
+	} else if ok {
+		if err := p.parseValue(); err != nil {
+			return err
+		}
+	}
+	return p.parseDirectives()
+}
+
+// parseType parses e.g. [String!]!
+func (p *parser) parseType() error {
+	if ok, err := p.skip("["); err != nil {
+		return err
+	} else if ok {
+		if err := p.enter(); err != nil {
+			return err
+		}
+		if err := p.parseType(); err != nil {
+			return err
+		}
+		p.leave()
+		if err := p.expect("]"); err != nil {
+			return err
+		}
+	} else if _, err := p.name(); err != nil {
+		return err
+	}
+	_, err := p.skip("!")
+	return err
+}
+
+// parseFragment parses e.g. fragment Name on Type @directive { ... }
+func (p *parser) parseFragment() (string, []selection, error) {
+	if err := p.advance(); err != nil {
+		return "", nil, err
+	}
+	if p.peek("on") {
+		return "", nil, p.unexpected()
+	}
+	name, err := p.name()
+	if err != nil {
+		return "", nil, err
+	}
+	if err := p.expect("on"); err != nil {
+		return "", nil, err
+	}
+	if _, err := p.name(); err != nil {
+		return "", nil, err
+	}
+	if err := p.parseDirectives(); err != nil {
+		return "", nil, err
+	}
+	selections, err := p.parseSelectionSet()
+	return name, selections, err
+}
+
+func (p *parser) parseSelectionSet() ([]selection, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	var selections []selection
+	for !p.peek("}") {
+		sel, err := p.parseSelection()
+		if err != nil {
+			return nil, err
+		}
+		selections = append(selections, sel)
+	}
+	if len(selections) == 0 {
+		return nil, fmt.Errorf("empty selection set at offset %d", p.tok.pos)
+	}
+	p.leave()
+	return selections, p.advance()
+}
+
+// parseSelection parses a field, e.g. alias: name(arg: 1) @directive { ... },
+// a fragment spread, e.g. ...Name, or an inline fragment, e.g.
+// ... on Type { ... }
+func (p *parser) parseSelection() (selection, error) {
+	if ok, err := p.skip("..."); err != nil {
+		return selection{}, err
+	} else if ok {
+		if p.tok.kind == tokenName && p.tok.value != "on" {
+			name, err := p.name()
+			if err != nil {
+				return selection{}, err
+			}
+			return selection{spread: name}, p.parseDirectives()
+		}
+		if ok, err := p.skip("on"); err != nil {
+			return selection{}, err
+		} else if ok {
+			if _, err := p.name(); err != nil {
+				return selection{}, err
+			}
+		}
+		if err := p.parseDirectives(); err != nil {
+			return selection{}, err
+		}
+		children, err := p.parseSelectionSet()
+		return selection{children: children}, err
+	}
+
+	name, err := p.name()
+	if err != nil {
+		return selection{}, err
+	}
+	if ok, err := p.skip(":"); err != nil {
+		return selection{}, err
+	} else if ok {
+		if name, err = p.name(); err != nil {
+			return selection{}, err
+		}
+	}
+	if err := p.parseArguments(); err != nil {
+		return selection{}, err
+	}
+	if err := p.parseDirectives(); err != nil {
+		return selection{}, err
+	}
+	sel := selection{field: name}
+	if p.peek("{") {
+		sel.children, err = p.parseSelectionSet()
+	}
+	return sel, err
+}
+
+// parseArguments parses optional arguments, e.g. (first: 10, after: $cursor)
+func (p *parser) parseArguments() error {
+	if ok, err := p.skip("("); err != nil || !ok {
+		return err
+	}
+	for !p.peek(")") {
+		if _, err := p.name(); err != nil {
+			return err
+		}
+		if err := p.expect(":"); err != nil {
+			return err
+		}
+		if err := p.parseValue(); err != nil {
+			return err
+		}
+	}
+	return p.advance()
+}
+
+// parseDirectives parses optional directives, e.g. @include(if: $details)
+func (p *parser) parseDirectives() error {
+	for p.peek("@") {
+		if err := p.advance(); err != nil {
+			return err
+		}
+		if _, err := p.name(); err != nil {
+			return err
+		}
+		if err := p.parseArguments(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseValue parses a variable, literal, enum value, list or object
+func (p *parser) parseValue() error {
+	switch {
+	case p.peek("$"):
+		if err := p.advance(); err != nil {
+			return err
+		}
+		_, err := p.name()
+		return err
+	case p.tok.kind == tokenName, p.tok.kind == tokenNumber, p.tok.kind == tokenString:
+		return p.advance()
+	case p.peek("["):
+		if err := p.advance(); err != nil {
+			return err
+		}
+		if err := p.enter(); err != nil {
+			return err
+		}
+		for !p.peek("]") {
+			if err := p.parseValue(); err != nil {
+				return err
+			}
+		}
+		p.leave()
+		return p.advance()
+	case p.peek("{"):
+		if err := p.advance(); err != nil {
+			return err
+		}
+		if err := p.enter(); err != nil {
+			return err
+		}
+		for !p.peek("}") {
+			if _, err := p.name(); err != nil {
+				return err
+			}
+			if err := p.expect(":"); err != nil {
+				return err
+			}
+			if err := p.parseValue(); err != nil {
+				return err
+			}
+		}
+		p.leave()
+		return p.advance()
+	}
+	return p.unexpected()
+}
@@ -0,0 +1,181 @@
+// Package idempotency stores the responses to requests carrying an
+// Idempotency-Key header, so a retried request is answered with the first
+// response instead of being processed again.
+package idempotency
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gateway/template/pkg/redis"
+)
+
+// Store keeps values until they expire. Implementations must be safe for
+// concurrent use.
+type Store interface {
+	// Reserve stores value under key for ttl unless key exists, it reports
+	// whether it did
+	Reserve(ctx context.Context, key, value string, ttl time.Duration) (bool, error)
+	// Get returns the value of key, empty if there is none
+	Get(ctx context.Context, key string) (string, error)
+	// Set stores value under key for ttl
+	Set(ctx context.Context, key, value string, ttl time.Duration) error
+	// Delete removes key
+	Delete(ctx context.Context, key string) error
+}
+
+var (
+	defaultMu    sync.RWMutex
+	defaultStore Store
+)
+
+// SetDefaultStore sets the process-wide store of services with idempotency
+// paths
+func SetDefaultStore(store Store) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultStore = store
+}
+
+// DefaultStore returns the process-wide store, if any
+func DefaultStore() Store {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultStore
+}
+
+// MemoryStore keeps values in memory. They are lost on restart and not
+// shared between gateway instances.
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]entry
+	sweep   time.Time // next time expired entries are removed
+}
+
+// entry is a stored value and when it expires
+type entry struct {
+	value   string
+	expires time.Time
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: make(map[string]entry)}
+}
+
+// Reserve implements Store
+func (s *MemoryStore) Reserve(_ context.Context, key, value string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweepExpired(now)
+	if e, ok := s.entries[key]; ok && now.Before(e.expires) {
+		return false, nil
+	}
+	s.entries[key] = entry{value: value, expires: now.Add(ttl)}
+	return true, nil
+}
+
+// Get implements Store
+func (s *MemoryStore) Get(_ context.Context, key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok || time.Now().After(e.expires) {
+		return "", nil
+	}
+	return e.value, nil
+}
+
+// Set implements Store
+func (s *MemoryStore) Set(_ context.Context, key, value string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweepExpired(now)
+	s.entries[key] = entry{value: value, expires: now.Add(ttl)}
+	return nil
+}
+
+// Delete implements Store
+func (s *MemoryStore) Delete(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+	return nil
+}
+
+// sweepExpired removes expired entries at most once a minute, s.mu must be held
+func (s *MemoryStore) sweepExpired(now time.Time) {
+	if now.Before(s.sweep) {
+		return
+	}
+	for k, e := range s.entries {
+		if now.After(e.expires) {
+			delete(s.entries, k)
+		}
+	}
+	s.sweep = now.Add(time.Minute)
+}
+
+// redisKeyPrefix namespaces the idempotency keys in a shared Redis
+const redisKeyPrefix = "gateway:idempotency:"
+
+// RedisStore keeps values in Redis so they are shared by all gateway
+// instances and survive restarts.
+type RedisStore struct {
+	client *redis.Client
+}
+
+// NewRedisStore creates a store for a Redis URL such as
+// redis://:password@redis:6379/0, use rediss:// for TLS. The connection is
+// opened on first use.
+func NewRedisStore(rawURL string) (*RedisStore, error) {
+	client, err := redis.New(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	return &RedisStore{client: client}, nil
+}
+
+// Reserve implements Store, atomically across gateway instances
+func (s *RedisStore) Reserve(ctx context.Context, key, value string, ttl time.Duration) (bool, error) {
+	reply, err := s.client.Do(ctx, "SET", redisKeyPrefix+key, value, "NX", "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	if err != nil {
+		return false, err
+	}
+	// NX replies nil when the key already exists
+	return reply != nil, nil
+}
+
+// Get implements Store
+func (s *RedisStore) Get(ctx context.Context, key string) (string, error) {
+	reply, err := s.client.Do(ctx, "GET", redisKeyPrefix+key)
+	if err != nil || reply == nil {
+		return "", err
+	}
+	return reply.(string), nil
+}
+
+// Set implements Store
+func (s *RedisStore) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	_, err := s.client.Do(ctx, "SET", redisKeyPrefix+key, value, "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	return err
+}
+
+// Delete implements Store
+func (s *RedisStore) Delete(ctx context.Context, key string) error {
+	_, err := s.client.Do(ctx, "DEL", redisKeyPrefix+key)
+	return err
+}
+
+// Close closes the connection to Redis
+func (s *RedisStore) Close() error {
+	return s.client.Close()
+}
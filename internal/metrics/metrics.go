@@ -0,0 +1,301 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Default is the process-wide registry exposed on the /metrics endpoint.
+var Default = NewRegistry()
+
+// Registry holds metric families and renders them in the Prometheus text format.
+type Registry struct {
+	mu       sync.RWMutex
+	families map[string]*family
+}
+
+// family is a named metric with a fixed set of label names.
+type family struct {
+	name       string
+	help       string
+	kind       string
+	labelNames []string
+	buckets    []float64 // upper bounds, histograms only
+
+	mu     sync.RWMutex
+	series map[string]*series
+}
+
+// series is a single labelled value within a family.
+// For histograms bits holds the sum of observations.
+type series struct {
+	labelValues []string
+	bits        uint64
+	counts      []uint64 // observations per bucket (not cumulative), histograms only
+	count       uint64   // total observations, histograms only
+}
+
+// DefaultBuckets are histogram buckets suited to request latencies in seconds.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// NewRegistry creates an empty metrics registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		families: make(map[string]*family),
+	}
+}
+
+// CounterVec is a monotonically increasing metric partitioned by labels.
+type CounterVec struct {
+	f *family
+}
+
+// GaugeVec is a metric that can go up and down, partitioned by labels.
+type GaugeVec struct {
+	f *family
+}
+
+// HistogramVec samples observations into buckets, partitioned by labels.
+type HistogramVec struct {
+	f *family
+}
+
+// Counter registers (or returns the existing) counter family with the given name.
+func (r *Registry) Counter(name, help string, labelNames ...string) *CounterVec {
+	return &CounterVec{f: r.register(name, help, "counter", labelNames)}
+}
+
+// Gauge registers (or returns the existing) gauge family with the given name.
+func (r *Registry) Gauge(name, help string, labelNames ...string) *GaugeVec {
+	return &GaugeVec{f: r.register(name, help, "gauge", labelNames)}
+}
+
+// Histogram registers (or returns the existing) histogram family with the given
+// name. Buckets are upper bounds in increasing order, DefaultBuckets if nil.
+func (r *Registry) Histogram(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	f := r.register(name, help, "histogram", labelNames)
+	f.mu.Lock()
+	if f.buckets == nil {
+		f.buckets = append([]float64(nil), buckets...)
+	}
+	f.mu.Unlock()
+	return &HistogramVec{f: f}
+}
+
+// Inc increments the counter for the given label values by one.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.f.get(labelValues).add(1)
+}
+
+// Add increments the counter for the given label values by v.
+// Negative values are ignored.
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		return
+	}
+	c.f.get(labelValues).add(v)
+}
+
+// Value returns the current counter value for the given label values.
+func (c *CounterVec) Value(labelValues ...string) float64 {
+	return c.f.get(labelValues).load()
+}
+
+// Set sets the gauge for the given label values.
+func (g *GaugeVec) Set(v float64, labelValues ...string) {
+	g.f.get(labelValues).store(v)
+}
+
+// Add adds v (which may be negative) to the gauge for the given label values.
+func (g *GaugeVec) Add(v float64, labelValues ...string) {
+	g.f.get(labelValues).add(v)
+}
+
+// Value returns the current gauge value for the given label values.
+func (g *GaugeVec) Value(labelValues ...string) float64 {
+	return g.f.get(labelValues).load()
+}
+
+// Observe records v for the given label values.
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	s := h.f.get(labelValues)
+	for i, upper := range h.f.buckets {
+		if v <= upper {
+			atomic.AddUint64(&s.counts[i], 1)
+			break
+		}
+	}
+	atomic.AddUint64(&s.count, 1)
+	s.add(v)
+}
+
+// Count returns the number of observations for the given label values.
+func (h *HistogramVec) Count(labelValues ...string) uint64 {
+	return atomic.LoadUint64(&h.f.get(labelValues).count)
+}
+
+// Sum returns the sum of observations for the given label values.
+func (h *HistogramVec) Sum(labelValues ...string) float64 {
+	return h.f.get(labelValues).load()
+}
+
+// Handler returns an http.Handler that serves the registry in the Prometheus text format.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.write(w)
+	})
+}
+
+// register returns the named family, creating it if necessary
+func (r *Registry) register(name, help, kind string, labelNames []string) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if f, ok := r.families[name]; ok {
+		return f
+	}
+
+	f := &family{
+		name:       name,
+		help:       help,
+		kind:       kind,
+		labelNames: labelNames,
+		series:     make(map[string]*series),
+	}
+	r.families[name] = f
+	return f
+}
+
+// write renders all families sorted by name
+func (r *Registry) write(w io.Writer) {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	r.mu.RUnlock()
+	sort.Strings(names)
+
+	for _, name := range names {
+		r.mu.RLock()
+		f := r.families[name]
+		r.mu.RUnlock()
+		f.write(w)
+	}
+}
+
+// get returns the series for the given label values, creating it if necessary
+func (f *family) get(labelValues []string) *series {
+	key := strings.Join(labelValues, "\xff")
+
+	f.mu.RLock()
+	s, ok := f.series[key]
+	f.mu.RUnlock()
+	if ok {
+		return s
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if s, ok := f.series[key]; ok {
+		return s
+	}
+	s = &series{labelValues: append([]string(nil), labelValues...)}
+	if f.kind == "histogram" {
+		s.counts = make([]uint64, len(f.buckets))
+	}
+	f.series[key] = s
+	return s
+}
+
+// write renders a single family
+func (f *family) write(w io.Writer) {
+	f.mu.RLock()
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	f.mu.RUnlock()
+	sort.Strings(keys)
+
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, f.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+
+	for _, key := range keys {
+		f.mu.RLock()
+		s := f.series[key]
+		f.mu.RUnlock()
+		if f.kind == "histogram" {
+			f.writeHistogram(w, s)
+			continue
+		}
+		fmt.Fprintf(w, "%s%s %v\n", f.name, formatLabels(f.labelNames, s.labelValues), s.load())
+	}
+}
+
+// writeHistogram renders the cumulative buckets, sum and count of a histogram series
+func (f *family) writeHistogram(w io.Writer, s *series) {
+	names := append(append([]string(nil), f.labelNames...), "le")
+
+	var cumulative uint64
+	for i, upper := range f.buckets {
+		cumulative += atomic.LoadUint64(&s.counts[i])
+		values := append(append([]string(nil), s.labelValues...), strconv.FormatFloat(upper, 'g', -1, 64))
+		fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, formatLabels(names, values), cumulative)
+	}
+
+	count := atomic.LoadUint64(&s.count)
+	values := append(append([]string(nil), s.labelValues...), "+Inf")
+	fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, formatLabels(names, values), count)
+	fmt.Fprintf(w, "%s_sum%s %v\n", f.name, formatLabels(f.labelNames, s.labelValues), s.load())
+	fmt.Fprintf(w, "%s_count%s %d\n", f.name, formatLabels(f.labelNames, s.labelValues), count)
+}
+
+// formatLabels renders a Prometheus label set
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, 0, len(names))
+	for i, name := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs = append(pairs, fmt.Sprintf("%s=%q", name, value))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// load returns the current value
+func (s *series) load() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&s.bits))
+}
+
+// store sets the current value
+func (s *series) store(v float64) {
+	atomic.StoreUint64(&s.bits, math.Float64bits(v))
+}
+
+// add atomically adds v to the current value
+func (s *series) add(v float64) {
+	for {
+		old := atomic.LoadUint64(&s.bits)
+		updated := math.Float64bits(math.Float64frombits(old) + v)
+		if atomic.CompareAndSwapUint64(&s.bits, old, updated) {
+			return
+		}
+	}
+}
@@ -0,0 +1,329 @@
+package middleware
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/gateway/template/internal/config"
+	"github.com/gateway/template/pkg/logger"
+)
+
+// AccessLogFields lists the fields available to the json access log format
+var AccessLogFields = []string{
+	"client_ip", "method", "path", "query", "proto", "status", "latency_ms",
+	"user_agent", "referer", "user_id", "request_id", "request_bytes",
+	"response_bytes", "service", "team", "tier", "area", "outcome",
+}
+
+// DefaultAccessLogFields are logged by the json format when no fields are configured
+var DefaultAccessLogFields = []string{
+	"client_ip", "method", "path", "status", "latency_ms", "user_agent",
+	"user_id", "request_id", "request_bytes", "response_bytes",
+	"service", "team", "tier", "area", "outcome",
+}
+
+// AccessEntry holds everything known about a completed request.
+// Its exported fields are available to custom access log templates.
+type AccessEntry struct {
+	Time          time.Time
+	ClientIP      string
+	Method        string
+	Path          string
+	Query         string
+	Proto         string
+	Status        int
+	Latency       time.Duration
+	UserAgent     string
+	Referer       string
+	UserID        string
+	RequestID     string
+	RequestBytes  int64
+	ResponseBytes int64
+	Service       string
+	Team          string
+	Tier          string
+	Area          string
+	Outcome       string // empty, or client_canceled if the client went away
+}
+
+// LatencyMs returns the request latency in milliseconds
+func (e *AccessEntry) LatencyMs() int64 {
+	return e.Latency.Milliseconds()
+}
+
+// accessLogWriter emits a single access log entry
+type accessLogWriter interface {
+	write(e *AccessEntry)
+}
+
+// newAccessLogWriter creates the access log writer for the configured format
+func newAccessLogWriter(cfg *config.AccessLogConfig, log logger.Logger, out io.Writer) (accessLogWriter, error) {
+	switch cfg.Format {
+	case "", "json":
+		fields := cfg.Fields
+		if len(fields) == 0 {
+			fields = DefaultAccessLogFields
+		}
+		for _, field := range fields {
+			if !isAccessLogField(field) {
+				return nil, fmt.Errorf("unknown access log field %q", field)
+			}
+		}
+		return &jsonAccessLog{log: log, fields: fields}, nil
+	case "combined":
+		return &combinedAccessLog{out: out}, nil
+	case "template":
+		tmpl, err := template.New("access_log").Parse(cfg.Template)
+		if err != nil {
+			return nil, fmt.Errorf("invalid access log template: %w", err)
+		}
+		return &templateAccessLog{out: out, tmpl: tmpl}, nil
+	default:
+		return nil, fmt.Errorf("unknown access log format %q", cfg.Format)
+	}
+}
+
+// isAccessLogField checks if name is a known json access log field
+func isAccessLogField(name string) bool {
+	for _, field := range AccessLogFields {
+		if field == name {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonAccessLog writes entries through the structured logger
+type jsonAccessLog struct {
+	log    logger.Logger
+	fields []string
+}
+
+// write logs the configured fields of the entry
+func (l *jsonAccessLog) write(e *AccessEntry) {
+	kv := make([]interface{}, 0, len(l.fields)*2)
+	for _, field := range l.fields {
+		value, omitEmpty := accessFieldValue(e, field)
+		if omitEmpty && value == "" {
+			continue
+		}
+		kv = append(kv, field, value)
+	}
+	l.log.Info("http request processed", kv...)
+}
+
+// accessFieldValue returns the value of a json access log field and whether
+// it should be omitted when empty
+func accessFieldValue(e *AccessEntry, field string) (interface{}, bool) {
+	switch field {
+	case "client_ip":
+		return e.ClientIP, false
+	case "method":
+		return e.Method, false
+	case "path":
+		return e.Path, false
+	case "query":
+		return e.Query, true
+	case "proto":
+		return e.Proto, false
+	case "status":
+		return e.Status, false
+	case "latency_ms":
+		return e.Latency.Milliseconds(), false
+	case "user_agent":
+		return e.UserAgent, false
+	case "referer":
+		return e.Referer, true
+	case "user_id":
+		return e.UserID, false
+	case "request_id":
+		return e.RequestID, false
+	case "request_bytes":
+		return e.RequestBytes, false
+	case "response_bytes":
+		return e.ResponseBytes, false
+	case "service":
+		return e.Service, true
+	case "team":
+		return e.Team, true
+	case "tier":
+		return e.Tier, true
+	case "area":
+		return e.Area, true
+	case "outcome":
+		return e.Outcome, true
+	}
+	return nil, true
+}
+
+// combinedAccessLog writes entries in the Apache combined log format
+type combinedAccessLog struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// write prints the entry as an Apache combined log line
+func (l *combinedAccessLog) write(e *AccessEntry) {
+	uri := e.Path
+	if e.Query != "" {
+		uri += "?" + e.Query
+	}
+
+	line := fmt.Sprintf("%s - %s [%s] \"%s %s %s\" %d %s \"%s\" \"%s\"\n",
+		e.ClientIP,
+		dashIfEmpty(e.UserID),
+		e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		e.Method,
+		uri,
+		e.Proto,
+		e.Status,
+		combinedBytes(e.ResponseBytes),
+		dashIfEmpty(escapeQuotes(e.Referer)),
+		dashIfEmpty(escapeQuotes(e.UserAgent)),
+	)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, _ = io.WriteString(l.out, line)
+}
+
+// templateAccessLog writes entries using a custom text/template
+type templateAccessLog struct {
+	mu   sync.Mutex
+	out  io.Writer
+	tmpl *template.Template
+}
+
+// write renders the entry with the template, one line per entry
+func (l *templateAccessLog) write(e *AccessEntry) {
+	var sb strings.Builder
+	if err := l.tmpl.Execute(&sb, e); err != nil {
+		sb.Reset()
+		sb.WriteString("access log template error: " + err.Error())
+	}
+	line := strings.TrimRight(sb.String(), "\n") + "\n"
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, _ = io.WriteString(l.out, line)
+}
+
+// dashIfEmpty returns "-" for empty values as in Apache logs
+func dashIfEmpty(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// combinedBytes formats a response size, "-" for empty bodies
+func combinedBytes(n int64) string {
+	if n == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%d", n)
+}
+
+// escapeQuotes escapes double quotes in header values
+func escapeQuotes(s string) string {
+	return strings.ReplaceAll(s, `"`, `\"`)
+}
+
+// accessLogSampler decides whether a request is written to the access log.
+// Responses at or above alwaysLogStatus are always logged, other responses
+// are sampled using the rate of the longest matching path prefix rule.
+type accessLogSampler struct {
+	defaultRate     float64
+	rules           []sampleRule
+	alwaysLogStatus int
+}
+
+// sampleRule is a sample rate for requests under a path prefix
+type sampleRule struct {
+	prefix string
+	rate   float64
+}
+
+// newAccessLogSampler creates a sampler from the access log configuration
+func newAccessLogSampler(cfg *config.AccessLogConfig) *accessLogSampler {
+	rules := make([]sampleRule, 0, len(cfg.SampleRules))
+	for prefix, rate := range cfg.SampleRules {
+		rules = append(rules, sampleRule{prefix: prefix, rate: rate})
+	}
+	// longest prefix first
+	sort.Slice(rules, func(i, j int) bool {
+		return len(rules[i].prefix) > len(rules[j].prefix)
+	})
+
+	return &accessLogSampler{
+		defaultRate:     cfg.SampleRate,
+		rules:           rules,
+		alwaysLogStatus: cfg.AlwaysLogStatus,
+	}
+}
+
+// shouldLog reports whether a request with the given path and status is logged
+func (s *accessLogSampler) shouldLog(path string, status int) bool {
+	if s.alwaysLogStatus > 0 && status >= s.alwaysLogStatus {
+		return true
+	}
+
+	rate := s.defaultRate
+	for _, rule := range s.rules {
+		if matchesPathPrefix(path, rule.prefix) {
+			rate = rule.rate
+			break
+		}
+	}
+
+	if rate >= 1 {
+		return true
+	}
+	if rate <= 0 {
+		return false
+	}
+	return rand.Float64() < rate
+}
+
+// matchesPathPrefix checks if path is prefix or lies below it
+func matchesPathPrefix(path, prefix string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	return len(path) == len(prefix) || strings.HasSuffix(prefix, "/") || path[len(prefix)] == '/'
+}
+
+// newAccessEntry builds an access log entry for a completed request
+func newAccessEntry(r *http.Request, path string, start time.Time, ww *responseWriter, body *countingReader, info *requestInfo) *AccessEntry {
+	e := &AccessEntry{
+		Time:          start,
+		ClientIP:      GetClientIP(r),
+		Method:        r.Method,
+		Path:          path,
+		Query:         r.URL.RawQuery,
+		Proto:         r.Proto,
+		Status:        ww.statusCode,
+		Latency:       time.Since(start),
+		UserAgent:     r.UserAgent(),
+		Referer:       r.Referer(),
+		UserID:        info.userID,
+		RequestBytes:  body.bytes,
+		ResponseBytes: ww.bytes,
+	}
+
+	if info.annotation != nil {
+		e.Service = info.annotation.Service
+		e.Team = info.annotation.Labels.Team
+		e.Tier = info.annotation.Labels.Tier
+		e.Area = info.annotation.Labels.Area
+	}
+
+	return e
+}
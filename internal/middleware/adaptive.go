@@ -0,0 +1,166 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gateway/template/internal/config"
+	"github.com/gateway/template/internal/metrics"
+	"github.com/gateway/template/pkg/logger"
+)
+
+var concurrencyLimitGauge = metrics.Default.Gauge(
+	"gateway_concurrency_limit",
+	"Current adaptive concurrency limit per service.",
+	"scope",
+)
+
+const (
+	// aimdBackoff is the factor aimd cuts the limit by
+	aimdBackoff = 0.9
+	// gradientTolerance is how much slower than usual responses may get
+	// before gradient lowers the limit
+	gradientTolerance = 1.5
+	// gradientLongWindow and gradientShortWindow are the samples the usual
+	// and the current latency of gradient are averaged over
+	gradientLongWindow  = 600
+	gradientShortWindow = 10
+	// gradientSmoothing is the share of a new limit gradient moves to
+	gradientSmoothing = 0.2
+)
+
+// AdaptiveConcurrencyLimit returns a ConcurrencyLimit whose limit follows the
+// latency of the requests it admits. It starts at adaptive.MaxLimit, or
+// fixedLimit, or 1000, and moves between it and adaptive.MinLimit. Responses
+// with 429, 502, 503 or 504 count as overload. The limit reached is kept in
+// state while the settings stay the same.
+func AdaptiveConcurrencyLimit(scope string, fixedLimit int, adaptive *config.AdaptiveConcurrencyConfig, cfg *config.ConcurrencyConfig, state *State, log logger.Logger) func(next http.Handler) http.Handler {
+	maxLimit := adaptive.MaxLimit
+	if maxLimit == 0 {
+		maxLimit = fixedLimit
+	}
+	if maxLimit <= 0 {
+		maxLimit = 1000
+	}
+	minLimit := max(1, adaptive.MinLimit)
+
+	settings := struct {
+		Algorithm string
+		Min, Max  int
+		Latency   time.Duration
+		QueueSize int
+	}{adaptive.Algorithm, minLimit, maxLimit, adaptive.Latency, cfg.QueueSize}
+	limit := keep(state, "adaptive:"+scope, settings, func() *adaptiveLimit {
+		concurrencyLimitGauge.Set(float64(maxLimit), scope)
+		return &adaptiveLimit{
+			scope:     scope,
+			algorithm: adaptive.Algorithm,
+			min:       float64(minLimit),
+			max:       float64(maxLimit),
+			latency:   adaptive.Latency,
+			limit:     float64(maxLimit),
+			slots:     &slotQueue{limit: maxLimit, maxQueue: cfg.QueueSize},
+			log:       log,
+		}
+	})
+	return limitConcurrency(scope, limit.slots, limit, cfg, log)
+}
+
+// overloadStatus reports whether a response status signals an overloaded
+// upstream
+func overloadStatus(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// adaptiveLimit moves the limit of slots by the latency of requests
+type adaptiveLimit struct {
+	mu        sync.Mutex
+	scope     string
+	algorithm string
+	min, max  float64
+	latency   time.Duration
+	limit     float64
+	slots     *slotQueue
+	log       logger.Logger
+
+	lastCut           time.Time // aimd only cuts for requests started after it
+	longRTT, shortRTT float64   // average latencies in seconds for gradient
+}
+
+// sample adjusts the limit to a request started at start that took rtt
+func (a *adaptiveLimit) sample(start time.Time, rtt time.Duration, overloaded bool) {
+	inFlight := a.slots.inFlight()
+
+	a.mu.Lock()
+	before := int(a.limit)
+	if a.algorithm == "gradient" {
+		a.gradient(rtt, overloaded, inFlight)
+	} else {
+		a.aimd(start, rtt, overloaded, inFlight)
+	}
+	a.limit = min(a.max, max(a.min, a.limit))
+	limit := int(a.limit)
+	a.mu.Unlock()
+
+	if limit == before {
+		return
+	}
+	a.slots.setLimit(limit)
+	concurrencyLimitGauge.Set(float64(limit), a.scope)
+	if limit < before {
+		a.log.Debug("lowered adaptive concurrency limit",
+			"scope", a.scope,
+			"limit", limit,
+			"latency", rtt.String(),
+		)
+	}
+}
+
+// aimd cuts the limit for a slow or failed request, once for all requests
+// in flight at the time, and raises it by one for others while at least half
+// of it is in use
+func (a *adaptiveLimit) aimd(start time.Time, rtt time.Duration, overloaded bool, inFlight int) {
+	if overloaded || rtt > a.latency {
+		if start.After(a.lastCut) {
+			a.limit *= aimdBackoff
+			a.lastCut = time.Now()
+		}
+		return
+	}
+	if float64(inFlight)*2 >= a.limit {
+		a.limit++
+	}
+}
+
+// gradient moves the limit by the ratio of the usual to the current
+// latency, lowering it while the upstream slows down and raising it by about
+// its square root while latency is as usual and at least half of it is in
+// use
+func (a *adaptiveLimit) gradient(rtt time.Duration, overloaded bool, inFlight int) {
+	seconds := rtt.Seconds()
+	if a.longRTT == 0 {
+		a.longRTT, a.shortRTT = seconds, seconds
+	}
+	a.longRTT += (seconds - a.longRTT) * 2 / (gradientLongWindow + 1)
+	a.shortRTT += (seconds - a.shortRTT) * 2 / (gradientShortWindow + 1)
+	// an upstream faster than usual sets a new usual quickly
+	if a.longRTT > 2*a.shortRTT {
+		a.longRTT *= 0.95
+	}
+
+	gradient := 0.5
+	if !overloaded {
+		gradient = max(0.5, min(1, gradientTolerance*a.longRTT/a.shortRTT))
+	}
+	if gradient == 1 && float64(inFlight)*2 < a.limit {
+		return
+	}
+	target := a.limit*gradient + math.Sqrt(a.limit)
+	a.limit += (target - a.limit) * gradientSmoothing
+}
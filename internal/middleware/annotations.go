@@ -0,0 +1,114 @@
+package middleware
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gateway/template/internal/config"
+	"github.com/gateway/template/internal/costreport"
+	"github.com/gateway/template/internal/metrics"
+	"github.com/gateway/template/internal/problem"
+)
+
+// RouteLabelsContextKey is the context key for route business labels
+const RouteLabelsContextKey ContextKey = "route_labels"
+
+var (
+	routeRequests = metrics.Default.Counter(
+		"gateway_route_requests_total",
+		"Number of requests handled per route, partitioned by business labels.",
+		"service", "team", "tier", "area", "code",
+	)
+	routeDuration = metrics.Default.Counter(
+		"gateway_route_request_duration_seconds_total",
+		"Total time spent handling requests per route, partitioned by business labels.",
+		"service", "team", "tier", "area",
+	)
+	routeRequestBytes = metrics.Default.Counter(
+		"gateway_route_request_bytes_total",
+		"Request body bytes received from clients and forwarded upstream per route.",
+		"service", "team", "tier", "area",
+	)
+	routeResponseBytes = metrics.Default.Counter(
+		"gateway_route_response_bytes_total",
+		"Response body bytes returned to clients per route.",
+		"service", "team", "tier", "area",
+	)
+)
+
+// RouteAnnotation describes the route a request was matched to
+type RouteAnnotation struct {
+	Service string
+	Labels  config.RouteLabels
+}
+
+// requestInfo is placed in the context by Logging so that details set
+// further down the chain (route annotation, user) are visible to the access log
+type requestInfo struct {
+	annotation *RouteAnnotation
+	userID     string
+}
+
+// Annotate returns a chi middleware that attaches business labels (owning team,
+// criticality tier, product area) to requests routed to a service. Labels are
+// added to the request context, the access log and per-route metrics.
+func Annotate(service string, labels config.RouteLabels) func(next http.Handler) http.Handler {
+	annotation := &RouteAnnotation{
+		Service: service,
+		Labels:  labels,
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+
+			if info, ok := r.Context().Value(requestInfoContextKey).(*requestInfo); ok {
+				info.annotation = annotation
+			}
+			r = withLogFields(r, "service", service)
+
+			ww := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+			ctx := context.WithValue(r.Context(), RouteLabelsContextKey, annotation)
+			ctx = problem.WithService(ctx, service)
+
+			// count request body bytes as they are read by the proxy
+			body := &countingReader{ReadCloser: r.Body}
+			if r.Body != nil {
+				r.Body = body
+			}
+
+			next.ServeHTTP(ww, r.WithContext(ctx))
+
+			if ClientCanceled(r) {
+				clientCanceledRequests.Inc(service)
+			}
+			routeRequests.Inc(service, labels.Team, labels.Tier, labels.Area, strconv.Itoa(ww.statusCode))
+			routeDuration.Add(time.Since(start).Seconds(), service, labels.Team, labels.Tier, labels.Area)
+			routeRequestBytes.Add(float64(body.bytes), service, labels.Team, labels.Tier, labels.Area)
+			routeResponseBytes.Add(float64(ww.bytes), service, labels.Team, labels.Tier, labels.Area)
+			costreport.Default.Record(labels.Team, service, body.bytes, ww.bytes)
+		})
+	}
+}
+
+// GetRouteAnnotationFromContext extracts the route annotation from request context
+func GetRouteAnnotationFromContext(ctx context.Context) (*RouteAnnotation, bool) {
+	annotation, ok := ctx.Value(RouteLabelsContextKey).(*RouteAnnotation)
+	return annotation, ok
+}
+
+// countingReader counts bytes read from a request body
+type countingReader struct {
+	io.ReadCloser
+	bytes int64
+}
+
+// Read counts the bytes read
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.bytes += int64(n)
+	return n, err
+}
@@ -0,0 +1,204 @@
+package middleware
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gateway/template/internal/config"
+	"github.com/gateway/template/internal/metrics"
+)
+
+// maxThrottleChunk bounds the bytes taken from a bucket at once, so large
+// writes are spread over time instead of sleeping for their whole size
+const maxThrottleChunk = 32 << 10
+
+var throttledSeconds = metrics.Default.Counter(
+	"gateway_bandwidth_throttled_seconds_total",
+	"Time in seconds request and response bodies were delayed by per-client bandwidth throttling.",
+	"direction",
+)
+
+// BandwidthLimit returns a chi middleware that throttles the request and
+// response bodies of each client to cfg.Rate bytes per second in each
+// direction, after a burst of cfg.Burst bytes, so a single bulk download
+// cannot saturate the gateway's bandwidth. Clients are told apart by IP, or
+// by user if cfg.By is user, which requires it to run after authentication.
+// The limit is shared by all services the middleware is applied to, and
+// kept in state while its settings stay the same. A rate <= 0 disables
+// throttling.
+func BandwidthLimit(cfg *config.BandwidthConfig, state *State) func(next http.Handler) http.Handler {
+	if cfg.Rate <= 0 {
+		return func(next http.Handler) http.Handler {
+			return next
+		}
+	}
+
+	burst := cfg.Burst
+	if burst <= 0 {
+		burst = cfg.Rate
+	}
+	clients := keep(state, "bandwidth", [2]int64{cfg.Rate, burst}, func() *bandwidthClients {
+		return &bandwidthClients{rate: float64(cfg.Rate), burst: float64(burst), clients: make(map[string]*clientBandwidth)}
+	})
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			client := clients.get(bandwidthKey(r, cfg.By))
+			if r.Body != nil && r.Body != http.NoBody {
+				r.Body = &throttledBody{ReadCloser: r.Body, bucket: client.in, ctx: r.Context()}
+			}
+			next.ServeHTTP(&throttledWriter{ResponseWriter: w, bucket: client.out, ctx: r.Context()}, r)
+		})
+	}
+}
+
+// bandwidthKey identifies the client of a request: its user if by is user
+// and it has one, else its client IP, which is only taken from forwarded
+// headers of trusted proxies
+func bandwidthKey(r *http.Request, by string) string {
+	if by == "user" {
+		if userID, ok := GetUserIDFromContext(r.Context()); ok && userID != "" {
+			return "user:" + userID
+		}
+	}
+	return "ip:" + GetClientIP(r)
+}
+
+// bandwidthClients holds the token buckets of the clients seen recently
+type bandwidthClients struct {
+	rate, burst float64
+
+	mu      sync.Mutex
+	clients map[string]*clientBandwidth
+	sweep   time.Time // next time idle clients are removed
+}
+
+// clientBandwidth holds the buckets of a client, one per direction
+type clientBandwidth struct {
+	in, out  *tokenBucket
+	lastSeen time.Time
+}
+
+// get returns the buckets of a client, creating them on first use
+func (c *bandwidthClients) get(key string) *clientBandwidth {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	c.sweepIdle(now)
+	client, ok := c.clients[key]
+	if !ok {
+		client = &clientBandwidth{
+			in:  &tokenBucket{rate: c.rate, burst: c.burst, tokens: c.burst, last: now, direction: "in"},
+			out: &tokenBucket{rate: c.rate, burst: c.burst, tokens: c.burst, last: now, direction: "out"},
+		}
+		c.clients[key] = client
+	}
+	client.lastSeen = now
+	return client
+}
+
+// sweepIdle removes clients whose buckets have refilled at most once a
+// minute, c.mu must be held. Requests still holding their buckets keep
+// using them, a new request of the client starts with full ones.
+func (c *bandwidthClients) sweepIdle(now time.Time) {
+	if now.Before(c.sweep) {
+		return
+	}
+	idle := time.Duration(c.burst / c.rate * float64(time.Second))
+	for key, client := range c.clients {
+		if now.Sub(client.lastSeen) > idle+time.Minute {
+			delete(c.clients, key)
+		}
+	}
+	c.sweep = now.Add(time.Minute)
+}
+
+// tokenBucket allows rate bytes per second on average and bursts of up to
+// burst bytes
+type tokenBucket struct {
+	rate, burst float64
+	direction   string
+
+	mu     sync.Mutex
+	tokens float64 // may be negative while transfers wait for their share
+	last   time.Time
+}
+
+// wait takes n bytes from the bucket and sleeps until they are covered
+func (b *tokenBucket) wait(ctx context.Context, n int) error {
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens -= float64(n)
+	deficit := -b.tokens
+	b.mu.Unlock()
+
+	if deficit <= 0 {
+		return nil
+	}
+	delay := time.Duration(deficit / b.rate * float64(time.Second))
+	throttledSeconds.Add(delay.Seconds(), b.direction)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// throttledBody delays reads of a request body to the client's rate
+type throttledBody struct {
+	io.ReadCloser
+	bucket *tokenBucket
+	ctx    context.Context
+}
+
+func (b *throttledBody) Read(p []byte) (int, error) {
+	if len(p) > maxThrottleChunk {
+		p = p[:maxThrottleChunk]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		if werr := b.bucket.wait(b.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// throttledWriter delays writes of a response body to the client's rate
+type throttledWriter struct {
+	http.ResponseWriter
+	bucket *tokenBucket
+	ctx    context.Context
+}
+
+func (w *throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), maxThrottleChunk)]
+		if err := w.bucket.wait(w.ctx, len(chunk)); err != nil {
+			return written, err
+		}
+		n, err := w.ResponseWriter.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Unwrap returns the wrapped writer, so http.ResponseController can flush
+func (w *throttledWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gateway/template/internal/config"
+	"github.com/gateway/template/internal/problem"
+	"github.com/gateway/template/pkg/auth"
+	"github.com/gateway/template/pkg/logger"
+)
+
+// BasicAuth returns a chi middleware requiring HTTP basic auth with the
+// users of an htpasswd file and the inline users of cfg, for quick
+// protection of internal dashboards. The user name is used as the user ID.
+func BasicAuth(serviceName string, cfg *config.BasicAuthConfig, log logger.Logger) (func(next http.Handler) http.Handler, error) {
+	users := make(auth.Htpasswd)
+	if cfg.HtpasswdFile != "" {
+		fileUsers, err := auth.LoadHtpasswd(cfg.HtpasswdFile)
+		if err != nil {
+			return nil, fmt.Errorf("service %q: %w", serviceName, err)
+		}
+		for user, hash := range fileUsers {
+			users[user] = hash
+		}
+	}
+	for user, hash := range cfg.Users {
+		users[user] = hash
+	}
+	if len(users) == 0 {
+		return nil, fmt.Errorf("service %q: basic auth has no users", serviceName)
+	}
+
+	realm := cfg.Realm
+	if realm == "" {
+		realm = serviceName
+	}
+	challenge := "Basic realm=" + strconv.Quote(realm) + `, charset="UTF-8"`
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, password, ok := r.BasicAuth()
+			if !ok || !users.Verify(user, password) {
+				if ok {
+					log.Warn("basic auth failed",
+						"service", serviceName,
+						"path", r.URL.Path,
+						"method", r.Method,
+						"user", user,
+					)
+				}
+				w.Header().Set("WWW-Authenticate", challenge)
+				problem.Write(w, r, http.StatusUnauthorized, "authentication required")
+				return
+			}
+
+			// report the user to the access log
+			if info, ok := r.Context().Value(requestInfoContextKey).(*requestInfo); ok {
+				info.userID = user
+			}
+
+			// the credentials are for the gateway, not the backend
+			r.Header.Del("Authorization")
+
+			ctx := context.WithValue(r.Context(), UserIDContextKey, user)
+			next.ServeHTTP(w, withLogFields(r.WithContext(ctx), "user_id", user))
+		})
+	}, nil
+}
@@ -0,0 +1,393 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gateway/template/internal/cache"
+	"github.com/gateway/template/internal/config"
+	"github.com/gateway/template/internal/metrics"
+)
+
+// CacheStatusHeader tells clients whether a response came from the cache:
+// HIT, STALE when it expired, MISS, or BYPASS for requests the bypass rules
+// kept away from it
+const CacheStatusHeader = "X-Cache"
+
+var cacheRequests = metrics.Default.Counter(
+	"gateway_cache_requests_total",
+	"Number of requests on cached paths by whether they were answered from the response cache.",
+	"service", "result",
+)
+
+// cacheKeyHeaders are the request headers that must be equal for requests
+// to share a cached response, besides path, query and user
+var cacheKeyHeaders = []string{"Authorization", "Cookie", "Accept", "Accept-Encoding", "Accept-Language"}
+
+// notModifiedHeaders are the headers of a cached response, by canonical
+// name, repeated in the 304 answering a conditional request for it
+var notModifiedHeaders = []string{"Cache-Control", "Content-Location", "Date", "Etag", "Expires", "Vary"}
+
+// cacheRefreshTimeout bounds the background refresh of a stale response
+const cacheRefreshTimeout = 30 * time.Second
+
+// cacheableStatus are the statuses of responses stored in the cache
+var cacheableStatus = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusPermanentRedirect:    true,
+}
+
+// Cache returns a chi middleware answering GET requests on the paths of cfg
+// (exact or prefixes ending in /*) from store, and storing the responses up
+// to maxBody bytes that may be cached, with the tags listed in their
+// tagHeader. Requests share a response if their cacheKey and the headers it
+// varies on are equal, so it must run after authentication.
+func Cache(serviceName string, cfg *config.TargetCacheConfig, store *cache.Cache, maxBody int64, tagHeader string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		var refreshing sync.Map // keys of stale responses being refreshed
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet || !matchPath(cfg.Paths, r.URL.EscapedPath()) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			noCache := requestNoCache(r)
+			if cacheBypassed(cfg, r) || noCache && cfg.RequestNoCache == "bypass" {
+				cacheRequests.Inc(serviceName, "bypass")
+				w.Header().Set(CacheStatusHeader, "BYPASS")
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			key := cacheKey(serviceName, cfg, r)
+			now := time.Now()
+			entry, ok := cachedEntry(store, key, r)
+			if noCache && cfg.RequestNoCache == "refresh" || r.Context().Value(cacheRefreshContextKey) != nil {
+				entry, ok = nil, false
+			}
+			switch {
+			case ok && entry.Fresh(now):
+				cacheRequests.Inc(serviceName, "hit")
+				writeCachedEntry(w, r, entry, now, "HIT")
+				return
+			case ok && entry.Revalidating(now):
+				cacheRequests.Inc(serviceName, "stale")
+				writeCachedEntry(w, r, entry, now, "STALE")
+				if _, running := refreshing.LoadOrStore(key, true); !running {
+					go func() {
+						defer refreshing.Delete(key)
+						refreshCachedEntry(next, cfg, store, key, r, maxBody, tagHeader)
+					}()
+				}
+				return
+			}
+
+			cacheRequests.Inc(serviceName, "miss")
+			w.Header().Set(CacheStatusHeader, "MISS")
+			if !ok || !entry.ServableOnError(now) {
+				capture := newCaptureWriter(w, int(maxBody))
+				next.ServeHTTP(capture, r)
+				storeCachedEntry(cfg, store, key, r, capture, now, tagHeader)
+				return
+			}
+
+			// keep the response until it is known whether the stale entry
+			// must replace it
+			buffer := &responseBuffer{header: w.Header().Clone()}
+			capture := newCaptureWriter(buffer, int(maxBody))
+			next.ServeHTTP(capture, r)
+			if buffer.status >= http.StatusInternalServerError {
+				cacheRequests.Inc(serviceName, "stale")
+				writeCachedEntry(w, r, entry, now, "STALE")
+				return
+			}
+			writeCaptured(w, buffer.status, buffer.header, buffer.body.Bytes())
+			storeCachedEntry(cfg, store, key, r, capture, now, tagHeader)
+		})
+	}
+}
+
+// cacheRefreshContextKey marks requests that must not be answered from the
+// cache
+const cacheRefreshContextKey ContextKey = "cache_refresh"
+
+// WithCacheRefresh returns a context for requests that fetch a response anew
+// and store it instead of being answered from the cache, e.g. to warm it up
+func WithCacheRefresh(ctx context.Context) context.Context {
+	return context.WithValue(ctx, cacheRefreshContextKey, true)
+}
+
+// refreshCachedEntry fetches the response to r anew in the background,
+// detached from the client's request, and stores it under key
+func refreshCachedEntry(next http.Handler, cfg *config.TargetCacheConfig, store *cache.Cache, key string, r *http.Request, maxBody int64, tagHeader string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), cacheRefreshTimeout)
+	defer cancel()
+	r = r.Clone(ctx)
+	capture := newCaptureWriter(&responseBuffer{header: make(http.Header)}, int(maxBody))
+	next.ServeHTTP(capture, r)
+	if capture.status >= http.StatusInternalServerError {
+		return // the stale response is better than a failure
+	}
+	storeCachedEntry(cfg, store, key, r, capture, time.Now(), tagHeader)
+}
+
+// storeCachedEntry stores the captured response to r received at now under
+// key, unless it has a status that is not cached, is larger than the
+// capture's limit, sets cookies, must not be cached or varies on every
+// request, or r was canceled. 404 and 5xx responses are cached for the
+// negative TTL of cfg, without stale windows. Responses without an ETag get
+// one from a hash of their body.
+func storeCachedEntry(cfg *config.TargetCacheConfig, store *cache.Cache, key string, r *http.Request, capture *captureWriter, now time.Time, tagHeader string) {
+	negative := capture.status == http.StatusNotFound || capture.status >= http.StatusInternalServerError
+	if !cacheableStatus[capture.status] && !negative || capture.overflow || r.Context().Err() != nil ||
+		len(capture.header.Values("Set-Cookie")) > 0 {
+		return
+	}
+	var ttl, revalidate, ifError time.Duration
+	if negative {
+		if cache.Storable(capture.header) {
+			ttl = cfg.NegativeTTL
+		}
+	} else {
+		ttl = cacheTTL(cfg, r.URL.EscapedPath(), capture.header, now)
+		revalidate, ifError = staleWindows(cfg, r.URL.EscapedPath(), capture.header)
+	}
+	vary := responseVary(capture.header)
+	if ttl <= 0 || slices.Contains(vary, "*") {
+		return
+	}
+	if len(vary) > 0 {
+		store.Set(key, &cache.Entry{
+			Path:                 r.URL.Path,
+			Stored:               now,
+			Expires:              now.Add(ttl),
+			StaleWhileRevalidate: revalidate,
+			StaleIfError:         ifError,
+			Vary:                 vary,
+		})
+		key = variantKey(key, vary, r)
+	}
+	if capture.header.Get("ETag") == "" {
+		sum := sha256.Sum256(capture.body)
+		capture.header.Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	}
+	var tags []string
+	if tagHeader != "" {
+		for _, value := range capture.header.Values(tagHeader) {
+			tags = append(tags, strings.FieldsFunc(value, func(r rune) bool { return r == ' ' || r == ',' })...)
+		}
+	}
+	store.Set(key, &cache.Entry{
+		Path:                 r.URL.Path,
+		Tags:                 tags,
+		Status:               capture.status,
+		Header:               capture.header,
+		Body:                 capture.body,
+		Stored:               now,
+		Expires:              now.Add(ttl),
+		StaleWhileRevalidate: revalidate,
+		StaleIfError:         ifError,
+	})
+}
+
+// cacheTTL returns how long a response to path received at now is cached,
+// 0 if it is not: never with no-store or private, else for a matching
+// override of cfg, else as long as its Cache-Control or Expires headers
+// allow unless they forbid shared caching, else for the TTL of cfg for path
+func cacheTTL(cfg *config.TargetCacheConfig, path string, header http.Header, now time.Time) time.Duration {
+	// overrides fix cache times, they don't share what is for one user or
+	// must not be kept
+	if !cache.Shareable(header) {
+		return 0
+	}
+	if ttl := pathDuration(cfg.Overrides, path, 0); ttl > 0 {
+		return ttl
+	}
+	if !cache.Storable(header) {
+		return 0
+	}
+	if lifetime, ok := cache.Lifetime(header, now); ok {
+		return lifetime
+	}
+	return pathDuration(cfg.TTLs, path, cfg.TTL)
+}
+
+// staleWindows returns how long after it expired a response to path may be
+// served while it is refreshed and when the upstream fails: its
+// stale-while-revalidate and stale-if-error directives, else those of cfg,
+// and never with must-revalidate
+func staleWindows(cfg *config.TargetCacheConfig, path string, header http.Header) (revalidate, ifError time.Duration) {
+	revalidate, ifError = cfg.StaleWhileRevalidate, cfg.StaleIfError
+	if pathDuration(cfg.Overrides, path, 0) > 0 {
+		return revalidate, ifError
+	}
+	d := cache.ParseCacheControl(header)
+	if d.Has("must-revalidate") || d.Has("proxy-revalidate") {
+		return 0, 0
+	}
+	if seconds, ok := d.Seconds("stale-while-revalidate"); ok {
+		revalidate = seconds
+	}
+	if seconds, ok := d.Seconds("stale-if-error"); ok {
+		ifError = seconds
+	}
+	return revalidate, ifError
+}
+
+// cacheBypassed reports whether the bypass rules of cfg keep a request away
+// from the cache
+func cacheBypassed(cfg *config.TargetCacheConfig, r *http.Request) bool {
+	if cfg.BypassAuthorization && r.Header.Get("Authorization") != "" {
+		return true
+	}
+	if len(cfg.BypassQuery) > 0 {
+		query := r.URL.Query()
+		for _, name := range cfg.BypassQuery {
+			if query.Has(name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// requestNoCache reports whether a client asked for a response that does not
+// come from a cache
+func requestNoCache(r *http.Request) bool {
+	if cache.ParseCacheControl(r.Header).Has("no-cache") {
+		return true
+	}
+	return len(r.Header.Values("Cache-Control")) == 0 && strings.EqualFold(r.Header.Get("Pragma"), "no-cache")
+}
+
+// cacheKey identifies the requests to a service that can share a response,
+// unless it varies on other headers: their path, query, user, credential and
+// content negotiation headers and the key headers and claims of cfg are equal
+func cacheKey(serviceName string, cfg *config.TargetCacheConfig, r *http.Request) string {
+	userID, _ := GetUserIDFromContext(r.Context())
+	parts := []string{serviceName, r.URL.EscapedPath(), r.URL.RawQuery, userID}
+	for _, name := range cacheKeyHeaders {
+		parts = append(parts, strings.Join(r.Header.Values(name), "\n"))
+	}
+	for _, name := range cfg.KeyHeaders {
+		parts = append(parts, strings.Join(r.Header.Values(name), "\n"))
+	}
+	if len(cfg.KeyClaims) > 0 {
+		claims, _ := GetClaimsFromContext(r.Context())
+		for _, name := range cfg.KeyClaims {
+			var value any
+			if claims != nil {
+				value = claims.Metadata[name]
+			}
+			parts = append(parts, fmt.Sprint(value))
+		}
+	}
+	return strings.Join(parts, "\x00")
+}
+
+// variantKey identifies the requests sharing the variant of a response
+// cached under key that varies on headers
+func variantKey(key string, headers []string, r *http.Request) string {
+	parts := []string{key}
+	for _, name := range headers {
+		parts = append(parts, strings.Join(r.Header.Values(name), "\n"))
+	}
+	return strings.Join(parts, "\x00")
+}
+
+// responseVary returns the canonical, sorted request headers a response
+// varies on, * if it varies on more than headers
+func responseVary(header http.Header) []string {
+	var vary []string
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				vary = append(vary, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	slices.Sort(vary)
+	return slices.Compact(vary)
+}
+
+// cachedEntry returns the cached response answering r under key, following
+// responses that vary on request headers to the variant of r
+func cachedEntry(store *cache.Cache, key string, r *http.Request) (*cache.Entry, bool) {
+	entry, ok := store.Get(key)
+	if ok && len(entry.Vary) > 0 {
+		entry, ok = store.Get(variantKey(key, entry.Vary, r))
+	}
+	return entry, ok
+}
+
+// etagMatch reports whether the If-None-Match values of a request list etag,
+// comparing weakly: W/"a" and "a" match
+func etagMatch(ifNoneMatch []string, etag string) bool {
+	if etag == "" {
+		return false
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, value := range ifNoneMatch {
+		for _, tag := range strings.Split(value, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// writeCachedEntry answers r from a cached response, with its age and how
+// it was answered, or with 304 if the If-None-Match of r lists its ETag
+func writeCachedEntry(w http.ResponseWriter, r *http.Request, entry *cache.Entry, now time.Time, status string) {
+	notModified := etagMatch(r.Header.Values("If-None-Match"), entry.Header.Get("ETag"))
+	for name, values := range entry.Header {
+		if !notModified || slices.Contains(notModifiedHeaders, name) {
+			w.Header()[name] = slices.Clone(values)
+		}
+	}
+	w.Header().Set("Age", strconv.FormatInt(int64(now.Sub(entry.Stored)/time.Second), 10))
+	w.Header().Set(CacheStatusHeader, status)
+	if notModified {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.WriteHeader(entry.Status)
+	_, _ = w.Write(entry.Body)
+}
+
+// responseBuffer keeps a response instead of writing it
+type responseBuffer struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *responseBuffer) Header() http.Header {
+	return b.header
+}
+
+func (b *responseBuffer) WriteHeader(code int) {
+	if b.status == 0 {
+		b.status = code
+	}
+}
+
+func (b *responseBuffer) Write(p []byte) (int, error) {
+	b.WriteHeader(http.StatusOK)
+	return b.body.Write(p)
+}
@@ -0,0 +1,551 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gateway/template/internal/cache"
+	"github.com/gateway/template/internal/config"
+	"github.com/gateway/template/pkg/auth"
+)
+
+// countingUpstream answers with the number of requests it received
+type countingUpstream struct {
+	requests int
+	status   int
+	header   http.Header
+}
+
+func (u *countingUpstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u.requests++
+	for name, values := range u.header {
+		w.Header()[http.CanonicalHeaderKey(name)] = values
+	}
+	status := u.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "response %d", u.requests)
+}
+
+// getCached sends a GET request through a cache handler
+func getCached(h http.Handler, path string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestCacheAnswersFromCache(t *testing.T) {
+	upstream := &countingUpstream{header: http.Header{"Content-Type": {"application/json"}}}
+	cfg := &config.TargetCacheConfig{Paths: []string{"/crm/catalog/*"}, TTL: time.Minute}
+	h := Cache("crm", cfg, cache.New(1<<20), 1<<20, "")(upstream)
+
+	first := getCached(h, "/crm/catalog/1", nil)
+	if first.Header().Get(CacheStatusHeader) != "MISS" || first.Body.String() != "response 1" {
+		t.Fatalf("expected the first request to be forwarded, got %s %q", first.Header().Get(CacheStatusHeader), first.Body)
+	}
+	second := getCached(h, "/crm/catalog/1", nil)
+	if second.Header().Get(CacheStatusHeader) != "HIT" || second.Body.String() != "response 1" {
+		t.Errorf("expected the second request to be answered from the cache, got %s %q", second.Header().Get(CacheStatusHeader), second.Body)
+	}
+	if second.Header().Get("Content-Type") != "application/json" || second.Header().Get("Age") != "0" {
+		t.Errorf("expected the cached headers and an age, got %v", second.Header())
+	}
+
+	// other queries, users and paths outside the cache paths are forwarded
+	getCached(h, "/crm/catalog/1?page=2", nil)
+	getCached(h, "/crm/catalog/1", http.Header{"Authorization": {"Bearer other"}})
+	getCached(h, "/crm/contacts", nil)
+	getCached(h, "/crm/contacts", nil)
+	if upstream.requests != 5 {
+		t.Errorf("expected 5 forwarded requests, got %d", upstream.requests)
+	}
+}
+
+func TestCacheExpiresResponses(t *testing.T) {
+	upstream := &countingUpstream{}
+	store := cache.New(1 << 20)
+	cfg := &config.TargetCacheConfig{Paths: []string{"/crm/*"}, TTL: time.Minute}
+	h := Cache("crm", cfg, store, 1<<20, "")(upstream)
+
+	getCached(h, "/crm/a", nil)
+	key := cacheKey("crm", cfg, httptest.NewRequest(http.MethodGet, "/crm/a", nil))
+	entry, ok := store.Get(key)
+	if !ok {
+		t.Fatal("expected the response to be cached")
+	}
+	entry.Expires = time.Now().Add(-time.Second)
+
+	if rec := getCached(h, "/crm/a", nil); rec.Header().Get(CacheStatusHeader) != "MISS" || upstream.requests != 2 {
+		t.Errorf("expected an expired response to be fetched again, got %s after %d requests", rec.Header().Get(CacheStatusHeader), upstream.requests)
+	}
+}
+
+func TestCacheSkipsUncacheableResponses(t *testing.T) {
+	tests := []struct {
+		name     string
+		upstream *countingUpstream
+		maxBody  int64
+	}{
+		{"server error", &countingUpstream{status: http.StatusInternalServerError}, 1 << 20},
+		{"not found", &countingUpstream{status: http.StatusNotFound}, 1 << 20},
+		{"cookie", &countingUpstream{header: http.Header{"Set-Cookie": {"session=1"}}}, 1 << 20},
+		{"too large", &countingUpstream{}, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.TargetCacheConfig{Paths: []string{"/crm/*"}, TTL: time.Minute}
+			h := Cache("crm", cfg, cache.New(1<<20), tt.maxBody, "")(tt.upstream)
+			getCached(h, "/crm/a", nil)
+			getCached(h, "/crm/a", nil)
+			if tt.upstream.requests != 2 {
+				t.Errorf("expected the response not to be cached, got %d forwarded requests", tt.upstream.requests)
+			}
+		})
+	}
+}
+
+func TestCacheSkipsCanceledRequests(t *testing.T) {
+	upstream := &countingUpstream{}
+	cfg := &config.TargetCacheConfig{Paths: []string{"/crm/*"}, TTL: time.Minute}
+	h := Cache("crm", cfg, cache.New(1<<20), 1<<20, "")(upstream)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/crm/a", nil).WithContext(ctx))
+	getCached(h, "/crm/a", nil)
+	if upstream.requests != 2 {
+		t.Errorf("expected the response of a canceled request not to be cached, got %d forwarded requests", upstream.requests)
+	}
+}
+
+func TestCacheHonorsCachingHeaders(t *testing.T) {
+	tests := []struct {
+		name      string
+		header    http.Header
+		ttl       time.Duration
+		overrides map[string]time.Duration
+		want      time.Duration // 0 for not cached
+	}{
+		{"max-age", http.Header{"Cache-Control": {"max-age=30"}}, time.Minute, nil, 30 * time.Second},
+		{"expires", http.Header{"Expires": {time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)}}, time.Minute, nil, time.Hour},
+		{"no caching headers", nil, time.Minute, nil, time.Minute},
+		{"no caching headers without TTL", nil, 0, nil, 0},
+		{"max-age without TTL", http.Header{"Cache-Control": {"max-age=30"}}, 0, nil, 30 * time.Second},
+		{"no-store", http.Header{"Cache-Control": {"no-store"}}, time.Minute, nil, 0},
+		{"private", http.Header{"Cache-Control": {"private, max-age=30"}}, time.Minute, nil, 0},
+		{"no-cache", http.Header{"Cache-Control": {"no-cache"}}, time.Minute, nil, 0},
+		{"max-age=0", http.Header{"Cache-Control": {"max-age=0"}}, time.Minute, nil, 0},
+		{
+			"override", http.Header{"Cache-Control": {"no-cache"}}, time.Minute,
+			map[string]time.Duration{"/*": time.Second, "/crm/a": time.Hour}, time.Hour,
+		},
+		{
+			"override of max-age=0", http.Header{"Cache-Control": {"max-age=0"}}, 0,
+			map[string]time.Duration{"/crm/*": time.Hour}, time.Hour,
+		},
+		{
+			"override of no-store", http.Header{"Cache-Control": {"no-store"}}, time.Minute,
+			map[string]time.Duration{"/crm/*": time.Hour}, 0,
+		},
+		{
+			"override of private", http.Header{"Cache-Control": {"private, max-age=30"}}, time.Minute,
+			map[string]time.Duration{"/crm/*": time.Hour}, 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := cache.New(1 << 20)
+			cfg := &config.TargetCacheConfig{Paths: []string{"/crm/*"}, TTL: tt.ttl, Overrides: tt.overrides}
+			h := Cache("crm", cfg, store, 1<<20, "")(&countingUpstream{header: tt.header})
+			getCached(h, "/crm/a", nil)
+
+			entry, ok := store.Get(cacheKey("crm", cfg, httptest.NewRequest(http.MethodGet, "/crm/a", nil)))
+			if tt.want == 0 {
+				if ok {
+					t.Errorf("expected the response not to be cached, cached for %s", entry.Expires.Sub(entry.Stored))
+				}
+				return
+			}
+			if !ok {
+				t.Fatal("expected the response to be cached")
+			}
+			// Expires dates have a precision of seconds
+			if got := entry.Expires.Sub(entry.Stored); got < tt.want-time.Second || got > tt.want {
+				t.Errorf("expected the response to be cached for %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestCachePathTTLs(t *testing.T) {
+	store := cache.New(1 << 20)
+	cfg := &config.TargetCacheConfig{
+		Paths: []string{"/crm/*"},
+		TTL:   time.Minute,
+		TTLs:  map[string]time.Duration{"/crm/catalog/*": time.Hour, "/crm/catalog/live": 0},
+	}
+	h := Cache("crm", cfg, store, 1<<20, "")(&countingUpstream{})
+
+	for path, want := range map[string]time.Duration{"/crm/a": time.Minute, "/crm/catalog/1": time.Hour, "/crm/catalog/live": 0} {
+		getCached(h, path, nil)
+		entry, ok := store.Get(cacheKey("crm", cfg, httptest.NewRequest(http.MethodGet, path, nil)))
+		switch {
+		case want == 0 && ok:
+			t.Errorf("%s: expected the response not to be cached", path)
+		case want != 0 && (!ok || entry.Expires.Sub(entry.Stored) != want):
+			t.Errorf("%s: expected the response to be cached for %s", path, want)
+		}
+	}
+}
+
+func TestCacheBypass(t *testing.T) {
+	upstream := &countingUpstream{}
+	cfg := &config.TargetCacheConfig{
+		Paths:               []string{"/crm/*"},
+		TTL:                 time.Minute,
+		BypassAuthorization: true,
+		BypassQuery:         []string{"preview", "nocache"},
+	}
+	h := Cache("crm", cfg, cache.New(1<<20), 1<<20, "")(upstream)
+
+	for _, req := range []struct {
+		path   string
+		header http.Header
+	}{
+		{"/crm/a", http.Header{"Authorization": {"Bearer token"}}},
+		{"/crm/a?preview", nil},
+		{"/crm/a?page=1&nocache=1", nil},
+	} {
+		for range 2 {
+			if rec := getCached(h, req.path, req.header); rec.Header().Get(CacheStatusHeader) != "BYPASS" {
+				t.Errorf("%s %v: expected the cache to be bypassed, got %q", req.path, req.header, rec.Header().Get(CacheStatusHeader))
+			}
+		}
+	}
+	if upstream.requests != 6 {
+		t.Errorf("expected every bypassed request to be forwarded, got %d", upstream.requests)
+	}
+
+	getCached(h, "/crm/a?page=1", nil)
+	if rec := getCached(h, "/crm/a?page=1", nil); rec.Header().Get(CacheStatusHeader) != "HIT" {
+		t.Errorf("expected other query parameters to be cached, got %q", rec.Header().Get(CacheStatusHeader))
+	}
+}
+
+func TestCacheRequestNoCache(t *testing.T) {
+	tests := []struct {
+		policy   string
+		status   string // of the no-cache request
+		requests int    // forwarded after the no-cache request
+		body     string // of the request following it
+	}{
+		{"", "HIT", 1, "response 1"},
+		{"ignore", "HIT", 1, "response 1"},
+		{"refresh", "MISS", 2, "response 2"},
+		{"bypass", "BYPASS", 2, "response 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			upstream := &countingUpstream{}
+			cfg := &config.TargetCacheConfig{Paths: []string{"/crm/*"}, TTL: time.Minute, RequestNoCache: tt.policy}
+			h := Cache("crm", cfg, cache.New(1<<20), 1<<20, "")(upstream)
+
+			getCached(h, "/crm/a", nil)
+			rec := getCached(h, "/crm/a", http.Header{"Cache-Control": {"no-cache"}})
+			if rec.Header().Get(CacheStatusHeader) != tt.status || upstream.requests != tt.requests {
+				t.Errorf("expected %s after %d requests, got %s after %d", tt.status, tt.requests, rec.Header().Get(CacheStatusHeader), upstream.requests)
+			}
+			if rec := getCached(h, "/crm/a", nil); rec.Body.String() != tt.body {
+				t.Errorf("expected the next request to get %q, got %q", tt.body, rec.Body)
+			}
+		})
+	}
+
+	// Pragma only counts without Cache-Control
+	cfg := &config.TargetCacheConfig{Paths: []string{"/crm/*"}, TTL: time.Minute, RequestNoCache: "bypass"}
+	h := Cache("crm", cfg, cache.New(1<<20), 1<<20, "")(&countingUpstream{})
+	if rec := getCached(h, "/crm/a", http.Header{"Pragma": {"no-cache"}}); rec.Header().Get(CacheStatusHeader) != "BYPASS" {
+		t.Errorf("expected Pragma: no-cache to bypass the cache, got %q", rec.Header().Get(CacheStatusHeader))
+	}
+	if rec := getCached(h, "/crm/a", http.Header{"Pragma": {"no-cache"}, "Cache-Control": {"max-age=60"}}); rec.Header().Get(CacheStatusHeader) != "MISS" {
+		t.Errorf("expected Cache-Control to replace Pragma, got %q", rec.Header().Get(CacheStatusHeader))
+	}
+}
+
+func TestCacheVariesOnResponseHeaders(t *testing.T) {
+	upstream := &countingUpstream{header: http.Header{"Vary": {"x-region, Accept-Encoding"}}}
+	cfg := &config.TargetCacheConfig{Paths: []string{"/crm/*"}, TTL: time.Minute}
+	h := Cache("crm", cfg, cache.New(1<<20), 1<<20, "")(upstream)
+
+	eu := http.Header{"X-Region": {"eu"}}
+	us := http.Header{"X-Region": {"us"}}
+	getCached(h, "/crm/a", eu)
+	if rec := getCached(h, "/crm/a", us); rec.Header().Get(CacheStatusHeader) != "MISS" || rec.Body.String() != "response 2" {
+		t.Errorf("expected another region to be forwarded, got %s %q", rec.Header().Get(CacheStatusHeader), rec.Body)
+	}
+	for i, header := range []http.Header{eu, us} {
+		want := fmt.Sprintf("response %d", i+1)
+		if rec := getCached(h, "/crm/a", header); rec.Header().Get(CacheStatusHeader) != "HIT" || rec.Body.String() != want {
+			t.Errorf("%v: expected %q from the cache, got %s %q", header, want, rec.Header().Get(CacheStatusHeader), rec.Body)
+		}
+	}
+	if upstream.requests != 2 {
+		t.Errorf("expected 2 forwarded requests, got %d", upstream.requests)
+	}
+
+	// responses varying on everything are not cached
+	upstream = &countingUpstream{header: http.Header{"Vary": {"*"}}}
+	h = Cache("crm", cfg, cache.New(1<<20), 1<<20, "")(upstream)
+	getCached(h, "/crm/a", nil)
+	getCached(h, "/crm/a", nil)
+	if upstream.requests != 2 {
+		t.Errorf("expected responses with Vary: * not to be cached, got %d forwarded requests", upstream.requests)
+	}
+}
+
+func TestCacheKeyHeadersAndClaims(t *testing.T) {
+	upstream := &countingUpstream{}
+	cfg := &config.TargetCacheConfig{Paths: []string{"/crm/*"}, TTL: time.Minute, KeyHeaders: []string{"X-Tenant"}, KeyClaims: []string{"tenant"}}
+	h := Cache("crm", cfg, cache.New(1<<20), 1<<20, "")(upstream)
+
+	get := func(header, claim string) string {
+		req := httptest.NewRequest(http.MethodGet, "/crm/a", nil)
+		req.Header.Set("X-Tenant", header)
+		claims := &auth.Claims{Metadata: map[string]interface{}{"tenant": claim}}
+		req = req.WithContext(context.WithValue(req.Context(), ClaimsContextKey, claims))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Body.String()
+	}
+	get("a", "a")
+	if body := get("b", "a"); body != "response 2" {
+		t.Errorf("expected another key header to be forwarded, got %q", body)
+	}
+	if body := get("a", "b"); body != "response 3" {
+		t.Errorf("expected another key claim to be forwarded, got %q", body)
+	}
+	if body := get("a", "a"); body != "response 1" {
+		t.Errorf("expected equal key headers and claims to share the response, got %q", body)
+	}
+}
+
+func TestCacheAnswersConditionalRequests(t *testing.T) {
+	upstream := &countingUpstream{header: http.Header{"Cache-Control": {"max-age=60"}}}
+	cfg := &config.TargetCacheConfig{Paths: []string{"/crm/*"}}
+	h := Cache("crm", cfg, cache.New(1<<20), 1<<20, "")(upstream)
+
+	getCached(h, "/crm/a", nil)
+	hit := getCached(h, "/crm/a", nil)
+	etag := hit.Header().Get("ETag")
+	if !strings.HasPrefix(etag, `"`) || !strings.HasSuffix(etag, `"`) || len(etag) < 3 {
+		t.Fatalf("expected cached responses to get an ETag, got %q", etag)
+	}
+
+	for _, ifNoneMatch := range []string{etag, "W/" + etag, `"other", ` + etag, "*"} {
+		rec := getCached(h, "/crm/a", http.Header{"If-None-Match": {ifNoneMatch}})
+		if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+			t.Errorf("If-None-Match %s: expected 304 without a body, got %d %q", ifNoneMatch, rec.Code, rec.Body)
+		}
+		if rec.Header().Get("ETag") != etag || rec.Header().Get("Cache-Control") != "max-age=60" {
+			t.Errorf("If-None-Match %s: expected the ETag and caching headers, got %v", ifNoneMatch, rec.Header())
+		}
+	}
+	if rec := getCached(h, "/crm/a", http.Header{"If-None-Match": {`"other"`}}); rec.Code != http.StatusOK || rec.Body.String() != "response 1" {
+		t.Errorf("expected another ETag to get the cached response, got %d %q", rec.Code, rec.Body)
+	}
+	if upstream.requests != 1 {
+		t.Errorf("expected conditional requests to be answered from the cache, got %d forwarded requests", upstream.requests)
+	}
+
+	// the upstream's ETag is kept
+	upstream = &countingUpstream{header: http.Header{"ETag": {`W/"v1"`}}}
+	h = Cache("crm", &config.TargetCacheConfig{Paths: []string{"/crm/*"}, TTL: time.Minute}, cache.New(1<<20), 1<<20, "")(upstream)
+	getCached(h, "/crm/a", nil)
+	if rec := getCached(h, "/crm/a", http.Header{"If-None-Match": {`"v1"`}}); rec.Code != http.StatusNotModified || rec.Header().Get("ETag") != `W/"v1"` {
+		t.Errorf("expected the upstream's ETag to match, got %d %v", rec.Code, rec.Header())
+	}
+}
+
+// expireCached makes the cached response to path expire
+func expireCached(t *testing.T, store *cache.Cache, cfg *config.TargetCacheConfig, path string) {
+	t.Helper()
+	entry, ok := store.Get(cacheKey("crm", cfg, httptest.NewRequest(http.MethodGet, path, nil)))
+	if !ok {
+		t.Fatal("expected the response to be cached")
+	}
+	entry.Expires = time.Now().Add(-time.Second)
+}
+
+func TestCacheServesStaleWhileRevalidating(t *testing.T) {
+	var requests atomic.Int32
+	release := make(chan struct{})
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if n := requests.Add(1); n > 1 {
+			<-release
+		}
+		fmt.Fprintf(w, "response %d", requests.Load())
+	})
+	store := cache.New(1 << 20)
+	cfg := &config.TargetCacheConfig{Paths: []string{"/crm/*"}, TTL: time.Minute, StaleWhileRevalidate: time.Minute}
+	h := Cache("crm", cfg, store, 1<<20, "")(upstream)
+
+	getCached(h, "/crm/a", nil)
+	expireCached(t, store, cfg, "/crm/a")
+
+	// stale responses are served at once while a single refresh runs
+	for range 3 {
+		if rec := getCached(h, "/crm/a", nil); rec.Header().Get(CacheStatusHeader) != "STALE" || rec.Body.String() != "response 1" {
+			t.Fatalf("expected the stale response, got %s %q", rec.Header().Get(CacheStatusHeader), rec.Body)
+		}
+	}
+	close(release)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		rec := getCached(h, "/crm/a", nil)
+		if rec.Header().Get(CacheStatusHeader) == "HIT" {
+			if rec.Body.String() != "response 2" {
+				t.Errorf("expected the refreshed response, got %q", rec.Body)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the stale response to be refreshed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if n := requests.Load(); n != 2 {
+		t.Errorf("expected a single refresh, got %d forwarded requests", n)
+	}
+}
+
+func TestCacheServesStaleOnErrors(t *testing.T) {
+	upstream := &countingUpstream{}
+	store := cache.New(1 << 20)
+	cfg := &config.TargetCacheConfig{Paths: []string{"/crm/*"}, TTL: time.Minute, StaleIfError: time.Minute}
+	h := Cache("crm", cfg, store, 1<<20, "")(upstream)
+
+	getCached(h, "/crm/a", nil)
+	expireCached(t, store, cfg, "/crm/a")
+	upstream.status = http.StatusBadGateway
+	if rec := getCached(h, "/crm/a", nil); rec.Code != http.StatusOK || rec.Header().Get(CacheStatusHeader) != "STALE" || rec.Body.String() != "response 1" {
+		t.Errorf("expected the stale response on an upstream error, got %d %s %q", rec.Code, rec.Header().Get(CacheStatusHeader), rec.Body)
+	}
+
+	upstream.status = http.StatusNotFound
+	if rec := getCached(h, "/crm/a", nil); rec.Code != http.StatusNotFound || rec.Header().Get(CacheStatusHeader) != "MISS" || rec.Body.String() != "response 3" {
+		t.Errorf("expected other responses to be passed on, got %d %s %q", rec.Code, rec.Header().Get(CacheStatusHeader), rec.Body)
+	}
+	upstream.status = http.StatusOK
+	getCached(h, "/crm/a", nil)
+	if rec := getCached(h, "/crm/a", nil); rec.Header().Get(CacheStatusHeader) != "HIT" || rec.Body.String() != "response 4" {
+		t.Errorf("expected a successful response to replace the stale one, got %s %q", rec.Header().Get(CacheStatusHeader), rec.Body)
+	}
+}
+
+func TestCacheStaleDirectives(t *testing.T) {
+	tests := []struct {
+		cacheControl string
+		revalidate   time.Duration
+		ifError      time.Duration
+	}{
+		{"max-age=60", time.Minute, time.Hour},
+		{"max-age=60, stale-while-revalidate=5, stale-if-error=10", 5 * time.Second, 10 * time.Second},
+		{"max-age=60, must-revalidate, stale-if-error=10", 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.cacheControl, func(t *testing.T) {
+			store := cache.New(1 << 20)
+			cfg := &config.TargetCacheConfig{Paths: []string{"/crm/*"}, StaleWhileRevalidate: time.Minute, StaleIfError: time.Hour}
+			h := Cache("crm", cfg, store, 1<<20, "")(&countingUpstream{header: http.Header{"Cache-Control": {tt.cacheControl}}})
+			getCached(h, "/crm/a", nil)
+
+			entry, ok := store.Get(cacheKey("crm", cfg, httptest.NewRequest(http.MethodGet, "/crm/a", nil)))
+			if !ok || entry.StaleWhileRevalidate != tt.revalidate || entry.StaleIfError != tt.ifError {
+				t.Errorf("expected stale windows %s and %s, got %+v", tt.revalidate, tt.ifError, entry)
+			}
+		})
+	}
+}
+
+func TestCacheNegativeResponses(t *testing.T) {
+	for _, status := range []int{http.StatusNotFound, http.StatusInternalServerError, http.StatusServiceUnavailable} {
+		t.Run(strconv.Itoa(status), func(t *testing.T) {
+			upstream := &countingUpstream{status: status}
+			store := cache.New(1 << 20)
+			cfg := &config.TargetCacheConfig{
+				Paths:        []string{"/crm/*"},
+				TTL:          time.Hour,
+				StaleIfError: time.Hour,
+				NegativeTTL:  5 * time.Second,
+			}
+			h := Cache("crm", cfg, store, 1<<20, "")(upstream)
+
+			getCached(h, "/crm/a", nil)
+			if rec := getCached(h, "/crm/a", nil); rec.Code != status || rec.Header().Get(CacheStatusHeader) != "HIT" || upstream.requests != 1 {
+				t.Errorf("expected the failure to be answered from the cache, got %d %s after %d requests", rec.Code, rec.Header().Get(CacheStatusHeader), upstream.requests)
+			}
+			entry, _ := store.Get(cacheKey("crm", cfg, httptest.NewRequest(http.MethodGet, "/crm/a", nil)))
+			if entry.Expires.Sub(entry.Stored) != 5*time.Second || entry.StaleIfError != 0 || entry.StaleWhileRevalidate != 0 {
+				t.Errorf("expected the failure to be cached for the negative TTL only, got %+v", entry)
+			}
+		})
+	}
+
+	// without a negative TTL failures are not cached, nor when the upstream forbids it
+	for _, cfg := range []*config.TargetCacheConfig{
+		{Paths: []string{"/crm/*"}, TTL: time.Hour},
+		{Paths: []string{"/crm/*"}, TTL: time.Hour, NegativeTTL: time.Minute},
+	} {
+		upstream := &countingUpstream{status: http.StatusNotFound}
+		if cfg.NegativeTTL > 0 {
+			upstream.header = http.Header{"Cache-Control": {"no-store"}}
+		}
+		h := Cache("crm", cfg, cache.New(1<<20), 1<<20, "")(upstream)
+		getCached(h, "/crm/a", nil)
+		getCached(h, "/crm/a", nil)
+		if upstream.requests != 2 {
+			t.Errorf("expected the failure not to be cached, got %d forwarded requests", upstream.requests)
+		}
+	}
+}
+
+func TestCacheKeepsStaleResponsesOverFailedRefreshes(t *testing.T) {
+	var failing atomic.Bool
+	var requests atomic.Int32
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if failing.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+		fmt.Fprint(w, "response")
+	})
+	store := cache.New(1 << 20)
+	cfg := &config.TargetCacheConfig{Paths: []string{"/crm/*"}, TTL: time.Minute, StaleWhileRevalidate: time.Minute, NegativeTTL: time.Minute}
+	h := Cache("crm", cfg, store, 1<<20, "")(upstream)
+
+	getCached(h, "/crm/a", nil)
+	expireCached(t, store, cfg, "/crm/a")
+	failing.Store(true)
+	getCached(h, "/crm/a", nil)
+	for requests.Load() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if rec := getCached(h, "/crm/a", nil); rec.Code != http.StatusOK || rec.Header().Get(CacheStatusHeader) != "STALE" {
+		t.Errorf("expected the stale response to survive a failed refresh, got %d %s", rec.Code, rec.Header().Get(CacheStatusHeader))
+	}
+}
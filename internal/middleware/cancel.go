@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gateway/template/internal/metrics"
+)
+
+// StatusClientClosedRequest is the nginx status for requests whose client
+// went away before the response. It is only seen in logs and metrics, the
+// client is no longer there to receive it.
+const StatusClientClosedRequest = 499
+
+// OutcomeClientCanceled is the access log outcome of requests whose client
+// went away before the response was complete
+const OutcomeClientCanceled = "client_canceled"
+
+var clientCanceledRequests = metrics.Default.Counter(
+	"gateway_client_canceled_total",
+	"Number of requests abandoned by their client before the response was complete.",
+	"service",
+)
+
+// ClientCanceled reports whether the client of r went away, as opposed to
+// the request running out of time
+func ClientCanceled(r *http.Request) bool {
+	return errors.Is(r.Context().Err(), context.Canceled)
+}
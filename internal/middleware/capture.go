@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+)
+
+// captureWriter writes a response to the client and keeps a copy of its
+// status, headers and body, up to limit bytes of body
+type captureWriter struct {
+	http.ResponseWriter
+	limit    int
+	before   http.Header // headers set by outer middleware, e.g. the request ID
+	status   int
+	header   http.Header
+	body     []byte
+	overflow bool // body larger than limit, not kept
+}
+
+// newCaptureWriter creates a capture of the response written to w
+func newCaptureWriter(w http.ResponseWriter, limit int) *captureWriter {
+	return &captureWriter{ResponseWriter: w, limit: limit, before: w.Header().Clone()}
+}
+
+// WriteHeader records the status and the headers of the response, leaving
+// out those outer middleware set for this request only
+func (c *captureWriter) WriteHeader(code int) {
+	if c.status == 0 {
+		c.status = code
+		c.header = make(http.Header)
+		for name, values := range c.ResponseWriter.Header() {
+			if !slices.Equal(values, c.before[name]) {
+				c.header[name] = slices.Clone(values)
+			}
+		}
+	}
+	c.ResponseWriter.WriteHeader(code)
+}
+
+// Write records the body until it grows larger than the limit
+func (c *captureWriter) Write(b []byte) (int, error) {
+	if c.status == 0 {
+		c.WriteHeader(http.StatusOK)
+	}
+	if !c.overflow {
+		if len(c.body)+len(b) > c.limit {
+			c.overflow = true
+			c.body = nil
+		} else {
+			c.body = append(c.body, b...)
+		}
+	}
+	return c.ResponseWriter.Write(b)
+}
+
+// Unwrap returns the client's ResponseWriter, e.g. for flushing
+func (c *captureWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+// writeCaptured writes a captured response to another client
+func writeCaptured(w http.ResponseWriter, status int, header http.Header, body []byte) {
+	for name, values := range header {
+		w.Header()[name] = slices.Clone(values)
+	}
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
@@ -3,11 +3,14 @@ package middleware
 import (
 	"context"
 	"errors"
+	"io"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/gateway/template/internal/config"
+	"github.com/gateway/template/internal/problem"
+	"github.com/gateway/template/internal/requestid"
 	"github.com/gateway/template/pkg/auth"
 	"github.com/gateway/template/pkg/logger"
 )
@@ -20,42 +23,78 @@ const (
 	UserIDContextKey ContextKey = "user_id"
 	// ClaimsContextKey is the context key for JWT claims
 	ClaimsContextKey ContextKey = "claims"
+
+	// requestInfoContextKey is the context key for the access log request info
+	requestInfoContextKey ContextKey = "request_info"
 )
 
-// Logging returns a chi middleware for logging requests
-func Logging(log logger.Logger) func(next http.Handler) http.Handler {
+// RequestID returns a chi middleware that assigns each request an ID.
+// A client-provided X-Request-ID is kept if it looks sane, otherwise a new
+// one is generated. The ID is stored in the context, echoed in the response
+// and forwarded to backends.
+func RequestID() func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(requestid.Header)
+			if !isValidRequestID(id) {
+				id = requestid.Generate()
+			}
+
+			r.Header.Set(requestid.Header, id)
+			w.Header().Set(requestid.Header, id)
+
+			next.ServeHTTP(w, r.WithContext(requestid.NewContext(r.Context(), id)))
+		})
+	}
+}
+
+// Logging returns a chi middleware for logging requests.
+// The access log format is selected by cfg: structured JSON fields through
+// the logger, the Apache combined format, or a custom text/template. The
+// combined and template formats are written to out. Successful requests can
+// be sampled per path prefix, errors are always logged.
+func Logging(log logger.Logger, cfg *config.AccessLogConfig, out io.Writer) (func(next http.Handler) http.Handler, error) {
+	accessLog, err := newAccessLogWriter(cfg, log, out)
+	if err != nil {
+		return nil, err
+	}
+	sampler := newAccessLogSampler(cfg)
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			start := time.Now()
 
+			// capture the path before service prefixes are stripped
+			path := r.URL.Path
+
 			// create response writer wrapper to capture status code
 			ww := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
 
+			// count request body bytes
+			body := &countingReader{ReadCloser: r.Body}
+			if r.Body != nil {
+				r.Body = body
+			}
+
+			// let inner middleware report the service and user that handled the request
+			info := &requestInfo{}
+			r = r.WithContext(context.WithValue(r.Context(), requestInfoContextKey, info))
+
 			// process request
 			next.ServeHTTP(ww, r)
 
-			// log after request
-			latency := time.Since(start)
-
-			// extract user ID from context if available
-			userID := ""
-			if uid := r.Context().Value(UserIDContextKey); uid != nil {
-				if uidStr, ok := uid.(string); ok {
-					userID = uidStr
-				}
+			// log after request, subject to sampling
+			if !sampler.shouldLog(path, ww.statusCode) {
+				return
 			}
-
-			log.Info("http request processed",
-				"client_ip", getClientIP(r),
-				"method", r.Method,
-				"path", r.URL.Path,
-				"status", ww.statusCode,
-				"latency_ms", latency.Milliseconds(),
-				"user_agent", r.UserAgent(),
-				"user_id", userID,
-			)
+			entry := newAccessEntry(r, path, start, ww, body, info)
+			entry.RequestID = requestid.FromContext(r.Context())
+			if ClientCanceled(r) {
+				entry.Outcome = OutcomeClientCanceled
+			}
+			accessLog.write(entry)
 		})
-	}
+	}, nil
 }
 
 // CORS returns a chi middleware for CORS
@@ -91,6 +130,51 @@ func CORS(cfg *config.CORSConfig) func(next http.Handler) http.Handler {
 	}
 }
 
+// AuthConfig converts the gateway's JWT settings to an auth.Config
+func AuthConfig(cfg *config.JWTConfig) *auth.Config {
+	authCfg := &auth.Config{
+		Secret:        cfg.Secret,
+		Issuer:        cfg.Issuer,
+		Audience:      cfg.Audience,
+		Expiration:    cfg.Expiration,
+		Leeway:        cfg.Leeway,
+		RefreshWindow: cfg.RefreshWindow,
+		KeyID:         cfg.KeyID,
+		Keys:          cfg.Keys,
+	}
+	for _, trusted := range cfg.TrustedIssuers {
+		authCfg.TrustedIssuers = append(authCfg.TrustedIssuers, auth.TrustedIssuer{
+			Issuer:    trusted.Issuer,
+			Secret:    trusted.Secret,
+			Keys:      trusted.Keys,
+			Audiences: trusted.Audiences,
+		})
+	}
+	return authCfg
+}
+
+// NewTokenValidator creates the validator for the configured token format
+func NewTokenValidator(cfg *config.JWTConfig) (auth.TokenValidator, error) {
+	if cfg.TokenFormat != "paseto" {
+		return auth.NewManager(AuthConfig(cfg))
+	}
+
+	pasetoCfg := &auth.PasetoConfig{
+		Issuer:      cfg.Issuer,
+		Audience:    cfg.Audience,
+		Leeway:      cfg.Leeway,
+		MaxLifetime: cfg.Expiration,
+	}
+	for _, key := range cfg.PasetoPublicKeys {
+		publicKey, err := auth.ParsePasetoPublicKey(key)
+		if err != nil {
+			return nil, err
+		}
+		pasetoCfg.PublicKeys = append(pasetoCfg.PublicKeys, publicKey)
+	}
+	return auth.NewPasetoValidator(pasetoCfg)
+}
+
 // Auth returns a chi middleware for JWT authentication
 //
 // ⚠️ WARNING: This is a LOCAL IMPLEMENTATION for development/testing only!
@@ -98,28 +182,67 @@ func CORS(cfg *config.CORSConfig) func(next http.Handler) http.Handler {
 // Before deploying to production, you MUST replace this with your corporate
 // authentication middleware from your common package.
 func Auth(cfg *config.JWTConfig, log logger.Logger) func(next http.Handler) http.Handler {
-	// create JWT manager
-	authManager, err := auth.NewManager(&auth.Config{
-		Secret:     cfg.Secret,
-		Issuer:     cfg.Issuer,
-		Audience:   cfg.Audience,
-		Expiration: cfg.Expiration,
-	})
+	// create JWT or PASETO validator
+	authManager, err := NewTokenValidator(cfg)
 	if err != nil {
 		log.Error("failed to create auth manager", "error", err)
 		return func(next http.Handler) http.Handler {
 			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-				respondJSON(w, http.StatusInternalServerError, map[string]string{
-					"error": "internal server error",
-				})
+				problem.Write(w, r, http.StatusInternalServerError, "internal server error")
 			})
 		}
 	}
 
+	// decrypt session cookies when they may replace the Authorization header
+	var sessions *auth.SessionCipher
+	if cfg.SessionCookie.Enabled {
+		if sessions, err = auth.NewSessionCipher(cfg.SessionCookie.Secret); err != nil {
+			log.Error("failed to create session cipher", "error", err)
+			return func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					problem.Write(w, r, http.StatusInternalServerError, "internal server error")
+				})
+			}
+		}
+	}
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			authHeader := r.Header.Get("Authorization")
 
+			if sessions != nil {
+				if cookie, err := r.Cookie(cfg.SessionCookie.Name); err == nil {
+					// the cookies are for the gateway, backends get the token as usual
+					removeCookie(r, cfg.SessionCookie.Name)
+					removeCookie(r, cfg.SessionCookie.CSRFCookieName)
+
+					if authHeader == "" {
+						token, err := sessions.Open(cookie.Value)
+						if err != nil {
+							log.Warn("authentication failed",
+								"path", r.URL.Path,
+								"method", r.Method,
+								"error", err.Error(),
+							)
+							problem.Write(w, r, http.StatusUnauthorized, "invalid session")
+							return
+						}
+						if !isSafeMethod(r.Method) && !matchPath(cfg.SessionCookie.CSRFExemptPaths, r.URL.EscapedPath()) &&
+							!sessions.VerifyCSRFToken(cookie.Value, r.Header.Get(CSRFHeader)) {
+							log.Warn("csrf check failed",
+								"path", r.URL.Path,
+								"method", r.Method,
+							)
+							problem.Write(w, r, http.StatusForbidden, "missing or invalid "+CSRFHeader+" header")
+							return
+						}
+
+						authHeader = "Bearer " + token
+						r.Header.Set("Authorization", authHeader)
+					}
+				}
+			}
+
 			// validate request and extract claims
 			claims, err := authManager.ValidateRequest(authHeader)
 			if err != nil {
@@ -138,12 +261,15 @@ func Auth(cfg *config.JWTConfig, log logger.Logger) func(next http.Handler) http
 					"error", err.Error(),
 				)
 
-				respondJSON(w, statusCode, map[string]string{
-					"error": message,
-				})
+				problem.Write(w, r, statusCode, message)
 				return
 			}
 
+			// report the user to the access log
+			if info, ok := r.Context().Value(requestInfoContextKey).(*requestInfo); ok {
+				info.userID = claims.UserID
+			}
+
 			// set claims and user ID in context
 			ctx := context.WithValue(r.Context(), ClaimsContextKey, claims)
 			ctx = context.WithValue(ctx, UserIDContextKey, claims.UserID)
@@ -154,7 +280,7 @@ func Auth(cfg *config.JWTConfig, log logger.Logger) func(next http.Handler) http
 				"user_id", claims.UserID,
 			)
 
-			next.ServeHTTP(w, r.WithContext(ctx))
+			next.ServeHTTP(w, withLogFields(r.WithContext(ctx), "user_id", claims.UserID))
 		})
 	}
 }
@@ -180,9 +306,18 @@ func GetClaimsFromContext(ctx context.Context) (*auth.Claims, bool) {
 }
 
 // responseWriter is a wrapper for http.ResponseWriter to capture status code
+// and the number of body bytes written
 type responseWriter struct {
 	http.ResponseWriter
 	statusCode int
+	bytes      int64
+}
+
+// Write counts the bytes written to the client
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	n, err := rw.ResponseWriter.Write(b)
+	rw.bytes += int64(n)
+	return n, err
 }
 
 // WriteHeader captures the status code
@@ -191,31 +326,41 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
-// getClientIP extracts the real client IP from the request
-func getClientIP(r *http.Request) string {
-	// check X-Forwarded-For header first
-	forwarded := r.Header.Get("X-Forwarded-For")
-	if forwarded != "" {
-		// X-Forwarded-For can contain multiple IPs, take the first one
-		ips := strings.Split(forwarded, ",")
-		if len(ips) > 0 {
-			return strings.TrimSpace(ips[0])
-		}
-	}
+// Unwrap returns the wrapped writer, so http.ResponseController can flush
+// and hijack connections, e.g. for WebSockets
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
 
-	// check X-Real-IP header
-	realIP := r.Header.Get("X-Real-IP")
-	if realIP != "" {
-		return realIP
-	}
+// RequireRole returns a chi middleware that only admits requests whose JWT
+// claims contain the given role. It must run after Auth.
+func RequireRole(role string, log logger.Logger) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, _ := GetClaimsFromContext(r.Context())
+			if err := auth.RequireRole(claims, role); err != nil {
+				var authErr *auth.AuthError
+				statusCode := http.StatusForbidden
+				message := "forbidden"
+
+				if errors.As(err, &authErr) {
+					statusCode = authErr.Code
+					message = authErr.Message
+				}
 
-	// fallback to RemoteAddr
-	ip := r.RemoteAddr
-	// remove port if present
-	if idx := strings.LastIndex(ip, ":"); idx != -1 {
-		ip = ip[:idx]
+				log.Warn("authorization failed",
+					"path", r.URL.Path,
+					"method", r.Method,
+					"role", role,
+				)
+
+				problem.Write(w, r, statusCode, message)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
 	}
-	return ip
 }
 
 // isOriginAllowed checks if the origin is in the allowed origins list
@@ -228,13 +373,15 @@ func isOriginAllowed(origin string, allowedOrigins []string) bool {
 	return false
 }
 
-// respondJSON sends a JSON response
-func respondJSON(w http.ResponseWriter, statusCode int, data interface{}) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(statusCode)
-
-	// simple JSON encoding for error responses
-	if m, ok := data.(map[string]string); ok {
-		w.Write([]byte(`{"error":"` + m["error"] + `"}`))
+// isValidRequestID checks that a client-provided request ID is safe to propagate
+func isValidRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
 	}
+	return true
 }
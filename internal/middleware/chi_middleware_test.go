@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gateway/template/internal/config"
+	"github.com/gateway/template/pkg/auth"
+	"github.com/gateway/template/pkg/logger"
+)
+
+func TestAuthSessionCookieCSRF(t *testing.T) {
+	cfg := &config.JWTConfig{
+		Secret:     "test-secret",
+		Expiration: time.Hour,
+		SessionCookie: config.SessionCookieConfig{
+			Enabled:         true,
+			Secret:          "0123456789abcdef0123456789abcdef",
+			Name:            "gateway_session",
+			CSRFCookieName:  "gateway_csrf",
+			CSRFExemptPaths: []string{"/hooks/*"},
+		},
+	}
+	manager, err := auth.NewManager(AuthConfig(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := manager.GenerateToken("user", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions, err := auth.NewSessionCipher(cfg.SessionCookie.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := sessions.Seal(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csrf := sessions.CSRFToken(session)
+
+	h := Auth(cfg, logger.NewMockLogger())(okHandler)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		cookie bool
+		header string // X-CSRF-Token
+		bearer bool
+		status int
+	}{
+		{"unsafe method without token", http.MethodPost, "/crm/contacts", true, "", false, http.StatusForbidden},
+		{"unsafe method with wrong token", http.MethodDelete, "/crm/contacts/1", true, "wrong", false, http.StatusForbidden},
+		{"token of another session", http.MethodPut, "/crm/contacts/1", true, sessions.CSRFToken(session + "x"), false, http.StatusForbidden},
+		{"unsafe method with token", http.MethodPost, "/crm/contacts", true, csrf, false, http.StatusOK},
+		{"safe method", http.MethodGet, "/crm/contacts", true, "", false, http.StatusOK},
+		{"head", http.MethodHead, "/crm/contacts", true, "", false, http.StatusOK},
+		{"options", http.MethodOptions, "/crm/contacts", true, "", false, http.StatusOK},
+		{"exempt path", http.MethodPost, "/hooks/stripe", true, "", false, http.StatusOK},
+		{"exempt prefix only", http.MethodPost, "/hooksx", true, "", false, http.StatusForbidden},
+		{"authorization header", http.MethodPost, "/crm/contacts", false, "", true, http.StatusOK},
+		{"authorization header and cookie", http.MethodPost, "/crm/contacts", true, "", true, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.cookie {
+				req.AddCookie(&http.Cookie{Name: cfg.SessionCookie.Name, Value: session})
+			}
+			if tt.header != "" {
+				req.Header.Set(CSRFHeader, tt.header)
+			}
+			if tt.bearer {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Errorf("expected %d, got %d: %s", tt.status, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
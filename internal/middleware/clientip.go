@@ -0,0 +1,207 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// clientInfoContextKey is the context key for the resolved client address
+const clientInfoContextKey ContextKey = "client_info"
+
+// clientInfo is the client address of a request and whether its connection
+// comes from a trusted proxy
+type clientInfo struct {
+	ip           string
+	trustedProxy bool
+}
+
+// ParseTrustedProxies parses IP addresses and CIDR ranges such as
+// 10.0.0.0/8 of proxies whose forwarded headers are trusted
+func ParseTrustedProxies(entries []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, entry := range entries {
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy range %q: %w", entry, err)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy address %q: %w", entry, err)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// ClientIP returns a chi middleware that resolves the client IP of each
+// request. Forwarded headers are only honored on connections from trusted
+// proxies: the client is then the nearest address in X-Forwarded-For that is
+// not a trusted proxy, or in the RFC 7239 Forwarded header or X-Real-IP
+// without one. Otherwise the headers could be set by the client itself, and
+// the connection's address is used.
+func ClientIP(trusted []netip.Prefix) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			info := resolveClientIP(r, trusted)
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientInfoContextKey, info)))
+		})
+	}
+}
+
+// GetClientIP returns the client IP resolved by ClientIP, or the address of
+// the connection for requests it did not handle
+func GetClientIP(r *http.Request) string {
+	if info, ok := r.Context().Value(clientInfoContextKey).(clientInfo); ok {
+		return info.ip
+	}
+	return remoteIP(r)
+}
+
+// FromTrustedProxy reports whether the connection of a request comes from a
+// trusted proxy, whose forwarded headers may be passed on
+func FromTrustedProxy(r *http.Request) bool {
+	info, ok := r.Context().Value(clientInfoContextKey).(clientInfo)
+	return ok && info.trustedProxy
+}
+
+// resolveClientIP determines the client address of a request
+func resolveClientIP(r *http.Request, trusted []netip.Prefix) clientInfo {
+	peer := remoteIP(r)
+	if !isTrustedProxy(trusted, peer) {
+		return clientInfo{ip: peer}
+	}
+
+	// walk the chain from the nearest hop, each trusted proxy vouches for
+	// the address before it
+	var hops []string
+	for _, value := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(value, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	if len(hops) == 0 {
+		hops = forwardedFor(r.Header.Values("Forwarded"))
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		if _, err := netip.ParseAddr(hops[i]); err != nil {
+			// a malformed hop ends what can be trusted
+			break
+		}
+		if !isTrustedProxy(trusted, hops[i]) || i == 0 {
+			return clientInfo{ip: hops[i], trustedProxy: true}
+		}
+	}
+	if len(hops) == 0 {
+		realIP := strings.TrimSpace(r.Header.Get("X-Real-IP"))
+		if _, err := netip.ParseAddr(realIP); err == nil {
+			return clientInfo{ip: realIP, trustedProxy: true}
+		}
+	}
+	return clientInfo{ip: peer, trustedProxy: true}
+}
+
+// isTrustedProxy reports whether ip is in one of the trusted ranges
+func isTrustedProxy(trusted []netip.Prefix, ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteIP returns the IP of the connection of a request
+func remoteIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+// forwardedFor returns the for= addresses of the elements of RFC 7239
+// Forwarded headers, e.g. for=192.0.2.60;proto=https, for="[2001:db8::1]:4711".
+// Elements without an address, or with obfuscated ones like for=_hidden,
+// yield hops that are no IP, which ends the chain of trusted hops.
+func forwardedFor(values []string) []string {
+	var hops []string
+	for _, element := range parseForwarded(values) {
+		node := element["for"]
+		if strings.HasPrefix(node, "[") {
+			if end := strings.IndexByte(node, ']'); end > 0 {
+				node = node[1:end]
+			}
+		} else if host, _, err := net.SplitHostPort(node); err == nil {
+			node = host
+		}
+		hops = append(hops, node)
+	}
+	return hops
+}
+
+// parseForwarded splits Forwarded header values into their elements, the
+// parameters of each by lower-cased name with quoted strings unquoted
+func parseForwarded(values []string) []map[string]string {
+	var elements []map[string]string
+	for _, value := range values {
+		element := map[string]string{}
+		for i := 0; i <= len(value); {
+			// read a name=value pair up to the next ; or , outside quotes
+			start := i
+			for i < len(value) && value[i] != '=' && value[i] != ';' && value[i] != ',' {
+				i++
+			}
+			name := strings.ToLower(strings.TrimSpace(value[start:i]))
+			var param string
+			if i < len(value) && value[i] == '=' {
+				i++
+				for i < len(value) && value[i] == ' ' {
+					i++
+				}
+				if i < len(value) && value[i] == '"' {
+					var b strings.Builder
+					for i++; i < len(value) && value[i] != '"'; i++ {
+						if value[i] == '\\' && i+1 < len(value) {
+							i++
+						}
+						b.WriteByte(value[i])
+					}
+					param = b.String()
+					for i < len(value) && value[i] != ';' && value[i] != ',' {
+						i++
+					}
+				} else {
+					start = i
+					for i < len(value) && value[i] != ';' && value[i] != ',' {
+						i++
+					}
+					param = strings.TrimSpace(value[start:i])
+				}
+			}
+			if name != "" {
+				element[name] = param
+			}
+			if i >= len(value) || value[i] == ',' {
+				if len(element) > 0 {
+					elements = append(elements, element)
+				}
+				element = map[string]string{}
+			}
+			i++
+		}
+	}
+	return elements
+}
@@ -0,0 +1,289 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted, err := ParseTrustedProxies([]string{"10.0.0.0/8", "192.168.1.1", "2001:db8:ffff::/48"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		peer         string
+		headers      map[string][]string
+		ip           string
+		trustedProxy bool
+	}{
+		{
+			name: "no headers",
+			peer: "203.0.113.9:5000",
+			ip:   "203.0.113.9",
+		},
+		{
+			name:    "spoofed X-Forwarded-For from untrusted peer",
+			peer:    "203.0.113.9:5000",
+			headers: map[string][]string{"X-Forwarded-For": {"198.51.100.1"}},
+			ip:      "203.0.113.9",
+		},
+		{
+			name:    "spoofed Forwarded from untrusted peer",
+			peer:    "203.0.113.9:5000",
+			headers: map[string][]string{"Forwarded": {"for=198.51.100.1"}},
+			ip:      "203.0.113.9",
+		},
+		{
+			name:    "spoofed X-Real-IP from untrusted peer",
+			peer:    "203.0.113.9:5000",
+			headers: map[string][]string{"X-Real-IP": {"198.51.100.1"}},
+			ip:      "203.0.113.9",
+		},
+		{
+			name:         "trusted peer without headers",
+			peer:         "10.0.0.1:5000",
+			ip:           "10.0.0.1",
+			trustedProxy: true,
+		},
+		{
+			name:         "X-Forwarded-For from trusted peer",
+			peer:         "10.0.0.1:5000",
+			headers:      map[string][]string{"X-Forwarded-For": {"198.51.100.1"}},
+			ip:           "198.51.100.1",
+			trustedProxy: true,
+		},
+		{
+			name:         "address spoofed by the client before the trusted proxies",
+			peer:         "10.0.0.1:5000",
+			headers:      map[string][]string{"X-Forwarded-For": {"1.2.3.4, 198.51.100.1"}},
+			ip:           "198.51.100.1",
+			trustedProxy: true,
+		},
+		{
+			name:         "several trusted hops",
+			peer:         "192.168.1.1:5000",
+			headers:      map[string][]string{"X-Forwarded-For": {"1.2.3.4, 198.51.100.1, 10.20.0.5, 10.0.0.7"}},
+			ip:           "198.51.100.1",
+			trustedProxy: true,
+		},
+		{
+			name:         "hops in several headers",
+			peer:         "10.0.0.1:5000",
+			headers:      map[string][]string{"X-Forwarded-For": {"198.51.100.1", "10.0.0.5"}},
+			ip:           "198.51.100.1",
+			trustedProxy: true,
+		},
+		{
+			name:         "all hops trusted",
+			peer:         "10.0.0.1:5000",
+			headers:      map[string][]string{"X-Forwarded-For": {"10.0.0.3, 10.0.0.4"}},
+			ip:           "10.0.0.3",
+			trustedProxy: true,
+		},
+		{
+			name:         "untrusted hop between trusted ones",
+			peer:         "10.0.0.1:5000",
+			headers:      map[string][]string{"X-Forwarded-For": {"198.51.100.1, 203.0.113.7, 10.0.0.5"}},
+			ip:           "203.0.113.7",
+			trustedProxy: true,
+		},
+		{
+			name:         "malformed X-Forwarded-For hop",
+			peer:         "10.0.0.1:5000",
+			headers:      map[string][]string{"X-Forwarded-For": {"198.51.100.1, garbage, 10.0.0.5"}},
+			ip:           "10.0.0.1",
+			trustedProxy: true,
+		},
+		{
+			name:         "X-Forwarded-For hop with port",
+			peer:         "10.0.0.1:5000",
+			headers:      map[string][]string{"X-Forwarded-For": {"198.51.100.1:4711"}},
+			ip:           "10.0.0.1",
+			trustedProxy: true,
+		},
+		{
+			name:         "IPv4-mapped trusted peer",
+			peer:         "[::ffff:10.0.0.1]:5000",
+			headers:      map[string][]string{"X-Forwarded-For": {"198.51.100.1"}},
+			ip:           "198.51.100.1",
+			trustedProxy: true,
+		},
+		{
+			name:         "IPv6 hops",
+			peer:         "[2001:db8:ffff::1]:5000",
+			headers:      map[string][]string{"X-Forwarded-For": {"2001:db8::1, 2001:db8:ffff::2"}},
+			ip:           "2001:db8::1",
+			trustedProxy: true,
+		},
+		{
+			name:         "Forwarded from trusted peer",
+			peer:         "10.0.0.1:5000",
+			headers:      map[string][]string{"Forwarded": {"for=198.51.100.1;proto=https;by=10.0.0.1"}},
+			ip:           "198.51.100.1",
+			trustedProxy: true,
+		},
+		{
+			name:         "Forwarded with several trusted hops",
+			peer:         "10.0.0.1:5000",
+			headers:      map[string][]string{"Forwarded": {"for=1.2.3.4, for=198.51.100.1", "for=10.0.0.5;proto=http"}},
+			ip:           "198.51.100.1",
+			trustedProxy: true,
+		},
+		{
+			name:         "Forwarded with quoted IPv6 and port",
+			peer:         "10.0.0.1:5000",
+			headers:      map[string][]string{"Forwarded": {`for="[2001:db8::1]:4711"`}},
+			ip:           "2001:db8::1",
+			trustedProxy: true,
+		},
+		{
+			name:         "Forwarded with quoted IPv4 and port",
+			peer:         "10.0.0.1:5000",
+			headers:      map[string][]string{"Forwarded": {`For="198.51.100.1:8080"`}},
+			ip:           "198.51.100.1",
+			trustedProxy: true,
+		},
+		{
+			name:         "Forwarded with obfuscated hop",
+			peer:         "10.0.0.1:5000",
+			headers:      map[string][]string{"Forwarded": {"for=198.51.100.1, for=_hidden, for=10.0.0.5"}},
+			ip:           "10.0.0.1",
+			trustedProxy: true,
+		},
+		{
+			name:         "Forwarded element without for",
+			peer:         "10.0.0.1:5000",
+			headers:      map[string][]string{"Forwarded": {"for=198.51.100.1, proto=https"}},
+			ip:           "10.0.0.1",
+			trustedProxy: true,
+		},
+		{
+			name:         "Forwarded with empty for",
+			peer:         "10.0.0.1:5000",
+			headers:      map[string][]string{"Forwarded": {"for="}},
+			ip:           "10.0.0.1",
+			trustedProxy: true,
+		},
+		{
+			name:         "Forwarded of separators only",
+			peer:         "10.0.0.1:5000",
+			headers:      map[string][]string{"Forwarded": {";;,, ,"}, "X-Real-IP": {"198.51.100.1"}},
+			ip:           "198.51.100.1",
+			trustedProxy: true,
+		},
+		{
+			name: "X-Forwarded-For takes precedence over Forwarded",
+			peer: "10.0.0.1:5000",
+			headers: map[string][]string{
+				"X-Forwarded-For": {"198.51.100.1"},
+				"Forwarded":       {"for=198.51.100.2"},
+			},
+			ip:           "198.51.100.1",
+			trustedProxy: true,
+		},
+		{
+			name:         "X-Real-IP from trusted peer",
+			peer:         "10.0.0.1:5000",
+			headers:      map[string][]string{"X-Real-IP": {" 198.51.100.1 "}},
+			ip:           "198.51.100.1",
+			trustedProxy: true,
+		},
+		{
+			name:         "malformed X-Real-IP",
+			peer:         "10.0.0.1:5000",
+			headers:      map[string][]string{"X-Real-IP": {"198.51.100.1, 10.0.0.5"}},
+			ip:           "10.0.0.1",
+			trustedProxy: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ip string
+			var fromTrustedProxy bool
+			h := ClientIP(trusted)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ip, fromTrustedProxy = GetClientIP(r), FromTrustedProxy(r)
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.peer
+			for name, values := range tt.headers {
+				for _, value := range values {
+					req.Header.Add(name, value)
+				}
+			}
+			h.ServeHTTP(httptest.NewRecorder(), req)
+
+			if ip != tt.ip {
+				t.Errorf("expected client IP %s, got %s", tt.ip, ip)
+			}
+			if fromTrustedProxy != tt.trustedProxy {
+				t.Errorf("expected trusted proxy %v, got %v", tt.trustedProxy, fromTrustedProxy)
+			}
+		})
+	}
+}
+
+func TestParseForwarded(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []string
+		want   []map[string]string
+	}{
+		{
+			name:   "elements and parameters",
+			values: []string{"for=192.0.2.60;proto=http;by=203.0.113.43, for=198.51.100.17"},
+			want: []map[string]string{
+				{"for": "192.0.2.60", "proto": "http", "by": "203.0.113.43"},
+				{"for": "198.51.100.17"},
+			},
+		},
+		{
+			name:   "names are case-insensitive and spaces are trimmed",
+			values: []string{" For = 192.0.2.60 ; PROTO=https "},
+			want:   []map[string]string{{"for": "192.0.2.60", "proto": "https"}},
+		},
+		{
+			name:   "quoted strings with separators and escapes",
+			values: []string{`for="[2001:db8::1]:4711";host="a,b;c";ext="say \"hi\""`},
+			want:   []map[string]string{{"for": "[2001:db8::1]:4711", "host": "a,b;c", "ext": `say "hi"`}},
+		},
+		{
+			name:   "several headers",
+			values: []string{"for=192.0.2.60", "for=198.51.100.17"},
+			want:   []map[string]string{{"for": "192.0.2.60"}, {"for": "198.51.100.17"}},
+		},
+		{
+			name:   "empty elements are skipped",
+			values: []string{",, for=192.0.2.60 ,;,"},
+			want:   []map[string]string{{"for": "192.0.2.60"}},
+		},
+		{
+			name:   "parameter without value",
+			values: []string{"for;proto=https"},
+			want:   []map[string]string{{"for": "", "proto": "https"}},
+		},
+		{
+			name:   "unterminated quoted string",
+			values: []string{`for="192.0.2.60;proto=https`},
+			want:   []map[string]string{{"for": "192.0.2.60;proto=https"}},
+		},
+		{
+			name:   "empty",
+			values: []string{""},
+			want:   nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseForwarded(tt.values); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gateway/template/internal/metrics"
+)
+
+// maxCoalescedBody is the largest response body shared with coalesced
+// requests. Requests waiting for a larger response are forwarded on their
+// own instead.
+const maxCoalescedBody = 1 << 20
+
+var coalescedRequests = metrics.Default.Counter(
+	"gateway_coalesced_requests_total",
+	"Number of requests answered with the response of an identical concurrent request.",
+	"service",
+)
+
+// coalesceHeaders are the request headers that must be equal for requests to
+// share a response, besides method, path, query and user
+var coalesceHeaders = []string{"Authorization", "Cookie", "Accept", "Accept-Encoding", "Accept-Language"}
+
+// coalescedCall is a request in flight whose response identical requests
+// arriving meanwhile wait for
+type coalescedCall struct {
+	done   chan struct{}
+	shared bool // whether the response below can be sent to the waiting requests
+	status int
+	header http.Header
+	body   []byte
+}
+
+// Coalesce returns a chi middleware that collapses identical concurrent GET
+// requests on the paths matching patterns (exact or prefixes ending in /*)
+// into one: the first is forwarded, the others wait and receive a copy of
+// its response, so a burst of requests for the same resource reaches the
+// backend once. Requests are identical if their path, query, user and
+// credential and content negotiation headers are. It must run after
+// authentication, so responses are only shared with the same user.
+func Coalesce(serviceName string, patterns []string) func(next http.Handler) http.Handler {
+	var mu sync.Mutex
+	calls := make(map[string]*coalescedCall)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet || !matchPath(patterns, r.URL.EscapedPath()) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			key := coalesceKey(r)
+			mu.Lock()
+			if call, ok := calls[key]; ok {
+				mu.Unlock()
+				select {
+				case <-call.done:
+				case <-r.Context().Done():
+					// client went away while waiting, nothing to write
+					return
+				}
+				if !call.shared {
+					next.ServeHTTP(w, r)
+					return
+				}
+
+				coalescedRequests.Inc(serviceName)
+				writeCaptured(w, call.status, call.header, call.body)
+				return
+			}
+			call := &coalescedCall{done: make(chan struct{})}
+			calls[key] = call
+			mu.Unlock()
+
+			capture := newCaptureWriter(w, maxCoalescedBody)
+			complete := false
+			defer func() {
+				mu.Lock()
+				delete(calls, key)
+				mu.Unlock()
+
+				// responses cut short by a canceled request or a panic are not shared
+				call.shared = complete && !capture.overflow && capture.status != 0 && r.Context().Err() == nil
+				call.status, call.header, call.body = capture.status, capture.header, capture.body
+				close(call.done)
+			}()
+
+			next.ServeHTTP(capture, r)
+			complete = true
+		})
+	}
+}
+
+// coalesceKey identifies the requests that can share a response
+func coalesceKey(r *http.Request) string {
+	userID, _ := GetUserIDFromContext(r.Context())
+	parts := []string{r.URL.Path, r.URL.RawQuery, userID}
+	for _, name := range coalesceHeaders {
+		parts = append(parts, strings.Join(r.Header.Values(name), "\n"))
+	}
+	return strings.Join(parts, "\x00")
+}
@@ -0,0 +1,315 @@
+package middleware
+
+import (
+	"container/heap"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gateway/template/internal/config"
+	"github.com/gateway/template/internal/metrics"
+	"github.com/gateway/template/internal/problem"
+	"github.com/gateway/template/pkg/logger"
+)
+
+var (
+	inFlightRequests = metrics.Default.Gauge(
+		"gateway_in_flight_requests",
+		"Number of requests currently being processed per concurrency scope.",
+		"scope",
+	)
+	shedRequests = metrics.Default.Counter(
+		"gateway_shed_requests_total",
+		"Number of requests rejected because a concurrency limit was saturated.",
+		"scope",
+	)
+	preemptedRequests = metrics.Default.Counter(
+		"gateway_preempted_requests_total",
+		"Number of waiting requests shed from a full concurrency queue for higher priority ones.",
+		"scope", "class",
+	)
+)
+
+// ConcurrencyLimit returns a chi middleware that caps the number of in-flight
+// requests for the given scope (e.g. "global" or a service name). When the
+// limit is reached, requests wait up to cfg.QueueTimeout for a free slot and
+// are then rejected with 503. Free slots go to the waiting request of the
+// highest priority class, and a queue of cfg.QueueSize requests sheds its
+// lowest priority one for a higher priority newcomer. Classes may select
+// users, so the middleware must run after authentication. A limit <= 0
+// disables limiting. The slots of a scope are kept in state, so requests
+// in flight on a handler replaced by a reload still count.
+func ConcurrencyLimit(scope string, limit int, cfg *config.ConcurrencyConfig, state *State, log logger.Logger) func(next http.Handler) http.Handler {
+	if limit <= 0 {
+		return func(next http.Handler) http.Handler {
+			return next
+		}
+	}
+
+	slots := keep(state, "concurrency:"+scope, nil, func() *slotQueue { return &slotQueue{} })
+	slots.configure(limit, cfg.QueueSize)
+	return limitConcurrency(scope, slots, nil, cfg, log)
+}
+
+// limitConcurrency returns the middleware of ConcurrencyLimit taking slots
+// from slots, reporting the latency of requests to adaptive if set
+func limitConcurrency(scope string, slots *slotQueue, adaptive *adaptiveLimit, cfg *config.ConcurrencyConfig, log logger.Logger) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// WebSockets stay open far longer than requests, they are
+			// limited by their service's max connections instead
+			if IsWebSocket(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			class, priority := requestClass(r, cfg.Classes)
+			if ok, preempted := slots.acquire(r, priority, cfg.QueueTimeout); !ok {
+				if ClientCanceled(r) {
+					// the client went away while queued
+					w.WriteHeader(StatusClientClosedRequest)
+					return
+				}
+				if preempted {
+					preemptedRequests.Inc(scope, class)
+				}
+				shedRequests.Inc(scope)
+				log.Warn("request shed by concurrency limit",
+					"scope", scope,
+					"limit", slots.currentLimit(),
+					"class", class,
+					"path", r.URL.Path,
+					"method", r.Method,
+				)
+
+				w.Header().Set("Retry-After", "1")
+				problem.Write(w, r, http.StatusServiceUnavailable, "gateway is overloaded, retry later")
+				return
+			}
+
+			inFlightRequests.Add(1, scope)
+			defer func() {
+				inFlightRequests.Add(-1, scope)
+				slots.release()
+			}()
+
+			if adaptive == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			start := time.Now()
+			rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+			next.ServeHTTP(rw, r)
+			// requests given up by their client tell nothing about the upstream
+			if r.Context().Err() == nil {
+				adaptive.sample(start, time.Since(start), overloadStatus(rw.statusCode))
+			}
+		})
+	}
+}
+
+// requestClass returns the name and priority of the first class matching
+// the request, "default" and 0 without one
+func requestClass(r *http.Request, classes []config.PriorityClass) (string, int) {
+	for _, class := range classes {
+		if matchClass(r, &class) {
+			return class.Name, class.Priority
+		}
+	}
+	return "default", 0
+}
+
+// matchClass reports whether the request matches all selectors of class
+func matchClass(r *http.Request, class *config.PriorityClass) bool {
+	if len(class.Paths) > 0 && !matchPath(class.Paths, r.URL.EscapedPath()) {
+		return false
+	}
+	if len(class.Roles) == 0 && len(class.Claims) == 0 {
+		return true
+	}
+
+	claims, ok := GetClaimsFromContext(r.Context())
+	if !ok {
+		return false
+	}
+	if len(class.Roles) > 0 && !slices.ContainsFunc(claims.Roles, func(role string) bool {
+		return slices.Contains(class.Roles, role)
+	}) {
+		return false
+	}
+	for key, want := range class.Claims {
+		if value, _ := claims.Metadata[key].(string); value != want {
+			return false
+		}
+	}
+	return true
+}
+
+// slotQueue hands out limit slots, queueing requests by priority while all
+// are taken
+type slotQueue struct {
+	mu       sync.Mutex
+	limit    int
+	maxQueue int // 0 is unbounded
+	inUse    int
+	waiting  waitQueue
+	seq      uint64 // arrival order of waiting requests of the same priority
+}
+
+// waiter is a request waiting for a slot
+type waiter struct {
+	priority int
+	seq      uint64
+	index    int       // in the queue, -1 once removed from it
+	granted  chan bool // receives true when handed a slot, false when shed
+}
+
+// acquire takes a slot, waiting up to timeout for one to become free. It
+// reports whether a slot was taken, and if not, whether the request was shed
+// from the queue for a higher priority one.
+func (q *slotQueue) acquire(r *http.Request, priority int, timeout time.Duration) (ok, preempted bool) {
+	q.mu.Lock()
+	if q.inUse < q.limit {
+		q.inUse++
+		q.mu.Unlock()
+		return true, false
+	}
+	if timeout <= 0 {
+		q.mu.Unlock()
+		return false, false
+	}
+	if q.maxQueue > 0 && len(q.waiting) >= q.maxQueue {
+		lowest := q.waiting.lowest()
+		if lowest.priority >= priority {
+			q.mu.Unlock()
+			return false, false
+		}
+		heap.Remove(&q.waiting, lowest.index)
+		lowest.granted <- false
+	}
+	q.seq++
+	w := &waiter{priority: priority, seq: q.seq, granted: make(chan bool, 1)}
+	heap.Push(&q.waiting, w)
+	q.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case granted := <-w.granted:
+		return granted, !granted
+	case <-timer.C:
+	case <-r.Context().Done():
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if w.index >= 0 {
+		heap.Remove(&q.waiting, w.index)
+		return false, false
+	}
+	// handed a slot or shed while giving up
+	if <-w.granted {
+		q.releaseLocked()
+	}
+	return false, false
+}
+
+// currentLimit returns the number of slots
+func (q *slotQueue) currentLimit() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.limit
+}
+
+// inFlight returns the number of slots taken
+func (q *slotQueue) inFlight() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.inUse
+}
+
+// configure sets the number of slots and the queue size
+func (q *slotQueue) configure(limit, maxQueue int) {
+	q.mu.Lock()
+	q.maxQueue = maxQueue
+	q.mu.Unlock()
+	q.setLimit(limit)
+}
+
+// setLimit changes the number of slots, handing new ones to waiting
+// requests. Slots taken beyond a lowered limit are freed as usual.
+func (q *slotQueue) setLimit(limit int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.limit = limit
+	for q.inUse < q.limit && len(q.waiting) > 0 {
+		q.inUse++
+		heap.Pop(&q.waiting).(*waiter).granted <- true
+	}
+}
+
+// release frees a slot, handing it to the waiting request of the highest
+// priority if there is one
+func (q *slotQueue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.releaseLocked()
+}
+
+// releaseLocked is release with q.mu held
+func (q *slotQueue) releaseLocked() {
+	if len(q.waiting) == 0 || q.inUse > q.limit {
+		q.inUse--
+		return
+	}
+	w := heap.Pop(&q.waiting).(*waiter)
+	w.granted <- true
+}
+
+// waitQueue is a heap of waiters, the highest priority and then the
+// earliest first
+type waitQueue []*waiter
+
+func (h waitQueue) Len() int { return len(h) }
+
+func (h waitQueue) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority > h[j].priority
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h waitQueue) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *waitQueue) Push(x any) {
+	w := x.(*waiter)
+	w.index = len(*h)
+	*h = append(*h, w)
+}
+
+func (h *waitQueue) Pop() any {
+	old := *h
+	w := old[len(old)-1]
+	old[len(old)-1] = nil
+	w.index = -1
+	*h = old[:len(old)-1]
+	return w
+}
+
+// lowest returns the waiter served last, the lowest priority and then the
+// latest
+func (h waitQueue) lowest() *waiter {
+	lowest := h[0]
+	for _, w := range h[1:] {
+		if w.priority < lowest.priority || w.priority == lowest.priority && w.seq > lowest.seq {
+			lowest = w
+		}
+	}
+	return lowest
+}
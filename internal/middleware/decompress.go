@@ -0,0 +1,96 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gateway/template/internal/config"
+	"github.com/gateway/template/internal/problem"
+	"github.com/gateway/template/pkg/logger"
+)
+
+// Decompress returns a chi middleware for gzip-encoded request bodies.
+//
+// In "decompress" mode the body is inflated before forwarding and the
+// Content-Encoding header is removed. The decompressed size is capped by
+// MaxDecompressedSize to protect against zip bombs. In "reject" mode gzip
+// bodies are refused with 415. Any other mode passes bodies through untouched.
+func Decompress(cfg *config.RequestConfig, log logger.Logger) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !isGzipEncoded(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			switch cfg.GzipMode {
+			case "reject":
+				log.Warn("rejected gzip-encoded request",
+					"path", r.URL.Path,
+					"method", r.Method,
+				)
+				problem.Write(w, r, http.StatusUnsupportedMediaType, "gzip-encoded request bodies are not accepted")
+				return
+			case "decompress":
+				body, status, err := inflateBody(r.Body, cfg.MaxDecompressedSize)
+				if err != nil {
+					log.Warn("failed to decompress request body",
+						"path", r.URL.Path,
+						"method", r.Method,
+						"error", err.Error(),
+					)
+					message := "invalid gzip request body"
+					if status == http.StatusRequestEntityTooLarge {
+						message = "decompressed request body too large"
+					}
+					problem.Write(w, r, status, message)
+					return
+				}
+
+				r.Body = io.NopCloser(bytes.NewReader(body))
+				r.ContentLength = int64(len(body))
+				r.Header.Del("Content-Encoding")
+				r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// isGzipEncoded reports whether the request body is gzip-encoded
+func isGzipEncoded(r *http.Request) bool {
+	encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+	return encoding == "gzip" || encoding == "x-gzip"
+}
+
+// errBodyTooLarge is returned when the decompressed body exceeds the limit
+var errBodyTooLarge = errors.New("decompressed body exceeds size limit")
+
+// inflateBody reads and decompresses a gzip body, enforcing maxSize.
+// It returns the HTTP status to use when decompression fails.
+func inflateBody(body io.ReadCloser, maxSize int64) ([]byte, int, error) {
+	defer body.Close()
+
+	gz, err := gzip.NewReader(body)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	defer gz.Close()
+
+	// read one byte past the limit to detect oversized bodies
+	data, err := io.ReadAll(io.LimitReader(gz, maxSize+1))
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	if int64(len(data)) > maxSize {
+		return nil, http.StatusRequestEntityTooLarge, errBodyTooLarge
+	}
+
+	return data, http.StatusOK, nil
+}
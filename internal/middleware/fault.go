@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gateway/template/internal/config"
+	"github.com/gateway/template/internal/metrics"
+	"github.com/gateway/template/internal/problem"
+	"github.com/gateway/template/pkg/logger"
+)
+
+// FaultInjectionHeader is the request header that opts a request into fault
+// injection when FaultInjectionConfig.RequireHeader is set
+const FaultInjectionHeader = "X-Gateway-Fault-Injection"
+
+var faultsInjected = metrics.Default.Counter(
+	"gateway_faults_injected_total",
+	"Number of faults injected for chaos testing.",
+	"service", "type",
+)
+
+// FaultInjection returns a chi middleware that injects latency and errors into
+// requests for a service, so client resilience can be tested through the real
+// gateway. It is a no-op unless fault injection is enabled globally and the
+// service has a fault configured. When RequireHeader is set, only requests
+// carrying the X-Gateway-Fault-Injection: on header are affected.
+func FaultInjection(service string, global *config.FaultInjectionConfig, fault config.FaultConfig, log logger.Logger) func(next http.Handler) http.Handler {
+	if !global.Enabled || (fault.Delay <= 0 && fault.ErrorRate <= 0) {
+		return func(next http.Handler) http.Handler {
+			return next
+		}
+	}
+
+	log.Warn("fault injection enabled",
+		"service", service,
+		"delay_ms", fault.Delay.Milliseconds(),
+		"delay_rate", fault.DelayRate,
+		"error_rate", fault.ErrorRate,
+		"error_status", fault.ErrorStatus,
+		"require_header", global.RequireHeader,
+	)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if global.RequireHeader && !strings.EqualFold(r.Header.Get(FaultInjectionHeader), "on") {
+				next.ServeHTTP(w, r)
+				return
+			}
+			// never leak the opt-in header to backends
+			r.Header.Del(FaultInjectionHeader)
+
+			if fault.Delay > 0 && rand.Float64() < fault.DelayRate {
+				faultsInjected.Inc(service, "delay")
+				timer := time.NewTimer(fault.Delay)
+				select {
+				case <-timer.C:
+				case <-r.Context().Done():
+					timer.Stop()
+					return
+				}
+			}
+
+			if fault.ErrorRate > 0 && rand.Float64() < fault.ErrorRate {
+				faultsInjected.Inc(service, "error")
+				log.Debug("injected fault response",
+					"service", service,
+					"path", r.URL.Path,
+					"status", fault.ErrorStatus,
+				)
+				problem.Write(w, r, fault.ErrorStatus, "fault injected by gateway")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
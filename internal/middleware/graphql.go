@@ -0,0 +1,143 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/gateway/template/internal/config"
+	"github.com/gateway/template/internal/graphql"
+	"github.com/gateway/template/internal/metrics"
+	"github.com/gateway/template/internal/problem"
+	"github.com/gateway/template/pkg/logger"
+)
+
+// maxGraphQLBodySize caps the request bodies read to check GraphQL operations
+const maxGraphQLBodySize = 10 << 20
+
+var graphQLRejected = metrics.Default.Counter(
+	"gateway_graphql_rejected_total",
+	"Number of GraphQL requests rejected before reaching the service.",
+	"service", "reason",
+)
+
+// graphQLParams is the body of a GraphQL request sent as JSON, only its
+// query is checked
+type graphQLParams struct {
+	Query string `json:"query"`
+}
+
+// GraphQL returns a chi middleware checking the GraphQL operations of
+// requests to the paths in cfg (exact or prefixes ending in /*) before they
+// are forwarded. Queries are read from the query parameter of GET requests
+// and from POST bodies, either JSON, also batches of operations, or
+// application/graphql. Requests exceeding the max depth or complexity,
+// querying the schema while introspection is disabled or failing to parse
+// are rejected with 400. Requests without a query, e.g. persisted queries
+// sent by hash, are forwarded unchecked.
+func GraphQL(serviceName string, cfg *config.GraphQLConfig, log logger.Logger) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !matchPath(cfg.Paths, r.URL.EscapedPath()) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			fail := func(status int, message, reason string) {
+				graphQLRejected.Inc(serviceName, reason)
+				log.Warn("graphql request rejected",
+					"service", serviceName,
+					"path", r.URL.Path,
+					"method", r.Method,
+					"reason", reason,
+				)
+				problem.Write(w, r, status, message)
+			}
+
+			var queries []string
+			switch r.Method {
+			case http.MethodGet:
+				if query := r.URL.Query().Get("query"); query != "" {
+					queries = append(queries, query)
+				}
+			case http.MethodPost:
+				body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxGraphQLBodySize))
+				if err != nil {
+					var maxErr *http.MaxBytesError
+					if errors.As(err, &maxErr) {
+						fail(http.StatusRequestEntityTooLarge, "graphql request body too large", "body_too_large")
+						return
+					}
+					fail(http.StatusBadRequest, "failed to read request body", "body_read_failed")
+					return
+				}
+				r.Body = io.NopCloser(bytes.NewReader(body))
+
+				queries, err = graphQLQueries(r.Header.Get("Content-Type"), body)
+				if err != nil {
+					fail(http.StatusBadRequest, "invalid graphql request", "invalid_request")
+					return
+				}
+			}
+
+			// a batch is measured as a whole, its operations run together
+			var stats graphql.Stats
+			for _, query := range queries {
+				s, err := graphql.Analyze(query)
+				if err != nil {
+					fail(http.StatusBadRequest, "invalid graphql query: "+err.Error(), "invalid_query")
+					return
+				}
+				stats.Depth = max(stats.Depth, s.Depth)
+				stats.Complexity += s.Complexity
+				stats.Introspection = stats.Introspection || s.Introspection
+			}
+
+			switch {
+			case cfg.DisableIntrospection && stats.Introspection:
+				fail(http.StatusBadRequest, "graphql introspection is disabled", "introspection")
+			case cfg.MaxDepth > 0 && stats.Depth > cfg.MaxDepth:
+				fail(http.StatusBadRequest, fmt.Sprintf("graphql query depth %d exceeds the limit of %d", stats.Depth, cfg.MaxDepth), "depth")
+			case cfg.MaxComplexity > 0 && stats.Complexity > cfg.MaxComplexity:
+				fail(http.StatusBadRequest, fmt.Sprintf("graphql query complexity %d exceeds the limit of %d", stats.Complexity, cfg.MaxComplexity), "complexity")
+			default:
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+// graphQLQueries returns the queries of a POST body, the body itself for
+// application/graphql and the query of each operation of JSON bodies
+func graphQLQueries(contentType string, body []byte) ([]string, error) {
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType == "application/graphql" {
+		return []string{string(body)}, nil
+	}
+
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var batch []graphQLParams
+		if err := json.Unmarshal(body, &batch); err != nil {
+			return nil, err
+		}
+		var queries []string
+		for _, params := range batch {
+			if params.Query != "" {
+				queries = append(queries, params.Query)
+			}
+		}
+		return queries, nil
+	}
+
+	var params graphQLParams
+	if err := json.Unmarshal(body, &params); err != nil {
+		return nil, err
+	}
+	if params.Query == "" {
+		return nil, nil
+	}
+	return []string{params.Query}, nil
+}
@@ -0,0 +1,149 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gateway/template/internal/config"
+	"github.com/gateway/template/internal/problem"
+	"github.com/gateway/template/pkg/auth"
+	"github.com/gateway/template/pkg/logger"
+)
+
+// maxSignedBodySize caps the request bodies read to verify a signature
+const maxSignedBodySize = 10 << 20
+
+// HMACAuth returns a chi middleware authenticating requests signed with a
+// shared secret, for webhooks and machine-to-machine callers that cannot
+// obtain JWTs. The signature covers the timestamp, method, path with query
+// and body (see auth.SignRequest); requests signed outside the replay window
+// or whose signature was already seen within it are rejected. The key ID is
+// used as the user ID. Seen signatures are kept in state, so they cannot be
+// replayed after a reload either.
+func HMACAuth(serviceName string, cfg *config.HMACConfig, state *State, log logger.Logger) func(next http.Handler) http.Handler {
+	window := cfg.Window
+	if window <= 0 {
+		window = 5 * time.Minute
+	}
+	seen := keep(state, "hmac:"+serviceName, nil, newSignatureCache)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fail := func(status int, message, reason string) {
+				log.Warn("request signature rejected",
+					"service", serviceName,
+					"path", r.URL.Path,
+					"method", r.Method,
+					"reason", reason,
+				)
+				problem.Write(w, r, status, message)
+			}
+
+			signature := r.Header.Get(auth.SignatureHeader)
+			timestamp := r.Header.Get(auth.TimestampHeader)
+			if signature == "" || timestamp == "" {
+				fail(http.StatusUnauthorized, "missing request signature", "missing_headers")
+				return
+			}
+
+			unix, err := strconv.ParseInt(timestamp, 10, 64)
+			if err != nil {
+				fail(http.StatusUnauthorized, "invalid request timestamp", "invalid_timestamp")
+				return
+			}
+			if skew := time.Since(time.Unix(unix, 0)); skew > window || skew < -window {
+				fail(http.StatusUnauthorized, "request timestamp outside the allowed window", "stale_timestamp")
+				return
+			}
+
+			keyID := r.Header.Get(auth.KeyIDHeader)
+			if keyID == "" && len(cfg.Keys) == 1 {
+				for id := range cfg.Keys {
+					keyID = id
+				}
+			}
+			secret, ok := cfg.Keys[keyID]
+			if !ok {
+				fail(http.StatusUnauthorized, "unknown signing key", "unknown_key_id")
+				return
+			}
+
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSignedBodySize))
+			if err != nil {
+				var maxErr *http.MaxBytesError
+				if errors.As(err, &maxErr) {
+					fail(http.StatusRequestEntityTooLarge, "signed request body too large", "body_too_large")
+					return
+				}
+				fail(http.StatusBadRequest, "failed to read request body", "body_read_failed")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			if !auth.VerifyRequestSignature([]byte(secret), signature, timestamp, r.Method, r.URL.RequestURI(), body) {
+				fail(http.StatusUnauthorized, "invalid request signature", "bad_signature")
+				return
+			}
+
+			// a signature is only valid once, remember it while its timestamp
+			// is accepted, in the one spelling every accepted variant of it
+			// (sha256= prefix, upper case hex) has
+			canonical := strings.ToLower(strings.TrimPrefix(signature, "sha256="))
+			if !seen.add(keyID+":"+canonical, time.Unix(unix, 0).Add(window)) {
+				fail(http.StatusUnauthorized, "request signature already used", "replayed")
+				return
+			}
+
+			// report the caller to the access log
+			if info, ok := r.Context().Value(requestInfoContextKey).(*requestInfo); ok {
+				info.userID = keyID
+			}
+
+			ctx := context.WithValue(r.Context(), UserIDContextKey, keyID)
+			next.ServeHTTP(w, withLogFields(r.WithContext(ctx), "user_id", keyID))
+		})
+	}
+}
+
+// signatureCache remembers signatures until their timestamp leaves the
+// replay window
+type signatureCache struct {
+	mu      sync.Mutex
+	expires map[string]time.Time
+	sweep   time.Time // next time expired entries are removed
+}
+
+// newSignatureCache creates an empty signature cache
+func newSignatureCache() *signatureCache {
+	return &signatureCache{expires: make(map[string]time.Time)}
+}
+
+// add records a signature until expires, it reports false if the signature
+// is already known
+func (c *signatureCache) add(signature string, expires time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if now.After(c.sweep) {
+		for s, exp := range c.expires {
+			if now.After(exp) {
+				delete(c.expires, s)
+			}
+		}
+		c.sweep = now.Add(time.Minute)
+	}
+
+	if exp, ok := c.expires[signature]; ok && now.Before(exp) {
+		return false
+	}
+	c.expires[signature] = expires
+	return true
+}
@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gateway/template/internal/config"
+	"github.com/gateway/template/pkg/auth"
+	"github.com/gateway/template/pkg/logger"
+)
+
+func TestHMACAuthRejectsInvalidSignatures(t *testing.T) {
+	cfg := &config.HMACConfig{Keys: map[string]string{"partner": "secret", "other": "other-secret"}, Window: time.Minute}
+	handler := HMACAuth("hooks", cfg, nil, logger.NewMockLogger())(okHandler)
+
+	now := time.Now()
+	sign := func(secret string, at time.Time, body string) (string, string) {
+		timestamp := strconv.FormatInt(at.Unix(), 10)
+		return auth.SignRequest([]byte(secret), timestamp, http.MethodPost, "/hook", []byte(body)), timestamp
+	}
+
+	tests := []struct {
+		name   string
+		secret string
+		at     time.Time
+		body   string // sent, the signature is over {}
+		keyID  string
+		want   int
+	}{
+		{"valid", "secret", now, "{}", "partner", http.StatusOK},
+		{"expired", "secret", now.Add(-2 * time.Minute), "{}", "partner", http.StatusUnauthorized},
+		{"from the future", "secret", now.Add(2 * time.Minute), "{}", "partner", http.StatusUnauthorized},
+		{"wrong key", "other-secret", now, "{}", "partner", http.StatusUnauthorized},
+		{"unknown key ID", "secret", now, "{}", "nobody", http.StatusUnauthorized},
+		{"tampered body", "secret", now, `{"amount":1}`, "partner", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signature, timestamp := sign(tt.secret, tt.at, "{}")
+			req := httptest.NewRequest(http.MethodPost, "/hook", strings.NewReader(tt.body))
+			req.Header.Set(auth.SignatureHeader, signature)
+			req.Header.Set(auth.TimestampHeader, timestamp)
+			req.Header.Set(auth.KeyIDHeader, tt.keyID)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("expected status %d, got %d", tt.want, rec.Code)
+			}
+		})
+	}
+}
+
+func TestHMACAuthRejectsReplays(t *testing.T) {
+	cfg := &config.HMACConfig{Keys: map[string]string{"partner": "secret"}, Window: time.Minute}
+	handler := HMACAuth("hooks", cfg, nil, logger.NewMockLogger())(okHandler)
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	signature := auth.SignRequest([]byte("secret"), timestamp, http.MethodPost, "/hook", []byte("{}"))
+	send := func(signature string) int {
+		req := httptest.NewRequest(http.MethodPost, "/hook", strings.NewReader("{}"))
+		req.Header.Set(auth.SignatureHeader, signature)
+		req.Header.Set(auth.TimestampHeader, timestamp)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := send(signature); code != http.StatusOK {
+		t.Fatalf("expected the first request to be accepted, got %d", code)
+	}
+	// every spelling of the same signature is a replay
+	for _, replay := range []string{signature, "sha256=" + signature, strings.ToUpper(signature), "sha256=" + strings.ToUpper(signature)} {
+		if code := send(replay); code != http.StatusUnauthorized {
+			t.Errorf("expected the replay %s to be rejected, got %d", replay, code)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gateway/template/internal/idempotency"
+	"github.com/gateway/template/internal/metrics"
+	"github.com/gateway/template/internal/problem"
+	"github.com/gateway/template/pkg/logger"
+)
+
+const (
+	// IdempotencyKeyHeader identifies retries of the same request
+	IdempotencyKeyHeader = "Idempotency-Key"
+
+	// IdempotentReplayedHeader marks responses replayed from the store
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+
+	// maxIdempotencyKey is the longest Idempotency-Key accepted
+	maxIdempotencyKey = 255
+
+	// maxIdempotentBody is the largest response body stored for replay,
+	// requests with larger responses can be repeated
+	maxIdempotentBody = 1 << 20
+
+	// idempotencyStoreTimeout bounds each store operation
+	idempotencyStoreTimeout = 2 * time.Second
+)
+
+var idempotentReplays = metrics.Default.Counter(
+	"gateway_idempotent_replays_total",
+	"Number of requests answered with the stored response of an earlier request with the same Idempotency-Key.",
+	"service",
+)
+
+// idempotentRecord is the stored state of an Idempotency-Key: in progress,
+// or the response to replay
+type idempotentRecord struct {
+	Fingerprint string      `json:"fingerprint"` // hash of the request the key was first used for
+	Pending     bool        `json:"pending,omitempty"`
+	Status      int         `json:"status,omitempty"`
+	Header      http.Header `json:"header,omitempty"`
+	Body        []byte      `json:"body,omitempty"`
+}
+
+// Idempotency returns a chi middleware that makes POST, PUT and PATCH
+// requests with an Idempotency-Key header on the paths matching patterns
+// (exact or prefixes ending in /*) safe to retry: the first response is
+// stored for ttl and replayed for later requests with the same key, so a
+// retried payment is not charged twice. A request arriving while the first
+// is still in progress is rejected, lock bounds how long that lasts if the
+// gateway dies meanwhile. Request bodies larger than maxBody are rejected.
+// Keys are scoped to the service and user, so it must run after
+// authentication.
+func Idempotency(serviceName string, patterns []string, store idempotency.Store, ttl, lock time.Duration, maxBody int64, log logger.Logger) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if key == "" || !isIdempotencyMethod(r.Method) || !matchPath(patterns, r.URL.EscapedPath()) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > maxIdempotencyKey {
+				problem.Write(w, r, http.StatusBadRequest, "Idempotency-Key header is too long")
+				return
+			}
+
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBody))
+			if err != nil {
+				var maxErr *http.MaxBytesError
+				if errors.As(err, &maxErr) {
+					problem.Write(w, r, http.StatusRequestEntityTooLarge, "request body too large")
+					return
+				}
+				problem.Write(w, r, http.StatusBadRequest, "failed to read request body")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			userID, _ := GetUserIDFromContext(r.Context())
+			storeKey := hashParts(serviceName, userID, key)
+			fingerprint := hashParts(r.Method, r.URL.Path, r.URL.RawQuery, string(body))
+			pending, _ := json.Marshal(idempotentRecord{Fingerprint: fingerprint, Pending: true})
+
+			ctx, cancel := context.WithTimeout(r.Context(), idempotencyStoreTimeout)
+			reserved, err := store.Reserve(ctx, storeKey, string(pending), lock)
+			var stored string
+			if err == nil && !reserved {
+				stored, err = store.Get(ctx, storeKey)
+			}
+			cancel()
+			if err != nil {
+				log.Error("idempotency store unavailable", "service", serviceName, "error", err)
+				problem.Write(w, r, http.StatusServiceUnavailable, "idempotency check unavailable")
+				return
+			}
+
+			if !reserved {
+				var record idempotentRecord
+				switch {
+				case stored == "" || json.Unmarshal([]byte(stored), &record) != nil:
+					// expired or released between the two store operations
+					problem.Write(w, r, http.StatusConflict, "request with this Idempotency-Key is in progress, retry later")
+				case record.Fingerprint != fingerprint:
+					problem.Write(w, r, http.StatusUnprocessableEntity, "Idempotency-Key was already used for a different request")
+				case record.Pending:
+					problem.Write(w, r, http.StatusConflict, "request with this Idempotency-Key is in progress, retry later")
+				default:
+					idempotentReplays.Inc(serviceName)
+					log.Debug("replaying idempotent response",
+						"service", serviceName,
+						"path", r.URL.Path,
+						"status", record.Status,
+					)
+					w.Header().Set(IdempotentReplayedHeader, "true")
+					writeCaptured(w, record.Status, record.Header, record.Body)
+				}
+				return
+			}
+
+			capture := newCaptureWriter(w, maxIdempotentBody)
+			complete := false
+			defer func() {
+				ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), idempotencyStoreTimeout)
+				defer cancel()
+
+				// server errors and responses that were cut short or are too
+				// large release the key, so the request can be retried
+				var err error
+				if complete && !capture.overflow && capture.status != 0 && capture.status < http.StatusInternalServerError {
+					record, _ := json.Marshal(idempotentRecord{
+						Fingerprint: fingerprint,
+						Status:      capture.status,
+						Header:      capture.header,
+						Body:        capture.body,
+					})
+					err = store.Set(ctx, storeKey, string(record), ttl)
+				} else {
+					err = store.Delete(ctx, storeKey)
+				}
+				if err != nil {
+					log.Error("failed to store idempotent response", "service", serviceName, "error", err)
+				}
+			}()
+
+			next.ServeHTTP(capture, r)
+			complete = true
+		})
+	}
+}
+
+// isIdempotencyMethod reports whether requests with a method are made safe
+// to retry by an Idempotency-Key, the other methods are idempotent already
+func isIdempotencyMethod(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch:
+		return true
+	}
+	return false
+}
+
+// hashParts hashes strings into a fixed-length key, separating them so
+// different splits of the same bytes differ
+func hashParts(parts ...string) string {
+	h := sha256.New()
+	for _, part := range parts {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gateway/template/internal/idempotency"
+	"github.com/gateway/template/pkg/logger"
+)
+
+func TestIdempotencyRejectsLargeBodies(t *testing.T) {
+	forwarded := 0
+	handler := Idempotency("billing", []string{"/billing/*"}, idempotency.NewMemoryStore(), time.Hour, time.Minute, 16, logger.NewMockLogger())(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			forwarded++
+			w.WriteHeader(http.StatusCreated)
+		}))
+	send := func(key, body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/billing/payments", strings.NewReader(body))
+		req.Header.Set(IdempotencyKeyHeader, key)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := send("large", strings.Repeat("x", 17)); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected a body over the limit to be rejected with 413, got %d", code)
+	}
+	if code := send("small", strings.Repeat("x", 16)); code != http.StatusCreated {
+		t.Errorf("expected a body at the limit to be forwarded, got %d", code)
+	}
+	if code := send("small", strings.Repeat("x", 16)); code != http.StatusCreated || forwarded != 1 {
+		t.Errorf("expected the retry to be replayed, got %d after %d forwarded requests", code, forwarded)
+	}
+}
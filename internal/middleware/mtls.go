@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http"
+
+	"github.com/gateway/template/internal/config"
+	"github.com/gateway/template/internal/problem"
+	"github.com/gateway/template/pkg/logger"
+)
+
+// ClientCertAuth returns a chi middleware requiring a client certificate
+// verified by the server's client CAs during the TLS handshake, for
+// service-to-service callers. With subjects set, the certificate's common
+// name or one of its DNS names must be among them. The common name is used
+// as the user ID.
+func ClientCertAuth(serviceName string, cfg *config.MTLSConfig, log logger.Logger) func(next http.Handler) http.Handler {
+	allowed := make(map[string]bool, len(cfg.Subjects))
+	for _, subject := range cfg.Subjects {
+		allowed[subject] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// verified chains are only set for certificates issued by the client CAs
+			if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+				problem.Write(w, r, http.StatusUnauthorized, "client certificate required")
+				return
+			}
+
+			cert := r.TLS.VerifiedChains[0][0]
+			if len(allowed) > 0 && !certMatches(cert, allowed) {
+				log.Warn("client certificate rejected",
+					"service", serviceName,
+					"path", r.URL.Path,
+					"method", r.Method,
+					"subject", cert.Subject.String(),
+				)
+				problem.Write(w, r, http.StatusForbidden, "client certificate not allowed")
+				return
+			}
+
+			caller := cert.Subject.CommonName
+
+			// report the caller to the access log
+			if info, ok := r.Context().Value(requestInfoContextKey).(*requestInfo); ok {
+				info.userID = caller
+			}
+
+			ctx := context.WithValue(r.Context(), UserIDContextKey, caller)
+			next.ServeHTTP(w, withLogFields(r.WithContext(ctx), "user_id", caller))
+		})
+	}
+}
+
+// certMatches reports whether the common name or a DNS name of a
+// certificate is allowed
+func certMatches(cert *x509.Certificate, allowed map[string]bool) bool {
+	if allowed[cert.Subject.CommonName] {
+		return true
+	}
+	for _, name := range cert.DNSNames {
+		if allowed[name] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,151 @@
+package middleware
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gateway/template/internal/config"
+	"github.com/gateway/template/internal/problem"
+	"github.com/gateway/template/pkg/logger"
+)
+
+// NormalizePaths returns a chi middleware that normalizes request paths
+// before routing and authentication, so differently spelled paths cannot
+// bypass rules matching path prefixes, e.g. /crm/public/../admin reaching
+// /crm/admin past public paths.
+//
+// With NormalizePaths repeated slashes are collapsed and . and .. segments
+// resolved, also when percent-encoded. EncodedSlashes decides whether %2F
+// is kept as part of a segment, decoded to a separator first or rejected
+// with 400. Kept %2F next to dot segments are rejected too, as backends
+// decoding them would resolve a path the gateway did not route. TrailingSlash passes a trailing slash on, strips it or
+// redirects to the path without it with 308.
+func NormalizePaths(cfg *config.RequestConfig, log logger.Logger) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			raw := r.URL.EscapedPath()
+			if !strings.HasPrefix(raw, "/") {
+				// e.g. OPTIONS *
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			path := raw
+			if hasEncodedSlash(path) {
+				switch cfg.EncodedSlashes {
+				case "reject":
+					log.Warn("rejected request path with encoded slash",
+						"path", raw,
+						"method", r.Method,
+					)
+					problem.Write(w, r, http.StatusBadRequest, "encoded slashes are not allowed in paths")
+					return
+				case "decode":
+					path = strings.NewReplacer("%2F", "/", "%2f", "/").Replace(path)
+				default:
+					if hasEncodedDotSegment(path) {
+						log.Warn("rejected request path with dot segment next to encoded slash",
+							"path", raw,
+							"method", r.Method,
+						)
+						problem.Write(w, r, http.StatusBadRequest, "dot segments next to encoded slashes are not allowed in paths")
+						return
+					}
+				}
+			}
+			if cfg.NormalizePaths {
+				path = cleanPath(path)
+			}
+
+			if path != "/" && strings.HasSuffix(path, "/") {
+				switch cfg.TrailingSlash {
+				case "strip":
+					path = strings.TrimRight(path, "/")
+				case "redirect":
+					location := localPath(strings.TrimRight(path, "/"))
+					if location == "" {
+						location = "/"
+					}
+					if r.URL.RawQuery != "" {
+						location += "?" + r.URL.RawQuery
+					}
+					http.Redirect(w, r, location, http.StatusPermanentRedirect)
+					return
+				}
+				if path == "" {
+					path = "/"
+				}
+			}
+
+			if path != raw {
+				decoded, err := url.PathUnescape(path)
+				if err != nil {
+					problem.Write(w, r, http.StatusBadRequest, "invalid path encoding")
+					return
+				}
+				r.URL.Path = decoded
+				r.URL.RawPath = path
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// hasEncodedSlash reports whether an escaped path contains %2F
+func hasEncodedSlash(path string) bool {
+	return strings.Contains(path, "%2F") || strings.Contains(path, "%2f")
+}
+
+// hasEncodedDotSegment reports whether a segment of an escaped path holds
+// a . or .. segment once its %2F are decoded, e.g. public%2F..%2Fadmin
+func hasEncodedDotSegment(path string) bool {
+	for _, segment := range strings.Split(path, "/") {
+		if !hasEncodedSlash(segment) {
+			continue
+		}
+		decoded, err := url.PathUnescape(segment)
+		if err != nil {
+			continue
+		}
+		for _, part := range strings.Split(decoded, "/") {
+			if part == "." || part == ".." {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// cleanPath collapses repeated slashes of an escaped path and resolves its
+// . and .. segments like RFC 3986 dot-segment removal, never above the
+// root. Segments are compared decoded, so %2e%2e counts as .. too. A path
+// ending in a slash or dot segment keeps a trailing slash.
+func cleanPath(path string) string {
+	segments := strings.Split(path[1:], "/")
+	cleaned := make([]string, 0, len(segments))
+	trailing := false
+	for _, segment := range segments {
+		decoded, err := url.PathUnescape(segment)
+		if err != nil {
+			decoded = segment
+		}
+		trailing = true
+		switch decoded {
+		case "", ".":
+		case "..":
+			if len(cleaned) > 0 {
+				cleaned = cleaned[:len(cleaned)-1]
+			}
+		default:
+			cleaned = append(cleaned, segment)
+			trailing = false
+		}
+	}
+
+	result := "/" + strings.Join(cleaned, "/")
+	if trailing && len(cleaned) > 0 {
+		result += "/"
+	}
+	return result
+}
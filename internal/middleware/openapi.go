@@ -0,0 +1,101 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/legacy"
+
+	"github.com/gateway/template/internal/problem"
+	"github.com/gateway/template/pkg/logger"
+)
+
+// OpenAPIValidation returns a chi middleware that validates requests against
+// the OpenAPI 3 specification at specPath. Path, method, parameters and
+// request body are checked before the request reaches the backend.
+//
+// The spec describes the backend API, so this middleware must run after the
+// service prefix has been stripped. Servers declared in the spec are ignored
+// and only paths are matched. Security requirements are not evaluated here,
+// authentication is handled by the Auth middleware.
+func OpenAPIValidation(specPath, service string, log logger.Logger) (func(next http.Handler) http.Handler, error) {
+	loader := openapi3.NewLoader()
+	doc, err := loader.LoadFromFile(specPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load openapi spec %q: %w", specPath, err)
+	}
+
+	if err := doc.Validate(loader.Context); err != nil {
+		return nil, fmt.Errorf("invalid openapi spec %q: %w", specPath, err)
+	}
+
+	// match on paths only, backend hosts differ from the gateway host
+	doc.Servers = nil
+
+	router, err := legacy.NewRouter(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build openapi router for %q: %w", specPath, err)
+	}
+
+	options := &openapi3filter.Options{
+		AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route, pathParams, err := router.FindRoute(r)
+			if err != nil {
+				statusCode, message := routeErrorResponse(err)
+
+				log.Warn("request rejected by openapi validation",
+					"service", service,
+					"path", r.URL.Path,
+					"method", r.Method,
+					"error", err.Error(),
+				)
+
+				problem.Write(w, r, statusCode, message)
+				return
+			}
+
+			input := &openapi3filter.RequestValidationInput{
+				Request:    r,
+				PathParams: pathParams,
+				Route:      route,
+				Options:    options,
+			}
+
+			if err := openapi3filter.ValidateRequest(r.Context(), input); err != nil {
+				log.Warn("request rejected by openapi validation",
+					"service", service,
+					"path", r.URL.Path,
+					"method", r.Method,
+					"error", err.Error(),
+				)
+
+				problem.Write(w, r, http.StatusBadRequest, err.Error())
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
+
+// routeErrorResponse maps router errors to a status code and client message
+func routeErrorResponse(err error) (int, string) {
+	var routeErr *routers.RouteError
+	if errors.As(err, &routeErr) {
+		switch routeErr.Reason {
+		case routers.ErrPathNotFound.Error():
+			return http.StatusNotFound, "route not found in api specification"
+		case routers.ErrMethodNotAllowed.Error():
+			return http.StatusMethodNotAllowed, "method not allowed by api specification"
+		}
+	}
+	return http.StatusBadRequest, "request does not match api specification"
+}
@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ExceptPaths returns mw applied only to requests whose path matches none of
+// the patterns, e.g. to leave the public paths of a service unauthenticated
+func ExceptPaths(patterns []string, mw func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	if len(patterns) == 0 {
+		return mw
+	}
+	return func(next http.Handler) http.Handler {
+		wrapped := mw(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if matchPath(patterns, r.URL.EscapedPath()) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			wrapped.ServeHTTP(w, r)
+		})
+	}
+}
+
+// matchPath reports whether path matches one of the patterns, exact paths
+// or prefixes ending in /*. Callers pass the escaped request path, which is
+// normalized and forwarded upstream as it is, so a %2F kept inside a
+// segment never matches a separator of a pattern.
+func matchPath(patterns []string, path string) bool {
+	for _, pattern := range patterns {
+		if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+			if path == prefix || strings.HasPrefix(path, prefix+"/") {
+				return true
+			}
+		} else if path == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// pathDuration returns the duration of the longest pattern of paths
+// matching path, or fallback
+func pathDuration(paths map[string]time.Duration, path string, fallback time.Duration) time.Duration {
+	duration, longest := fallback, -1
+	for pattern, d := range paths {
+		if len(pattern) > longest && matchPath([]string{pattern}, path) {
+			duration, longest = d, len(pattern)
+		}
+	}
+	return duration
+}
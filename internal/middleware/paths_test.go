@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestExceptPaths(t *testing.T) {
+	deny := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+		})
+	}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	h := ExceptPaths([]string{"/crm/public/*", "/crm/status"}, deny)(ok)
+
+	tests := []struct {
+		path   string
+		public bool
+	}{
+		{"/crm/public", true},
+		{"/crm/public/docs", true},
+		{"/crm/public/docs/a%2Fb", true},
+		{"/crm/status", true},
+		{"/crm/publications", false},
+		{"/crm/status/details", false},
+		{"/crm/admin", false},
+		// kept encoded slashes are part of a segment, as forwarded upstream
+		{"/crm/public%2Fdocs", false},
+		{"/crm/public%2F..%2Fadmin", false},
+		{"/crm/status%2F..%2Fadmin", false},
+		{"/crm%2Fpublic/docs", false},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if public := rec.Code == http.StatusOK; public != tt.public {
+			t.Errorf("%s: expected public %v, got status %d", tt.path, tt.public, rec.Code)
+		}
+	}
+}
+
+func TestPathDuration(t *testing.T) {
+	paths := map[string]time.Duration{"/crm/*": time.Second, "/crm/reports/*": time.Minute}
+	tests := []struct {
+		path string
+		want time.Duration
+	}{
+		{"/crm/contacts", time.Second},
+		{"/crm/reports/daily", time.Minute},
+		{"/crm/reports%2Fdaily", time.Second},
+		{"/billing/invoices", time.Hour},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		if got := pathDuration(paths, r.URL.EscapedPath(), time.Hour); got != tt.want {
+			t.Errorf("%s: expected %s, got %s", tt.path, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package middleware
+
+import "time"
+
+// rateAlgorithm enforces the rate limit of one client. It is only used with
+// the rate limiter's lock held.
+type rateAlgorithm interface {
+	// allow takes a request made at now if the limit allows it
+	allow(now time.Time) rateState
+}
+
+// rateState is the state of a client's limit after a request
+type rateState struct {
+	allowed    bool
+	remaining  int           // requests allowed right away
+	reset      time.Duration // until the full burst is allowed again
+	retryAfter time.Duration // until the next request is allowed, if not allowed
+}
+
+// newRateAlgorithm returns the algorithm of tier for a client first seen at
+// now. The window algorithms allow burst requests per window of burst / rate
+// seconds.
+func newRateAlgorithm(tier *rateTier, now time.Time) rateAlgorithm {
+	limit := int(tier.burst)
+	switch tier.algorithm {
+	case "gcra":
+		interval := time.Duration(float64(time.Second) / tier.rate)
+		return &gcra{interval: interval, tolerance: interval * time.Duration(limit), tat: now}
+	case "fixed_window":
+		return &fixedWindow{window: tier.window(), limit: limit}
+	case "sliding_window":
+		return &slidingWindow{window: tier.window(), limit: limit, start: now.Truncate(tier.window())}
+	case "sliding_log":
+		return &slidingLog{window: tier.window(), limit: limit}
+	default:
+		return &requestBucket{rate: tier.rate, burst: tier.burst, tokens: tier.burst, last: now}
+	}
+}
+
+// requestBucket is a token bucket allowing rate requests per second on average
+// and bursts of up to burst requests
+type requestBucket struct {
+	rate, burst float64
+	tokens      float64
+	last        time.Time
+}
+
+func (b *requestBucket) allow(now time.Time) rateState {
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	state := rateState{allowed: b.tokens >= 1}
+	if state.allowed {
+		b.tokens--
+	} else {
+		state.retryAfter = b.until(1)
+	}
+	state.remaining = int(b.tokens)
+	state.reset = b.until(b.burst)
+	return state
+}
+
+// until returns the time until the bucket holds n tokens
+func (b *requestBucket) until(n float64) time.Duration {
+	return time.Duration(max(0, n-b.tokens) / b.rate * float64(time.Second))
+}
+
+// gcra is the generic cell rate algorithm, a token bucket keeping only the
+// theoretical arrival time of the next request. Requests are spaced by
+// interval, up to tolerance ahead of time.
+type gcra struct {
+	interval, tolerance time.Duration
+	tat                 time.Time
+}
+
+func (g *gcra) allow(now time.Time) rateState {
+	tat := g.tat
+	if tat.Before(now) {
+		tat = now
+	}
+	next := tat.Add(g.interval)
+	if ahead := next.Sub(now); ahead > g.tolerance {
+		return rateState{reset: tat.Sub(now), retryAfter: ahead - g.tolerance}
+	}
+	g.tat = next
+	return rateState{
+		allowed:   true,
+		remaining: int((g.tolerance - next.Sub(now)) / g.interval),
+		reset:     next.Sub(now),
+	}
+}
+
+// fixedWindow allows limit requests per window, the windows aligned to the
+// clock so all clients' counts reset together
+type fixedWindow struct {
+	window time.Duration
+	limit  int
+	start  time.Time
+	count  int
+}
+
+func (f *fixedWindow) allow(now time.Time) rateState {
+	if !now.Before(f.start.Add(f.window)) {
+		f.start, f.count = now.Truncate(f.window), 0
+	}
+	state := rateState{allowed: f.count < f.limit, reset: f.start.Add(f.window).Sub(now)}
+	if state.allowed {
+		f.count++
+	} else {
+		state.retryAfter = state.reset
+	}
+	state.remaining = f.limit - f.count
+	return state
+}
+
+// slidingWindow allows limit requests in any window, estimating the
+// requests of the window ending now from the counts of the current and the
+// previous fixed window, weighted by their overlap
+type slidingWindow struct {
+	window      time.Duration
+	limit       int
+	start       time.Time // of the current fixed window
+	prev, count int
+}
+
+func (s *slidingWindow) allow(now time.Time) rateState {
+	switch elapsed := now.Sub(s.start); {
+	case elapsed >= 2*s.window:
+		s.start, s.prev, s.count = now.Truncate(s.window), 0, 0
+	case elapsed >= s.window:
+		s.start, s.prev, s.count = s.start.Add(s.window), s.count, 0
+	}
+
+	weight := 1 - float64(now.Sub(s.start))/float64(s.window)
+	used := float64(s.prev)*weight + float64(s.count)
+	state := rateState{allowed: used+1 <= float64(s.limit)}
+	if state.allowed {
+		s.count++
+		used++
+	} else {
+		state.retryAfter = s.until(now)
+	}
+	state.remaining = max(0, int(float64(s.limit)-used))
+
+	// counted requests weigh until the window after theirs ends
+	switch {
+	case s.count > 0:
+		state.reset = s.start.Add(2 * s.window).Sub(now)
+	case s.prev > 0:
+		state.reset = s.start.Add(s.window).Sub(now)
+	}
+	return state
+}
+
+// until returns the time until the estimate leaves room for one more
+// request, s.count being its share of a later window if it alone exhausts
+// the limit
+func (s *slidingWindow) until(now time.Time) time.Duration {
+	room := float64(s.limit - 1)
+	window := float64(s.window)
+	if s.count <= s.limit-1 {
+		// s.prev must weigh at most the room left by s.count
+		at := s.start.Add(time.Duration(window * (1 - (room-float64(s.count))/float64(s.prev))))
+		return max(0, at.Sub(now))
+	}
+	at := s.start.Add(s.window + time.Duration(window*(1-room/float64(s.count))))
+	return max(0, at.Sub(now))
+}
+
+// slidingLog allows limit requests in any window, keeping the time of every
+// request in it. It is exact but keeps up to limit times per client.
+type slidingLog struct {
+	window time.Duration
+	limit  int
+	times  []time.Time // oldest first
+}
+
+func (l *slidingLog) allow(now time.Time) rateState {
+	expired := 0
+	for expired < len(l.times) && now.Sub(l.times[expired]) >= l.window {
+		expired++
+	}
+	l.times = l.times[:copy(l.times, l.times[expired:])]
+
+	state := rateState{allowed: len(l.times) < l.limit}
+	if state.allowed {
+		l.times = append(l.times, now)
+	} else {
+		state.retryAfter = l.times[0].Add(l.window).Sub(now)
+	}
+	state.remaining = l.limit - len(l.times)
+	if len(l.times) > 0 {
+		state.reset = l.times[len(l.times)-1].Add(l.window).Sub(now)
+	}
+	return state
+}
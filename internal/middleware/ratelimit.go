@@ -0,0 +1,327 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gateway/template/internal/config"
+	"github.com/gateway/template/internal/metrics"
+	"github.com/gateway/template/internal/problem"
+	"github.com/gateway/template/internal/quota"
+	"github.com/gateway/template/pkg/logger"
+)
+
+var (
+	rateLimited = metrics.Default.Counter(
+		"gateway_rate_limited_total",
+		"Number of requests rejected by per-client rate limits.",
+		"tier",
+	)
+	serviceRateLimited = metrics.Default.Counter(
+		"gateway_service_rate_limited_total",
+		"Number of requests rejected by the total rate limit of their service.",
+		"service",
+	)
+	quotaExceeded = metrics.Default.Counter(
+		"gateway_quota_exceeded_total",
+		"Number of requests rejected by per-client daily or monthly quotas.",
+		"tier", "period",
+	)
+)
+
+// rateTier holds limits and the roles and plans selecting them, 0 is
+// unlimited
+type rateTier struct {
+	name           string
+	roles, plans   []string
+	rate, burst    float64
+	algorithm      string
+	daily, monthly int64
+}
+
+// window returns the time the tier's rate allows its burst in
+func (t *rateTier) window() time.Duration {
+	return time.Duration(t.burst / t.rate * float64(time.Second))
+}
+
+// RateLimit returns a chi middleware that limits the requests of each
+// client to cfg.Rate per second after a burst of cfg.Burst, enforced by
+// cfg.Algorithm or the tier's, rejecting the excess with 429 and
+// Retry-After. Users are limited by user ID with the
+// limits of the first tier matching one of their roles or their plan, and
+// the default limits without one, and told their rate limit in headers if
+// cfg.Headers is set. Anonymous clients, e.g. on public paths, are limited
+// by client IP, so the middleware must run after authentication.
+//
+// With daily or monthly quotas, every request allowed by the rate limit is
+// counted in store, and requests over a quota are rejected until the UTC
+// day or month ends. A failing store lets requests through. The limits are
+// shared by all services the middleware is applied to, and kept in state
+// while the tiers stay the same.
+func RateLimit(cfg *config.RateLimitConfig, store quota.Store, state *State, log logger.Logger) func(next http.Handler) http.Handler {
+	// the store is created at startup, quotas added later need a restart
+	quotas := cfg.HasQuotas() && store != nil
+	if cfg.Rate <= 0 && !quotas && !slices.ContainsFunc(cfg.Tiers, func(t config.RateLimitTier) bool { return t.Rate > 0 }) {
+		return func(next http.Handler) http.Handler {
+			return next
+		}
+	}
+
+	defaultTier := &rateTier{
+		name: "default", rate: cfg.Rate, burst: burstOf(cfg.Rate, cfg.Burst),
+		algorithm: cfg.Algorithm, daily: cfg.Daily, monthly: cfg.Monthly,
+	}
+	tiers := make([]*rateTier, 0, len(cfg.Tiers))
+	for _, t := range cfg.Tiers {
+		algorithm := t.Algorithm
+		if algorithm == "" {
+			algorithm = cfg.Algorithm
+		}
+		tiers = append(tiers, &rateTier{
+			name: t.Name, roles: t.Roles, plans: t.Plans,
+			rate: t.Rate, burst: burstOf(t.Rate, t.Burst), algorithm: algorithm,
+			daily: t.Daily, monthly: t.Monthly,
+		})
+	}
+	settings := []rateTier{*defaultTier}
+	for _, t := range tiers {
+		settings = append(settings, *t)
+	}
+	limiter := keep(state, "rate_limit", settings, func() *rateLimiter {
+		return &rateLimiter{clients: make(map[string]*rateClient)}
+	})
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key, tier := "ip:"+GetClientIP(r), defaultTier
+			if userID, ok := GetUserIDFromContext(r.Context()); ok && userID != "" {
+				key = "user:" + userID
+				tier = userTier(r, cfg.PlanClaim, tiers, defaultTier)
+			}
+
+			if tier.rate > 0 {
+				state := limiter.allow(tier, key)
+				if cfg.Headers {
+					setRateLimitHeaders(w.Header(), tier, state)
+				}
+				if !state.allowed {
+					rateLimited.Inc(tier.name)
+					log.Debug("request rejected by rate limit",
+						"client", key,
+						"tier", tier.name,
+						"path", r.URL.Path,
+						"method", r.Method,
+					)
+
+					w.Header().Set("Retry-After", seconds(state.retryAfter))
+					problem.Write(w, r, http.StatusTooManyRequests, "rate limit exceeded, retry later")
+					return
+				}
+			}
+
+			// usage is counted for every tier, so it can be looked up
+			if quotas {
+				if period, end := takeQuota(r, store, tier, key, log); period != "" {
+					quotaExceeded.Inc(tier.name, string(period))
+					log.Debug("request rejected by quota",
+						"client", key,
+						"tier", tier.name,
+						"period", period,
+					)
+
+					detail := "monthly quota exceeded"
+					if period == quota.Day {
+						detail = "daily quota exceeded"
+					}
+					w.Header().Set("Retry-After", seconds(time.Until(end)))
+					problem.Write(w, r, http.StatusTooManyRequests, detail)
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ServiceRateLimit returns a chi middleware that limits the requests to a
+// service to cfg.Rate per second after a burst of cfg.Burst, from all
+// clients together, so a backend is never sent more than it can take. The
+// excess is rejected with 503 and Retry-After. The limit is kept in state
+// while its settings stay the same.
+func ServiceRateLimit(service string, cfg config.ServiceRateLimitConfig, state *State, log logger.Logger) func(next http.Handler) http.Handler {
+	if cfg.Rate <= 0 {
+		return func(next http.Handler) http.Handler {
+			return next
+		}
+	}
+
+	tier := &rateTier{name: service, rate: cfg.Rate, burst: burstOf(cfg.Rate, cfg.Burst), algorithm: cfg.Algorithm}
+	limit := keep(state, "service_rate_limit:"+service, *tier, func() *serviceRateLimit {
+		return &serviceRateLimit{algorithm: newRateAlgorithm(tier, time.Now())}
+	})
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			limit.mu.Lock()
+			state := limit.algorithm.allow(time.Now())
+			limit.mu.Unlock()
+
+			if !state.allowed {
+				serviceRateLimited.Inc(service)
+				log.Warn("request rejected by service rate limit",
+					"service", service,
+					"rate", cfg.Rate,
+					"path", r.URL.Path,
+					"method", r.Method,
+				)
+
+				w.Header().Set("Retry-After", seconds(state.retryAfter))
+				problem.Write(w, r, http.StatusServiceUnavailable, "service is over capacity, retry later")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// serviceRateLimit is the limit of a service shared by all its clients
+type serviceRateLimit struct {
+	mu        sync.Mutex
+	algorithm rateAlgorithm
+}
+
+// takeQuota counts a request of client in the current day and month. If it
+// exceeds a quota of tier, it is taken back from both, and the exhausted
+// period and its end are returned. Requests are allowed if the store fails.
+func takeQuota(r *http.Request, store quota.Store, tier *rateTier, client string, log logger.Logger) (quota.Period, time.Time) {
+	now := time.Now()
+	var counted []quota.Period
+	var exceeded quota.Period
+	var end time.Time
+	for _, p := range []struct {
+		period quota.Period
+		limit  int64
+	}{{quota.Day, tier.daily}, {quota.Month, tier.monthly}} {
+		count, periodEnd, err := quota.Count(r.Context(), store, p.period, client, now)
+		if err != nil {
+			log.Warn("failed to count request for quota", "period", p.period, "error", err.Error())
+			continue
+		}
+		counted = append(counted, p.period)
+		if p.limit > 0 && count > p.limit && exceeded == "" {
+			exceeded, end = p.period, periodEnd
+		}
+	}
+	if exceeded == "" {
+		return "", time.Time{}
+	}
+
+	for _, period := range counted {
+		if err := quota.Uncount(r.Context(), store, period, client, now); err != nil {
+			log.Warn("failed to take back rejected request from quota", "period", period, "error", err.Error())
+		}
+	}
+	return exceeded, end
+}
+
+// setRateLimitHeaders tells the client its limit, both in the widespread
+// X-RateLimit headers, with the reset as Unix time, and in the RateLimit
+// headers of the IETF draft, with the reset in seconds. The limit is the
+// burst, refilled over the policy's window of burst / rate seconds.
+func setRateLimitHeaders(h http.Header, tier *rateTier, state rateState) {
+	limit := strconv.Itoa(int(tier.burst))
+	remaining := strconv.Itoa(state.remaining)
+	h.Set("X-RateLimit-Limit", limit)
+	h.Set("X-RateLimit-Remaining", remaining)
+	resetAt := math.Ceil(float64(time.Now().Add(state.reset).UnixMilli()) / 1000)
+	h.Set("X-RateLimit-Reset", strconv.FormatInt(int64(resetAt), 10))
+	h.Set("RateLimit-Limit", limit)
+	h.Set("RateLimit-Remaining", remaining)
+	h.Set("RateLimit-Reset", seconds(state.reset))
+	h.Set("RateLimit-Policy", limit+";w="+seconds(tier.window()))
+}
+
+// seconds formats d as whole seconds, rounded up
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// userTier returns the first tier matching a role or the plan of the
+// request's user, the default tier without one
+func userTier(r *http.Request, planClaim string, tiers []*rateTier, defaultTier *rateTier) *rateTier {
+	claims, ok := GetClaimsFromContext(r.Context())
+	if !ok || len(tiers) == 0 {
+		return defaultTier
+	}
+	plan, _ := claims.Metadata[planClaim].(string)
+	for _, tier := range tiers {
+		if plan != "" && slices.Contains(tier.plans, plan) {
+			return tier
+		}
+		for _, role := range claims.Roles {
+			if slices.Contains(tier.roles, role) {
+				return tier
+			}
+		}
+	}
+	return defaultTier
+}
+
+// burstOf returns the burst of a rate limit, the rate rounded up if unset
+func burstOf(rate float64, burst int) float64 {
+	if burst > 0 {
+		return float64(burst)
+	}
+	return math.Ceil(rate)
+}
+
+// rateLimiter holds the limits of the clients seen recently
+type rateLimiter struct {
+	mu      sync.Mutex
+	clients map[string]*rateClient
+	sweep   time.Time // next time idle clients are removed
+}
+
+// rateClient is the limit of a client in a tier
+type rateClient struct {
+	algorithm rateAlgorithm
+	window    time.Duration // after which an idle client's limit is reset
+	last      time.Time
+}
+
+// allow takes a request of the client from its limit in tier
+func (l *rateLimiter) allow(tier *rateTier, client string) rateState {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.sweepIdle(now)
+	// clients changing tier start over with a full limit
+	key := tier.name + "|" + client
+	c, ok := l.clients[key]
+	if !ok {
+		c = &rateClient{algorithm: newRateAlgorithm(tier, now), window: tier.window()}
+		l.clients[key] = c
+	}
+	c.last = now
+	return c.algorithm.allow(now)
+}
+
+// sweepIdle removes clients whose limits have reset at most once a minute,
+// l.mu must be held
+func (l *rateLimiter) sweepIdle(now time.Time) {
+	if now.Before(l.sweep) {
+		return
+	}
+	for key, c := range l.clients {
+		// a sliding window weighs requests for two windows
+		if now.Sub(c.last) > 2*c.window+time.Minute {
+			delete(l.clients, key)
+		}
+	}
+	l.sweep = now.Add(time.Minute)
+}
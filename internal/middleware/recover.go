@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/gateway/template/internal/errreport"
+	"github.com/gateway/template/internal/problem"
+	"github.com/gateway/template/pkg/logger"
+)
+
+// ReportErrors returns a chi middleware that recovers panics and reports them,
+// together with 5xx bursts, to the error reporting sink. It must run after
+// Logging so the service that handled the request is known.
+func ReportErrors(bursts *errreport.BurstDetector, log logger.Logger) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ww := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+
+			defer func() {
+				rec := recover()
+				if rec == nil {
+					bursts.Observe(serviceFromContext(r), ww.statusCode, r)
+					return
+				}
+
+				// the reverse proxy aborts responses on purpose, let net/http handle it
+				if rec == http.ErrAbortHandler {
+					panic(rec)
+				}
+
+				stack := string(debug.Stack())
+				log.Error("panic recovered",
+					"path", r.URL.Path,
+					"method", r.Method,
+					"panic", fmt.Sprint(rec),
+					"stack", stack,
+				)
+
+				errreport.Capture(&errreport.Event{
+					Message: "panic recovered",
+					Err:     fmt.Errorf("%v", rec),
+					Level:   errreport.LevelFatal,
+					Service: serviceFromContext(r),
+					Request: r,
+					Extra: map[string]interface{}{
+						"stack": stack,
+					},
+				})
+
+				problem.Write(ww, r, http.StatusInternalServerError, "internal server error")
+				bursts.Observe(serviceFromContext(r), http.StatusInternalServerError, r)
+			}()
+
+			next.ServeHTTP(ww, r)
+		})
+	}
+}
+
+// serviceFromContext returns the service recorded for the access log, or "gateway"
+func serviceFromContext(r *http.Request) string {
+	if info, ok := r.Context().Value(requestInfoContextKey).(*requestInfo); ok && info.annotation != nil {
+		return info.annotation.Service
+	}
+	return "gateway"
+}
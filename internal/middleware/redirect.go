@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gateway/template/internal/config"
+	"github.com/gateway/template/internal/metrics"
+)
+
+var redirectsTotal = metrics.Default.Counter(
+	"gateway_redirects_total",
+	"Number of requests redirected by redirect rules.",
+	"rule",
+)
+
+// Redirects returns a chi middleware redirecting requests matching one of
+// the rules before they are routed, the first matching rule wins. Prefix
+// rules whose target ends in /* keep the rest of the path, e.g. /old/* to
+// /new/* redirects /old/a/b to /new/a/b, and the query is kept unless the
+// target has its own.
+func Redirects(rules []config.RedirectRule) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if len(rules) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			host := r.Host
+			if h, _, err := net.SplitHostPort(host); err == nil {
+				host = h
+			}
+			path := r.URL.EscapedPath()
+
+			for _, rule := range rules {
+				if rule.Host != "" && !strings.EqualFold(rule.Host, host) {
+					continue
+				}
+				location, ok := redirectTarget(rule, path)
+				if !ok {
+					continue
+				}
+				if r.URL.RawQuery != "" && !strings.Contains(location, "?") {
+					location += "?" + r.URL.RawQuery
+				}
+				status := rule.Status
+				if status == 0 {
+					status = http.StatusMovedPermanently
+				}
+
+				redirectsTotal.Inc(rule.Host + rule.Path)
+				http.Redirect(w, r, location, status)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// redirectTarget returns the location path is redirected to by rule,
+// reporting false if the rule does not match it
+func redirectTarget(rule config.RedirectRule, path string) (string, bool) {
+	prefix, isPrefix := strings.CutSuffix(rule.Path, "/*")
+	if !isPrefix {
+		return rule.To, path == rule.Path
+	}
+	if path != prefix && !strings.HasPrefix(path, prefix+"/") {
+		return "", false
+	}
+
+	to, keepRest := strings.CutSuffix(rule.To, "/*")
+	if !keepRest {
+		return rule.To, true
+	}
+	location := localPath(to + strings.TrimPrefix(path, prefix))
+	if location == "" {
+		// /old to /* for /old/*
+		location = "/"
+	}
+	return location, true
+}
+
+// localPath collapses the leading slashes of a location, which would
+// otherwise be a protocol-relative URL: /old//evil.com redirected by /old/*
+// to /* must go to /evil.com on the same host, not to //evil.com
+func localPath(location string) string {
+	if strings.HasPrefix(location, "//") {
+		return "/" + strings.TrimLeft(location, "/")
+	}
+	return location
+}
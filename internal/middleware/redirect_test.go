@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gateway/template/internal/config"
+	"github.com/gateway/template/pkg/logger"
+)
+
+func TestRedirectsStayOnTheHost(t *testing.T) {
+	handler := Redirects([]config.RedirectRule{
+		{Path: "/old/*", To: "/*"},
+		{Path: "/docs/*", To: "https://docs.example.com/*"},
+	})(okHandler)
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/old/a/b?x=1", "/a/b?x=1"},
+		{"/old", "/"},
+		{"/old//evil.com", "/evil.com"},
+		{"/old///evil.com/x", "/evil.com/x"},
+		{"/docs//evil.com", "https://docs.example.com//evil.com"},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if got := rec.Header().Get("Location"); got != tt.want {
+			t.Errorf("%s: expected Location %q, got %q", tt.path, tt.want, got)
+		}
+	}
+}
+
+func TestTrailingSlashRedirectStaysOnTheHost(t *testing.T) {
+	cfg := &config.RequestConfig{TrailingSlash: "redirect", EncodedSlashes: "keep"}
+	handler := NormalizePaths(cfg, logger.NewMockLogger())(okHandler)
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/crm/contacts/?page=2", "/crm/contacts?page=2"},
+		{"//evil.com/", "/evil.com"},
+		{"//", "/"},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != http.StatusPermanentRedirect {
+			t.Errorf("%s: expected status 308, got %d", tt.path, rec.Code)
+		}
+		if got := rec.Header().Get("Location"); got != tt.want {
+			t.Errorf("%s: expected Location %q, got %q", tt.path, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gateway/template/internal/requestid"
+	"github.com/gateway/template/pkg/logger"
+)
+
+// RequestLogger returns a chi middleware that places a request-scoped child
+// of log in the request context. The child carries the request ID; Annotate
+// and Auth add the service and user ID as they become known. Handlers
+// retrieve it with logger.FromContext.
+func RequestLogger(log logger.Logger) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			reqLog := log
+			if id := requestid.FromContext(r.Context()); id != "" {
+				reqLog = log.With("request_id", id)
+			}
+
+			next.ServeHTTP(w, r.WithContext(logger.NewContext(r.Context(), reqLog)))
+		})
+	}
+}
+
+// withLogFields returns r with fields added to its request-scoped logger.
+// Requests without one are returned unchanged.
+func withLogFields(r *http.Request, keysAndValues ...interface{}) *http.Request {
+	reqLog := logger.FromContextOr(r.Context(), nil)
+	if reqLog == nil {
+		return r
+	}
+	return r.WithContext(logger.NewContext(r.Context(), reqLog.With(keysAndValues...)))
+}
@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gateway/template/internal/config"
+)
+
+// CSRFHeader must carry the session's CSRF token on unsafe requests
+// authenticated by a session cookie. Other sites can make browsers send the
+// cookie but cannot read the token from the CSRF cookie.
+const CSRFHeader = "X-CSRF-Token"
+
+// NewSessionCookie returns the session cookie holding an encrypted token
+// until expires, or a cookie deleting the session when value is empty
+func NewSessionCookie(cfg *config.SessionCookieConfig, value string, expires time.Time) *http.Cookie {
+	return newCookie(cfg, cfg.Name, value, expires, true)
+}
+
+// NewCSRFCookie returns the cookie holding the CSRF token of a session for
+// JavaScript to read, or a cookie deleting it when token is empty
+func NewCSRFCookie(cfg *config.SessionCookieConfig, token string, expires time.Time) *http.Cookie {
+	return newCookie(cfg, cfg.CSRFCookieName, token, expires, false)
+}
+
+// newCookie creates a cookie with the attributes configured for sessions
+func newCookie(cfg *config.SessionCookieConfig, name, value string, expires time.Time, httpOnly bool) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Domain:   cfg.Domain,
+		Secure:   cfg.Secure,
+		HttpOnly: httpOnly,
+	}
+	switch cfg.SameSite {
+	case "strict":
+		cookie.SameSite = http.SameSiteStrictMode
+	case "none":
+		cookie.SameSite = http.SameSiteNoneMode
+	default:
+		cookie.SameSite = http.SameSiteLaxMode
+	}
+
+	if value == "" {
+		cookie.MaxAge = -1
+	} else if !expires.IsZero() {
+		cookie.Expires = expires
+	}
+	return cookie
+}
+
+// isSafeMethod reports whether a method only reads, which needs no CSRF
+// protection
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// removeCookie drops a cookie from the request, keeping the others
+func removeCookie(r *http.Request, name string) {
+	cookies := r.Cookies()
+	r.Header.Del("Cookie")
+	for _, cookie := range cookies {
+		if cookie.Name != name {
+			r.AddCookie(cookie)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gateway/template/internal/problem"
+	"github.com/gateway/template/pkg/auth"
+	"github.com/gateway/template/pkg/logger"
+)
+
+// SingleUseTokens returns a chi middleware that accepts a token only once on
+// the paths matching patterns (exact or prefixes ending in /*), so captured
+// requests to sensitive routes such as payment initiation cannot be
+// replayed. Used token IDs are kept in store until the tokens expire, plus
+// leeway; tokens without expiry are remembered for lifetime. It must run
+// after Auth, which provides the claims.
+func SingleUseTokens(serviceName string, patterns []string, store auth.TokenUseStore, leeway, lifetime time.Duration, log logger.Logger) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !matchPath(patterns, r.URL.EscapedPath()) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			claims, ok := r.Context().Value(ClaimsContextKey).(*auth.Claims)
+			if !ok {
+				problem.Write(w, r, http.StatusUnauthorized, "unauthorized")
+				return
+			}
+
+			if err := auth.UseToken(r.Context(), store, claims, leeway, lifetime); err != nil {
+				log.Warn("single-use token rejected",
+					"service", serviceName,
+					"path", r.URL.Path,
+					"method", r.Method,
+					"jti", claims.ID,
+					"reason", auth.FailureReason(err),
+				)
+
+				switch {
+				case errors.Is(err, auth.ErrRevocationUnavailable):
+					problem.Write(w, r, http.StatusServiceUnavailable, "token replay check unavailable")
+				case errors.Is(err, auth.ErrTokenReused):
+					problem.Write(w, r, http.StatusUnauthorized, "token has already been used")
+				default:
+					problem.Write(w, r, http.StatusUnauthorized, "single-use token required")
+				}
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/gateway/template/pkg/auth"
+	"github.com/gateway/template/pkg/logger"
+)
+
+// failingUseStore fails every token use check, like an unreachable Redis
+type failingUseStore struct{}
+
+func (failingUseStore) MarkUsed(context.Context, string, time.Duration) (bool, error) {
+	return false, errors.New("connection refused")
+}
+
+func TestSingleUseTokens(t *testing.T) {
+	h := SingleUseTokens("billing", []string{"/billing/payments/*"}, auth.NewMemoryRevocationStore(), time.Minute, time.Hour, logger.NewMockLogger())(okHandler)
+
+	send := func(path string, claims *auth.Claims) int {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		if claims != nil {
+			req = req.WithContext(context.WithValue(req.Context(), ClaimsContextKey, claims))
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	token := func(id string) *auth.Claims {
+		return &auth.Claims{UserID: "user", RegisteredClaims: jwt.RegisteredClaims{
+			ID:        id,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		}}
+	}
+
+	if code := send("/billing/payments/1", token("a")); code != http.StatusOK {
+		t.Errorf("expected the first use of a token to pass, got %d", code)
+	}
+	if code := send("/billing/payments/2", token("a")); code != http.StatusUnauthorized {
+		t.Errorf("expected a reused token to be rejected, got %d", code)
+	}
+	if code := send("/billing/payments/1", token("b")); code != http.StatusOK {
+		t.Errorf("expected another token to pass, got %d", code)
+	}
+	if code := send("/billing/payments/1", token("")); code != http.StatusUnauthorized {
+		t.Errorf("expected a token without jti to be rejected, got %d", code)
+	}
+	if code := send("/billing/payments/1", nil); code != http.StatusUnauthorized {
+		t.Errorf("expected a request without claims to be rejected, got %d", code)
+	}
+
+	// other paths accept a token any number of times
+	for range 2 {
+		if code := send("/billing/invoices", token("a")); code != http.StatusOK {
+			t.Errorf("expected paths that are not single-use to pass, got %d", code)
+		}
+	}
+}
+
+func TestSingleUseTokensStoreUnavailable(t *testing.T) {
+	h := SingleUseTokens("billing", []string{"/billing/payments/*"}, failingUseStore{}, time.Minute, time.Hour, logger.NewMockLogger())(okHandler)
+
+	claims := &auth.Claims{RegisteredClaims: jwt.RegisteredClaims{ID: "a"}}
+	req := httptest.NewRequest(http.MethodPost, "/billing/payments/1", nil)
+	req = req.WithContext(context.WithValue(req.Context(), ClaimsContextKey, claims))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 when the store fails, got %d", rec.Code)
+	}
+}
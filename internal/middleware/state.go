@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"fmt"
+	"sync"
+)
+
+// State keeps what middleware learns from traffic, such as rate limit and
+// bandwidth buckets, concurrency queues and the signatures seen by HMAC
+// authentication, across handler rebuilds on config reloads. Middleware
+// created with the same State, scope and settings as before continues with
+// its state; a nil State gives every middleware fresh state.
+type State struct {
+	mu      sync.Mutex
+	gen     int // stamps the entries used since the last Sweep
+	entries map[string]*stateEntry
+}
+
+// stateEntry is the state of a scope and its settings
+type stateEntry struct {
+	value any
+	gen   int
+}
+
+// NewState creates an empty State
+func NewState() *State {
+	return &State{entries: make(map[string]*stateEntry)}
+}
+
+// Sweep forgets the state of scopes and settings no middleware created since
+// the previous Sweep uses, call it once a rebuilt handler serves requests
+func (s *State) Sweep() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, entry := range s.entries {
+		if entry.gen < s.gen {
+			delete(s.entries, key)
+		}
+	}
+	s.gen++
+}
+
+// keep returns the state of scope kept in s for settings, created by create
+// if there is none. Settings are compared by value, so they must not hold
+// pointers.
+func keep[T any](s *State, scope string, settings any, create func() T) T {
+	if s == nil {
+		return create()
+	}
+
+	key := scope
+	if settings != nil {
+		key += fmt.Sprintf("|%#v", settings)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if entry, ok := s.entries[key]; ok {
+		if value, ok := entry.value.(T); ok {
+			entry.gen = s.gen
+			return value
+		}
+	}
+	value := create()
+	s.entries[key] = &stateEntry{value: value, gen: s.gen}
+	return value
+}
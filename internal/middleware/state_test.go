@@ -0,0 +1,96 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gateway/template/internal/config"
+	"github.com/gateway/template/pkg/auth"
+	"github.com/gateway/template/pkg/logger"
+)
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+})
+
+func TestStateKeepsHMACSignaturesAcrossRebuilds(t *testing.T) {
+	state := NewState()
+	cfg := &config.HMACConfig{Keys: map[string]string{"partner": "secret"}}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	signature := auth.SignRequest([]byte("secret"), timestamp, http.MethodPost, "/hook", []byte("{}"))
+	send := func(h http.Handler) int {
+		req := httptest.NewRequest(http.MethodPost, "/hook", strings.NewReader("{}"))
+		req.Header.Set(auth.SignatureHeader, signature)
+		req.Header.Set(auth.TimestampHeader, timestamp)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := send(HMACAuth("hooks", cfg, state, logger.NewMockLogger())(okHandler)); code != http.StatusOK {
+		t.Fatalf("expected the first request to pass, got %d", code)
+	}
+	state.Sweep()
+
+	// a reload rebuilds the middleware, the signature must stay used
+	rebuilt := HMACAuth("hooks", cfg, state, logger.NewMockLogger())(okHandler)
+	if code := send(rebuilt); code != http.StatusUnauthorized {
+		t.Errorf("expected the replayed signature to be rejected after a rebuild, got %d", code)
+	}
+}
+
+func TestStateKeepsRateLimitsWithTheSameSettings(t *testing.T) {
+	state := NewState()
+	cfg := &config.RateLimitConfig{Rate: 1, Burst: 1}
+	send := func(h http.Handler) int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		return rec.Code
+	}
+
+	if code := send(RateLimit(cfg, nil, state, logger.NewMockLogger())(okHandler)); code != http.StatusOK {
+		t.Fatalf("expected the first request to pass, got %d", code)
+	}
+	state.Sweep()
+
+	if code := send(RateLimit(cfg, nil, state, logger.NewMockLogger())(okHandler)); code != http.StatusTooManyRequests {
+		t.Errorf("expected the limit to carry over a rebuild, got %d", code)
+	}
+
+	// other settings start over
+	changed := &config.RateLimitConfig{Rate: 1, Burst: 2}
+	if code := send(RateLimit(changed, nil, state, logger.NewMockLogger())(okHandler)); code != http.StatusOK {
+		t.Errorf("expected changed settings to start with a full limit, got %d", code)
+	}
+}
+
+func TestStateSweepForgetsUnusedState(t *testing.T) {
+	state := NewState()
+	created := 0
+	create := func() *int { created++; return new(int) }
+
+	keep(state, "a", nil, create)
+	keep(state, "b", nil, create)
+	state.Sweep()
+
+	// the next build only uses a
+	keep(state, "a", nil, create)
+	state.Sweep()
+
+	keep(state, "a", nil, create)
+	keep(state, "b", nil, create)
+	if created != 3 {
+		t.Errorf("expected b to be created again after it was swept, created %d values", created)
+	}
+
+	var nilState *State
+	keep(nilState, "a", nil, create)
+	if created != 4 {
+		t.Errorf("expected a nil state to create fresh values")
+	}
+}
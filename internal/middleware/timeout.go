@@ -0,0 +1,173 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gateway/template/internal/metrics"
+	"github.com/gateway/template/internal/problem"
+	"github.com/gateway/template/pkg/logger"
+)
+
+var requestTimeouts = metrics.Default.Counter(
+	"gateway_request_timeouts_total",
+	"Number of requests answered with 504 because they exceeded their request timeout.",
+	"service",
+)
+
+// RequestTimeout returns a chi middleware that gives requests to a service
+// at most timeout, or the timeout of the most specific of paths matching
+// the request, for all middleware after it and the proxy together. A request
+// without a response when its time is up is answered with 504 while the
+// rest of its handling is cancelled; a response already started is cut off.
+// WebSockets are left to the proxy's handshake timeout.
+func RequestTimeout(service string, timeout time.Duration, paths map[string]time.Duration, log logger.Logger) func(next http.Handler) http.Handler {
+	if timeout <= 0 && len(paths) == 0 {
+		return func(next http.Handler) http.Handler {
+			return next
+		}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			limit := pathDuration(paths, r.URL.EscapedPath(), timeout)
+			if limit <= 0 || IsWebSocket(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(r.Context(), limit)
+			defer cancel()
+			tw := newTimeoutWriter(w)
+			done := make(chan struct{})
+			panicked := make(chan any, 1)
+			go func() {
+				defer func() {
+					if p := recover(); p != nil {
+						panicked <- p
+					}
+				}()
+				// a copy, as later handlers change the path while this one
+				// may still answer for the request
+				next.ServeHTTP(tw, r.Clone(ctx))
+				close(done)
+			}()
+
+			wait := func() {
+				select {
+				case <-done:
+				case p := <-panicked:
+					panic(p)
+				}
+			}
+
+			select {
+			case <-done:
+			case p := <-panicked:
+				panic(p)
+			case <-ctx.Done():
+				if r.Context().Err() != nil {
+					// the client went away, nobody is waiting for a response
+					wait()
+					return
+				}
+				if tw.timeOut() {
+					requestTimeouts.Inc(service)
+					log.Warn("request timed out",
+						"service", service,
+						"timeout", limit.String(),
+						"path", r.URL.Path,
+						"method", r.Method,
+					)
+					problem.Write(w, r, http.StatusGatewayTimeout, "request timed out")
+					return
+				}
+				// the response has started, the proxy notices the deadline
+				wait()
+			}
+		})
+	}
+}
+
+// timeoutWriter passes a response to the client until the request timed
+// out without having started it, then drops it. Headers are kept apart
+// until the response starts, so a late handler cannot change the 504's.
+type timeoutWriter struct {
+	http.ResponseWriter
+	header   http.Header
+	mu       sync.Mutex
+	started  bool
+	timedOut bool
+}
+
+// newTimeoutWriter creates a writer to w, starting with the headers outer
+// middleware set, e.g. the request ID
+func newTimeoutWriter(w http.ResponseWriter) *timeoutWriter {
+	return &timeoutWriter{ResponseWriter: w, header: w.Header().Clone()}
+}
+
+// timeOut marks the request as timed out, reporting false if its response
+// has already started
+func (tw *timeoutWriter) timeOut() bool {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.started {
+		return false
+	}
+	tw.timedOut = true
+	return true
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	tw.writeHeader(code)
+}
+
+// writeHeader sends the headers with code unless the request timed out,
+// tw.mu must be held
+func (tw *timeoutWriter) writeHeader(code int) {
+	if tw.timedOut || tw.started {
+		return
+	}
+	dst := tw.ResponseWriter.Header()
+	for name := range dst {
+		if _, ok := tw.header[name]; !ok {
+			dst.Del(name)
+		}
+	}
+	for name, values := range tw.header {
+		dst[name] = values
+	}
+	// informational responses, e.g. 103 Early Hints, do not start the response
+	if code >= 200 || code == http.StatusSwitchingProtocols {
+		tw.started = true
+	}
+	tw.ResponseWriter.WriteHeader(code)
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	tw.writeHeader(http.StatusOK)
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	return tw.ResponseWriter.Write(b)
+}
+
+// Flush sends buffered data unless the request timed out
+func (tw *timeoutWriter) Flush() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	tw.writeHeader(http.StatusOK)
+	if !tw.timedOut {
+		_ = http.NewResponseController(tw.ResponseWriter).Flush()
+	}
+}
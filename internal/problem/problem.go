@@ -0,0 +1,62 @@
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gateway/template/internal/requestid"
+)
+
+// ContentType is the media type for RFC 7807 problem details
+const ContentType = "application/problem+json"
+
+// Details is an RFC 7807 problem details object
+type Details struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+
+	// Service is the service the request was routed to, for error templates
+	Service string `json:"-"`
+}
+
+// New creates problem details for the given status and detail message.
+// The type is "about:blank" and the title is the standard status text.
+func New(r *http.Request, status int, detail string) *Details {
+	return &Details{
+		Type:      "about:blank",
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    detail,
+		Instance:  r.URL.Path,
+		RequestID: requestid.FromContext(r.Context()),
+		Service:   serviceFromContext(r.Context()),
+	}
+}
+
+// Write sends an application/problem+json response
+func Write(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	WriteDetails(w, New(r, status, detail))
+}
+
+// WriteDetails sends the given problem details as an application/problem+json
+// response, or the body of the template set for its status
+func WriteDetails(w http.ResponseWriter, p *Details) {
+	if t := templateFor(p.Status); t != nil {
+		if body, ok := t.render(p); ok {
+			w.Header().Set("Content-Type", t.contentType)
+			w.Header().Set("X-Content-Type-Options", "nosniff")
+			w.WriteHeader(p.Status)
+			_, _ = w.Write(body)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	_ = json.NewEncoder(w).Encode(p)
+}
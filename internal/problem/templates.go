@@ -0,0 +1,106 @@
+package problem
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+)
+
+// Template renders the bodies of error responses with one status
+type Template struct {
+	contentType string
+	tmpl        interface {
+		Execute(w io.Writer, data any) error
+	}
+}
+
+var (
+	templatesMu sync.RWMutex
+	templates   map[int]*Template
+)
+
+// SetTemplates replaces the process-wide templates used by WriteDetails by
+// status, errors without one are sent as problem details
+func SetTemplates(t map[int]*Template) {
+	templatesMu.Lock()
+	defer templatesMu.Unlock()
+	templates = t
+}
+
+func templateFor(status int) *Template {
+	templatesMu.RLock()
+	defer templatesMu.RUnlock()
+	return templates[status]
+}
+
+// ParseTemplates reads the template files by status. Files ending in .html
+// or .htm are HTML templates escaping their fields, .json files are JSON
+// templates with a json function encoding a field as a JSON value, others
+// are plain text. Templates are executed with the Details of the error.
+func ParseTemplates(files map[int]string) (map[int]*Template, error) {
+	parsed := make(map[int]*Template, len(files))
+	for status, file := range files {
+		source, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read error template: %w", err)
+		}
+
+		t := &Template{}
+		name := filepath.Base(file)
+		switch strings.ToLower(filepath.Ext(file)) {
+		case ".html", ".htm":
+			t.contentType = "text/html; charset=utf-8"
+			t.tmpl, err = htmltemplate.New(name).Parse(string(source))
+		case ".json":
+			t.contentType = "application/json"
+			t.tmpl, err = texttemplate.New(name).Funcs(texttemplate.FuncMap{"json": jsonValue}).Parse(string(source))
+		default:
+			t.contentType = "text/plain; charset=utf-8"
+			t.tmpl, err = texttemplate.New(name).Parse(string(source))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid error template for status %d: %w", status, err)
+		}
+		parsed[status] = t
+	}
+	return parsed, nil
+}
+
+// render executes the template with p, reporting false if it failed
+func (t *Template) render(p *Details) ([]byte, bool) {
+	var buf bytes.Buffer
+	if err := t.tmpl.Execute(&buf, p); err != nil {
+		return nil, false
+	}
+	return buf.Bytes(), true
+}
+
+// jsonValue encodes v as a JSON value for JSON templates, e.g.
+// {"message": {{json .Detail}}}
+func jsonValue(v any) (string, error) {
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+// serviceContextKey is the context key for the service a request is routed to
+type serviceContextKey struct{}
+
+// WithService returns a copy of ctx naming the service a request is routed
+// to, for the error templates
+func WithService(ctx context.Context, service string) context.Context {
+	return context.WithValue(ctx, serviceContextKey{}, service)
+}
+
+// serviceFromContext returns the service named in ctx, or an empty string
+func serviceFromContext(ctx context.Context) string {
+	service, _ := ctx.Value(serviceContextKey{}).(string)
+	return service
+}
@@ -0,0 +1,140 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gateway/template/internal/config"
+	"github.com/gateway/template/internal/middleware"
+)
+
+// ringPoints is the number of points an upstream of weight 1 has on a hash
+// ring, more points spread keys more evenly
+const ringPoints = 160
+
+// hashRing maps keys to upstreams by consistent hashing, so adding or
+// removing an upstream only moves the keys it gains or loses
+type hashRing struct {
+	points    []uint64    // sorted hashes of the upstreams' points
+	upstreams []*upstream // owner of each point
+}
+
+// newHashRing creates a ring of the upstreams, each with points in
+// proportion to its weight
+func newHashRing(upstreams []*upstream) *hashRing {
+	divisor := 0
+	for _, u := range upstreams {
+		divisor = gcd(divisor, u.weight)
+	}
+
+	type point struct {
+		hash     uint64
+		upstream *upstream
+	}
+	var points []point
+	for _, u := range upstreams {
+		if u.weight <= 0 {
+			continue
+		}
+		for i := range ringPoints * u.weight / divisor {
+			points = append(points, point{hashKey(u.target.String() + "#" + strconv.Itoa(i)), u})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].hash < points[j].hash })
+
+	ring := &hashRing{points: make([]uint64, len(points)), upstreams: make([]*upstream, len(points))}
+	for i, p := range points {
+		ring.points[i], ring.upstreams[i] = p.hash, p.upstream
+	}
+	return ring
+}
+
+// pick returns the upstream owning key, that of the first point at or after
+// its hash. Keys of an unavailable upstream move on to the next available
+// one until it recovers.
+func (h *hashRing) pick(key string) *upstream {
+	hash := hashKey(key)
+	i := sort.Search(len(h.points), func(i int) bool { return h.points[i] >= hash })
+	for n := range len(h.points) {
+		if u := h.upstreams[(i+n)%len(h.points)]; u.available() {
+			return u
+		}
+	}
+	return h.upstreams[i%len(h.points)]
+}
+
+// hashKey hashes a key onto the ring, the same on every gateway instance
+func hashKey(key string) uint64 {
+	sum := sha256.Sum256([]byte(key))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+// affinity pins clients to upstreams by hashing their IP, affinity cookie
+// or user ID
+type affinity struct {
+	by     string // ip, cookie or sub
+	cookie string
+	ring   *hashRing
+}
+
+// assign issues an affinity cookie to clients without one, the request
+// carries it from then on so it is routed like later ones
+func (a *affinity) assign(w http.ResponseWriter, r *http.Request) {
+	if a.by != "cookie" {
+		return
+	}
+	if _, err := r.Cookie(a.cookie); err == nil {
+		return
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return
+	}
+	cookie := &http.Cookie{
+		Name:     a.cookie,
+		Value:    hex.EncodeToString(id),
+		Path:     "/",
+		Secure:   r.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	http.SetCookie(w, cookie)
+	r.AddCookie(cookie)
+}
+
+// key returns the value a request is hashed by, empty if it has none
+func (a *affinity) key(r *http.Request) string {
+	switch a.by {
+	case "ip":
+		// the client IP, the connection's address unless it comes from a
+		// trusted proxy
+		return middleware.GetClientIP(r)
+	case "cookie":
+		if cookie, err := r.Cookie(a.cookie); err == nil {
+			return cookie.Value
+		}
+	case "sub":
+		userID, _ := middleware.GetUserIDFromContext(r.Context())
+		return userID
+	}
+	return ""
+}
+
+// newAffinity creates the affinity of a service's upstreams, nil if it has
+// none configured
+func newAffinity(cfg *config.AffinityConfig, upstreams []*upstream) *affinity {
+	if cfg.By == "" {
+		return nil
+	}
+	cookie := cfg.Cookie
+	if cookie == "" {
+		cookie = "gateway_affinity"
+	}
+	return &affinity{by: cfg.By, cookie: cookie, ring: newHashRing(upstreams)}
+}
@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gateway/template/internal/config"
+	"github.com/gateway/template/internal/metrics"
+)
+
+var (
+	backoffApplied = metrics.Default.Counter(
+		"gateway_backoff_applied_total",
+		"Number of times a backend signalled overload and backoff was applied.",
+		"service", "status",
+	)
+	backoffSeconds = metrics.Default.Gauge(
+		"gateway_backoff_seconds",
+		"Length in seconds of the most recently applied backoff window.",
+		"service",
+	)
+	backoffThrottled = metrics.Default.Counter(
+		"gateway_backoff_throttled_total",
+		"Number of requests rejected by the gateway while a backend was backing off.",
+		"service",
+	)
+	backoffQueued = metrics.Default.Counter(
+		"gateway_backoff_queued_total",
+		"Number of requests delayed until a backend's backoff window expired.",
+		"service",
+	)
+)
+
+// backoff tracks the window during which a backend should not receive traffic.
+type backoff struct {
+	cfg   *config.BackoffConfig
+	mu    sync.Mutex
+	until time.Time
+}
+
+// newBackoff creates a backoff tracker
+func newBackoff(cfg *config.BackoffConfig) *backoff {
+	return &backoff{cfg: cfg}
+}
+
+// remaining returns how long the backend is still backing off
+func (b *backoff) remaining(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if now.After(b.until) {
+		return 0
+	}
+	return b.until.Sub(now)
+}
+
+// apply extends the backoff window based on the response and returns its length.
+// Windows never shrink: a shorter Retry-After does not cut an existing window.
+func (b *backoff) apply(resp *http.Response, now time.Time) time.Duration {
+	delay, ok := parseRetryAfter(resp.Header.Get("Retry-After"), now)
+	if !ok {
+		delay = b.cfg.DefaultDelay
+	}
+	if b.cfg.MaxDelay > 0 && delay > b.cfg.MaxDelay {
+		delay = b.cfg.MaxDelay
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if until := now.Add(delay); until.After(b.until) {
+		b.until = until
+	}
+	return delay
+}
+
+// isOverloadStatus reports whether the status code signals backend overload
+func isOverloadStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code == http.StatusServiceUnavailable
+}
+
+// parseRetryAfter parses a Retry-After header in delta-seconds or HTTP-date form
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		delay := date.Sub(now)
+		if delay < 0 {
+			delay = 0
+		}
+		return delay, true
+	}
+
+	return 0, false
+}
+
+// retryAfterSeconds formats a duration as a Retry-After value, rounding up
+func retryAfterSeconds(d time.Duration) string {
+	seconds := int((d + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return strconv.Itoa(seconds)
+}
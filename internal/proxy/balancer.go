@@ -0,0 +1,300 @@
+package proxy
+
+import (
+	"context"
+	"math/rand"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// latencyDecay is the weight of a new sample in an upstream's average
+	// latency
+	latencyDecay = 0.2
+
+	// latencyTTL is how long a latency average is trusted. An upstream
+	// avoided for being slow gets requests again once its average is this
+	// old, to find out whether it recovered.
+	latencyTTL = 10 * time.Second
+
+	// slowStartMinFactor is the share of its weight an upstream receives
+	// right after becoming available again, so it is not left idle
+	slowStartMinFactor = 0.1
+)
+
+// upstream is a single endpoint of a service
+type upstream struct {
+	target   *url.URL
+	endpoint *url.URL // requests are sent to, the target's logical host for Unix sockets
+	proxy    *httputil.ReverseProxy
+	weight   int // relative share of requests, 0 receives none
+
+	// slowStart is the time an upstream's share ramps up over after it
+	// became available again at availableSince (Unix nanoseconds), 0 for
+	// no ramp
+	slowStart      time.Duration
+	availableSince atomic.Int64
+
+	unhealthy    atomic.Bool  // failing its health checks, receives no requests
+	ejectedUntil atomic.Int64 // Unix nanoseconds until which it is ejected as an outlier
+	failures     atomic.Int64 // consecutive failed requests
+	inFlight     atomic.Int64 // requests sent and not yet answered
+
+	mu        sync.Mutex
+	latency   time.Duration // exponentially weighted moving average
+	samples   int           // in the average
+	sampledAt time.Time
+}
+
+// available reports whether an upstream may receive requests, it passes
+// its health checks and is not ejected as an outlier
+func (u *upstream) available() bool {
+	if u.unhealthy.Load() {
+		return false
+	}
+	until := u.ejectedUntil.Load()
+	return until == 0 || time.Now().UnixNano() >= until
+}
+
+// recovered starts the slow start of an upstream becoming available at t
+func (u *upstream) recovered(t time.Time) {
+	if u.slowStart > 0 {
+		u.availableSince.Store(t.UnixNano())
+	}
+}
+
+// slowStartFactor returns the share of its weight an upstream receives,
+// rising linearly to 1 over the slow start after it became available again
+func (u *upstream) slowStartFactor(now time.Time) float64 {
+	since := u.availableSince.Load()
+	if since == 0 {
+		return 1
+	}
+	elapsed := now.Sub(time.Unix(0, since))
+	if elapsed >= u.slowStart {
+		return 1
+	}
+	return max(slowStartMinFactor, float64(elapsed)/float64(u.slowStart))
+}
+
+// admit reports whether a request picked for an upstream may go to it,
+// upstreams in slow start turn away the requests beyond their share
+func (u *upstream) admit(now time.Time) bool {
+	factor := u.slowStartFactor(now)
+	return factor >= 1 || rand.Float64() < factor
+}
+
+// anyAvailable reports whether any of the upstreams is available
+func anyAvailable(upstreams []*upstream) bool {
+	for _, u := range upstreams {
+		if u.available() {
+			return true
+		}
+	}
+	return false
+}
+
+// observeLatency adds the time an upstream took to respond to its average
+func (u *upstream) observeLatency(d time.Duration, now time.Time) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.samples == 0 || now.Sub(u.sampledAt) > latencyTTL {
+		u.latency = d
+		u.samples = 0
+	} else {
+		u.latency = time.Duration(latencyDecay*float64(d) + (1-latencyDecay)*float64(u.latency))
+	}
+	u.samples++
+	u.sampledAt = now
+}
+
+// averageLatency returns the average latency of an upstream and the number
+// of samples in it, 0 if it has not been measured recently
+func (u *upstream) averageLatency(now time.Time) (time.Duration, int) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.samples == 0 || now.Sub(u.sampledAt) > latencyTTL {
+		return 0, 0
+	}
+	return u.latency, u.samples
+}
+
+// resetLatency discards the average latency of an upstream
+func (u *upstream) resetLatency() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.samples = 0
+}
+
+// balancer chooses the upstream of each request among a service's upstreams
+type balancer interface {
+	pick() *upstream
+}
+
+// newBalancer creates the balancer of a strategy, see config.TargetConfig
+func newBalancer(strategy string, upstreams []*upstream) balancer {
+	switch strategy {
+	case "least-connections":
+		return &leastLoaded{upstreams: upstreams}
+	case "least-latency":
+		return &leastLoaded{upstreams: upstreams, byLatency: true}
+	default:
+		return newWeightedRoundRobin(upstreams)
+	}
+}
+
+// roundRobin spreads requests across a service's upstreams, evenly or in
+// proportion to their weights
+type roundRobin struct {
+	upstreams []*upstream
+	schedule  []int // indexes of upstreams in the order they are picked, nil picks them in turn
+	next      atomic.Uint64
+}
+
+// newWeightedRoundRobin creates a balancer sending each upstream a share of
+// requests proportional to its weight, upstreams of weight 0 receive none
+func newWeightedRoundRobin(upstreams []*upstream) *roundRobin {
+	b := &roundRobin{upstreams: upstreams}
+	weights := make([]int, len(upstreams))
+	equal := true
+	for i, u := range upstreams {
+		weights[i] = u.weight
+		equal = equal && u.weight == upstreams[0].weight
+	}
+	if !equal {
+		b.schedule = weightedSchedule(weights)
+	}
+	return b
+}
+
+// weightedSchedule interleaves upstream indexes in proportion to their
+// weights with nginx's smooth weighted round-robin, so a heavy upstream
+// is not sent its whole share in one burst
+func weightedSchedule(weights []int) []int {
+	divisor, total := 0, 0
+	for _, w := range weights {
+		divisor = gcd(divisor, w)
+	}
+	reduced := make([]int, len(weights))
+	for i, w := range weights {
+		reduced[i] = w / divisor
+		total += reduced[i]
+	}
+
+	schedule := make([]int, 0, total)
+	current := make([]int, len(weights))
+	for range total {
+		best := -1
+		for i, w := range reduced {
+			current[i] += w
+			if w > 0 && (best < 0 || current[i] > current[best]) {
+				best = i
+			}
+		}
+		current[best] -= total
+		schedule = append(schedule, best)
+	}
+	return schedule
+}
+
+// gcd returns the greatest common divisor of a and b
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// pick returns the upstream for the next request, skipping unavailable
+// upstreams unless all are, and upstreams in slow start for requests
+// beyond their share
+func (b *roundRobin) pick() *upstream {
+	if len(b.upstreams) == 1 {
+		return b.upstreams[0]
+	}
+	n := b.next.Add(1) - 1
+	size := uint64(len(b.upstreams))
+	if b.schedule != nil {
+		size = uint64(len(b.schedule))
+	}
+	now := time.Now()
+	var fallback *upstream
+	for i := uint64(0); i < size; i++ {
+		u := b.at(n + i)
+		if !u.available() {
+			continue
+		}
+		if u.admit(now) {
+			return u
+		}
+		if fallback == nil {
+			fallback = u
+		}
+	}
+	if fallback != nil {
+		return fallback
+	}
+	return b.at(n)
+}
+
+// at returns the upstream at position n of the rotation
+func (b *roundRobin) at(n uint64) *upstream {
+	if b.schedule != nil {
+		return b.upstreams[b.schedule[n%uint64(len(b.schedule))]]
+	}
+	return b.upstreams[n%uint64(len(b.upstreams))]
+}
+
+// leastLoaded sends each request to the available upstream with the fewest
+// requests in flight relative to its weight, reduced during slow start,
+// or with byLatency to the one with the
+// lowest average latency times its requests in flight, so a slow upstream
+// gets fewer requests without the fastest one being flooded
+type leastLoaded struct {
+	upstreams []*upstream
+	byLatency bool
+	next      atomic.Uint64 // rotates the scan so ties are spread
+}
+
+// pick returns the upstream for the next request
+func (b *leastLoaded) pick() *upstream {
+	if len(b.upstreams) == 1 {
+		return b.upstreams[0]
+	}
+
+	now := time.Now()
+	offset := int(b.next.Add(1) % uint64(len(b.upstreams)))
+	var best *upstream
+	var bestScore float64
+	for i := range b.upstreams {
+		u := b.upstreams[(offset+i)%len(b.upstreams)]
+		if u.weight <= 0 || !u.available() {
+			continue
+		}
+		load := float64(u.inFlight.Load() + 1)
+		if b.byLatency {
+			// unmeasured upstreams score by their load alone, so they are tried
+			latency, _ := u.averageLatency(now)
+			load *= 1 + latency.Seconds()*1000
+		}
+		if score := load / (float64(u.weight) * u.slowStartFactor(now)); best == nil || score < bestScore {
+			best, bestScore = u, score
+		}
+	}
+	if best == nil {
+		return b.upstreams[offset]
+	}
+	return best
+}
+
+// upstreamKey is the context key of the upstream chosen for a request
+type upstreamKey struct{}
+
+// upstreamFromContext returns the upstream chosen for a request, if any
+func upstreamFromContext(ctx context.Context) *upstream {
+	u, _ := ctx.Value(upstreamKey{}).(*upstream)
+	return u
+}
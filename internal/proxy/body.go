@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxModifiedBody is the largest response body passed to body modifiers of
+// services without a max response size. Larger bodies are sent unmodified.
+const maxModifiedBody = 10 << 20
+
+// bodyModifier rewrites the complete body of an upstream response, returning
+// the body to send instead
+type bodyModifier func(resp *http.Response, body []byte) ([]byte, error)
+
+// modifyBody passes the body of resp through the service's body modifiers.
+// Gzip-encoded bodies are decoded for them and sent decoded if modified,
+// bodies in other encodings, larger bodies and event streams, which never
+// end, are sent as they are.
+func (rp *ReverseProxy) modifyBody(resp *http.Response) error {
+	if len(rp.bodyModifiers) == 0 || resp.Body == nil || resp.Body == http.NoBody ||
+		resp.Request.Method == http.MethodHead {
+		return nil
+	}
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType == "text/event-stream" {
+		return nil
+	}
+	encoding := strings.ToLower(resp.Header.Get("Content-Encoding"))
+	if encoding != "" && encoding != "identity" && encoding != "gzip" {
+		return nil
+	}
+
+	limit := rp.cfg.MaxResponseSize
+	if limit <= 0 {
+		limit = maxModifiedBody
+	}
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		resp.Body.Close()
+		return err
+	}
+	if int64(len(raw)) > limit {
+		rp.requestLog(resp.Request).Warn("response body too large to modify, sending it unmodified",
+			"target", rp.targetOf(resp.Request),
+			"limit", limit,
+		)
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(raw), resp.Body), resp.Body}
+		return nil
+	}
+	resp.Body.Close()
+
+	body := raw
+	if encoding == "gzip" {
+		zr, err := gzip.NewReader(bytes.NewReader(raw))
+		if err != nil {
+			return err
+		}
+		if body, err = io.ReadAll(io.LimitReader(zr, limit+1)); err != nil {
+			return err
+		}
+		if int64(len(body)) > limit {
+			resp.Body = io.NopCloser(bytes.NewReader(raw))
+			return nil
+		}
+	}
+
+	modified := body
+	for _, modify := range rp.bodyModifiers {
+		if modified, err = modify(resp, modified); err != nil {
+			return err
+		}
+	}
+
+	if bytes.Equal(modified, body) {
+		resp.Body = io.NopCloser(bytes.NewReader(raw))
+		return nil
+	}
+	resp.Header.Del("Content-Encoding")
+	// the representation changed, it is at most equivalent to the upstream's
+	if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		resp.Header.Set("ETag", "W/"+etag)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(modified))
+	resp.ContentLength = int64(len(modified))
+	resp.TransferEncoding = nil
+	resp.Header.Set("Content-Length", strconv.Itoa(len(modified)))
+	return nil
+}
@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gateway/template/internal/config"
+)
+
+// deadlineHeader returns the deadline header of a service, the proxy's
+// unless it sets its own or none
+func deadlineHeader(cfg *config.ProxyConfig, target config.TargetConfig) string {
+	switch {
+	case strings.EqualFold(target.DeadlineHeader, "none"):
+		return ""
+	case target.DeadlineHeader != "":
+		return target.DeadlineHeader
+	}
+	return cfg.DeadlineHeader
+}
+
+// setDeadlineHeader tells the upstream the time left until the deadline of
+// req in header, replacing any value sent by the client: the gRPC format
+// for grpc-timeout, e.g. 1500m, whole milliseconds otherwise. Requests
+// without a deadline get none.
+func setDeadlineHeader(req *http.Request, header string) {
+	req.Header.Del(header)
+	deadline, ok := req.Context().Deadline()
+	if !ok {
+		return
+	}
+	// a request past its deadline fails anyway, backends may reject 0
+	left := max(time.Until(deadline), time.Millisecond)
+	if strings.EqualFold(header, "grpc-timeout") {
+		req.Header.Set(header, grpcTimeout(left))
+		return
+	}
+	req.Header.Set(header, strconv.FormatInt(left.Milliseconds(), 10))
+}
+
+// grpcTimeout formats d as a gRPC timeout of at most 8 digits in the finest
+// unit it fits, rounded down so the upstream never gets more time than left,
+// but at least 1 of the unit
+func grpcTimeout(d time.Duration) string {
+	for _, unit := range []struct {
+		size   time.Duration
+		suffix string
+	}{
+		{time.Nanosecond, "n"},
+		{time.Microsecond, "u"},
+		{time.Millisecond, "m"},
+		{time.Second, "S"},
+		{time.Minute, "M"},
+	} {
+		if value := d / unit.size; value < 1e8 {
+			return strconv.FormatInt(max(int64(value), 1), 10) + unit.suffix
+		}
+	}
+	return strconv.FormatInt(max(int64(d/time.Hour), 1), 10) + "H"
+}
@@ -0,0 +1,34 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGRPCTimeout(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{0, "1n"},
+		{time.Nanosecond, "1n"},
+		{99999999 * time.Nanosecond, "99999999n"},
+		{100 * time.Millisecond, "100000u"},
+		{100*time.Millisecond + 999*time.Nanosecond, "100000u"},
+		{99999999*time.Microsecond + 999*time.Nanosecond, "99999999u"},
+		{100 * time.Second, "100000m"},
+		{100*time.Second + 999*time.Microsecond, "100000m"},
+		{99999999*time.Millisecond + 999*time.Microsecond, "99999999m"},
+		{100000 * time.Second, "100000S"},
+		{100000*time.Second + 999*time.Millisecond, "100000S"},
+		{99999999*time.Second + 999*time.Millisecond, "99999999S"},
+		{100000000 * time.Second, "1666666M"},
+		{1e8 * time.Minute, "1666666H"},
+		{1e8*time.Minute + 59*time.Minute, "1666667H"},
+	}
+	for _, tt := range tests {
+		if got := grpcTimeout(tt.d); got != tt.want {
+			t.Errorf("grpcTimeout(%s) = %s, want %s", tt.d, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/gateway/template/internal/metrics"
+	"github.com/gateway/template/pkg/logger"
+)
+
+var dnsChanges = metrics.Default.Counter(
+	"gateway_upstream_dns_changes_total",
+	"Number of times the resolved addresses of an upstream host changed.",
+	"service",
+)
+
+// rotatingTransport delegates to a transport that can be replaced at runtime.
+// Replacing it moves new requests to fresh connections while requests in
+// flight finish on the old ones.
+type rotatingTransport struct {
+	current atomic.Pointer[http.Transport]
+}
+
+func newRotatingTransport(t *http.Transport) *rotatingTransport {
+	rt := &rotatingTransport{}
+	rt.current.Store(t)
+	return rt
+}
+
+// RoundTrip implements http.RoundTripper
+func (rt *rotatingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return rt.current.Load().RoundTrip(req)
+}
+
+// rotate switches to a fresh transport with the same settings and drains
+// the old one. Connections still busy after grace are closed once idle.
+func (rt *rotatingTransport) rotate(grace time.Duration) {
+	old := rt.current.Load()
+	rt.current.Store(old.Clone())
+
+	old.CloseIdleConnections()
+	time.AfterFunc(grace, old.CloseIdleConnections)
+}
+
+// CloseIdleConnections closes idle connections of the current transport
+func (rt *rotatingTransport) CloseIdleConnections() {
+	rt.current.Load().CloseIdleConnections()
+}
+
+// dnsRefresher periodically re-resolves an upstream host and rotates the
+// transport's connections when its addresses change, so connection reuse
+// does not pin traffic to the IPs of a previous deployment
+type dnsRefresher struct {
+	host      string
+	service   string
+	interval  time.Duration
+	grace     time.Duration
+	transport *rotatingTransport
+	resolver  *net.Resolver
+	log       logger.Logger
+	addrs     []string
+}
+
+// run re-resolves the host every interval until ctx is done
+func (d *dnsRefresher) run(ctx context.Context) {
+	// IP literals never change
+	if net.ParseIP(d.host) != nil {
+		return
+	}
+
+	d.addrs, _ = d.lookup(ctx)
+
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.refresh(ctx)
+		}
+	}
+}
+
+// refresh resolves the host once and rotates connections if the addresses changed
+func (d *dnsRefresher) refresh(ctx context.Context) {
+	addrs, err := d.lookup(ctx)
+	if err != nil {
+		// keep the current connections, the backend may still be reachable
+		d.log.Warn("failed to re-resolve upstream host", "host", d.host, "error", err)
+		return
+	}
+
+	if slices.Equal(addrs, d.addrs) {
+		return
+	}
+
+	d.log.Info("upstream addresses changed, rotating connections",
+		"host", d.host,
+		"previous", d.addrs,
+		"current", addrs,
+	)
+	dnsChanges.Inc(d.service)
+	d.addrs = addrs
+	d.transport.rotate(d.grace)
+}
+
+// lookup returns the sorted addresses of the host
+func (d *dnsRefresher) lookup(ctx context.Context) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	addrs, err := d.resolver.LookupHost(ctx, d.host)
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(addrs)
+	return addrs, nil
+}
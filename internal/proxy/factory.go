@@ -13,27 +13,20 @@ type Factory struct {
 	log     logger.Logger
 }
 
-// NewFactory creates a new proxy factory with multiple reverse proxies.
-func NewFactory(cfg *config.ProxyConfig, log logger.Logger) (*Factory, error) {
-	if len(cfg.Targets) == 0 {
-		return nil, fmt.Errorf("no proxy targets configured")
-	}
-
+// NewFactory creates a new proxy factory with multiple reverse proxies,
+// each customized by opts.
+func NewFactory(cfg *config.ProxyConfig, log logger.Logger, opts ...Option) (*Factory, error) {
 	proxies := make(map[string]*ReverseProxy)
 
 	for name, targetCfg := range cfg.Targets {
-		// create a single proxy config for this target
-		singleCfg := &config.ProxyConfig{
-			Targets: map[string]config.TargetConfig{
-				name: targetCfg,
-			},
-			Timeout: cfg.Timeout,
-		}
-
-		// create proxy
-		proxy, err := New(singleCfg, targetCfg.URL, log, name)
+		proxy, err := NewForTarget(cfg, name, targetCfg, log, opts...)
 		if err != nil {
-			return nil, fmt.Errorf("failed to create proxy for %q: %w", name, err)
+			// stop the health checks and close the recordings of the
+			// proxies created so far
+			for _, created := range proxies {
+				created.Close()
+			}
+			return nil, err
 		}
 
 		proxies[name] = proxy
@@ -46,6 +39,32 @@ func NewFactory(cfg *config.ProxyConfig, log logger.Logger) (*Factory, error) {
 	}, nil
 }
 
+// NewForTarget creates a proxy for a single target, applying the target's
+// overrides of the shared proxy settings.
+func NewForTarget(cfg *config.ProxyConfig, name string, targetCfg config.TargetConfig, log logger.Logger, opts ...Option) (*ReverseProxy, error) {
+	if targetCfg.DNSRefresh == 0 {
+		targetCfg.DNSRefresh = cfg.DNSRefresh
+	}
+
+	// create a single proxy config for this target
+	singleCfg := *cfg
+	singleCfg.Targets = map[string]config.TargetConfig{
+		name: targetCfg,
+	}
+	if targetCfg.Timeout > 0 {
+		singleCfg.Timeout = targetCfg.Timeout
+	}
+	if targetCfg.MaxResponseSize > 0 {
+		singleCfg.MaxResponseSize = targetCfg.MaxResponseSize
+	}
+
+	proxy, err := New(&singleCfg, targetCfg.URL, log, name, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create proxy for %q: %w", name, err)
+	}
+	return proxy, nil
+}
+
 // Get returns a proxy by service name.
 func (f *Factory) Get(name string) (*ReverseProxy, bool) {
 	proxy, ok := f.proxies[name]
@@ -57,6 +76,13 @@ func (f *Factory) All() map[string]*ReverseProxy {
 	return f.proxies
 }
 
+// Close stops background work of all proxies.
+func (f *Factory) Close() {
+	for _, proxy := range f.proxies {
+		proxy.Close()
+	}
+}
+
 // Services returns a list of all configured service names.
 func (f *Factory) Services() []string {
 	services := make([]string, 0, len(f.proxies))
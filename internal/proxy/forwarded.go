@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// forwardedElement returns the RFC 7239 Forwarded element describing the
+// hop of req to the gateway, e.g.
+// for=192.0.2.60;proto=https;host=api.example.com;by=10.0.0.5
+func forwardedElement(req *http.Request) string {
+	params := []string{"for=" + forwardedNode(req.RemoteAddr)}
+	if req.TLS != nil {
+		params = append(params, "proto=https")
+	} else {
+		params = append(params, "proto=http")
+	}
+	if req.Host != "" {
+		params = append(params, "host="+forwardedValue(req.Host))
+	}
+	if local, ok := req.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		params = append(params, "by="+forwardedNode(local.String()))
+	}
+	return strings.Join(params, ";")
+}
+
+// forwardedNode formats the IP of addr as a node identifier, IPv6
+// addresses are bracketed and quoted. Addresses that are no IP, e.g. of
+// Unix socket connections, are obfuscated as "unknown".
+func forwardedNode(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return "unknown"
+	}
+	if ip.Is4() || ip.Is4In6() {
+		return ip.Unmap().String()
+	}
+	return `"[` + ip.String() + `]"`
+}
+
+// forwardedValue returns v as a token, or as a quoted string if it contains
+// other characters such as the colon of a port
+func forwardedValue(v string) string {
+	for _, c := range v {
+		if !isTokenChar(c) {
+			return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(v) + `"`
+		}
+	}
+	return v
+}
+
+// isTokenChar reports whether c may appear in an HTTP token (RFC 9110)
+func isTokenChar(c rune) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		return true
+	}
+	return strings.ContainsRune("!#$%&'*+-.^_`|~", c)
+}
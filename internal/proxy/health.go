@@ -0,0 +1,158 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gateway/template/internal/config"
+	"github.com/gateway/template/internal/metrics"
+	"github.com/gateway/template/pkg/logger"
+)
+
+var (
+	upstreamHealthy = metrics.Default.Gauge(
+		"gateway_upstream_healthy",
+		"Whether an upstream passes its health checks (1) or not (0).",
+		"service", "upstream",
+	)
+	upstreamHealthChanges = metrics.Default.Counter(
+		"gateway_upstream_health_changes_total",
+		"Number of times upstreams became healthy or unhealthy, by new state.",
+		"service", "state",
+	)
+)
+
+// healthChecker periodically requests the health check path of a service's
+// upstreams and marks them unhealthy or healthy again after enough
+// consecutive failed or passed checks
+type healthChecker struct {
+	service string
+	cfg     config.HealthCheckConfig
+	client  *http.Client
+	states  []*healthState
+	log     logger.Logger
+}
+
+// healthState holds the consecutive check results of an upstream, only
+// touched by its check
+type healthState struct {
+	upstream  *upstream
+	failures  int
+	successes int
+}
+
+// newHealthChecker creates the health checker of a service's upstreams,
+// nil if health checks are disabled
+func newHealthChecker(service string, cfg config.HealthCheckConfig, upstreams []*upstream, log logger.Logger) *healthChecker {
+	if cfg.Path == "" {
+		return nil
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = 10 * time.Second
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 2 * time.Second
+	}
+	if cfg.UnhealthyThreshold <= 0 {
+		cfg.UnhealthyThreshold = 2
+	}
+	if cfg.HealthyThreshold <= 0 {
+		cfg.HealthyThreshold = 1
+	}
+
+	h := &healthChecker{
+		service: service,
+		cfg:     cfg,
+		client: &http.Client{
+			Timeout: cfg.Timeout,
+			// a redirect is an answer, following it would check another host
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		log: log,
+	}
+	for _, u := range upstreams {
+		h.states = append(h.states, &healthState{upstream: u})
+		upstreamHealthy.Set(1, service, u.target.Redacted())
+	}
+	return h
+}
+
+// run checks all upstreams every interval until ctx is done
+func (h *healthChecker) run(ctx context.Context) {
+	ticker := time.NewTicker(h.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		var wg sync.WaitGroup
+		for _, state := range h.states {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				h.check(ctx, state)
+			}()
+		}
+		wg.Wait()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// check requests the health check path of an upstream and updates its
+// health, 2xx and 3xx responses pass
+func (h *healthChecker) check(ctx context.Context, state *healthState) {
+	u := state.upstream
+	status := 0
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.endpoint.JoinPath(h.cfg.Path).String(), nil)
+	if err == nil {
+		req.Header.Set("User-Agent", "gateway-health-check")
+		// checks go over the connections of the upstream's requests
+		client := *h.client
+		client.Transport = u.proxy.Transport
+		var resp *http.Response
+		if resp, err = client.Do(req); err == nil {
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+			resp.Body.Close()
+			status = resp.StatusCode
+		}
+	}
+	if ctx.Err() != nil {
+		// shutting down, not a result
+		return
+	}
+
+	if err == nil && status < http.StatusBadRequest {
+		state.failures = 0
+		state.successes++
+		if u.unhealthy.Load() && state.successes >= h.cfg.HealthyThreshold {
+			u.unhealthy.Store(false)
+			u.recovered(time.Now())
+			upstreamHealthy.Set(1, h.service, u.target.Redacted())
+			upstreamHealthChanges.Inc(h.service, "healthy")
+			h.log.Info("upstream is healthy again", "upstream", u.target.Redacted())
+		}
+		return
+	}
+
+	state.successes = 0
+	state.failures++
+	if !u.unhealthy.Load() && state.failures >= h.cfg.UnhealthyThreshold {
+		u.unhealthy.Store(true)
+		upstreamHealthy.Set(0, h.service, u.target.Redacted())
+		upstreamHealthChanges.Inc(h.service, "unhealthy")
+		h.log.Warn("upstream is unhealthy",
+			"upstream", u.target.Redacted(),
+			"failures", state.failures,
+			"status", status,
+			"error", err,
+		)
+	}
+}
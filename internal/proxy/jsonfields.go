@@ -0,0 +1,111 @@
+package proxy
+
+import (
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gateway/template/internal/config"
+)
+
+// fieldRename moves the field at path to a sibling named to
+type fieldRename struct {
+	path []string
+	to   string
+}
+
+// jsonFields removes and renames fields of JSON response bodies
+type jsonFields struct {
+	remove [][]string
+	rename []fieldRename
+}
+
+// newJSONFields returns the body modifier for the field edits in cfg, nil
+// if there are none
+func newJSONFields(cfg config.ResponseBodyConfig) bodyModifier {
+	if len(cfg.RemoveFields) == 0 && len(cfg.RenameFields) == 0 {
+		return nil
+	}
+	f := &jsonFields{}
+	for _, field := range cfg.RemoveFields {
+		f.remove = append(f.remove, strings.Split(field, "."))
+	}
+	for field, to := range cfg.RenameFields {
+		f.rename = append(f.rename, fieldRename{path: strings.Split(field, "."), to: to})
+	}
+	// paths use the upstream's names, so nested fields are renamed before
+	// their parents
+	slices.SortFunc(f.rename, func(a, b fieldRename) int {
+		return slices.Compare(b.path, a.path)
+	})
+	return f.modify
+}
+
+// modify edits JSON bodies, others and bodies failing to parse are left
+// alone. Fields are removed first, so a field can be replaced by renaming
+// another one to its name.
+func (f *jsonFields) modify(resp *http.Response, body []byte) ([]byte, error) {
+	if !isJSON(resp.Header.Get("Content-Type")) {
+		return body, nil
+	}
+	doc, err := decodeJSON(body)
+	if err != nil {
+		return body, nil
+	}
+
+	changed := false
+	for _, path := range f.remove {
+		changed = editField(doc, path, func(obj map[string]any, key string) bool {
+			if _, ok := obj[key]; !ok {
+				return false
+			}
+			delete(obj, key)
+			return true
+		}) || changed
+	}
+	for _, r := range f.rename {
+		changed = editField(doc, r.path, func(obj map[string]any, key string) bool {
+			value, ok := obj[key]
+			if !ok || key == r.to {
+				return false
+			}
+			delete(obj, key)
+			obj[r.to] = value
+			return true
+		}) || changed
+	}
+	if !changed {
+		return body, nil
+	}
+	return encodeJSON(doc)
+}
+
+// editField calls edit with the object holding the last key of path, for
+// every element of arrays on the way, and reports whether any call did
+func editField(v any, path []string, edit func(obj map[string]any, key string) bool) bool {
+	switch v := v.(type) {
+	case []any:
+		changed := false
+		for _, elem := range v {
+			changed = editField(elem, path, edit) || changed
+		}
+		return changed
+	case map[string]any:
+		if len(path) == 1 {
+			return edit(v, path[0])
+		}
+		return editField(v[path[0]], path[1:], edit)
+	}
+	return false
+}
+
+// isJSON reports whether contentType is application/json or a JSON-based
+// media type such as application/problem+json
+func isJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
@@ -0,0 +1,223 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/gateway/template/internal/config"
+	"github.com/gateway/template/internal/problem"
+)
+
+// mockEndpoint is the URL requests to mock targets are sent to, never
+// leaving the gateway
+var mockEndpoint = &url.URL{Scheme: "http", Host: "mock"}
+
+// maxMockBodySize caps the request bodies read for mock response
+// templates, larger ones are answered with 413
+const maxMockBodySize = 1 << 20
+
+// errMockBodyTooLarge is returned for request bodies over maxMockBodySize
+var errMockBodyTooLarge = errors.New("request body too large")
+
+// isMock reports whether target is the URL of a mock target
+func isMock(target *url.URL) bool {
+	return target.String() == config.MockURL
+}
+
+// mockTransport answers the requests of a mock target with the first of
+// its canned responses matching them, 404 if none does
+type mockTransport struct {
+	responses []*mockResponse
+	rp        *ReverseProxy
+}
+
+// mockResponse is a compiled config.MockResponse
+type mockResponse struct {
+	methods map[string]bool // nil matches every method
+	path    *regexp.Regexp
+	status  int
+	header  http.Header
+	body    *template.Template
+	latency time.Duration
+}
+
+// mockRequest is the data mock body templates are rendered with
+type mockRequest struct {
+	Method string
+	Path   string            // as requested from the gateway
+	Params map[string]string // values of the {name} segments of the path template
+	Query  url.Values
+	Header http.Header
+	Body   string
+	JSON   any // the decoded body if it is JSON, else nil
+}
+
+// newMockTransport compiles the mock responses of a service. Body template
+// files are read here.
+func (rp *ReverseProxy) newMockTransport(responses []config.MockResponse) (*mockTransport, error) {
+	m := &mockTransport{rp: rp}
+	for _, r := range responses {
+		source := r.Body
+		if r.File != "" {
+			data, err := os.ReadFile(r.File)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read mock response: %w", err)
+			}
+			source = string(data)
+		}
+		body, err := template.New(r.Path).Parse(source)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mock response for %s: %w", r.Path, err)
+		}
+		path, _, err := pathTemplateRegexp(r.Path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mock response path: %w", err)
+		}
+
+		c := &mockResponse{path: path, status: r.Status, header: make(http.Header), body: body, latency: r.Latency}
+		if c.status == 0 {
+			c.status = http.StatusOK
+		}
+		if len(r.Methods) > 0 {
+			c.methods = make(map[string]bool, len(r.Methods))
+			for _, method := range r.Methods {
+				c.methods[strings.ToUpper(method)] = true
+			}
+		}
+		for name, value := range r.Headers {
+			c.header.Set(name, value)
+		}
+		m.responses = append(m.responses, c)
+	}
+	return m, nil
+}
+
+// RoundTrip answers req with the first matching mock response after its
+// latency
+func (m *mockTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	path := m.gatewayPath(req)
+	body, err := readBody(req)
+	if errors.Is(err, errMockBodyTooLarge) {
+		return mockProblem(req, http.StatusRequestEntityTooLarge, "request body too large", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range m.responses {
+		if r.methods != nil && !r.methods[req.Method] {
+			continue
+		}
+		match := r.path.FindStringSubmatch(path)
+		if match == nil {
+			continue
+		}
+
+		data := &mockRequest{
+			Method: req.Method,
+			Path:   path,
+			Params: make(map[string]string),
+			Query:  req.URL.Query(),
+			Header: req.Header,
+			Body:   string(body),
+		}
+		for i, name := range r.path.SubexpNames() {
+			if name != "" {
+				data.Params[name] = match[i]
+			}
+		}
+		if isJSON(req.Header.Get("Content-Type")) {
+			data.JSON, _ = decodeJSON(body)
+		}
+		var rendered bytes.Buffer
+		if err := r.body.Execute(&rendered, data); err != nil {
+			return nil, fmt.Errorf("failed to render mock response: %w", err)
+		}
+
+		if r.latency > 0 {
+			timer := time.NewTimer(r.latency)
+			defer timer.Stop()
+			select {
+			case <-timer.C:
+			case <-req.Context().Done():
+				return nil, req.Context().Err()
+			}
+		}
+		header := r.header.Clone()
+		if header.Get("Content-Type") == "" && rendered.Len() > 0 {
+			if json.Valid(rendered.Bytes()) {
+				header.Set("Content-Type", "application/json")
+			} else {
+				header.Set("Content-Type", "text/plain; charset=utf-8")
+			}
+		}
+		return mockHTTPResponse(req, r.status, header, rendered.Bytes()), nil
+	}
+
+	return mockProblem(req, http.StatusNotFound, "no mock response for "+req.Method+" "+path, path)
+}
+
+// mockProblem returns a problem details response to req sent to the
+// gateway path
+func mockProblem(req *http.Request, status int, detail, path string) (*http.Response, error) {
+	details := problem.New(req, status, detail)
+	details.Instance = path
+	body, err := json.Marshal(details)
+	if err != nil {
+		return nil, err
+	}
+	header := http.Header{"Content-Type": {problem.ContentType}}
+	return mockHTTPResponse(req, status, header, body), nil
+}
+
+// gatewayPath returns the path req was sent to the gateway with, before the
+// service prefix was stripped or replaced
+func (m *mockTransport) gatewayPath(req *http.Request) string {
+	prefix := strippedPrefix(req.Context())
+	if prefix == "" {
+		return req.URL.Path
+	}
+	return prefix + "/" + strings.TrimPrefix(strings.TrimPrefix(req.URL.Path, m.rp.fwdPrefix), "/")
+}
+
+// readBody reads and closes the body of req, up to maxMockBodySize
+func readBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil {
+		return nil, nil
+	}
+	defer req.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(req.Body, maxMockBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxMockBodySize {
+		return nil, errMockBodyTooLarge
+	}
+	return body, nil
+}
+
+// mockHTTPResponse returns a response to req with a complete body
+func mockHTTPResponse(req *http.Request, status int, header http.Header, body []byte) *http.Response {
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
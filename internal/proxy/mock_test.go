@@ -0,0 +1,36 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gateway/template/internal/config"
+)
+
+func TestMockRequestBodyLimit(t *testing.T) {
+	m, err := (&ReverseProxy{}).newMockTransport([]config.MockResponse{{Path: "/echo", Body: "{{.Body}}"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	send := func(body string) (int, string) {
+		resp, err := m.RoundTrip(httptest.NewRequest(http.MethodPost, "/echo", strings.NewReader(body)))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(data)
+	}
+
+	body := strings.Repeat("a", maxMockBodySize)
+	if status, got := send(body); status != http.StatusOK || got != body {
+		t.Errorf("expected a body of the limit to be rendered, got %d with %d bytes", status, len(got))
+	}
+	if status, _ := send(body + "a"); status != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413 for a body over the limit, got %d", status)
+	}
+}
@@ -0,0 +1,87 @@
+package proxy
+
+import "net/http"
+
+// Option customizes a ReverseProxy created by New, so embedders can plug
+// in their own transport or request and response handling without changing
+// this package.
+type Option func(*options)
+
+// options holds the customizations applied by New
+type options struct {
+	service           string // the service of the proxy being created
+	transport         http.RoundTripper
+	directors         []func(*http.Request)
+	responseModifiers []func(*http.Response) error
+	bodyModifiers     []bodyModifier
+	reqModifiers      []requestBodyModifier
+}
+
+// ForService applies opts only to the proxy of the named service, e.g. to
+// modify the responses of a single service
+func ForService(name string, opts ...Option) Option {
+	return func(o *options) {
+		if o.service != name {
+			return
+		}
+		for _, opt := range opts {
+			opt(o)
+		}
+	}
+}
+
+// WithTransport sends the requests to all upstreams of the service, and its
+// health checks, through transport instead of the one built from the pool
+// settings, e.g. an instrumented, proxied or mocked transport. The pool and
+// per-service transport settings and DNS re-resolution do not apply then.
+func WithTransport(transport http.RoundTripper) Option {
+	return func(o *options) {
+		o.transport = transport
+	}
+}
+
+// WithDirector adds a function modifying requests before they are sent
+// upstream. It runs after the gateway has set the upstream URL and the
+// X-Forwarded headers, directors added by several options run in order.
+func WithDirector(director func(*http.Request)) Option {
+	return func(o *options) {
+		o.directors = append(o.directors, director)
+	}
+}
+
+// WithModifyResponse adds a function modifying upstream responses before
+// they are sent to the client. It runs after the gateway's own handling,
+// an error fails the request with 502 like an unreachable upstream.
+// Functions added by several options run in order until one fails.
+func WithModifyResponse(modify func(*http.Response) error) Option {
+	return func(o *options) {
+		o.responseModifiers = append(o.responseModifiers, modify)
+	}
+}
+
+// WithModifyResponseBody adds a function rewriting the bodies of upstream
+// responses, e.g. to strip or rename deprecated fields without a backend
+// release. It receives the complete body, decoded if it was gzip-encoded,
+// and returns the body to send; Content-Length is updated to match. It runs
+// after the service's configured body edits and before the functions added
+// by WithModifyResponse, an error fails the request with 502. Bodies larger
+// than the max response size, or 10 MiB without one, bodies in other
+// encodings and event streams are sent unmodified without calling it.
+func WithModifyResponseBody(modify func(resp *http.Response, body []byte) ([]byte, error)) Option {
+	return func(o *options) {
+		o.bodyModifiers = append(o.bodyModifiers, modify)
+	}
+}
+
+// WithModifyRequestBody adds a function rewriting the bodies of requests
+// before they are forwarded, e.g. to adapt the payloads of legacy clients to
+// a new backend contract. It receives the complete body and returns the body
+// to send; Content-Length is updated to match. It runs after the service's
+// request templates, an error rejects the request with 400. Bodies larger
+// than 10 MiB and bodies with a Content-Encoding are sent unmodified without
+// calling it.
+func WithModifyRequestBody(modify func(req *http.Request, body []byte) ([]byte, error)) Option {
+	return func(o *options) {
+		o.reqModifiers = append(o.reqModifiers, modify)
+	}
+}
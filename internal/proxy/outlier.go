@@ -0,0 +1,101 @@
+package proxy
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gateway/template/internal/config"
+	"github.com/gateway/template/internal/metrics"
+	"github.com/gateway/template/pkg/logger"
+)
+
+// minLatencySamples is the number of responses an average latency needs
+// before an upstream can be ejected for it
+const minLatencySamples = 5
+
+var upstreamEjections = metrics.Default.Counter(
+	"gateway_upstream_ejections_total",
+	"Number of times upstreams were ejected from balancing as outliers, by reason.",
+	"service", "reason",
+)
+
+// outlierDetector ejects upstreams of a service from balancing for a while
+// when the responses to proxied requests show they fail or are slow,
+// without waiting for health checks to notice
+type outlierDetector struct {
+	service   string
+	cfg       config.OutlierConfig
+	upstreams []*upstream
+	log       logger.Logger
+	mu        sync.Mutex // serializes ejections so the limit holds
+}
+
+// newOutlierDetector creates the outlier detector of a service's upstreams,
+// nil if outlier detection is disabled
+func newOutlierDetector(service string, cfg config.OutlierConfig, upstreams []*upstream, log logger.Logger) *outlierDetector {
+	if cfg.ConsecutiveErrors <= 0 && cfg.MaxLatency <= 0 {
+		return nil
+	}
+	if cfg.EjectionTime <= 0 {
+		cfg.EjectionTime = 30 * time.Second
+	}
+	if cfg.MaxEjectionPercent <= 0 {
+		cfg.MaxEjectionPercent = 50
+	}
+	return &outlierDetector{service: service, cfg: cfg, upstreams: upstreams, log: log}
+}
+
+// observe records the outcome of a request to an upstream, failed if it
+// could not be sent or was answered with a 5xx status
+func (d *outlierDetector) observe(u *upstream, failed bool) {
+	if !slices.Contains(d.upstreams, u) {
+		// route rule and version upstreams have no alternative
+		return
+	}
+
+	if !failed {
+		u.failures.Store(0)
+	} else if d.cfg.ConsecutiveErrors > 0 && u.failures.Add(1) >= int64(d.cfg.ConsecutiveErrors) {
+		d.eject(u, "errors")
+		return
+	}
+
+	if d.cfg.MaxLatency > 0 {
+		if latency, samples := u.averageLatency(time.Now()); samples >= minLatencySamples && latency > d.cfg.MaxLatency {
+			d.eject(u, "latency")
+		}
+	}
+}
+
+// eject takes an upstream out of balancing for the ejection time, unless
+// that would exceed the share of upstreams that may be ejected at once
+func (d *outlierDetector) eject(u *upstream, reason string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	ejected := 0
+	for _, other := range d.upstreams {
+		if other.ejectedUntil.Load() > now.UnixNano() {
+			if other == u {
+				return
+			}
+			ejected++
+		}
+	}
+	if (ejected+1)*100 > d.cfg.MaxEjectionPercent*len(d.upstreams) {
+		return
+	}
+
+	u.ejectedUntil.Store(now.Add(d.cfg.EjectionTime).UnixNano())
+	u.recovered(now.Add(d.cfg.EjectionTime))
+	u.failures.Store(0)
+	u.resetLatency()
+	upstreamEjections.Inc(d.service, reason)
+	d.log.Warn("upstream ejected as outlier",
+		"upstream", u.target.Redacted(),
+		"reason", reason,
+		"ejection_time", d.cfg.EjectionTime.String(),
+	)
+}
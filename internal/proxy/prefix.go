@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// prefixKey is the context key of the path prefix stripped from a request
+type prefixKey struct{}
+
+// strippedPrefix returns the path prefix removed from a request before it
+// was forwarded, e.g. /crm or /crm/v2, empty if its path is forwarded as is
+func strippedPrefix(ctx context.Context) string {
+	prefix, _ := ctx.Value(prefixKey{}).(string)
+	return prefix
+}
+
+// withStrippedPrefix returns ctx carrying the service prefix and, for
+// services stripping versions, the version segment removed from the path,
+// found by comparing it to the path before routing. Nothing is removed if
+// the service keeps its prefix and has no version stripped.
+func (rp *ReverseProxy) withStrippedPrefix(ctx context.Context, before, after string) context.Context {
+	prefix := rp.pathPrefix
+	if after != before {
+		// paths stripped of the service prefix may lack the leading slash
+		prefix += strings.TrimSuffix("/"+strings.TrimPrefix(before, "/"), after)
+	}
+	if prefix == rp.fwdPrefix {
+		return ctx
+	}
+	return context.WithValue(ctx, prefixKey{}, prefix)
+}
+
+// forwardPath puts the prefix the service's upstreams expect in front of
+// the path of a request, stripped of the service prefix when routed
+func (rp *ReverseProxy) forwardPath(r *http.Request) {
+	if rp.fwdPrefix == "" {
+		return
+	}
+	r.URL.Path = rp.fwdPrefix + "/" + strings.TrimPrefix(r.URL.Path, "/")
+	if r.URL.RawPath != "" {
+		r.URL.RawPath = rp.fwdPrefix + "/" + strings.TrimPrefix(r.URL.RawPath, "/")
+	}
+}
+
+// rewriteLocation replaces the upstream's prefix by the stripped prefix in
+// the Location header of a redirect to the upstream itself, so e.g.
+// Location: /login of the crm service becomes /crm/login. Absolute URLs
+// pointing at any of the service's upstreams are made relative to the
+// gateway's host, also when the path is forwarded as is; other hosts and
+// paths outside the upstream's prefix are left alone.
+func rewriteLocation(resp *http.Response, prefix, upstreamPrefix string, upstreamHosts map[string]bool) {
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return
+	}
+	u, err := url.Parse(location)
+	if err != nil {
+		return
+	}
+	if u.Host != "" && !upstreamHosts[strings.ToLower(u.Host)] &&
+		u.Host != resp.Request.URL.Host && u.Host != resp.Request.Host {
+		return
+	}
+	if u.Host == "" && (prefix == "" || !strings.HasPrefix(u.Path, "/")) {
+		// nothing stripped, or relative to the request's path, which
+		// already carries the prefix
+		return
+	}
+	if prefix == "" {
+		// forwarded as is, only the upstream's host is removed
+		prefix = upstreamPrefix
+	}
+	rest, ok := strings.CutPrefix(u.Path, upstreamPrefix)
+	if !ok || rest != "" && !strings.HasPrefix(rest, "/") {
+		return
+	}
+	u.Scheme, u.Host, u.User = "", "", nil
+	u.Path = prefix + rest
+	if u.RawPath != "" {
+		u.RawPath = prefix + strings.TrimPrefix(u.RawPath, upstreamPrefix)
+	}
+	resp.Header.Set("Location", u.String())
+}
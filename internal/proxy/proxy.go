@@ -2,47 +2,253 @@ package proxy
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gateway/template/internal/config"
+	"github.com/gateway/template/internal/errreport"
+	"github.com/gateway/template/internal/middleware"
+	"github.com/gateway/template/internal/problem"
 	"github.com/gateway/template/pkg/logger"
 )
 
-// ReverseProxy wraps httputil.ReverseProxy with additional functionality.
+// ReverseProxy wraps httputil.ReverseProxy, balancing a service's requests
+// across its upstreams.
 type ReverseProxy struct {
-	proxy       *httputil.ReverseProxy
-	target      *url.URL
+	// balancer spreads requests over the target URL and its additional
+	// endpoints with the service's strategy, round-robin in proportion to
+	// their weights by default; affinity uses consistent hashing instead
+	// for services with client affinity
+	balancer  balancer
+	affinity  *affinity
+	primaries []*upstream // upstreams available again ramp up to their share over a slow start
+
+	// backups take over while all primaries fail their health checks or
+	// are ejected as outliers, which receive no requests
+	backups    balancer // nil without backup upstreams
+	backupList []*upstream
+	outliers   *outlierDetector // nil without outlier detection
+
+	rules       []*routeRule // requests matching a rule go to its upstream instead
+	versions    *versionRouter
+	pathPrefix  string   // service prefix stripped from request paths, rules match the full path
+	fwdPrefix   string   // prefix of forwarded paths instead, the service prefix if kept
+	target      *url.URL // primary upstream, used when no upstream was chosen yet
 	log         logger.Logger
-	cfg         *config.ProxyConfig
+	cfg         *config.ProxyConfig // responses larger than its max response size are rejected, or cut off when streamed
 	serviceName string
+	backoff     *backoff
+	buffered    bool               // read responses completely before sending them
+	forwarded   bool               // send the RFC 7239 Forwarded header
+	keepHost    bool               // send the client's Host header instead of the upstream's
+	rewriteLoc  bool               // add the stripped prefix to Location headers
+	deadlineHdr string             // header telling upstreams the time left, empty for none
+	upHosts     map[string]bool    // hosts of the service's upstreams, whose URLs are rewritten
+	webSockets  *webSockets        // open WebSocket connections and their limits
+	recording   trafficRecording   // records or replays upstream traffic, nil for neither
+	mock        *mockTransport     // answers requests if the target URL is mock
+	stop        context.CancelFunc // stops background work, see Close
+
+	directors         []func(*http.Request)        // added by WithDirector
+	responseModifiers []func(*http.Response) error // added by WithModifyResponse
+	bodyModifiers     []bodyModifier               // configured body edits, then those added by WithModifyResponseBody
+	reqModifiers      []requestBodyModifier        // request templates, then those added by WithModifyRequestBody
 }
 
-// New creates a new reverse proxy instance.
-func New(cfg *config.ProxyConfig, targetURL string, log logger.Logger, serviceName string) (*ReverseProxy, error) {
+// New creates a new reverse proxy instance, customized by opts.
+func New(cfg *config.ProxyConfig, targetURL string, log logger.Logger, serviceName string, opts ...Option) (_ *ReverseProxy, err error) {
 	target, err := url.Parse(targetURL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse target URL: %w", err)
 	}
 
-	proxy := httputil.NewSingleHostReverseProxy(target)
+	o := options{service: serviceName}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	targetCfg := cfg.Targets[serviceName]
+	rotating := newRotatingTransport(newTransport(&cfg.Pool, targetCfg))
+	var transport http.RoundTripper = rotating
+	if o.transport != nil {
+		transport = o.transport
+	}
 
 	rp := &ReverseProxy{
-		proxy:       proxy,
-		target:      target,
-		log:         log,
-		cfg:         cfg,
-		serviceName: serviceName,
+		target:            target,
+		log:               log.With("service", serviceName),
+		cfg:               cfg,
+		serviceName:       serviceName,
+		buffered:          targetCfg.BufferResponses,
+		forwarded:         targetCfg.ForwardedHeader,
+		keepHost:          targetCfg.PreserveHost,
+		rewriteLoc:        targetCfg.RewriteLocation,
+		deadlineHdr:       deadlineHeader(cfg, targetCfg),
+		webSockets:        &webSockets{service: serviceName, limits: targetCfg.WebSocket},
+		directors:         o.directors,
+		responseModifiers: o.responseModifiers,
+	}
+	if rp.recording, err = newTrafficRecording(serviceName, &targetCfg.Recording, rp.log); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil && rp.recording != nil {
+			rp.recording.close()
+		}
+	}()
+	if isMock(target) {
+		if rp.mock, err = rp.newMockTransport(targetCfg.Mock); err != nil {
+			return nil, err
+		}
+	}
+	templates, err := rp.newRequestTemplates(targetCfg.RequestTemplates)
+	if err != nil {
+		return nil, err
+	}
+	if templates != nil {
+		rp.reqModifiers = append(rp.reqModifiers, templates)
+	}
+	rp.reqModifiers = append(rp.reqModifiers, o.reqModifiers...)
+
+	targets := []*url.URL{target}
+	for _, endpoint := range targetCfg.Endpoints {
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse endpoint URL %q: %w", endpoint, err)
+		}
+		targets = append(targets, u)
+	}
+	for _, backup := range targetCfg.Backups {
+		u, err := url.Parse(backup)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse backup URL %q: %w", backup, err)
+		}
+		targets = append(targets, u)
+	}
+
+	raw := append(append([]string{targetURL}, targetCfg.Endpoints...), targetCfg.Backups...)
+	upstreams := make([]*upstream, len(targets))
+	for i, t := range targets {
+		upstreams[i] = rp.newUpstream(t, transport)
+		if w, ok := targetCfg.Weights[raw[i]]; ok {
+			upstreams[i].weight = w
+		}
+		upstreams[i].slowStart = targetCfg.SlowStart
 	}
+	rp.primaries = upstreams[:len(upstreams)-len(targetCfg.Backups)]
+	rp.balancer = newBalancer(targetCfg.Balancer, rp.primaries)
+	rp.affinity = newAffinity(&targetCfg.Affinity, rp.primaries)
+	if len(targetCfg.Backups) > 0 {
+		rp.backupList = upstreams[len(rp.primaries):]
+		rp.backups = newBalancer(targetCfg.Balancer, rp.backupList)
+	}
+
+	if rp.rules, err = compileRouteRules(targetCfg.Routes); err != nil {
+		return nil, err
+	}
+	for _, rule := range rp.rules {
+		rule.balancer = &roundRobin{upstreams: []*upstream{rp.newUpstream(rule.target, transport)}}
+		targets = append(targets, rule.target)
+	}
+	if serviceName != config.DefaultTargetName {
+		rp.pathPrefix = "/" + serviceName
+	}
+	switch targetCfg.PrefixMode {
+	case "keep":
+		rp.fwdPrefix = rp.pathPrefix
+	case "replace":
+		rp.fwdPrefix = strings.TrimSuffix(targetCfg.ReplacePrefix, "/")
+	}
+
+	var versionTargets []*url.URL
+	if rp.versions, versionTargets, err = rp.newVersionRouter(&targetCfg.Versions, transport); err != nil {
+		return nil, err
+	}
+	targets = append(targets, versionTargets...)
+	rp.upHosts = upstreamHostSet(targets)
+
+	if fields := newJSONFields(targetCfg.ResponseBody); fields != nil {
+		rp.bodyModifiers = append(rp.bodyModifiers, fields)
+	}
+	if targetCfg.RewriteBodyURLs {
+		if rewriter := rp.newBodyURLRewriter(); rewriter != nil {
+			rp.bodyModifiers = append(rp.bodyModifiers, rewriter)
+		}
+	}
+	rp.bodyModifiers = append(rp.bodyModifiers, o.bodyModifiers...)
+
+	if cfg.Backoff.Enabled {
+		rp.backoff = newBackoff(&cfg.Backoff)
+	}
+
+	rp.outliers = newOutlierDetector(serviceName, targetCfg.Outliers, upstreams, rp.log)
+
+	ctx, stop := context.WithCancel(context.Background())
+	rp.stop = stop
+
+	if checker := newHealthChecker(serviceName, targetCfg.HealthCheck, upstreams, rp.log); checker != nil {
+		go checker.run(ctx)
+	}
+
+	// periodically re-resolve the upstream hosts, a custom transport
+	// manages its own connections
+	if targetCfg.DNSRefresh > 0 && o.transport == nil {
+		hosts := make(map[string]bool)
+		for _, t := range targets {
+			if hosts[t.Hostname()] || t.Scheme == "unix" || isMock(t) {
+				continue
+			}
+			hosts[t.Hostname()] = true
+
+			refresher := &dnsRefresher{
+				host:      t.Hostname(),
+				service:   serviceName,
+				interval:  targetCfg.DNSRefresh,
+				grace:     cfg.Timeout,
+				transport: rotating,
+				resolver:  net.DefaultResolver,
+				log:       rp.log,
+			}
+			go refresher.run(ctx)
+		}
+	}
+
+	return rp, nil
+}
+
+// newUpstream creates the proxy of a single endpoint, all endpoints share
+// the service's transport except Unix sockets, which get their own, and
+// mocks, which answer requests themselves
+func (rp *ReverseProxy) newUpstream(target *url.URL, transport http.RoundTripper) *upstream {
+	endpoint := target
+	switch {
+	case target.Scheme == "unix":
+		endpoint, transport = unixEndpoint(target, transport, rp.cfg.Pool.DialTimeout)
+	case isMock(target):
+		endpoint, transport = mockEndpoint, rp.mock
+	}
+	if rp.recording != nil {
+		transport = rp.recording.transport(transport)
+	}
+
+	proxy := httputil.NewSingleHostReverseProxy(endpoint)
+	proxy.Transport = transport
 
 	// customize director to modify requests before proxying
 	originalDirector := proxy.Director
 	proxy.Director = func(req *http.Request) {
 		originalDirector(req)
 		rp.modifyRequest(req)
+		for _, director := range rp.directors {
+			director(req)
+		}
 	}
 
 	// customize error handler
@@ -51,26 +257,71 @@ func New(cfg *config.ProxyConfig, targetURL string, log logger.Logger, serviceNa
 	// customize response modifier
 	proxy.ModifyResponse = rp.modifyResponse
 
-	return rp, nil
+	return &upstream{target: target, endpoint: endpoint, proxy: proxy, weight: 1}
+}
+
+// Close stops background work such as health checks and DNS re-resolution,
+// and closes the recording file.
+func (rp *ReverseProxy) Close() {
+	rp.stop()
+	if rp.recording != nil {
+		rp.recording.close()
+	}
 }
 
 // ServeHTTP implements http.Handler interface.
 // This is called after all middleware (logging, CORS, auth) have run.
 // It forwards the request to the backend service and returns the response.
 func (rp *ReverseProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// hold back traffic while the backend asked us to back off
+	if rp.backoff != nil && !rp.waitForBackoff(w, r) {
+		return
+	}
+	if !rp.modifyRequestBody(w, r) {
+		return
+	}
+
 	// create a context with timeout to prevent hanging requests
 	// if backend doesn't respond within PROXY_TIMEOUT, returns 504
-	ctx, cancel := context.WithTimeout(r.Context(), rp.cfg.Timeout)
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if middleware.IsWebSocket(r) {
+		if !rp.webSockets.acquire() {
+			rp.requestLog(r).Warn("websocket rejected by max connections",
+				"max_connections", rp.webSockets.limits.MaxConnections,
+			)
+			w.Header().Set("Retry-After", "1")
+			problem.Write(w, r, http.StatusServiceUnavailable, "too many websocket connections, retry later")
+			return
+		}
+		defer rp.webSockets.release()
+		ctx, cancel = withHandshakeTimeout(r.Context(), rp.cfg.Timeout)
+	} else {
+		ctx, cancel = context.WithTimeout(r.Context(), rp.cfg.Timeout)
+	}
 	defer cancel()
 
+	// trace upstream dial, TTFB and total time
+	ctx, timing := withUpstreamTrace(ctx, rp.serviceName)
+	ctx = context.WithValue(ctx, timingKey{}, timing)
+
+	// choose the endpoint for this request
+	if rp.affinity != nil {
+		rp.affinity.assign(w, r)
+	}
+	path := r.URL.Path
+	upstream := rp.route(r)
+	ctx = context.WithValue(ctx, upstreamKey{}, upstream)
+	ctx = rp.withStrippedPrefix(ctx, path, r.URL.Path)
+	rp.forwardPath(r)
+
 	// update request with timeout context
 	r = r.WithContext(ctx)
 
-	rp.log.Debug("proxying request",
+	rp.requestLog(r).Debug("proxying request",
 		"method", r.Method,
 		"path", r.URL.Path,
-		"target", rp.target.String(),
-		"service", rp.serviceName,
+		"target", upstream.target.String(),
 	)
 
 	// proxy.ServeHTTP does the actual work:
@@ -80,7 +331,45 @@ func (rp *ReverseProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// 4. Calls ModifyResponse (currently just logs)
 	// 5. Writes backend response to client
 	// 6. If error occurs, calls ErrorHandler
-	rp.proxy.ServeHTTP(w, r)
+	upstream.inFlight.Add(1)
+	defer upstream.inFlight.Add(-1)
+	upstream.proxy.ServeHTTP(w, r)
+}
+
+// route chooses the upstream of a request: that of the first matching route
+// rule, else that of its API version, else the service's own, the one its
+// affinity key hashes to if it has one, or a backup while all of the
+// service's own are unhealthy or ejected. The version segment is removed from the path
+// if the service strips versions.
+func (rp *ReverseProxy) route(r *http.Request) *upstream {
+	if len(rp.rules) > 0 {
+		path := rp.gatewayPath(r)
+		for _, rule := range rp.rules {
+			if rule.matches(r.Method, path) {
+				return rule.balancer.pick()
+			}
+		}
+	}
+	if rp.versions != nil {
+		if upstream := rp.versions.route(r); upstream != nil {
+			return upstream
+		}
+	}
+	if rp.backups != nil && !anyAvailable(rp.primaries) && anyAvailable(rp.backupList) {
+		return rp.backups.pick()
+	}
+	if rp.affinity != nil {
+		if key := rp.affinity.key(r); key != "" {
+			return rp.affinity.ring.pick(key)
+		}
+	}
+	return rp.balancer.pick()
+}
+
+// gatewayPath returns the path a request was sent to the gateway with, the
+// service prefix is already stripped from its URL
+func (rp *ReverseProxy) gatewayPath(r *http.Request) string {
+	return rp.pathPrefix + "/" + strings.TrimPrefix(r.URL.Path, "/")
 }
 
 // modifyRequest modifies the request before proxying to backend.
@@ -88,40 +377,85 @@ func (rp *ReverseProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 // The httputil.ReverseProxy already changes req.URL to point to the target,
 // we just add additional headers here.
 //
-// SECURITY: We ALWAYS overwrite X-Forwarded headers to prevent client spoofing.
-// See docs/X_FORWARDED_HEADERS.md for details.
+// SECURITY: X-Forwarded and Forwarded headers sent by clients are dropped,
+// only those of trusted proxies (TRUSTED_PROXIES) are passed on and extended.
 func (rp *ReverseProxy) modifyRequest(req *http.Request) {
-	// extract real client IP from connection
-	clientIP, _, err := net.SplitHostPort(req.RemoteAddr)
-	if err != nil {
-		// if SplitHostPort fails, use RemoteAddr as-is
-		clientIP = req.RemoteAddr
-	}
+	// client IP resolved by the ClientIP middleware, the connection's
+	// address unless it comes from a trusted proxy
+	clientIP := middleware.GetClientIP(req)
+	trusted := middleware.FromTrustedProxy(req)
 
-	// SECURITY: Delete any X-Forwarded headers from client request
-	// to prevent spoofing. We don't trust client-provided headers.
+	// SECURITY: Delete any X-Forwarded headers from untrusted clients
+	// to prevent spoofing. Chains of trusted proxies are kept as one header.
+	forwardedFor := strings.Join(req.Header.Values("X-Forwarded-For"), ", ")
+	forwardedProto := req.Header.Get("X-Forwarded-Proto")
+	forwardedHost := req.Header.Get("X-Forwarded-Host")
+	forwardedPrefix := req.Header.Get("X-Forwarded-Prefix")
+	forwarded := strings.Join(req.Header.Values("Forwarded"), ", ")
 	req.Header.Del("X-Real-IP")
 	req.Header.Del("X-Forwarded-For")
 	req.Header.Del("X-Forwarded-Proto")
 	req.Header.Del("X-Forwarded-Host")
+	req.Header.Del("X-Forwarded-Prefix")
+	req.Header.Del("Forwarded")
+	if !trusted {
+		forwardedFor, forwardedProto, forwardedHost, forwardedPrefix, forwarded = "", "", "", "", ""
+	}
 
-	// set our own trusted X-Forwarded headers based on actual connection
 	req.Header.Set("X-Real-IP", clientIP)
-	req.Header.Set("X-Forwarded-For", clientIP)
+
+	// httputil.ReverseProxy appends the connection's address to
+	// X-Forwarded-For, so only the chain before it is set here
+	if forwardedFor != "" {
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+	}
 
 	// set protocol based on TLS connection state
-	if req.TLS != nil {
+	switch {
+	case forwardedProto != "":
+		req.Header.Set("X-Forwarded-Proto", forwardedProto)
+	case req.TLS != nil:
 		req.Header.Set("X-Forwarded-Proto", "https")
-	} else {
+	default:
 		req.Header.Set("X-Forwarded-Proto", "http")
 	}
 
 	// set original host from request
-	req.Header.Set("X-Forwarded-Host", req.Host)
+	if forwardedHost != "" {
+		req.Header.Set("X-Forwarded-Host", forwardedHost)
+	} else {
+		req.Header.Set("X-Forwarded-Host", req.Host)
+	}
+
+	// path prefix stripped before forwarding, after that of trusted proxies,
+	// so backends can build links and redirects the client can follow
+	if prefix := strings.TrimSuffix(forwardedPrefix, "/") + strippedPrefix(req.Context()); prefix != "" {
+		req.Header.Set("X-Forwarded-Prefix", prefix)
+	}
+
+	// the RFC 7239 Forwarded header describes every hop in one element,
+	// a trusted chain is passed on even when not extended
+	if rp.forwarded {
+		if forwarded != "" {
+			forwarded += ", "
+		}
+		forwarded += forwardedElement(req)
+	}
+	if forwarded != "" {
+		req.Header.Set("Forwarded", forwarded)
+	}
+
+	// the time left, so the upstream can stop work the gateway gave up on
+	if rp.deadlineHdr != "" {
+		setDeadlineHeader(req, rp.deadlineHdr)
+	}
 
 	// IMPORTANT: Change Host header to target host for virtual host routing
-	// Backend nginx may use Host header for routing (virtual hosts)
-	req.Host = req.URL.Host
+	// Backend nginx may use Host header for routing (virtual hosts).
+	// Services resolving tenants by Host keep the client's instead.
+	if !rp.keepHost {
+		req.Host = req.URL.Host
+	}
 
 	// Note: All other headers (including Authorization with JWT)
 	// are preserved and forwarded to the backend unchanged
@@ -129,29 +463,171 @@ func (rp *ReverseProxy) modifyRequest(req *http.Request) {
 
 // modifyResponse modifies the response before returning to client.
 func (rp *ReverseProxy) modifyResponse(resp *http.Response) error {
-	rp.log.Debug("received response from target",
+	// upgraded connections have no response body to meter or limit
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		return rp.upgraded(resp)
+	}
+
+	if timing := timingFromContext(resp.Request.Context()); timing != nil {
+		timing.instrumentResponse(resp)
+
+		// time to the response headers, the body depends on its size
+		if u := upstreamFromContext(resp.Request.Context()); u != nil {
+			u.observeLatency(time.Since(timing.start), time.Now())
+			if rp.outliers != nil {
+				rp.outliers.observe(u, resp.StatusCode >= http.StatusInternalServerError)
+			}
+		}
+	}
+
+	if rp.rewriteLoc {
+		rewriteLocation(resp, strippedPrefix(resp.Request.Context()), rp.fwdPrefix, rp.upHosts)
+	}
+
+	// responses depend on the version requested in the Accept header
+	if rp.versions != nil {
+		resp.Header.Add("Vary", "Accept")
+	}
+
+	log := rp.requestLog(resp.Request)
+	log.Debug("received response from target",
 		"status", resp.StatusCode,
-		"target", rp.target.String(),
-		"service", rp.serviceName,
+		"target", rp.targetOf(resp.Request),
 	)
+
+	if rp.backoff != nil && isOverloadStatus(resp.StatusCode) {
+		delay := rp.backoff.apply(resp, time.Now())
+		backoffApplied.Inc(rp.serviceName, strconv.Itoa(resp.StatusCode))
+		backoffSeconds.Set(delay.Seconds(), rp.serviceName)
+
+		log.Warn("backend signalled overload, backing off",
+			"status", resp.StatusCode,
+			"retry_after", resp.Header.Get("Retry-After"),
+			"backoff_ms", delay.Milliseconds(),
+		)
+	}
+
+	if err := rp.limitResponse(resp); err != nil {
+		return err
+	}
+	if err := rp.modifyBody(resp); err != nil {
+		return err
+	}
+	for _, modify := range rp.responseModifiers {
+		if err := modify(resp); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// waitForBackoff delays or rejects the request while the backend is backing off.
+// It returns false if a response has already been written to the client.
+func (rp *ReverseProxy) waitForBackoff(w http.ResponseWriter, r *http.Request) bool {
+	remaining := rp.backoff.remaining(time.Now())
+	if remaining <= 0 {
+		return true
+	}
+
+	if remaining > rp.cfg.Backoff.MaxWait {
+		backoffThrottled.Inc(rp.serviceName)
+		rp.requestLog(r).Debug("request throttled by backend backoff",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"remaining_ms", remaining.Milliseconds(),
+		)
+
+		w.Header().Set("Retry-After", retryAfterSeconds(remaining))
+		problem.Write(w, r, http.StatusServiceUnavailable, "backend is temporarily overloaded, retry later")
+		return false
+	}
+
+	backoffQueued.Inc(rp.serviceName)
+	timer := time.NewTimer(remaining)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-r.Context().Done():
+		// client went away while queued, the status is only logged
+		w.WriteHeader(middleware.StatusClientClosedRequest)
+		return false
+	}
+}
+
 // errorHandler handles errors that occur during proxying.
 func (rp *ReverseProxy) errorHandler(w http.ResponseWriter, r *http.Request, err error) {
-	rp.log.Error("proxy error",
+	class := classifyUpstreamError(err)
+
+	// a client that went away is not an upstream failure, the upstream call
+	// was cancelled with its request
+	if class == "canceled" && middleware.ClientCanceled(r) {
+		rp.requestLog(r).Debug("client canceled request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"target", rp.targetOf(r),
+		)
+		w.WriteHeader(middleware.StatusClientClosedRequest)
+		return
+	}
+	upstreamErrors.Inc(rp.serviceName, class)
+
+	// a failed upstream counts as taking the full timeout, so the
+	// least-latency strategy avoids it
+	if u := upstreamFromContext(r.Context()); u != nil && class != "canceled" && class != "too_large" {
+		u.observeLatency(rp.cfg.Timeout, time.Now())
+		if rp.outliers != nil {
+			rp.outliers.observe(u, true)
+		}
+	}
+
+	rp.requestLog(r).Error("proxy error",
 		"method", r.Method,
 		"path", r.URL.Path,
-		"target", rp.target.String(),
-		"service", rp.serviceName,
+		"target", rp.targetOf(r),
+		"error_class", class,
 		"error", err,
 	)
 
-	// check if context deadline exceeded
-	if r.Context().Err() == context.DeadlineExceeded {
-		http.Error(w, "gateway timeout", http.StatusGatewayTimeout)
+	// a client that went away is not a gateway error
+	if !errors.Is(err, context.Canceled) {
+		errreport.Capture(&errreport.Event{
+			Message: "proxy error",
+			Err:     err,
+			Service: rp.serviceName,
+			Request: r,
+			Tags: map[string]string{
+				"target": rp.targetOf(r),
+			},
+		})
+	}
+
+	if errors.Is(err, errResponseTooLarge) {
+		problem.Write(w, r, http.StatusBadGateway, "backend response is too large")
 		return
 	}
 
-	http.Error(w, "bad gateway", http.StatusBadGateway)
+	// check if context deadline or the response header timeout exceeded
+	if r.Context().Err() == context.DeadlineExceeded || class == "timeout" {
+		problem.Write(w, r, http.StatusGatewayTimeout, "backend did not respond in time")
+		return
+	}
+
+	problem.Write(w, r, http.StatusBadGateway, "backend is unreachable")
+}
+
+// targetOf returns the upstream URL a request was sent to
+func (rp *ReverseProxy) targetOf(r *http.Request) string {
+	if u := upstreamFromContext(r.Context()); u != nil {
+		return u.target.String()
+	}
+	return rp.target.String()
+}
+
+// requestLog returns the request-scoped logger (with request ID, user and
+// service) at the proxy component level, or the service's proxy logger if
+// there is none.
+func (rp *ReverseProxy) requestLog(r *http.Request) logger.Logger {
+	return logger.ForComponent(logger.FromContextOr(r.Context(), rp.log), "proxy")
 }
@@ -0,0 +1,272 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gateway/template/internal/config"
+	"github.com/gateway/template/internal/metrics"
+	"github.com/gateway/template/internal/recording"
+	"github.com/gateway/template/pkg/logger"
+)
+
+// maxRecordedBody is the largest request or response body recorded,
+// exchanges with larger bodies are forwarded without being recorded
+const maxRecordedBody = 1 << 20
+
+var replayMisses = metrics.Default.Counter(
+	"gateway_replay_misses_total",
+	"Number of requests to replaying services without a recorded response.",
+	"service",
+)
+
+// errNotRecorded fails requests a replaying service has no response for
+var errNotRecorded = errors.New("no recorded response")
+
+// trafficRecording records or replays the exchanges of a service with its
+// upstreams by wrapping their transport
+type trafficRecording interface {
+	transport(next http.RoundTripper) http.RoundTripper
+	close()
+}
+
+// newTrafficRecording returns the recorder or replayer of cfg, nil if
+// recording is disabled
+func newTrafficRecording(service string, cfg *config.RecordingConfig, log logger.Logger) (trafficRecording, error) {
+	switch cfg.Mode {
+	case "record":
+		file, err := recording.Create(cfg.File)
+		if err != nil {
+			return nil, err
+		}
+		log.Info("recording upstream traffic", "file", cfg.File)
+		return &recorder{service: service, file: file, redact: recording.NewRedactor(cfg), log: log}, nil
+	case "replay":
+		exchanges, err := recording.ReadFile(cfg.File)
+		if err != nil {
+			return nil, err
+		}
+		log.Info("replaying recorded upstream traffic", "file", cfg.File, "exchanges", len(exchanges))
+		return newReplayer(service, exchanges, cfg, log), nil
+	}
+	return nil, nil
+}
+
+// recorder writes the exchanges of a service with its upstreams to a
+// recording file, redacted
+type recorder struct {
+	service string
+	file    *recording.Writer
+	redact  *recording.Redactor
+	log     logger.Logger
+}
+
+func (rec *recorder) transport(next http.RoundTripper) http.RoundTripper {
+	return &recordingTransport{rec: rec, next: next}
+}
+
+func (rec *recorder) close() {
+	_ = rec.file.Close()
+}
+
+// recordingTransport records the exchanges sent through next. WebSockets,
+// event streams and bodies larger than maxRecordedBody are not recorded.
+type recordingTransport struct {
+	rec  *recorder
+	next http.RoundTripper
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Upgrade") != "" {
+		return t.next.RoundTrip(req)
+	}
+	reqBody, complete := peekBody(&req.Body)
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || !complete {
+		return resp, err
+	}
+	duration := time.Since(start)
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType == "text/event-stream" {
+		return resp, nil
+	}
+	respBody, complete := peekBody(&resp.Body)
+	if !complete {
+		return resp, nil
+	}
+
+	rec := t.rec
+	e := &recording.Exchange{
+		Time:       start,
+		Service:    rec.service,
+		GatewayURI: rec.redact.URI(req.RequestURI),
+		DurationMs: duration.Milliseconds(),
+		Request: recording.Message{
+			Method: req.Method,
+			URI:    rec.redact.URI(req.URL.RequestURI()),
+			Header: rec.redact.Header(req.Header),
+		},
+		Response: recording.Message{
+			Status: resp.StatusCode,
+			Header: rec.redact.Header(resp.Header),
+		},
+	}
+	e.Request.SetBody(rec.redact.Body(req.Header, reqBody))
+	e.Response.SetBody(rec.redact.Body(resp.Header, respBody))
+	if err := rec.file.Write(e); err != nil {
+		rec.log.Warn("failed to record exchange",
+			"method", req.Method,
+			"uri", e.Request.URI,
+			"error", err,
+		)
+	}
+	return resp, nil
+}
+
+// peekBody reads *body up to maxRecordedBody and puts what it read back in
+// front of the rest. It returns the bytes read and whether they are the
+// whole body.
+func peekBody(body *io.ReadCloser) ([]byte, bool) {
+	if *body == nil || *body == http.NoBody {
+		return nil, true
+	}
+	data, err := io.ReadAll(io.LimitReader(*body, maxRecordedBody+1))
+	rest := io.Reader(*body)
+	if err != nil {
+		rest = &errReader{err: err}
+	}
+	*body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(data), rest), *body}
+	return data, err == nil && len(data) <= maxRecordedBody
+}
+
+// errReader fails all reads with err
+type errReader struct {
+	err error
+}
+
+func (r *errReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
+
+// replayer answers the requests of a service with the recorded responses
+// of requests with the same method, path and query, or the same method and
+// path if none has the same query. Requests recorded several times get
+// their responses in turn.
+type replayer struct {
+	service   string
+	redact    *recording.Redactor
+	latency   bool
+	log       logger.Logger
+	mu        sync.Mutex
+	exchanges map[string][]*recording.Exchange // by method and URI, and by method and path
+	next      map[string]int
+}
+
+// newReplayer creates a replayer of exchanges
+func newReplayer(service string, exchanges []recording.Exchange, cfg *config.RecordingConfig, log logger.Logger) *replayer {
+	rep := &replayer{
+		service:   service,
+		redact:    recording.NewRedactor(cfg),
+		latency:   cfg.ReplayLatency,
+		log:       log,
+		exchanges: make(map[string][]*recording.Exchange),
+		next:      make(map[string]int),
+	}
+	for i := range exchanges {
+		e := &exchanges[i]
+		for _, key := range replayKeys(e.Request.Method, e.Request.URI) {
+			rep.exchanges[key] = append(rep.exchanges[key], e)
+		}
+	}
+	return rep
+}
+
+// replayKeys returns the keys of a request, by method and URI and by method
+// and path
+func replayKeys(method, uri string) []string {
+	path, _, _ := strings.Cut(uri, "?")
+	return []string{method + " " + uri, method + " " + path + "?*"}
+}
+
+func (rep *replayer) transport(http.RoundTripper) http.RoundTripper {
+	return rep
+}
+
+func (rep *replayer) close() {}
+
+// RoundTrip answers req with a recorded response, never contacting the
+// upstream
+func (rep *replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		_ = req.Body.Close()
+	}
+	uri := rep.redact.URI(req.URL.RequestURI())
+	e := rep.lookup(req.Method, uri)
+	if e == nil {
+		replayMisses.Inc(rep.service)
+		rep.log.Warn("no recorded response to replay", "method", req.Method, "uri", uri)
+		return nil, fmt.Errorf("%w for %s %s", errNotRecorded, req.Method, uri)
+	}
+
+	body, err := e.Response.BodyBytes()
+	if err != nil {
+		return nil, err
+	}
+	if rep.latency {
+		timer := time.NewTimer(time.Duration(e.DurationMs) * time.Millisecond)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+
+	header := e.Response.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	if len(body) > 0 {
+		// redaction may have changed the body's length
+		header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", e.Response.Status, http.StatusText(e.Response.Status)),
+		StatusCode:    e.Response.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// lookup returns the next recorded exchange for a request, nil if there is
+// none
+func (rep *replayer) lookup(method, uri string) *recording.Exchange {
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	for _, key := range replayKeys(method, uri) {
+		recorded := rep.exchanges[key]
+		if len(recorded) == 0 {
+			continue
+		}
+		i := rep.next[key]
+		rep.next[key] = (i + 1) % len(recorded)
+		return recorded[i]
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gateway/template/internal/config"
+	"github.com/gateway/template/internal/problem"
+)
+
+// requestBodyModifier rewrites the complete body of a request before it is
+// forwarded, returning the body to send instead
+type requestBodyModifier func(req *http.Request, body []byte) ([]byte, error)
+
+// modifyRequestBody passes the body of r through the service's request body
+// modifiers. Bodies larger than 10 MiB or with a Content-Encoding are sent
+// as they are. It returns false if it answered the request itself, because
+// the body could not be read or a modifier failed.
+func (rp *ReverseProxy) modifyRequestBody(w http.ResponseWriter, r *http.Request) bool {
+	if len(rp.reqModifiers) == 0 || r.Body == nil || r.Body == http.NoBody || r.ContentLength == 0 {
+		return true
+	}
+	if encoding := r.Header.Get("Content-Encoding"); encoding != "" && !strings.EqualFold(encoding, "identity") {
+		return true
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxModifiedBody+1))
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, "failed to read request body")
+		return false
+	}
+	if len(body) > maxModifiedBody {
+		rp.requestLog(r).Warn("request body too large to modify, sending it unmodified",
+			"limit", maxModifiedBody,
+		)
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		return true
+	}
+	r.Body.Close()
+
+	modified := body
+	for _, modify := range rp.reqModifiers {
+		if modified, err = modify(r, modified); err != nil {
+			rp.requestLog(r).Warn("request body rejected", "error", err.Error())
+			problem.Write(w, r, http.StatusBadRequest, "request body rejected: "+err.Error())
+			return false
+		}
+	}
+
+	r.Body = io.NopCloser(bytes.NewReader(modified))
+	if !bytes.Equal(modified, body) {
+		r.ContentLength = int64(len(modified))
+		r.TransferEncoding = nil
+		r.Header.Set("Content-Length", strconv.Itoa(len(modified)))
+	}
+	return true
+}
+
+// requestTemplate rebuilds the JSON bodies of matching requests
+type requestTemplate struct {
+	methods  map[string]bool // empty matches every method
+	path     *regexp.Regexp  // matched against the gateway path
+	ifField  []string        // nil matches every body
+	template any
+}
+
+// newRequestTemplates compiles the request templates of a service into a
+// request body modifier using the first template matching a request, nil
+// without templates. Template files are read here.
+func (rp *ReverseProxy) newRequestTemplates(templates []config.RequestTemplate) (requestBodyModifier, error) {
+	if len(templates) == 0 {
+		return nil, nil
+	}
+	compiled := make([]*requestTemplate, 0, len(templates))
+	for _, t := range templates {
+		source := []byte(t.Template)
+		if t.File != "" {
+			var err error
+			if source, err = os.ReadFile(t.File); err != nil {
+				return nil, fmt.Errorf("failed to read request template: %w", err)
+			}
+		}
+		template, err := decodeJSON(source)
+		if err != nil {
+			return nil, fmt.Errorf("invalid request template for %s: %w", t.Path, err)
+		}
+		path, _, err := pathTemplateRegexp(t.Path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid request template path: %w", err)
+		}
+
+		c := &requestTemplate{path: path, template: template}
+		if len(t.Methods) > 0 {
+			c.methods = make(map[string]bool, len(t.Methods))
+			for _, method := range t.Methods {
+				c.methods[strings.ToUpper(method)] = true
+			}
+		}
+		if t.IfField != "" {
+			c.ifField = strings.Split(t.IfField, ".")
+		}
+		compiled = append(compiled, c)
+	}
+
+	return func(req *http.Request, body []byte) ([]byte, error) {
+		if !isJSON(req.Header.Get("Content-Type")) {
+			return body, nil
+		}
+		path := rp.gatewayPath(req)
+		for _, t := range compiled {
+			if len(t.methods) > 0 && !t.methods[req.Method] || !t.path.MatchString(path) {
+				continue
+			}
+			doc, err := decodeJSON(body)
+			if err != nil {
+				// left for the upstream to reject
+				return body, nil
+			}
+			if t.ifField != nil {
+				if _, ok := lookupField(doc, t.ifField); !ok {
+					return body, nil
+				}
+			}
+			rendered, _ := renderTemplate(t.template, doc)
+			return encodeJSON(rendered)
+		}
+		return body, nil
+	}, nil
+}
+
+// renderTemplate fills template with the fields of doc, reporting false for
+// a reference to a field doc lacks
+func renderTemplate(template, doc any) (any, bool) {
+	switch t := template.(type) {
+	case string:
+		switch {
+		case strings.HasPrefix(t, "$$"):
+			return t[1:], true
+		case t == "$":
+			return doc, true
+		case strings.HasPrefix(t, "$"):
+			return lookupField(doc, strings.Split(t[1:], "."))
+		}
+		return t, true
+	case map[string]any:
+		obj := make(map[string]any, len(t))
+		for key, value := range t {
+			if rendered, ok := renderTemplate(value, doc); ok {
+				obj[key] = rendered
+			}
+		}
+		return obj, true
+	case []any:
+		list := make([]any, len(t))
+		for i, value := range t {
+			// missing fields keep the positions of the others
+			list[i], _ = renderTemplate(value, doc)
+		}
+		return list, true
+	}
+	return template, true
+}
+
+// lookupField returns the field of v at path, array elements are selected
+// by their index
+func lookupField(v any, path []string) (any, bool) {
+	for _, name := range path {
+		switch node := v.(type) {
+		case map[string]any:
+			field, ok := node[name]
+			if !ok {
+				return nil, false
+			}
+			v = field
+		case []any:
+			i, err := strconv.Atoi(name)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, false
+			}
+			v = node[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// decodeJSON decodes a single JSON value, keeping numbers as sent
+func decodeJSON(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("unexpected data after the JSON value")
+	}
+	return v, nil
+}
+
+// encodeJSON encodes v without escaping HTML characters
+func encodeJSON(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gateway/template/internal/metrics"
+)
+
+// errResponseTooLarge is returned for upstream responses exceeding the
+// service's max response size
+var errResponseTooLarge = errors.New("upstream response exceeds the max response size")
+
+var oversizedResponses = metrics.Default.Counter(
+	"gateway_oversized_responses_total",
+	"Number of upstream responses rejected or cut off for exceeding the max response size.",
+	"service",
+)
+
+// limitResponse enforces the max response size on resp. Responses declaring
+// a larger Content-Length are rejected up front. Buffered responses are read
+// completely, so a larger body is rejected before anything is sent, others
+// are cut off once the limit is crossed.
+func (rp *ReverseProxy) limitResponse(resp *http.Response) error {
+	limit := rp.cfg.MaxResponseSize
+	if limit <= 0 || resp.Body == nil || resp.Body == http.NoBody {
+		return nil
+	}
+	if resp.ContentLength > limit {
+		oversizedResponses.Inc(rp.serviceName)
+		return errResponseTooLarge
+	}
+
+	if !rp.buffered {
+		resp.Body = &limitedBody{ReadCloser: resp.Body, remaining: limit, onExceeded: func() {
+			oversizedResponses.Inc(rp.serviceName)
+			rp.requestLog(resp.Request).Warn("upstream response cut off at max response size",
+				"target", rp.targetOf(resp.Request),
+				"max_response_size", limit,
+			)
+		}}
+		return nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	if int64(len(body)) > limit {
+		oversizedResponses.Inc(rp.serviceName)
+		return errResponseTooLarge
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.TransferEncoding = nil
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	return nil
+}
+
+// limitedBody fails reads once more than remaining bytes were read, which
+// makes the reverse proxy abort the response
+type limitedBody struct {
+	io.ReadCloser
+	remaining  int64
+	onExceeded func()
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining < 0 {
+		return 0, errResponseTooLarge
+	}
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		b.onExceeded()
+		return n - 1, errResponseTooLarge
+	}
+	return n, err
+}
@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// upstreamHostSet returns the hosts, with ports, of the HTTP upstreams of a
+// service in lower case
+func upstreamHostSet(targets []*url.URL) map[string]bool {
+	hosts := make(map[string]bool, len(targets))
+	for _, t := range targets {
+		if t.Scheme == "http" || t.Scheme == "https" {
+			hosts[strings.ToLower(t.Host)] = true
+		}
+	}
+	return hosts
+}
+
+// newBodyURLRewriter returns the body modifier replacing absolute URLs of
+// the service's upstreams, e.g. http://crm:9001/contacts/5, in JSON and
+// HTML bodies by the public URL of the gateway the client used, e.g.
+// https://api.example.com/crm/contacts/5. URLs outside the upstream prefix
+// of the service are left alone.
+func (rp *ReverseProxy) newBodyURLRewriter() bodyModifier {
+	if len(rp.upHosts) == 0 {
+		return nil
+	}
+	hosts := make([]string, 0, len(rp.upHosts))
+	for host := range rp.upHosts {
+		hosts = append(hosts, regexp.QuoteMeta(host))
+	}
+	// longer hosts first, so crm:9001 is not matched as crm
+	sort.Slice(hosts, func(i, j int) bool { return len(hosts[i]) > len(hosts[j]) })
+	pattern := regexp.MustCompile(`(?i)https?://(?:` + strings.Join(hosts, "|") + `)` + regexp.QuoteMeta(rp.fwdPrefix))
+
+	return func(resp *http.Response, body []byte) ([]byte, error) {
+		mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+		if !isJSON(resp.Header.Get("Content-Type")) && mediaType != "text/html" {
+			return body, nil
+		}
+		public := publicURL(resp.Request)
+		if public == "" {
+			return body, nil
+		}
+
+		var out []byte
+		last := 0
+		for _, match := range pattern.FindAllIndex(body, -1) {
+			start, end := match[0], match[1]
+			// e.g. crm:90012 or /api/v10 for crm:9001 or /api/v1
+			if end < len(body) && isURLContinuation(body[end]) {
+				continue
+			}
+			out = append(out, body[last:start]...)
+			out = append(out, public...)
+			last = end
+		}
+		if out == nil {
+			return body, nil
+		}
+		return append(out, body[last:]...), nil
+	}
+}
+
+// publicURL returns the URL of the gateway the client sent an upstream
+// request to, with the path prefix stripped from it, as told to the
+// upstream by the X-Forwarded headers
+func publicURL(req *http.Request) string {
+	proto, host := req.Header.Get("X-Forwarded-Proto"), req.Header.Get("X-Forwarded-Host")
+	if proto == "" || host == "" {
+		return ""
+	}
+	return proto + "://" + host + strings.TrimSuffix(req.Header.Get("X-Forwarded-Prefix"), "/")
+}
+
+// isURLContinuation reports whether c continues a host, port or path
+// segment, so a URL prefix followed by it belongs to another URL
+func isURLContinuation(c byte) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		return true
+	}
+	return strings.IndexByte("-._~%:@", c) >= 0
+}
@@ -0,0 +1,148 @@
+package proxy
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gateway/template/internal/config"
+)
+
+// routeRule sends the matching requests of a service to its own upstream
+// instead of the service's
+type routeRule struct {
+	methods  map[string]bool // empty matches every method
+	path     *regexp.Regexp  // matched against the gateway path
+	target   *url.URL
+	balancer *roundRobin
+
+	priority int
+	shape    *pathShape // nil for regular expressions
+}
+
+// pathShape describes a path template for ordering rules by specificity
+type pathShape struct {
+	literal []bool // whether each segment matches literally, not any segment
+	below   bool   // whether the template ends in /* and matches paths below it
+}
+
+// moreSpecific reports whether paths matching s are a narrower set than
+// those matching o, like /crm/reports/* compared to /crm/*: a literal
+// segment beats a placeholder at the first segment where they differ, then
+// more segments beat fewer, then an exact path beats one matching paths
+// below it
+func (s *pathShape) moreSpecific(o *pathShape) bool {
+	for i := 0; i < len(s.literal) && i < len(o.literal); i++ {
+		if s.literal[i] != o.literal[i] {
+			return s.literal[i]
+		}
+	}
+	if len(s.literal) != len(o.literal) {
+		return len(s.literal) > len(o.literal)
+	}
+	return !s.below && o.below
+}
+
+// matches reports whether a request with the given method and gateway path
+// is routed by the rule
+func (r *routeRule) matches(method, path string) bool {
+	if len(r.methods) > 0 && !r.methods[method] {
+		return false
+	}
+	return r.path.MatchString(path)
+}
+
+// compileRouteRules compiles the route rules of a target in the order they
+// are checked: higher priorities first, rules of equal priority with the
+// most specific path template first, regular expressions after templates
+// and rules equal in both in order
+func compileRouteRules(rules []config.RouteRule) ([]*routeRule, error) {
+	compiled := make([]*routeRule, 0, len(rules))
+	for _, rule := range rules {
+		target, err := url.Parse(rule.URL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse route URL %q: %w", rule.URL, err)
+		}
+
+		r := &routeRule{target: target, priority: rule.Priority}
+		if rule.Regex != "" {
+			r.path, err = regexp.Compile("^(?:" + rule.Regex + ")$")
+		} else {
+			r.path, r.shape, err = pathTemplateRegexp(rule.Path)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid route path: %w", err)
+		}
+
+		if len(rule.Methods) > 0 {
+			r.methods = make(map[string]bool, len(rule.Methods))
+			for _, method := range rule.Methods {
+				r.methods[strings.ToUpper(method)] = true
+			}
+		}
+		compiled = append(compiled, r)
+	}
+
+	sort.SliceStable(compiled, func(i, j int) bool {
+		a, b := compiled[i], compiled[j]
+		if a.priority != b.priority {
+			return a.priority > b.priority
+		}
+		if a.shape == nil || b.shape == nil {
+			return a.shape != nil && b.shape == nil
+		}
+		return a.shape.moreSpecific(b.shape)
+	})
+	return compiled, nil
+}
+
+// pathTemplateRegexp compiles a path template into a regular expression.
+// A {name} or * segment matches any single segment, captured as name if it
+// is a valid group name, a trailing /* matches the path and everything below
+// it; other characters match literally.
+func pathTemplateRegexp(template string) (*regexp.Regexp, *pathShape, error) {
+	rest, below := strings.CutSuffix(template, "/*")
+	shape := &pathShape{below: below}
+
+	var b strings.Builder
+	b.WriteString("^")
+	for i, segment := range strings.Split(rest, "/") {
+		if i > 0 {
+			b.WriteString("/")
+		}
+		if name, ok := templateParam(segment); ok {
+			b.WriteString("(?P<" + name + ">[^/]+)")
+			shape.literal = append(shape.literal, false)
+		} else if segment == "*" || (strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}")) {
+			b.WriteString("[^/]+")
+			shape.literal = append(shape.literal, false)
+		} else {
+			b.WriteString(regexp.QuoteMeta(segment))
+			shape.literal = append(shape.literal, true)
+		}
+	}
+	if below {
+		b.WriteString("(?:/.*)?")
+	}
+	b.WriteString("$")
+
+	path, err := regexp.Compile(b.String())
+	return path, shape, err
+}
+
+// templateParam returns the name of a {name} segment of a path template
+// that can name a regular expression group
+func templateParam(segment string) (string, bool) {
+	if len(segment) < 3 || segment[0] != '{' || segment[len(segment)-1] != '}' {
+		return "", false
+	}
+	name := segment[1 : len(segment)-1]
+	for _, c := range name {
+		if c != '_' && (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			return "", false
+		}
+	}
+	return name, true
+}
@@ -0,0 +1,53 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+
+	"github.com/gateway/template/internal/config"
+)
+
+// newTransport builds a dedicated http.Transport for a target from the pool
+// settings, so one busy backend cannot exhaust the idle connections of others.
+// Per-target limits and transport settings override the pool defaults.
+func newTransport(pool *config.PoolConfig, target config.TargetConfig) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   pool.DialTimeout,
+		KeepAlive: pool.KeepAlive,
+	}
+
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          pool.MaxIdleConns,
+		MaxIdleConnsPerHost:   pool.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       pool.MaxConnsPerHost,
+		IdleConnTimeout:       pool.IdleConnTimeout,
+		TLSHandshakeTimeout:   pool.TLSHandshakeTimeout,
+		ExpectContinueTimeout: pool.ExpectContinueTimeout,
+		ResponseHeaderTimeout: pool.ResponseHeaderTimeout,
+	}
+
+	if target.MaxConns > 0 {
+		transport.MaxConnsPerHost = target.MaxConns
+	}
+	if target.MaxIdleConns > 0 {
+		transport.MaxIdleConnsPerHost = target.MaxIdleConns
+	}
+	if target.Transport.ExpectContinueTimeout > 0 {
+		transport.ExpectContinueTimeout = target.Transport.ExpectContinueTimeout
+	}
+	if target.Transport.ResponseHeaderTimeout > 0 {
+		transport.ResponseHeaderTimeout = target.Transport.ResponseHeaderTimeout
+	}
+	transport.DisableKeepAlives = target.Transport.DisableKeepAlives
+	if target.Transport.DisableHTTP2 {
+		// a non-nil empty map keeps the transport from enabling HTTP/2
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+
+	return transport
+}
@@ -0,0 +1,40 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// defaultUnixHost is the Host header of requests to Unix socket upstreams
+// naming none
+const defaultUnixHost = "localhost"
+
+// unixEndpoint returns the URL and transport of requests to a Unix socket
+// upstream such as unix:///var/run/crm.sock?host=crm.internal. Requests are
+// sent to http://<host> over connections to the socket, so the backend sees
+// the logical host instead of the socket path. A custom transport is used
+// as given.
+func unixEndpoint(target *url.URL, transport http.RoundTripper, dialTimeout time.Duration) (*url.URL, http.RoundTripper) {
+	host := target.Query().Get("host")
+	if host == "" {
+		host = defaultUnixHost
+	}
+	endpoint := &url.URL{Scheme: "http", Host: host}
+
+	rotating, ok := transport.(*rotatingTransport)
+	if !ok {
+		return endpoint, transport
+	}
+
+	socket := target.Path
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	unix := rotating.current.Load().Clone()
+	unix.Proxy = nil
+	unix.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return dialer.DialContext(ctx, "unix", socket)
+	}
+	return endpoint, unix
+}
@@ -0,0 +1,156 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/gateway/template/internal/metrics"
+)
+
+var (
+	upstreamRequests = metrics.Default.Counter(
+		"gateway_upstream_requests_total",
+		"Number of responses received from upstream services, by status code.",
+		"service", "code",
+	)
+	upstreamErrors = metrics.Default.Counter(
+		"gateway_upstream_errors_total",
+		"Number of failed upstream requests, by error class.",
+		"service", "class",
+	)
+	upstreamDialSeconds = metrics.Default.Histogram(
+		"gateway_upstream_dial_seconds",
+		"Time in seconds to establish new connections to upstream services.",
+		nil,
+		"service",
+	)
+	upstreamTTFBSeconds = metrics.Default.Histogram(
+		"gateway_upstream_ttfb_seconds",
+		"Time in seconds from sending a request upstream to its first response byte.",
+		nil,
+		"service",
+	)
+	upstreamDurationSeconds = metrics.Default.Histogram(
+		"gateway_upstream_duration_seconds",
+		"Total time in seconds of upstream requests, including reading the response body.",
+		nil,
+		"service",
+	)
+	upstreamResponseBytes = metrics.Default.Histogram(
+		"gateway_upstream_response_size_bytes",
+		"Size in bytes of upstream response bodies.",
+		[]float64{100, 1 << 10, 10 << 10, 100 << 10, 1 << 20, 10 << 20, 100 << 20},
+		"service",
+	)
+)
+
+// upstreamTiming collects timings of a single upstream request
+type upstreamTiming struct {
+	service      string
+	start        time.Time
+	connectStart time.Time
+}
+
+// withUpstreamTrace returns ctx instrumented to record dial and TTFB metrics
+func withUpstreamTrace(ctx context.Context, service string) (context.Context, *upstreamTiming) {
+	timing := &upstreamTiming{service: service, start: time.Now()}
+
+	trace := &httptrace.ClientTrace{
+		ConnectStart: func(network, addr string) {
+			timing.connectStart = time.Now()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			if err == nil && !timing.connectStart.IsZero() {
+				upstreamDialSeconds.Observe(time.Since(timing.connectStart).Seconds(), service)
+			}
+		},
+		GotFirstResponseByte: func() {
+			upstreamTTFBSeconds.Observe(time.Since(timing.start).Seconds(), service)
+		},
+	}
+
+	return httptrace.WithClientTrace(ctx, trace), timing
+}
+
+// instrumentResponse records the status and wraps the body to record the
+// response size and total duration once the body has been copied to the client
+func (t *upstreamTiming) instrumentResponse(resp *http.Response) {
+	upstreamRequests.Inc(t.service, strconv.Itoa(resp.StatusCode))
+	if resp.Body != nil {
+		resp.Body = &meteredBody{ReadCloser: resp.Body, timing: t}
+	}
+}
+
+// meteredBody counts response body bytes and records metrics on Close
+type meteredBody struct {
+	io.ReadCloser
+	timing *upstreamTiming
+	bytes  int64
+	closed bool
+}
+
+func (b *meteredBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.bytes += int64(n)
+	return n, err
+}
+
+func (b *meteredBody) Close() error {
+	if !b.closed {
+		b.closed = true
+		upstreamResponseBytes.Observe(float64(b.bytes), b.timing.service)
+		upstreamDurationSeconds.Observe(time.Since(b.timing.start).Seconds(), b.timing.service)
+	}
+	return b.ReadCloser.Close()
+}
+
+// timingKey is the context key for the upstream timing of a request
+type timingKey struct{}
+
+// timingFromContext returns the upstream timing stored by ServeHTTP
+func timingFromContext(ctx context.Context) *upstreamTiming {
+	t, _ := ctx.Value(timingKey{}).(*upstreamTiming)
+	return t
+}
+
+// classifyUpstreamError maps a proxy error to a low-cardinality class
+func classifyUpstreamError(err error) string {
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+	var tlsErr *tls.CertificateVerificationError
+	var recordErr tls.RecordHeaderError
+	var netErr net.Error
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, errResponseTooLarge):
+		return "too_large"
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded):
+		return "timeout"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		// e.g. the response header timeout
+		return "timeout"
+	case errors.As(err, &dnsErr):
+		return "dns"
+	case errors.As(err, &tlsErr), errors.As(err, &recordErr):
+		return "tls"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection_refused"
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF):
+		return "connection_reset"
+	case errors.As(err, &opErr) && opErr.Op == "dial":
+		return "dial"
+	default:
+		return "other"
+	}
+}
@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gateway/template/internal/config"
+)
+
+// versionRouter sends requests to the upstream of their API version
+type versionRouter struct {
+	upstreams map[string]*roundRobin
+	fallback  string // version of requests naming none
+	strip     bool   // remove the version segment from the forwarded path
+}
+
+// route returns the upstream of the request's version, or nil to use the
+// service's own. The version is taken from the first path segment, then from
+// the Accept header.
+func (v *versionRouter) route(r *http.Request) *upstream {
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	segment, rest, _ := strings.Cut(path, "/")
+	if b, ok := v.upstreams[segment]; ok {
+		if v.strip {
+			r.URL.Path = "/" + rest
+			r.URL.RawPath = ""
+		}
+		return b.pick()
+	}
+
+	if version := acceptVersion(r.Header.Values("Accept")); version != "" {
+		if b, ok := v.upstreams[version]; ok {
+			return b.pick()
+		}
+	}
+
+	if b, ok := v.upstreams[v.fallback]; ok {
+		return b.pick()
+	}
+	return nil
+}
+
+// acceptVersion returns the version named by a vendor media type of an
+// Accept header, e.g. v2 for application/vnd.api.v2+json
+func acceptVersion(accept []string) string {
+	for _, header := range accept {
+		for _, mediaRange := range strings.Split(header, ",") {
+			mediaType, _, _ := strings.Cut(mediaRange, ";")
+			_, subtype, _ := strings.Cut(strings.TrimSpace(mediaType), "/")
+			if !strings.HasPrefix(subtype, "vnd.") {
+				continue
+			}
+			subtype, _, _ = strings.Cut(subtype, "+")
+			parts := strings.Split(subtype, ".")
+			if version := parts[len(parts)-1]; len(parts) > 1 && isVersion(version) {
+				return version
+			}
+		}
+	}
+	return ""
+}
+
+// isVersion reports whether s looks like an API version such as v2
+func isVersion(s string) bool {
+	return len(s) >= 2 && s[0] == 'v' && s[1] >= '0' && s[1] <= '9'
+}
+
+// newVersionRouter creates the version router of a service, nil if it has
+// no versions
+func (rp *ReverseProxy) newVersionRouter(cfg *config.VersionConfig, transport http.RoundTripper) (*versionRouter, []*url.URL, error) {
+	if len(cfg.URLs) == 0 {
+		return nil, nil, nil
+	}
+
+	v := &versionRouter{upstreams: make(map[string]*roundRobin, len(cfg.URLs)), fallback: cfg.Default, strip: cfg.Strip}
+	var targets []*url.URL
+	for version, raw := range cfg.URLs {
+		target, err := url.Parse(raw)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse URL of version %s: %w", version, err)
+		}
+		v.upstreams[version] = &roundRobin{upstreams: []*upstream{rp.newUpstream(target, transport)}}
+		targets = append(targets, target)
+	}
+	return v, targets, nil
+}
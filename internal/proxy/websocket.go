@@ -0,0 +1,179 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gateway/template/internal/config"
+	"github.com/gateway/template/internal/metrics"
+	"github.com/gateway/template/pkg/logger"
+)
+
+var (
+	webSocketConnections = metrics.Default.Gauge(
+		"gateway_websocket_connections",
+		"Number of open WebSocket connections.",
+		"service",
+	)
+	webSocketRejected = metrics.Default.Counter(
+		"gateway_websocket_rejected_total",
+		"Number of WebSocket connections rejected for exceeding the max connections.",
+		"service",
+	)
+	webSocketClosed = metrics.Default.Counter(
+		"gateway_websocket_closed_total",
+		"Number of WebSocket connections closed by the gateway for exceeding the idle timeout or max duration.",
+		"service", "reason",
+	)
+)
+
+// webSockets tracks the open WebSocket connections of a service and
+// enforces its limits on them
+type webSockets struct {
+	service string
+	limits  config.WebSocketConfig
+	open    atomic.Int64
+}
+
+// acquire counts a new connection, false if the service has its max
+// connections open already
+func (ws *webSockets) acquire() bool {
+	open := ws.open.Add(1)
+	if max := int64(ws.limits.MaxConnections); max > 0 && open > max {
+		ws.open.Add(-1)
+		webSocketRejected.Inc(ws.service)
+		return false
+	}
+	webSocketConnections.Set(float64(open), ws.service)
+	return true
+}
+
+// release uncounts a connection that was closed or failed its handshake
+func (ws *webSockets) release() {
+	webSocketConnections.Set(float64(ws.open.Add(-1)), ws.service)
+}
+
+// handshakeKey is the context key of the timer cancelling a WebSocket
+// request whose handshake takes longer than the proxy timeout
+type handshakeKey struct{}
+
+// withHandshakeTimeout returns a context cancelled if the upstream has not
+// accepted the upgrade within timeout. Unlike other requests, an upgraded
+// connection outlives the proxy timeout, which only covers the handshake.
+func withHandshakeTimeout(parent context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	timer := time.AfterFunc(timeout, cancel)
+	return context.WithValue(ctx, handshakeKey{}, timer), cancel
+}
+
+// upgraded handles an upstream accepting a protocol switch: the handshake
+// timeout no longer applies, and WebSocket connections are closed once
+// idle or open for too long
+func (rp *ReverseProxy) upgraded(resp *http.Response) error {
+	if timer, ok := resp.Request.Context().Value(handshakeKey{}).(*time.Timer); ok {
+		timer.Stop()
+	}
+
+	conn, ok := resp.Body.(io.ReadWriteCloser)
+	limits := rp.webSockets.limits
+	if ok && (limits.IdleTimeout > 0 || limits.MaxDuration > 0) {
+		tracked := &trackedConn{ReadWriteCloser: conn, done: make(chan struct{})}
+		tracked.touch()
+		go tracked.watch(limits, rp.serviceName, rp.requestLog(resp.Request))
+		resp.Body = tracked
+	}
+
+	rp.requestLog(resp.Request).Debug("upgraded connection",
+		"protocol", resp.Header.Get("Upgrade"),
+		"target", rp.targetOf(resp.Request),
+	)
+	for _, modify := range rp.responseModifiers {
+		if err := modify(resp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// trackedConn records traffic on an upgraded upstream connection in both
+// directions, the reverse proxy copies client data to it and its data to
+// the client
+type trackedConn struct {
+	io.ReadWriteCloser
+	lastActive atomic.Int64 // unix nanoseconds
+	closeOnce  sync.Once
+	done       chan struct{}
+}
+
+func (c *trackedConn) Read(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Read(p)
+	if n > 0 {
+		c.touch()
+	}
+	return n, err
+}
+
+func (c *trackedConn) Write(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Write(p)
+	if n > 0 {
+		c.touch()
+	}
+	return n, err
+}
+
+// Close closes the upstream connection, which ends the proxied connection
+func (c *trackedConn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.done)
+		err = c.ReadWriteCloser.Close()
+	})
+	return err
+}
+
+func (c *trackedConn) touch() {
+	c.lastActive.Store(time.Now().UnixNano())
+}
+
+// watch closes the connection once it was idle for the idle timeout or
+// open for the max duration, until it is closed otherwise
+func (c *trackedConn) watch(limits config.WebSocketConfig, service string, log logger.Logger) {
+	var deadline, idle <-chan time.Time
+	if limits.MaxDuration > 0 {
+		timer := time.NewTimer(limits.MaxDuration)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	var idleTimer *time.Timer
+	if limits.IdleTimeout > 0 {
+		idleTimer = time.NewTimer(limits.IdleTimeout)
+		defer idleTimer.Stop()
+		idle = idleTimer.C
+	}
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-deadline:
+			webSocketClosed.Inc(service, "max_duration")
+			log.Info("closing websocket open for the max duration", "max_duration_ms", limits.MaxDuration.Milliseconds())
+			c.Close()
+			return
+		case <-idle:
+			quiet := time.Since(time.Unix(0, c.lastActive.Load()))
+			if quiet < limits.IdleTimeout {
+				idleTimer.Reset(limits.IdleTimeout - quiet)
+				continue
+			}
+			webSocketClosed.Inc(service, "idle")
+			log.Info("closing idle websocket", "idle_timeout_ms", limits.IdleTimeout.Milliseconds())
+			c.Close()
+			return
+		}
+	}
+}
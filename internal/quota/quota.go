@@ -0,0 +1,211 @@
+// Package quota counts the requests of clients over long periods, UTC days
+// and months, for usage caps of API products. Counters are kept in a store,
+// Redis to share them between gateway instances and keep them on restart.
+package quota
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gateway/template/pkg/redis"
+)
+
+// Period is a calendar period requests are counted in
+type Period string
+
+const (
+	Day   Period = "day"
+	Month Period = "month"
+)
+
+// Window returns the name of the period containing t, e.g. 2026-10-15 or
+// 2026-10, and when it ends
+func (p Period) Window(t time.Time) (string, time.Time) {
+	t = t.UTC()
+	if p == Month {
+		start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return start.Format("2006-01"), start.AddDate(0, 1, 0)
+	}
+	start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	return start.Format("2006-01-02"), start.AddDate(0, 0, 1)
+}
+
+// Key returns the key of the counter of client in the period containing t
+// and when the period ends
+func (p Period) Key(client string, t time.Time) (string, time.Time) {
+	window, end := p.Window(t)
+	return string(p) + ":" + window + ":" + client, end
+}
+
+// retention is how long counters are kept after their period ended, so the
+// usage of the previous period can still be looked up
+const retention = 32 * 24 * time.Hour
+
+// Store keeps counters until they expire. Implementations must be safe for
+// concurrent use.
+type Store interface {
+	// Add adds n to the counter of key, created to expire at expires, and
+	// returns its new value
+	Add(ctx context.Context, key string, n int64, expires time.Time) (int64, error)
+	// Get returns the counter of key, 0 if there is none
+	Get(ctx context.Context, key string) (int64, error)
+}
+
+var (
+	defaultMu    sync.RWMutex
+	defaultStore Store
+)
+
+// SetDefaultStore sets the process-wide store of the quotas
+func SetDefaultStore(store Store) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultStore = store
+}
+
+// DefaultStore returns the process-wide store, if any
+func DefaultStore() Store {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultStore
+}
+
+// Count adds a request of client to its counter of period p and returns
+// the new count and when the period ends
+func Count(ctx context.Context, store Store, p Period, client string, now time.Time) (int64, time.Time, error) {
+	key, end := p.Key(client, now)
+	count, err := store.Add(ctx, key, 1, end.Add(retention))
+	return count, end, err
+}
+
+// Uncount takes back a request counted by Count in the same period, e.g.
+// because it was rejected
+func Uncount(ctx context.Context, store Store, p Period, client string, now time.Time) error {
+	key, end := p.Key(client, now)
+	_, err := store.Add(ctx, key, -1, end.Add(retention))
+	return err
+}
+
+// Usage returns the requests of client counted in the period p containing t
+func Usage(ctx context.Context, store Store, p Period, client string, t time.Time) (int64, error) {
+	key, _ := p.Key(client, t)
+	return store.Get(ctx, key)
+}
+
+// MemoryStore keeps counters in memory. They are lost on restart and not
+// shared between gateway instances.
+type MemoryStore struct {
+	mu       sync.Mutex
+	counters map[string]counter
+	sweep    time.Time // next time expired counters are removed
+}
+
+// counter is a stored count and when it expires
+type counter struct {
+	value   int64
+	expires time.Time
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{counters: make(map[string]counter)}
+}
+
+// Add implements Store
+func (s *MemoryStore) Add(_ context.Context, key string, n int64, expires time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweepExpired(now)
+	c, ok := s.counters[key]
+	if !ok || now.After(c.expires) {
+		c = counter{expires: expires}
+	}
+	c.value += n
+	s.counters[key] = c
+	return c.value, nil
+}
+
+// Get implements Store
+func (s *MemoryStore) Get(_ context.Context, key string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.counters[key]
+	if !ok || time.Now().After(c.expires) {
+		return 0, nil
+	}
+	return c.value, nil
+}
+
+// sweepExpired removes expired counters at most once a minute, s.mu must be
+// held
+func (s *MemoryStore) sweepExpired(now time.Time) {
+	if now.Before(s.sweep) {
+		return
+	}
+	for k, c := range s.counters {
+		if now.After(c.expires) {
+			delete(s.counters, k)
+		}
+	}
+	s.sweep = now.Add(time.Minute)
+}
+
+// redisKeyPrefix namespaces the quota counters in a shared Redis
+const redisKeyPrefix = "gateway:quota:"
+
+// addScript increments a counter and sets its expiry when it creates it, in
+// one step so counters never lack one
+const addScript = `local v = redis.call('INCRBY', KEYS[1], ARGV[1])
+if redis.call('PTTL', KEYS[1]) < 0 then redis.call('PEXPIREAT', KEYS[1], ARGV[2]) end
+return v`
+
+// RedisStore keeps counters in Redis so they are shared by all gateway
+// instances and survive restarts.
+type RedisStore struct {
+	client *redis.Client
+}
+
+// NewRedisStore creates a store for a Redis URL such as
+// redis://:password@redis:6379/0, use rediss:// for TLS. The connection is
+// opened on first use.
+func NewRedisStore(rawURL string) (*RedisStore, error) {
+	client, err := redis.New(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	return &RedisStore{client: client}, nil
+}
+
+// Add implements Store, atomically across gateway instances
+func (s *RedisStore) Add(ctx context.Context, key string, n int64, expires time.Time) (int64, error) {
+	reply, err := s.client.Do(ctx, "EVAL", addScript, "1", redisKeyPrefix+key,
+		strconv.FormatInt(n, 10), strconv.FormatInt(expires.UnixMilli(), 10))
+	if err != nil {
+		return 0, err
+	}
+	count, ok := reply.(int64)
+	if !ok {
+		return 0, fmt.Errorf("unexpected redis reply %v", reply)
+	}
+	return count, nil
+}
+
+// Get implements Store
+func (s *RedisStore) Get(ctx context.Context, key string) (int64, error) {
+	reply, err := s.client.Do(ctx, "GET", redisKeyPrefix+key)
+	if err != nil || reply == nil {
+		return 0, err
+	}
+	return strconv.ParseInt(reply.(string), 10, 64)
+}
+
+// Close closes the connection to Redis
+func (s *RedisStore) Close() error {
+	return s.client.Close()
+}
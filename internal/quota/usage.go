@@ -0,0 +1,59 @@
+package quota
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gateway/template/internal/problem"
+)
+
+// periodUsage is the usage of a client in a period
+type periodUsage struct {
+	Period   string    `json:"period"`
+	Requests int64     `json:"requests"`
+	Resets   time.Time `json:"resets_at"`
+}
+
+// usageReport is the response of the usage endpoint
+type usageReport struct {
+	Client string      `json:"client"`
+	Day    periodUsage `json:"day"`
+	Month  periodUsage `json:"month"`
+}
+
+// UsageHandler returns a handler reporting the requests of a client in the
+// current UTC day and month, the client selected by the user or ip query
+// parameter, e.g. GET /admin/usage?user=42
+func UsageHandler(store Store) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var client string
+		switch q := r.URL.Query(); {
+		case q.Get("user") != "":
+			client = "user:" + q.Get("user")
+		case q.Get("ip") != "":
+			client = "ip:" + q.Get("ip")
+		default:
+			problem.Write(w, r, http.StatusBadRequest, "the user or ip query parameter is required")
+			return
+		}
+
+		now := time.Now()
+		report := usageReport{Client: client}
+		for _, u := range []struct {
+			period Period
+			usage  *periodUsage
+		}{{Day, &report.Day}, {Month, &report.Month}} {
+			requests, err := Usage(r.Context(), store, u.period, client, now)
+			if err != nil {
+				problem.Write(w, r, http.StatusServiceUnavailable, "quota store unavailable")
+				return
+			}
+			u.usage.Period, u.usage.Resets = u.period.Window(now)
+			u.usage.Requests = requests
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(report)
+	})
+}
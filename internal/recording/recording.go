@@ -0,0 +1,249 @@
+package recording
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gateway/template/internal/config"
+)
+
+// Redacted replaces the values of recorded secrets
+const Redacted = "REDACTED"
+
+// defaultRedactedHeaders are redacted in every recording
+var defaultRedactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key"}
+
+// Exchange is a request sent to an upstream and its response, one line of
+// a recording file
+type Exchange struct {
+	Time       time.Time `json:"time"`
+	Service    string    `json:"service"`
+	GatewayURI string    `json:"gateway_uri,omitempty"` // as requested from the gateway, empty for health checks
+	DurationMs int64     `json:"duration_ms"`           // until the upstream's response headers
+	Request    Message   `json:"request"`
+	Response   Message   `json:"response"`
+}
+
+// Message is a recorded request or response
+type Message struct {
+	Method   string      `json:"method,omitempty"`
+	URI      string      `json:"uri,omitempty"` // path and query sent upstream
+	Status   int         `json:"status,omitempty"`
+	Header   http.Header `json:"header,omitempty"`
+	Body     string      `json:"body,omitempty"`
+	Encoding string      `json:"body_encoding,omitempty"` // base64 for bodies that are not UTF-8 text
+}
+
+// SetBody stores body as text, or base64-encoded if it is not UTF-8
+func (m *Message) SetBody(body []byte) {
+	if utf8.Valid(body) {
+		m.Body, m.Encoding = string(body), ""
+		return
+	}
+	m.Body, m.Encoding = base64.StdEncoding.EncodeToString(body), "base64"
+}
+
+// BodyBytes returns the body as stored by SetBody
+func (m *Message) BodyBytes() ([]byte, error) {
+	switch m.Encoding {
+	case "":
+		return []byte(m.Body), nil
+	case "base64":
+		return base64.StdEncoding.DecodeString(m.Body)
+	default:
+		return nil, fmt.Errorf("unknown body encoding %q", m.Encoding)
+	}
+}
+
+// Redactor replaces secrets in recorded messages by Redacted
+type Redactor struct {
+	headers map[string]bool // canonical names
+	query   map[string]bool
+	fields  map[string]bool
+}
+
+// NewRedactor creates a redactor of the default headers and the headers,
+// query parameters and JSON body fields listed in cfg
+func NewRedactor(cfg *config.RecordingConfig) *Redactor {
+	r := &Redactor{
+		headers: make(map[string]bool),
+		query:   make(map[string]bool),
+		fields:  make(map[string]bool),
+	}
+	for _, name := range append(defaultRedactedHeaders, cfg.RedactHeaders...) {
+		r.headers[http.CanonicalHeaderKey(name)] = true
+	}
+	for _, name := range cfg.RedactQuery {
+		r.query[name] = true
+	}
+	for _, name := range cfg.RedactFields {
+		r.fields[name] = true
+	}
+	return r
+}
+
+// Header returns a copy of h with the values of redacted headers replaced
+func (r *Redactor) Header(h http.Header) http.Header {
+	redacted := h.Clone()
+	for name, values := range redacted {
+		if !r.headers[name] {
+			continue
+		}
+		for i := range values {
+			values[i] = Redacted
+		}
+	}
+	return redacted
+}
+
+// URI returns uri with the values of redacted query parameters replaced.
+// The query of uri is re-encoded in a canonical order, so URIs differing
+// only in the order of their parameters are equal.
+func (r *Redactor) URI(uri string) string {
+	path, rawQuery, ok := strings.Cut(uri, "?")
+	if !ok {
+		return uri
+	}
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return uri
+	}
+	for name, values := range query {
+		if !r.query[name] {
+			continue
+		}
+		for i := range values {
+			values[i] = Redacted
+		}
+	}
+	return path + "?" + query.Encode()
+}
+
+// Body returns body with the values of redacted fields replaced at any
+// depth if header marks it as unencoded JSON, otherwise body as it is
+func (r *Redactor) Body(header http.Header, body []byte) []byte {
+	if len(r.fields) == 0 || len(body) == 0 || !isJSON(header.Get("Content-Type")) {
+		return body
+	}
+	if encoding := header.Get("Content-Encoding"); encoding != "" && encoding != "identity" {
+		return body
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return body
+	}
+	if !r.redactFields(doc) {
+		return body
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(doc); err != nil {
+		return body
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+}
+
+// redactFields replaces the values of redacted fields in v, reporting
+// whether it found any
+func (r *Redactor) redactFields(v any) bool {
+	found := false
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			if r.fields[key] {
+				v[key] = Redacted
+				found = true
+			} else if r.redactFields(value) {
+				found = true
+			}
+		}
+	case []any:
+		for _, value := range v {
+			if r.redactFields(value) {
+				found = true
+			}
+		}
+	}
+	return found
+}
+
+// isJSON reports whether contentType is application/json or a JSON-based
+// media type such as application/problem+json
+func isJSON(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// Writer appends exchanges to a recording file
+type Writer struct {
+	mu   sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+// Create opens the recording file at path for appending, creating it
+// readable only by the gateway's user if it does not exist
+func Create(path string) (*Writer, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open recording file: %w", err)
+	}
+	enc := json.NewEncoder(file)
+	enc.SetEscapeHTML(false)
+	return &Writer{file: file, enc: enc}, nil
+}
+
+// Write appends e as one line
+func (w *Writer) Write(e *Exchange) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.enc.Encode(e)
+}
+
+// Close closes the file, later writes fail
+func (w *Writer) Close() error {
+	return w.file.Close()
+}
+
+// ReadFile reads all exchanges of the recording file at path
+func ReadFile(path string) ([]Exchange, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open recording file: %w", err)
+	}
+	defer file.Close()
+	return Read(file)
+}
+
+// Read reads all exchanges of a recording
+func Read(r io.Reader) ([]Exchange, error) {
+	var exchanges []Exchange
+	dec := json.NewDecoder(r)
+	for {
+		var e Exchange
+		err := dec.Decode(&e)
+		if errors.Is(err, io.EOF) {
+			return exchanges, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid exchange %d: %w", len(exchanges)+1, err)
+		}
+		exchanges = append(exchanges, e)
+	}
+}
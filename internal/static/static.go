@@ -0,0 +1,107 @@
+// Package static serves a directory of static files, such as a frontend
+// bundle, from the gateway's origin, so the frontend calls the API without
+// CORS. Single-page applications get their index page for the paths of
+// their client-side routes.
+package static
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/gateway/template/internal/config"
+	"github.com/gateway/template/internal/problem"
+)
+
+// handler serves the files of a directory under a path prefix
+type handler struct {
+	root   http.Dir
+	prefix string // without trailing slash
+	cfg    *config.StaticConfig
+}
+
+// Handler returns the handler serving the directory of cfg under its
+// prefix. Only GET and HEAD are allowed, directories are served by their
+// index file and hidden files, starting with a dot, are never served.
+func Handler(cfg *config.StaticConfig) (http.Handler, error) {
+	info, err := os.Stat(cfg.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open static directory: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("static directory %s is not a directory", cfg.Dir)
+	}
+	return &handler{
+		root:   http.Dir(cfg.Dir),
+		prefix: strings.TrimSuffix(cfg.Prefix, "/"),
+		cfg:    cfg,
+	}, nil
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		problem.Write(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	name := path.Clean("/" + strings.TrimPrefix(r.URL.Path, h.prefix))
+	if !hidden(name) && h.serveFile(w, r, name) {
+		return
+	}
+	// client-side routes have no file extension, missing assets do
+	if h.cfg.SPAFallback && path.Ext(name) == "" && h.serveFile(w, r, "/"+h.cfg.Index) {
+		return
+	}
+	problem.Write(w, r, http.StatusNotFound, "file not found")
+}
+
+// serveFile serves the file at name, reporting false if there is none
+func (h *handler) serveFile(w http.ResponseWriter, r *http.Request, name string) bool {
+	f, err := h.root.Open(name)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+
+	index := info.IsDir() || path.Base(name) == h.cfg.Index
+	if info.IsDir() {
+		indexFile, err := h.root.Open(path.Join(name, h.cfg.Index))
+		if err != nil {
+			return false
+		}
+		defer indexFile.Close()
+		if info, err = indexFile.Stat(); err != nil || info.IsDir() {
+			return false
+		}
+		f = indexFile
+	}
+
+	// index pages name the assets of the current deployment, so they are
+	// revalidated while the assets may be cached
+	switch {
+	case index:
+		w.Header().Set("Cache-Control", "no-cache")
+	case h.cfg.CacheControl != "":
+		w.Header().Set("Cache-Control", h.cfg.CacheControl)
+	}
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+	return true
+}
+
+// hidden reports whether a segment of name starts with a dot, e.g. .env or
+// .git/config
+func hidden(name string) bool {
+	for _, segment := range strings.Split(name, "/") {
+		if strings.HasPrefix(segment, ".") {
+			return true
+		}
+	}
+	return false
+}
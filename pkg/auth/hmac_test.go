@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestVerifyRequestSignature(t *testing.T) {
+	secret := []byte("secret")
+	body := []byte(`{"event":"paid"}`)
+	signature := SignRequest(secret, "1700000000", http.MethodPost, "/hooks/payments?id=1", body)
+
+	valid := []string{signature, "sha256=" + signature, strings.ToUpper(signature)}
+	for _, s := range valid {
+		if !VerifyRequestSignature(secret, s, "1700000000", "post", "/hooks/payments?id=1", body) {
+			t.Errorf("expected signature %q to verify", s)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		secret     string
+		timestamp  string
+		method     string
+		requestURI string
+		body       string
+	}{
+		{"wrong key", "other", "1700000000", http.MethodPost, "/hooks/payments?id=1", `{"event":"paid"}`},
+		{"tampered body", "secret", "1700000000", http.MethodPost, "/hooks/payments?id=1", `{"event":"refunded"}`},
+		{"tampered query", "secret", "1700000000", http.MethodPost, "/hooks/payments?id=2", `{"event":"paid"}`},
+		{"tampered method", "secret", "1700000000", http.MethodPut, "/hooks/payments?id=1", `{"event":"paid"}`},
+		{"other timestamp", "secret", "1700000300", http.MethodPost, "/hooks/payments?id=1", `{"event":"paid"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if VerifyRequestSignature([]byte(tt.secret), signature, tt.timestamp, tt.method, tt.requestURI, []byte(tt.body)) {
+				t.Error("expected the signature to be rejected")
+			}
+		})
+	}
+
+	// the fields are separated, so moving bytes between them changes the signature
+	if SignRequest(secret, "1700000000", http.MethodPost, "/a", []byte("b")) == SignRequest(secret, "1700000000", http.MethodPost, "/ab", nil) {
+		t.Error("expected the request URI and body to be signed separately")
+	}
+}
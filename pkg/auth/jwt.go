@@ -1,6 +1,9 @@
 package auth
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"time"
@@ -17,6 +20,19 @@ var (
 	ErrInvalidSigningMethod = errors.New("invalid signing method")
 	// ErrInvalidClaims is returned when token claims are invalid
 	ErrInvalidClaims = errors.New("invalid token claims")
+	// ErrInvalidIssuer is returned with ErrInvalidClaims when the issuer does not match
+	ErrInvalidIssuer = errors.New("invalid issuer")
+	// ErrInvalidAudience is returned with ErrInvalidClaims when the audience does not match
+	ErrInvalidAudience = errors.New("invalid audience")
+	// ErrRefreshWindowExpired is returned when a token expired too long ago to be refreshed
+	ErrRefreshWindowExpired = errors.New("token expired too long ago to be refreshed")
+	// ErrRevokedToken is returned when the token or its subject has been revoked
+	ErrRevokedToken = errors.New("token has been revoked")
+	// ErrRevocationUnavailable is returned when the revocation store cannot be queried,
+	// tokens are rejected rather than accepted unchecked
+	ErrRevocationUnavailable = errors.New("token revocation check failed")
+	// ErrUnknownKeyID is returned when no verification key has the token's kid
+	ErrUnknownKeyID = errors.New("unknown signing key id")
 )
 
 // Config holds JWT configuration
@@ -25,6 +41,67 @@ type Config struct {
 	Issuer     string        // issuer claim
 	Audience   string        // audience claim
 	Expiration time.Duration // token expiration duration
+	Leeway     time.Duration // clock skew tolerated when checking exp, nbf and iat
+
+	// KeyID is the kid header of tokens signed with Secret, empty omits it.
+	// Keys are further verification secrets by kid, e.g. the previous secret
+	// during a rotation. Tokens with a kid are verified with that key only,
+	// tokens without one with any key.
+	KeyID string
+	Keys  map[string]string
+
+	// RefreshWindow is how long after expiry a token can still be refreshed,
+	// 0 allows refreshing any expired token
+	RefreshWindow time.Duration
+
+	// Revocations is checked for revoked tokens, nil uses the process-wide
+	// DefaultRevocationStore
+	Revocations RevocationStore
+
+	// TrustedIssuers are accepted in addition to Issuer, e.g. a legacy
+	// identity provider during a migration. Tokens are only signed as Issuer.
+	TrustedIssuers []TrustedIssuer
+}
+
+// TrustedIssuer is another issuer whose tokens are accepted
+type TrustedIssuer struct {
+	Issuer    string            // iss claim of its tokens
+	Secret    string            // secret key verifying its tokens without a kid
+	Keys      map[string]string // secret keys by kid
+	Audiences []string          // accepted audiences, empty accepts Config.Audience
+}
+
+// issuerKeys are the verification keys and accepted audiences of an issuer
+type issuerKeys struct {
+	byID      map[string][]byte // keys by kid
+	all       [][]byte          // every key, tried for tokens without a kid
+	audiences []string
+}
+
+// newIssuerKeys collects the keys of an issuer, secret may be empty when
+// keys are given and is also registered as keyID when that is set
+func newIssuerKeys(secret, keyID string, keys map[string]string, audiences []string) (issuerKeys, error) {
+	ik := issuerKeys{byID: make(map[string][]byte), audiences: audiences}
+	if secret != "" {
+		ik.all = append(ik.all, []byte(secret))
+		if keyID != "" {
+			ik.byID[keyID] = []byte(secret)
+		}
+	}
+	for kid, key := range keys {
+		if kid == "" || key == "" {
+			return issuerKeys{}, errors.New("key id and key cannot be empty")
+		}
+		if _, ok := ik.byID[kid]; ok {
+			return issuerKeys{}, fmt.Errorf("key id %q is configured twice", kid)
+		}
+		ik.byID[kid] = []byte(key)
+		ik.all = append(ik.all, []byte(key))
+	}
+	if len(ik.all) == 0 {
+		return issuerKeys{}, errors.New("secret cannot be empty")
+	}
+	return ik, nil
 }
 
 // Claims represents JWT claims structure
@@ -39,7 +116,8 @@ type Claims struct {
 
 // Manager handles JWT operations
 type Manager struct {
-	config *Config
+	config  *Config
+	issuers map[string]issuerKeys
 }
 
 // NewManager creates a new JWT manager
@@ -59,9 +137,36 @@ func NewManager(config *Config) (*Manager, error) {
 	if config.Audience == "" {
 		config.Audience = "api-gateway"
 	}
+	if config.Revocations == nil {
+		config.Revocations = DefaultRevocationStore()
+	}
+
+	own, err := newIssuerKeys(config.Secret, config.KeyID, config.Keys, []string{config.Audience})
+	if err != nil {
+		return nil, err
+	}
+	issuers := map[string]issuerKeys{config.Issuer: own}
+	for _, trusted := range config.TrustedIssuers {
+		if trusted.Issuer == "" {
+			return nil, errors.New("trusted issuer cannot be empty")
+		}
+		if _, ok := issuers[trusted.Issuer]; ok {
+			return nil, fmt.Errorf("issuer %q is configured twice", trusted.Issuer)
+		}
+		audiences := trusted.Audiences
+		if len(audiences) == 0 {
+			audiences = []string{config.Audience}
+		}
+		keys, err := newIssuerKeys(trusted.Secret, "", trusted.Keys, audiences)
+		if err != nil {
+			return nil, fmt.Errorf("trusted issuer %q: %w", trusted.Issuer, err)
+		}
+		issuers[trusted.Issuer] = keys
+	}
 
 	return &Manager{
-		config: config,
+		config:  config,
+		issuers: issuers,
 	}, nil
 }
 
@@ -79,14 +184,14 @@ func (m *Manager) GenerateToken(userID string, metadata map[string]interface{})
 			Issuer:    m.config.Issuer,
 			Audience:  jwt.ClaimStrings{m.config.Audience},
 			Subject:   userID,
+			ID:        newTokenID(),
 			ExpiresAt: jwt.NewNumericDate(now.Add(m.config.Expiration)),
 			IssuedAt:  jwt.NewNumericDate(now),
 			NotBefore: jwt.NewNumericDate(now),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(m.config.Secret))
+	return m.sign(claims)
 }
 
 // GenerateTokenWithClaims generates a new JWT token with custom claims
@@ -117,8 +222,19 @@ func (m *Manager) GenerateTokenWithClaims(claims *Claims) (string, error) {
 	if claims.NotBefore == nil {
 		claims.NotBefore = jwt.NewNumericDate(now)
 	}
+	if claims.ID == "" {
+		claims.ID = newTokenID()
+	}
 
+	return m.sign(claims)
+}
+
+// sign signs claims with the secret, setting the kid header when configured
+func (m *Manager) sign(claims *Claims) (string, error) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	if m.config.KeyID != "" {
+		token.Header["kid"] = m.config.KeyID
+	}
 	return token.SignedString([]byte(m.config.Secret))
 }
 
@@ -128,18 +244,12 @@ func (m *Manager) ValidateToken(tokenString string) (*Claims, error) {
 		return nil, ErrInvalidToken
 	}
 
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		// validate signing method
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("%w: %v", ErrInvalidSigningMethod, token.Header["alg"])
-		}
-		return []byte(m.config.Secret), nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, m.keyFunc, jwt.WithLeeway(m.config.Leeway), jwt.WithIssuedAt())
 	if err != nil {
 		if errors.Is(err, jwt.ErrTokenExpired) {
 			return nil, ErrExpiredToken
 		}
-		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
 	}
 
 	if !token.Valid {
@@ -151,27 +261,183 @@ func (m *Manager) ValidateToken(tokenString string) (*Claims, error) {
 		return nil, ErrInvalidClaims
 	}
 
+	if err := m.validateClaims(claims); err != nil {
+		return nil, err
+	}
+
+	if err := m.checkRevoked(claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// newTokenID returns a random token ID for the jti claim
+func newTokenID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// checkRevoked rejects revoked tokens when a revocation store is configured
+func (m *Manager) checkRevoked(claims *Claims) error {
+	return checkRevoked(m.config.Revocations, claims)
+}
+
+// unboundedRefreshRetention is how long revocations are kept when expired
+// tokens can be refreshed at any time
+const unboundedRefreshRetention = 30 * 24 * time.Hour
+
+// refreshRetention is how long after expiry a token can still be refreshed
+func (m *Manager) refreshRetention() time.Duration {
+	if m.config.RefreshWindow <= 0 {
+		return unboundedRefreshRetention
+	}
+	return m.config.RefreshWindow
+}
+
+// maxTokenLifetime is how long a token issued now can be used or refreshed,
+// the time revocations must be kept for
+func (m *Manager) maxTokenLifetime() time.Duration {
+	return m.config.Expiration + m.refreshRetention()
+}
+
+// Revoke revokes a token before it expires. Its signature, issuer and
+// audience are verified, it may already be expired. The token must have a
+// jti claim, which all tokens issued by the manager have.
+func (m *Manager) Revoke(ctx context.Context, tokenString string) (*Claims, error) {
+	claims, err := m.parseIgnoringExpiry(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.ID == "" {
+		return nil, fmt.Errorf("%w: token has no jti, revoke its subject instead", ErrInvalidClaims)
+	}
+
+	var expiresAt time.Time
+	if claims.ExpiresAt != nil {
+		expiresAt = claims.ExpiresAt.Time
+	}
+	if err := m.RevokeID(ctx, claims.ID, expiresAt); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// RevokeID revokes the token with the given jti. The revocation is kept
+// until expiresAt plus the refresh window, or for the longest token lifetime
+// when expiresAt is zero.
+func (m *Manager) RevokeID(ctx context.Context, jti string, expiresAt time.Time) error {
+	return revokeID(ctx, m.config.Revocations, jti, expiresAt, m.refreshRetention(), m.maxTokenLifetime())
+}
+
+// RevokeSubject revokes every token of a subject issued until now, tokens
+// issued afterwards are accepted again
+func (m *Manager) RevokeSubject(ctx context.Context, subject string) error {
+	return revokeSubject(ctx, m.config.Revocations, subject, m.maxTokenLifetime())
+}
+
+// keyFunc checks the signing method and returns the key of the token's
+// issuer selected by its kid header, or all of them without a kid
+func (m *Manager) keyFunc(token *jwt.Token) (interface{}, error) {
+	// validate signing method
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSigningMethod, token.Header["alg"])
+	}
+
+	claims, ok := token.Claims.(*Claims)
+	if !ok {
+		return nil, ErrInvalidClaims
+	}
+	issuer, ok := m.issuers[claims.Issuer]
+	if !ok {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidClaims, ErrInvalidIssuer)
+	}
+
+	// issuers without key IDs ignore the kid, as before key rotation
+	if kid, _ := token.Header["kid"].(string); kid != "" && len(issuer.byID) > 0 {
+		key, ok := issuer.byID[kid]
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownKeyID, kid)
+		}
+		return key, nil
+	}
+
+	if len(issuer.all) == 1 {
+		return issuer.all[0], nil
+	}
+	keys := jwt.VerificationKeySet{}
+	for _, key := range issuer.all {
+		keys.Keys = append(keys.Keys, key)
+	}
+	return keys, nil
+}
+
+// validateClaims checks the issuer and audience of a token
+func (m *Manager) validateClaims(claims *Claims) error {
 	// validate issuer
-	if claims.Issuer != m.config.Issuer {
-		return nil, fmt.Errorf("%w: invalid issuer", ErrInvalidClaims)
+	issuer, ok := m.issuers[claims.Issuer]
+	if !ok {
+		return fmt.Errorf("%w: %w", ErrInvalidClaims, ErrInvalidIssuer)
 	}
 
-	// validate audience
+	// validate audience against the audiences accepted from the issuer
 	validAudience := false
 	for _, aud := range claims.Audience {
-		if aud == m.config.Audience {
-			validAudience = true
-			break
+		for _, accepted := range issuer.audiences {
+			if aud == accepted {
+				validAudience = true
+				break
+			}
 		}
 	}
 	if !validAudience {
-		return nil, fmt.Errorf("%w: invalid audience", ErrInvalidClaims)
+		return fmt.Errorf("%w: %w", ErrInvalidClaims, ErrInvalidAudience)
 	}
 
-	return claims, nil
+	return nil
 }
 
-// RefreshToken generates a new token with the same claims but updated expiration
+// FailureReason classifies an error returned by ValidateToken, e.g. "expired"
+// or "bad_signature", to tell developers why a token was rejected
+func FailureReason(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, ErrExpiredToken):
+		return "expired"
+	case errors.Is(err, jwt.ErrTokenNotValidYet):
+		return "not_yet_valid"
+	case errors.Is(err, jwt.ErrTokenUsedBeforeIssued):
+		return "issued_in_future"
+	case errors.Is(err, ErrInvalidSigningMethod):
+		return "invalid_signing_method"
+	case errors.Is(err, ErrUnknownKeyID):
+		return "unknown_key_id"
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid):
+		return "bad_signature"
+	case errors.Is(err, jwt.ErrTokenMalformed):
+		return "malformed"
+	case errors.Is(err, ErrInvalidIssuer):
+		return "wrong_issuer"
+	case errors.Is(err, ErrInvalidAudience):
+		return "wrong_audience"
+	case errors.Is(err, ErrRevokedToken):
+		return "revoked"
+	case errors.Is(err, ErrTokenReused):
+		return "reused"
+	case errors.Is(err, ErrRevocationUnavailable):
+		return "revocation_unavailable"
+	case errors.Is(err, ErrInvalidClaims):
+		return "invalid_claims"
+	default:
+		return "invalid"
+	}
+}
+
+// RefreshToken generates a new token with the same claims but updated expiration.
+// Expired tokens are accepted within the refresh window, their signature,
+// issuer and audience are still verified.
 func (m *Manager) RefreshToken(tokenString string) (string, error) {
 	claims, err := m.ValidateToken(tokenString)
 	if err != nil {
@@ -180,31 +446,55 @@ func (m *Manager) RefreshToken(tokenString string) (string, error) {
 			return "", err
 		}
 
-		// try to parse expired token
-		token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-			return []byte(m.config.Secret), nil
-		}, jwt.WithoutClaimsValidation())
+		claims, err = m.parseIgnoringExpiry(tokenString)
 		if err != nil {
-			return "", fmt.Errorf("failed to parse expired token: %w", err)
+			return "", err
+		}
+		if err := m.checkRevoked(claims); err != nil {
+			return "", err
 		}
 
-		var ok bool
-		claims, ok = token.Claims.(*Claims)
-		if !ok {
-			return "", ErrInvalidClaims
+		window := m.config.RefreshWindow
+		if window > 0 && claims.ExpiresAt != nil && time.Since(claims.ExpiresAt.Time) > window {
+			return "", ErrRefreshWindowExpired
 		}
 	}
 
-	// generate new token with same claims
+	// generate new token with same claims, a new ID and fresh timestamps,
+	// tokens of trusted issuers are reissued as our own
+	claims.ID = ""
+	if claims.Issuer != m.config.Issuer {
+		claims.Issuer = ""
+		claims.Audience = nil
+	}
+	claims.ExpiresAt = nil
+	claims.IssuedAt = nil
+	claims.NotBefore = nil
 	return m.GenerateTokenWithClaims(claims)
 }
 
+// parseIgnoringExpiry parses a token, verifying its signature, issuer and
+// audience but not its expiry
+func (m *Manager) parseIgnoringExpiry(tokenString string) (*Claims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, m.keyFunc, jwt.WithoutClaimsValidation())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+
+	claims, ok := token.Claims.(*Claims)
+	if !ok {
+		return nil, ErrInvalidClaims
+	}
+	if err := m.validateClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
 // ExtractUserID extracts user ID from token without full validation
 // useful for logging purposes
 func (m *Manager) ExtractUserID(tokenString string) string {
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(m.config.Secret), nil
-	}, jwt.WithoutClaimsValidation())
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, m.keyFunc, jwt.WithoutClaimsValidation())
 	if err != nil {
 		return ""
 	}
@@ -72,14 +72,30 @@ func ExtractBearerToken(authHeader string) (string, error) {
 	return token, nil
 }
 
+// TokenValidator validates bearer tokens, Manager validates JWTs and
+// PasetoValidator PASETO tokens
+type TokenValidator interface {
+	// ValidateToken validates a token and returns its claims
+	ValidateToken(tokenString string) (*Claims, error)
+	// ValidateRequest validates the bearer token of an Authorization header,
+	// returning an *AuthError on failure
+	ValidateRequest(authHeader string) (*Claims, error)
+}
+
 // ValidateRequest validates the JWT token from the request and returns claims
 func (m *Manager) ValidateRequest(authHeader string) (*Claims, error) {
+	return validateRequest(m, authHeader)
+}
+
+// validateRequest validates the bearer token of an Authorization header with
+// v and maps failures to an *AuthError
+func validateRequest(v TokenValidator, authHeader string) (*Claims, error) {
 	token, err := ExtractBearerToken(authHeader)
 	if err != nil {
 		return nil, err
 	}
 
-	claims, err := m.ValidateToken(token)
+	claims, err := v.ValidateToken(token)
 	if err != nil {
 		statusCode := http.StatusUnauthorized
 		message := "invalid or expired token"
@@ -90,6 +106,11 @@ func (m *Manager) ValidateRequest(authHeader string) (*Claims, error) {
 			message = "invalid token signing method"
 		} else if errors.Is(err, ErrInvalidClaims) {
 			message = "invalid token claims"
+		} else if errors.Is(err, ErrRevokedToken) {
+			message = "token has been revoked"
+		} else if errors.Is(err, ErrRevocationUnavailable) {
+			statusCode = http.StatusServiceUnavailable
+			message = "token revocation check unavailable"
 		}
 
 		return nil, &AuthError{
@@ -0,0 +1,268 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// pasetoHeader is the header of PASETO v4 tokens signed with Ed25519
+const pasetoHeader = "v4.public."
+
+// PasetoConfig holds PASETO v4.public verification settings
+type PasetoConfig struct {
+	PublicKeys []ed25519.PublicKey // any of them verifies a token, several allow key rotation
+	Issuer     string              // required iss claim
+	Audience   string              // required aud claim
+	Leeway     time.Duration       // clock skew tolerated when checking exp, nbf and iat
+
+	// MaxLifetime is the longest lifetime of the issuer's tokens, how long
+	// revoked subjects are remembered
+	MaxLifetime time.Duration
+
+	// Revocations is checked for revoked tokens, nil uses the process-wide
+	// DefaultRevocationStore
+	Revocations RevocationStore
+}
+
+// PasetoValidator validates PASETO v4.public tokens issued by another
+// service. It only verifies tokens, it cannot issue them.
+type PasetoValidator struct {
+	config *PasetoConfig
+}
+
+// NewPasetoValidator creates a PASETO v4.public validator
+func NewPasetoValidator(config *PasetoConfig) (*PasetoValidator, error) {
+	if config == nil {
+		return nil, errors.New("config cannot be nil")
+	}
+	if len(config.PublicKeys) == 0 {
+		return nil, errors.New("public key cannot be empty")
+	}
+	for _, key := range config.PublicKeys {
+		if len(key) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("public key must be %d bytes", ed25519.PublicKeySize)
+		}
+	}
+	if config.Issuer == "" {
+		config.Issuer = "api-gateway"
+	}
+	if config.Audience == "" {
+		config.Audience = "api-gateway"
+	}
+	if config.MaxLifetime <= 0 {
+		config.MaxLifetime = 24 * time.Hour
+	}
+	if config.Revocations == nil {
+		config.Revocations = DefaultRevocationStore()
+	}
+
+	return &PasetoValidator{config: config}, nil
+}
+
+// ParsePasetoPublicKey decodes a hex-encoded Ed25519 public key
+func ParsePasetoPublicKey(s string) (ed25519.PublicKey, error) {
+	key, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("public key must be hex-encoded: %w", err)
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("public key must be %d bytes", ed25519.PublicKeySize)
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// pasetoClaims is the JSON payload of a PASETO token, the registered claims
+// carry times as RFC 3339 strings
+type pasetoClaims struct {
+	Issuer    string                 `json:"iss"`
+	Subject   string                 `json:"sub"`
+	Audience  jwt.ClaimStrings       `json:"aud"`
+	ExpiresAt string                 `json:"exp"`
+	NotBefore string                 `json:"nbf"`
+	IssuedAt  string                 `json:"iat"`
+	ID        string                 `json:"jti"`
+	Username  string                 `json:"username,omitempty"`
+	Email     string                 `json:"email,omitempty"`
+	Roles     []string               `json:"roles,omitempty"`
+	Metadata  map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// ValidateToken verifies a PASETO v4.public token and validates its claims
+func (v *PasetoValidator) ValidateToken(tokenString string) (*Claims, error) {
+	claims, err := v.parse(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	leeway := v.config.Leeway
+	if claims.ExpiresAt != nil && now.After(claims.ExpiresAt.Add(leeway)) {
+		return nil, ErrExpiredToken
+	}
+	if claims.NotBefore != nil && now.Before(claims.NotBefore.Add(-leeway)) {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, jwt.ErrTokenNotValidYet)
+	}
+	if claims.IssuedAt != nil && now.Before(claims.IssuedAt.Add(-leeway)) {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, jwt.ErrTokenUsedBeforeIssued)
+	}
+
+	if err := checkRevoked(v.config.Revocations, claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// ValidateRequest validates the PASETO token from the request and returns claims
+func (v *PasetoValidator) ValidateRequest(authHeader string) (*Claims, error) {
+	return validateRequest(v, authHeader)
+}
+
+// Revoke revokes a token before it expires. Its signature, issuer and
+// audience are verified, it may already be expired. The token must have a
+// jti claim.
+func (v *PasetoValidator) Revoke(ctx context.Context, tokenString string) (*Claims, error) {
+	claims, err := v.parse(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.ID == "" {
+		return nil, fmt.Errorf("%w: token has no jti, revoke its subject instead", ErrInvalidClaims)
+	}
+
+	var expiresAt time.Time
+	if claims.ExpiresAt != nil {
+		expiresAt = claims.ExpiresAt.Time
+	}
+	if err := v.RevokeID(ctx, claims.ID, expiresAt); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// RevokeID revokes the token with the given jti until expiresAt, or for
+// the longest token lifetime when expiresAt is zero
+func (v *PasetoValidator) RevokeID(ctx context.Context, jti string, expiresAt time.Time) error {
+	return revokeID(ctx, v.config.Revocations, jti, expiresAt, 0, v.config.MaxLifetime)
+}
+
+// RevokeSubject revokes every token of a subject issued until now
+func (v *PasetoValidator) RevokeSubject(ctx context.Context, subject string) error {
+	return revokeSubject(ctx, v.config.Revocations, subject, v.config.MaxLifetime)
+}
+
+// parse verifies the signature of a token and decodes its claims, checking
+// the issuer and audience but not the validity period
+func (v *PasetoValidator) parse(tokenString string) (*Claims, error) {
+	if !strings.HasPrefix(tokenString, pasetoHeader) {
+		return nil, fmt.Errorf("%w: %w: not a PASETO v4.public token", ErrInvalidToken, jwt.ErrTokenMalformed)
+	}
+
+	body, footer, _ := strings.Cut(strings.TrimPrefix(tokenString, pasetoHeader), ".")
+	signed, err := base64.RawURLEncoding.DecodeString(body)
+	if err != nil || len(signed) < ed25519.SignatureSize {
+		return nil, fmt.Errorf("%w: %w: invalid payload encoding", ErrInvalidToken, jwt.ErrTokenMalformed)
+	}
+	footerBytes, err := base64.RawURLEncoding.DecodeString(footer)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w: invalid footer encoding", ErrInvalidToken, jwt.ErrTokenMalformed)
+	}
+
+	payload := signed[:len(signed)-ed25519.SignatureSize]
+	signature := signed[len(signed)-ed25519.SignatureSize:]
+
+	// the signature covers the header, payload, footer and an empty implicit assertion
+	message := pasetoPAE([]byte(pasetoHeader), payload, footerBytes, nil)
+	verified := false
+	for _, key := range v.config.PublicKeys {
+		if ed25519.Verify(key, message, signature) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, jwt.ErrTokenSignatureInvalid)
+	}
+
+	var pc pasetoClaims
+	if err := json.Unmarshal(payload, &pc); err != nil {
+		return nil, fmt.Errorf("%w: %w: %v", ErrInvalidToken, jwt.ErrTokenMalformed, err)
+	}
+
+	claims := &Claims{
+		UserID:   pc.Subject,
+		Username: pc.Username,
+		Email:    pc.Email,
+		Roles:    pc.Roles,
+		Metadata: pc.Metadata,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:   pc.Issuer,
+			Subject:  pc.Subject,
+			Audience: pc.Audience,
+			ID:       pc.ID,
+		},
+	}
+	for _, field := range []struct {
+		value string
+		dst   **jwt.NumericDate
+	}{
+		{pc.ExpiresAt, &claims.ExpiresAt},
+		{pc.NotBefore, &claims.NotBefore},
+		{pc.IssuedAt, &claims.IssuedAt},
+	} {
+		if field.value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, field.value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid time %q", ErrInvalidClaims, field.value)
+		}
+		*field.dst = jwt.NewNumericDate(t)
+	}
+
+	if claims.Issuer != v.config.Issuer {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidClaims, ErrInvalidIssuer)
+	}
+	validAudience := false
+	for _, aud := range claims.Audience {
+		if aud == v.config.Audience {
+			validAudience = true
+			break
+		}
+	}
+	if !validAudience {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidClaims, ErrInvalidAudience)
+	}
+
+	return claims, nil
+}
+
+// pasetoPAE is the pre-authentication encoding of PASETO: the number of
+// pieces and the length of each piece as 64-bit little endian, followed by
+// the piece
+func pasetoPAE(pieces ...[]byte) []byte {
+	var buf bytes.Buffer
+	le64 := func(n int) {
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], uint64(n)&(1<<63-1))
+		buf.Write(b[:])
+	}
+
+	le64(len(pieces))
+	for _, piece := range pieces {
+		le64(len(piece))
+		buf.Write(piece)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gateway/template/pkg/redis"
+)
+
+// redisKeyPrefix namespaces the revocation keys in a shared Redis
+const redisKeyPrefix = "gateway:revoked:"
+
+// RedisRevocationStore keeps revocations in Redis so they are shared by all
+// gateway instances and survive restarts.
+type RedisRevocationStore struct {
+	client *redis.Client
+}
+
+// NewRedisRevocationStore creates a store for a Redis URL such as
+// redis://:password@redis:6379/0, use rediss:// for TLS. The connection is
+// opened on first use.
+func NewRedisRevocationStore(rawURL string) (*RedisRevocationStore, error) {
+	client, err := redis.New(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	return &RedisRevocationStore{client: client}, nil
+}
+
+// Revoke implements RevocationStore
+func (s *RedisRevocationStore) Revoke(ctx context.Context, key string, revokedAt time.Time, ttl time.Duration) error {
+	_, err := s.client.Do(ctx, "SET", redisKeyPrefix+key, strconv.FormatInt(revokedAt.Unix(), 10),
+		"PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	return err
+}
+
+// RevokedAt implements RevocationStore
+func (s *RedisRevocationStore) RevokedAt(ctx context.Context, key string) (time.Time, error) {
+	reply, err := s.client.Do(ctx, "GET", redisKeyPrefix+key)
+	if err != nil || reply == nil {
+		return time.Time{}, err
+	}
+
+	unix, err := strconv.ParseInt(reply.(string), 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid revocation time for %q", key)
+	}
+	return time.Unix(unix, 0), nil
+}
+
+// MarkUsed implements TokenUseStore, atomically across gateway instances
+func (s *RedisRevocationStore) MarkUsed(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	reply, err := s.client.Do(ctx, "SET", redisKeyPrefix+key, strconv.FormatInt(time.Now().Unix(), 10),
+		"NX", "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	if err != nil {
+		return false, err
+	}
+	// NX replies nil when the key already exists
+	return reply != nil, nil
+}
+
+// Close closes the connection to Redis
+func (s *RedisRevocationStore) Close() error {
+	return s.client.Close()
+}
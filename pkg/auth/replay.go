@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrTokenReused is returned when a single-use token is presented again
+var ErrTokenReused = errors.New("token has already been used")
+
+// TokenUseStore records the IDs of single-use tokens that were used, until
+// the tokens expire. The revocation stores implement it.
+type TokenUseStore interface {
+	// MarkUsed records key for ttl, it reports false if key is already recorded
+	MarkUsed(ctx context.Context, key string, ttl time.Duration) (bool, error)
+}
+
+// usedKey is the store key of a used token ID
+func usedKey(jti string) string { return "used:" + jti }
+
+// UseToken marks a token as used, so it is accepted only once. The token ID
+// is remembered until afterExpiry past the token's expiry, or for lifetime
+// when the token does not expire. Tokens without a jti claim are rejected.
+func UseToken(ctx context.Context, store TokenUseStore, claims *Claims, afterExpiry, lifetime time.Duration) error {
+	if claims.ID == "" {
+		return fmt.Errorf("%w: single-use token has no jti", ErrInvalidClaims)
+	}
+
+	ttl := lifetime
+	if claims.ExpiresAt != nil {
+		ttl = time.Until(claims.ExpiresAt.Add(afterExpiry))
+		if ttl <= 0 {
+			return ErrExpiredToken
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, revocationTimeout)
+	defer cancel()
+
+	first, err := store.MarkUsed(ctx, usedKey(claims.ID), ttl)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrRevocationUnavailable, err)
+	}
+	if !first {
+		return ErrTokenReused
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestCheckRevokedSubjectBySecond(t *testing.T) {
+	revokedAt := time.Date(2024, 1, 1, 12, 0, 0, 500_000_000, time.UTC)
+	store := NewMemoryRevocationStore()
+	if err := store.Revoke(context.Background(), subjectKey("user-1"), revokedAt, time.Hour); err != nil {
+		t.Fatalf("Revoke() failed: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		issuedAt *jwt.NumericDate
+		want     error
+	}{
+		{"before", jwt.NewNumericDate(revokedAt.Add(-time.Second)), ErrRevokedToken},
+		{"same second, before", jwt.NewNumericDate(revokedAt.Add(-400 * time.Millisecond)), ErrRevokedToken},
+		{"same second, after", &jwt.NumericDate{Time: revokedAt.Add(300 * time.Millisecond)}, ErrRevokedToken},
+		{"next second", jwt.NewNumericDate(revokedAt.Add(500 * time.Millisecond)), nil},
+		{"without iat", nil, ErrRevokedToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := &Claims{UserID: "user-1", RegisteredClaims: jwt.RegisteredClaims{IssuedAt: tt.issuedAt}}
+			if err := checkRevoked(store, claims); !errors.Is(err, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidSession is returned for session cookies that were tampered with,
+// encrypted with another secret or are not session cookies at all
+var ErrInvalidSession = errors.New("invalid session")
+
+// sessionAD binds sealed values to their use, so ciphertexts of other
+// features sharing the secret are not accepted as sessions
+var sessionAD = []byte("gateway session v1")
+
+// SessionCipher encrypts tokens into session cookie values with AES-256-GCM,
+// so browsers hold them without being able to read or alter them, and
+// derives the CSRF tokens of sessions
+type SessionCipher struct {
+	aead    cipher.AEAD
+	csrfKey []byte
+}
+
+// NewSessionCipher creates a session cipher with keys derived from secret
+func NewSessionCipher(secret string) (*SessionCipher, error) {
+	if secret == "" {
+		return nil, errors.New("session secret cannot be empty")
+	}
+
+	block, err := aes.NewCipher(deriveKey(secret, "gateway session encryption"))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &SessionCipher{aead: aead, csrfKey: deriveKey(secret, "gateway session csrf")}, nil
+}
+
+// deriveKey derives a 256-bit key for one purpose from a secret
+func deriveKey(secret, purpose string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// Seal encrypts a token into a cookie-safe value
+func (c *SessionCipher) Seal(token string) (string, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := c.aead.Seal(nonce, nonce, []byte(token), sessionAD)
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a value created by Seal and returns the token
+func (c *SessionCipher) Open(value string) (string, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(sealed) < c.aead.NonceSize() {
+		return "", ErrInvalidSession
+	}
+	nonce, ciphertext := sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():]
+	token, err := c.aead.Open(nil, nonce, ciphertext, sessionAD)
+	if err != nil {
+		return "", ErrInvalidSession
+	}
+	return string(token), nil
+}
+
+// CSRFToken returns the CSRF token of a session, an HMAC of its cookie
+// value, so a token is only valid together with its session cookie
+func (c *SessionCipher) CSRFToken(value string) string {
+	mac := hmac.New(sha256.New, c.csrfKey)
+	mac.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyCSRFToken reports whether token is the CSRF token of the session
+// with the given cookie value, comparing in constant time
+func (c *SessionCipher) VerifyCSRFToken(value, token string) bool {
+	return hmac.Equal([]byte(token), []byte(c.CSRFToken(value)))
+}
@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestSessionCipher(t *testing.T) {
+	cipher, err := NewSessionCipher("secret")
+	if err != nil {
+		t.Fatalf("NewSessionCipher() failed: %v", err)
+	}
+
+	value, err := cipher.Seal("token")
+	if err != nil {
+		t.Fatalf("Seal() failed: %v", err)
+	}
+	if token, err := cipher.Open(value); err != nil || token != "token" {
+		t.Errorf("Open() = %q, %v", token, err)
+	}
+	if other, _ := cipher.Seal("token"); other == value {
+		t.Error("expected every seal to use a new nonce")
+	}
+
+	sealed, _ := base64.RawURLEncoding.DecodeString(value)
+	sealed[len(sealed)-1] ^= 1
+	tampered := base64.RawURLEncoding.EncodeToString(sealed)
+
+	otherCipher, _ := NewSessionCipher("other")
+	tests := map[string]string{
+		"tampered":   tampered,
+		"truncated":  value[:10],
+		"not base64": "not a session!",
+		"empty":      "",
+	}
+	for name, v := range tests {
+		if _, err := cipher.Open(v); !errors.Is(err, ErrInvalidSession) {
+			t.Errorf("%s: expected ErrInvalidSession, got %v", name, err)
+		}
+	}
+	if _, err := otherCipher.Open(value); !errors.Is(err, ErrInvalidSession) {
+		t.Errorf("wrong key: expected ErrInvalidSession, got %v", err)
+	}
+
+	if _, err := NewSessionCipher(""); err == nil {
+		t.Error("expected an empty secret to be rejected")
+	}
+}
+
+// TestSessionExpiry checks that sessions end with their token: a session
+// holding an expired token opens, but the token is rejected
+func TestSessionExpiry(t *testing.T) {
+	manager, err := NewManager(&Config{Secret: "jwt-secret", Expiration: time.Hour})
+	if err != nil {
+		t.Fatalf("NewManager() failed: %v", err)
+	}
+	token, err := manager.GenerateTokenWithClaims(&Claims{
+		UserID:           "user-1",
+		RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Minute))},
+	})
+	if err != nil {
+		t.Fatalf("GenerateTokenWithClaims() failed: %v", err)
+	}
+
+	cipher, _ := NewSessionCipher("secret")
+	value, _ := cipher.Seal(token)
+	opened, err := cipher.Open(value)
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	if _, err := manager.ValidateToken(opened); !errors.Is(err, ErrExpiredToken) {
+		t.Errorf("expected the expired token of the session to be rejected, got %v", err)
+	}
+}
+
+func TestSessionCSRFToken(t *testing.T) {
+	cipher, _ := NewSessionCipher("secret")
+	value, _ := cipher.Seal("token")
+	otherValue, _ := cipher.Seal("token")
+	csrf := cipher.CSRFToken(value)
+
+	if !cipher.VerifyCSRFToken(value, csrf) {
+		t.Error("expected the CSRF token of the session to verify")
+	}
+	if cipher.VerifyCSRFToken(otherValue, csrf) {
+		t.Error("expected the CSRF token to be rejected for another session")
+	}
+	tampered := []byte(csrf)
+	tampered[0] ^= 1
+	if cipher.VerifyCSRFToken(value, string(tampered)) {
+		t.Error("expected a tampered CSRF token to be rejected")
+	}
+	otherCipher, _ := NewSessionCipher("other")
+	if otherCipher.VerifyCSRFToken(value, csrf) {
+		t.Error("expected the CSRF token to be rejected with another key")
+	}
+}
@@ -0,0 +1,48 @@
+package logger
+
+import "context"
+
+// contextKey is the type for the logger context key
+type contextKey struct{}
+
+// NewContext returns a context carrying l
+func NewContext(ctx context.Context, l Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns the request-scoped logger stored in ctx, or a logger
+// discarding everything if there is none
+func FromContext(ctx context.Context) Logger {
+	return FromContextOr(ctx, nopLogger{})
+}
+
+// FromContextOr returns the request-scoped logger stored in ctx, or fallback
+// if there is none
+func FromContextOr(ctx context.Context, fallback Logger) Logger {
+	if l, ok := ctx.Value(contextKey{}).(Logger); ok {
+		return l
+	}
+	return fallback
+}
+
+// ForComponent returns the logger of a subsystem when l supports
+// per-component levels (see ZapLogger.ForComponent), otherwise l itself
+func ForComponent(l Logger, name string) Logger {
+	if cl, ok := l.(interface {
+		ForComponent(name string) Logger
+	}); ok {
+		return cl.ForComponent(name)
+	}
+	return l
+}
+
+// nopLogger discards all messages
+type nopLogger struct{}
+
+func (nopLogger) Info(msg string, keysAndValues ...interface{})  {}
+func (nopLogger) Error(msg string, keysAndValues ...interface{}) {}
+func (nopLogger) Debug(msg string, keysAndValues ...interface{}) {}
+func (nopLogger) Warn(msg string, keysAndValues ...interface{})  {}
+func (nopLogger) Fatal(msg string, keysAndValues ...interface{}) {}
+func (n nopLogger) With(keysAndValues ...interface{}) Logger     { return n }
+func (nopLogger) Enabled(level Level) bool                       { return false }
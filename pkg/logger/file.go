@@ -0,0 +1,51 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// FileConfig holds the configuration for file output with rotation
+type FileConfig struct {
+	Path       string // log file path
+	MaxSizeMB  int    // rotate when the file reaches this size
+	MaxAgeDays int    // delete rotated files older than this, 0 keeps them
+	MaxBackups int    // max rotated files kept, 0 keeps all
+	Compress   bool   // gzip rotated files
+	LocalTime  bool   // use local time in rotated file names instead of UTC
+}
+
+// FileWriter writes log entries to a file, rotating it by size and age
+type FileWriter struct {
+	*lumberjack.Logger
+}
+
+// NewFileWriter creates a rotating file writer, creating the log directory if needed
+func NewFileWriter(cfg *FileConfig) (*FileWriter, error) {
+	if cfg.Path == "" {
+		return nil, fmt.Errorf("log file path is required")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(cfg.Path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+
+	return &FileWriter{
+		Logger: &lumberjack.Logger{
+			Filename:   cfg.Path,
+			MaxSize:    cfg.MaxSizeMB,
+			MaxAge:     cfg.MaxAgeDays,
+			MaxBackups: cfg.MaxBackups,
+			Compress:   cfg.Compress,
+			LocalTime:  cfg.LocalTime,
+		},
+	}, nil
+}
+
+// Sync is a no-op, entries are written to the file without buffering
+func (w *FileWriter) Sync() error {
+	return nil
+}
@@ -0,0 +1,62 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// HTTPBatchWriter posts batches of log entries as newline-delimited JSON
+type HTTPBatchWriter struct {
+	url     string
+	client  *http.Client
+	headers map[string]string
+}
+
+// NewHTTPBatchWriter creates a writer posting to the collector at url.
+// Headers (e.g. an API key) are added to every request.
+func NewHTTPBatchWriter(url string, headers map[string]string) *HTTPBatchWriter {
+	return &HTTPBatchWriter{
+		url:     url,
+		client:  &http.Client{},
+		headers: headers,
+	}
+}
+
+// WriteBatch posts the entries in a single request
+func (w *HTTPBatchWriter) WriteBatch(ctx context.Context, entries [][]byte) error {
+	var body bytes.Buffer
+	for _, entry := range entries {
+		body.Write(bytes.TrimRight(entry, "\n"))
+		body.WriteByte('\n')
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, &body)
+	if err != nil {
+		return fmt.Errorf("failed to create log collector request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	for key, value := range w.headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send logs: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("log collector returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Close releases idle connections
+func (w *HTTPBatchWriter) Close() error {
+	w.client.CloseIdleConnections()
+	return nil
+}
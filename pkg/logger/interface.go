@@ -1,5 +1,17 @@
 package logger
 
+// Level is a log level name
+type Level string
+
+// Log levels, from most to least verbose
+const (
+	DebugLevel Level = "debug"
+	InfoLevel  Level = "info"
+	WarnLevel  Level = "warn"
+	ErrorLevel Level = "error"
+	FatalLevel Level = "fatal"
+)
+
 // Logger defines a common interface for logging across the application
 type Logger interface {
 	// Info logs informational messages
@@ -13,4 +25,14 @@ type Logger interface {
 
 	// Warn logs warning messages
 	Warn(msg string, keysAndValues ...interface{})
+
+	// Fatal logs a fatal message and exits
+	Fatal(msg string, keysAndValues ...interface{})
+
+	// With returns a new logger with additional fields
+	With(keysAndValues ...interface{}) Logger
+
+	// Enabled reports whether messages at level are logged, so callers
+	// can skip building expensive fields
+	Enabled(level Level) bool
 }
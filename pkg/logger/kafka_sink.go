@@ -0,0 +1,45 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// KafkaBatchWriter produces batches of log entries to a Kafka topic
+type KafkaBatchWriter struct {
+	writer *kafka.Writer
+}
+
+// NewKafkaBatchWriter creates a writer producing to topic on the given brokers
+func NewKafkaBatchWriter(brokers []string, topic string) *KafkaBatchWriter {
+	return &KafkaBatchWriter{
+		writer: &kafka.Writer{
+			Addr:                   kafka.TCP(brokers...),
+			Topic:                  topic,
+			Balancer:               &kafka.LeastBytes{},
+			RequiredAcks:           kafka.RequireOne,
+			AllowAutoTopicCreation: false,
+		},
+	}
+}
+
+// WriteBatch produces one message per entry
+func (w *KafkaBatchWriter) WriteBatch(ctx context.Context, entries [][]byte) error {
+	messages := make([]kafka.Message, len(entries))
+	for i, entry := range entries {
+		messages[i] = kafka.Message{Value: bytes.TrimRight(entry, "\n")}
+	}
+
+	if err := w.writer.WriteMessages(ctx, messages...); err != nil {
+		return fmt.Errorf("failed to produce logs to kafka: %w", err)
+	}
+	return nil
+}
+
+// Close flushes and closes the Kafka writer
+func (w *KafkaBatchWriter) Close() error {
+	return w.writer.Close()
+}
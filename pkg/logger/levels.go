@@ -0,0 +1,129 @@
+package logger
+
+import (
+	"fmt"
+	"sync"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// levelRegistry holds the root log level and the levels of named components.
+// Components without an explicit level follow the root level.
+type levelRegistry struct {
+	mu         sync.Mutex
+	root       zap.AtomicLevel
+	components map[string]*componentLevel
+}
+
+// componentLevel is the level of a named component
+type componentLevel struct {
+	level  zap.AtomicLevel
+	pinned bool // set explicitly, not following the root level
+}
+
+func newLevelRegistry(root zap.AtomicLevel) *levelRegistry {
+	return &levelRegistry{
+		root:       root,
+		components: make(map[string]*componentLevel),
+	}
+}
+
+// component returns the level of a component, registering it if needed
+func (r *levelRegistry) component(name string) zap.AtomicLevel {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.components[name]
+	if !ok {
+		c = &componentLevel{level: zap.NewAtomicLevelAt(r.root.Level())}
+		r.components[name] = c
+	}
+	return c.level
+}
+
+// setRoot changes the root level and every component following it
+func (r *levelRegistry) setRoot(level string) error {
+	var l zapcore.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.root.SetLevel(l)
+	for _, c := range r.components {
+		if !c.pinned {
+			c.level.SetLevel(l)
+		}
+	}
+	return nil
+}
+
+// setComponent pins the level of a component, an empty level makes it
+// follow the root level again
+func (r *levelRegistry) setComponent(name, level string) error {
+	if name == "" {
+		return fmt.Errorf("component name is required")
+	}
+
+	l := r.root.Level()
+	if level != "" {
+		if err := l.UnmarshalText([]byte(level)); err != nil {
+			return err
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.components[name]
+	if !ok {
+		c = &componentLevel{level: zap.NewAtomicLevel()}
+		r.components[name] = c
+	}
+	c.level.SetLevel(l)
+	c.pinned = level != ""
+	return nil
+}
+
+// levels returns the current level of every registered component
+func (r *levelRegistry) levels() map[string]string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	levels := make(map[string]string, len(r.components))
+	for name, c := range r.components {
+		levels[name] = c.level.String()
+	}
+	return levels
+}
+
+// levelFilterCore drops entries below its level before they reach the
+// wrapped core, letting loggers sharing outputs use different levels
+type levelFilterCore struct {
+	zapcore.Core
+	level zapcore.LevelEnabler
+}
+
+func withLevel(level zapcore.LevelEnabler) zap.Option {
+	return zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return &levelFilterCore{Core: core, level: level}
+	})
+}
+
+func (c *levelFilterCore) Enabled(l zapcore.Level) bool {
+	return c.level.Enabled(l)
+}
+
+func (c *levelFilterCore) With(fields []zapcore.Field) zapcore.Core {
+	return &levelFilterCore{Core: c.Core.With(fields), level: c.level}
+}
+
+func (c *levelFilterCore) Check(entry zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.level.Enabled(entry.Level) {
+		return ce
+	}
+	return c.Core.Check(entry, ce)
+}
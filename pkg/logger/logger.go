@@ -2,6 +2,7 @@ package logger
 
 import (
 	"fmt"
+	"io"
 	"os"
 
 	"go.uber.org/zap"
@@ -14,6 +15,19 @@ type Config struct {
 	ComponentName string // component name for structured logging
 	EnableStdout  bool   // enable stdout logging
 	Development   bool   // enable development mode (pretty printing)
+
+	// ComponentLevels overrides Level for named components (see ForComponent)
+	ComponentLevels map[string]string
+
+	// Sinks receive every log entry in addition to stdout (e.g. an AsyncSink
+	// shipping to Kafka or an HTTP collector)
+	Sinks []zapcore.WriteSyncer
+
+	// Syslog receives every log entry with a matching syslog severity
+	Syslog *SyslogWriter
+
+	// File receives every log entry, rotated by size and age
+	File *FileWriter
 }
 
 // DefaultConfig returns a default configuration
@@ -28,8 +42,12 @@ func DefaultConfig(componentName string) *Config {
 
 // ZapLogger wraps zap.Logger to provide structured logging
 type ZapLogger struct {
-	logger    *zap.Logger
+	logger    *zap.Logger // filtered by level
+	base      *zap.Logger // unfiltered, used to derive component loggers
+	levels    *levelRegistry
+	output    zapcore.WriteSyncer
 	component string
+	subsystem string // set by ForComponent
 }
 
 // NewZapLogger creates a new zap-based logger
@@ -38,8 +56,8 @@ func NewZapLogger(config *Config) (*ZapLogger, error) {
 		config = DefaultConfig("default")
 	}
 
-	// parse log level
-	level := zapcore.InfoLevel
+	// parse log level, kept atomic so it can be changed at runtime
+	level := zap.NewAtomicLevel()
 	if err := level.UnmarshalText([]byte(config.Level)); err != nil {
 		return nil, fmt.Errorf("invalid log level %q: %w", config.Level, err)
 	}
@@ -60,6 +78,9 @@ func NewZapLogger(config *Config) (*ZapLogger, error) {
 		EncodeCaller:   zapcore.ShortCallerEncoder,
 	}
 
+	// syslog always receives JSON, console colors make no sense there
+	jsonEncoderConfig := encoderConfig
+
 	// use different encoder for development
 	var encoder zapcore.Encoder
 	if config.Development {
@@ -69,23 +90,48 @@ func NewZapLogger(config *Config) (*ZapLogger, error) {
 		encoder = zapcore.NewJSONEncoder(encoderConfig)
 	}
 
-	// create core
+	// combine stdout with the file and configured sinks, stdout is kept
+	// when nothing else is configured so logs are never silently discarded
+	var outputs []zapcore.WriteSyncer
+	if config.File != nil {
+		outputs = append(outputs, config.File)
+	}
+	outputs = append(outputs, config.Sinks...)
+	if config.EnableStdout || (len(outputs) == 0 && config.Syslog == nil) {
+		outputs = append([]zapcore.WriteSyncer{zapcore.AddSync(os.Stdout)}, outputs...)
+	}
+	output := zapcore.NewMultiWriteSyncer(outputs...)
+
+	// create core, levels are applied per logger by levelFilterCore
 	core := zapcore.NewCore(
 		encoder,
-		zapcore.AddSync(os.Stdout),
-		level,
+		output,
+		zapcore.DebugLevel,
 	)
+	if config.Syslog != nil {
+		core = zapcore.NewTee(core, newSyslogCore(zapcore.NewJSONEncoder(jsonEncoderConfig), config.Syslog, zapcore.DebugLevel))
+	}
 
 	// create logger with caller skip
-	logger := zap.New(core, zap.AddCaller(), zap.AddCallerSkip(1))
+	base := zap.New(core, zap.AddCaller(), zap.AddCallerSkip(1))
 
 	// add component field if provided
 	if config.ComponentName != "" {
-		logger = logger.With(zap.String("component", config.ComponentName))
+		base = base.With(zap.String("component", config.ComponentName))
+	}
+
+	levels := newLevelRegistry(level)
+	for name, componentLevel := range config.ComponentLevels {
+		if err := levels.setComponent(name, componentLevel); err != nil {
+			return nil, fmt.Errorf("invalid log level %q for component %q: %w", componentLevel, name, err)
+		}
 	}
 
 	return &ZapLogger{
-		logger:    logger,
+		logger:    base.WithOptions(withLevel(level)),
+		base:      base,
+		levels:    levels,
+		output:    output,
 		component: config.ComponentName,
 	}, nil
 }
@@ -139,10 +185,71 @@ func (l *ZapLogger) Fatal(msg string, keysAndValues ...interface{}) {
 
 // With returns a new logger with additional fields
 func (l *ZapLogger) With(keysAndValues ...interface{}) Logger {
+	fields := convertToFields(keysAndValues)
+	return &ZapLogger{
+		logger:    l.logger.With(fields...),
+		base:      l.base.With(fields...),
+		levels:    l.levels,
+		output:    l.output,
+		component: l.component,
+		subsystem: l.subsystem,
+	}
+}
+
+// ForComponent returns a logger for a subsystem (e.g. proxy, auth) that
+// logs with the component's own level, falling back to the root level.
+// Entries carry the component name in the "subsystem" field.
+func (l *ZapLogger) ForComponent(name string) Logger {
+	if l.subsystem == name {
+		return l
+	}
+
+	base := l.base.With(zap.String("subsystem", name))
 	return &ZapLogger{
-		logger:    l.logger.With(convertToFields(keysAndValues)...),
+		logger:    base.WithOptions(withLevel(l.levels.component(name))),
+		base:      base,
+		levels:    l.levels,
+		output:    l.output,
 		component: l.component,
+		subsystem: name,
+	}
+}
+
+// Enabled reports whether messages at level are logged
+func (l *ZapLogger) Enabled(level Level) bool {
+	var zl zapcore.Level
+	if err := zl.UnmarshalText([]byte(level)); err != nil {
+		return false
 	}
+	return l.logger.Core().Enabled(zl)
+}
+
+// SetLevel changes the root log level at runtime (debug, info, warn, error).
+// Components without their own level follow it.
+func (l *ZapLogger) SetLevel(level string) error {
+	return l.levels.setRoot(level)
+}
+
+// GetLevel returns the current root log level
+func (l *ZapLogger) GetLevel() string {
+	return l.levels.root.String()
+}
+
+// SetComponentLevel changes the log level of a component at runtime,
+// an empty level makes the component follow the root level again
+func (l *ZapLogger) SetComponentLevel(component, level string) error {
+	return l.levels.setComponent(component, level)
+}
+
+// ComponentLevels returns the current level of every known component
+func (l *ZapLogger) ComponentLevels() map[string]string {
+	return l.levels.levels()
+}
+
+// Output returns the writer log entries are written to, so other writers
+// (e.g. text access logs) can share the same destinations
+func (l *ZapLogger) Output() io.Writer {
+	return l.output
 }
 
 // Sync flushes any buffered log entries
@@ -1,29 +1,121 @@
 package logger
 
-// MockLogger is a mock implementation of Logger for testing
-type MockLogger struct{}
+import "sync"
+
+// MockEntry is a log call recorded by MockLogger
+type MockEntry struct {
+	Level         Level
+	Message       string
+	KeysAndValues []interface{} // fields added by With come first
+}
+
+// MockLogger is a mock implementation of Logger for testing.
+// It records every call so tests can assert on logged messages.
+type MockLogger struct {
+	records *mockRecords
+	fields  []interface{}
+}
+
+// mockRecords is shared by a MockLogger and the loggers derived with With
+type mockRecords struct {
+	mu      sync.Mutex
+	entries []MockEntry
+}
 
 // NewMockLogger creates a new mock logger
-func NewMockLogger() Logger {
-	return &MockLogger{}
+func NewMockLogger() *MockLogger {
+	return &MockLogger{records: &mockRecords{}}
 }
 
-// Info logs informational messages (no-op in mock)
+// Info records an informational message
 func (m *MockLogger) Info(msg string, keysAndValues ...interface{}) {
-	// No-op for tests
+	m.record(InfoLevel, msg, keysAndValues)
 }
 
-// Error logs error messages (no-op in mock)
+// Error records an error message
 func (m *MockLogger) Error(msg string, keysAndValues ...interface{}) {
-	// No-op for tests
+	m.record(ErrorLevel, msg, keysAndValues)
 }
 
-// Debug logs debug messages (no-op in mock)
+// Debug records a debug message
 func (m *MockLogger) Debug(msg string, keysAndValues ...interface{}) {
-	// No-op for tests
+	m.record(DebugLevel, msg, keysAndValues)
 }
 
-// Warn logs warning messages (no-op in mock)
+// Warn records a warning message
 func (m *MockLogger) Warn(msg string, keysAndValues ...interface{}) {
-	// No-op for tests
+	m.record(WarnLevel, msg, keysAndValues)
+}
+
+// Fatal records a fatal message (does not exit in mock)
+func (m *MockLogger) Fatal(msg string, keysAndValues ...interface{}) {
+	m.record(FatalLevel, msg, keysAndValues)
+}
+
+// With returns a mock logger recording into the same entries with additional fields
+func (m *MockLogger) With(keysAndValues ...interface{}) Logger {
+	fields := make([]interface{}, 0, len(m.fields)+len(keysAndValues))
+	fields = append(fields, m.fields...)
+	fields = append(fields, keysAndValues...)
+	return &MockLogger{records: m.records, fields: fields}
+}
+
+// Enabled always returns true so every call is recorded
+func (m *MockLogger) Enabled(level Level) bool {
+	return true
+}
+
+// Entries returns a copy of the recorded calls
+func (m *MockLogger) Entries() []MockEntry {
+	m.records.mu.Lock()
+	defer m.records.mu.Unlock()
+
+	entries := make([]MockEntry, len(m.records.entries))
+	copy(entries, m.records.entries)
+	return entries
+}
+
+// EntriesAt returns the recorded calls at level
+func (m *MockLogger) EntriesAt(level Level) []MockEntry {
+	var entries []MockEntry
+	for _, entry := range m.Entries() {
+		if entry.Level == level {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// Logged reports whether msg was logged at level
+func (m *MockLogger) Logged(level Level, msg string) bool {
+	for _, entry := range m.EntriesAt(level) {
+		if entry.Message == msg {
+			return true
+		}
+	}
+	return false
+}
+
+// Reset clears the recorded calls
+func (m *MockLogger) Reset() {
+	m.records.mu.Lock()
+	defer m.records.mu.Unlock()
+
+	m.records.entries = nil
+}
+
+// record stores a call
+func (m *MockLogger) record(level Level, msg string, keysAndValues []interface{}) {
+	fields := make([]interface{}, 0, len(m.fields)+len(keysAndValues))
+	fields = append(fields, m.fields...)
+	fields = append(fields, keysAndValues...)
+
+	m.records.mu.Lock()
+	defer m.records.mu.Unlock()
+
+	m.records.entries = append(m.records.entries, MockEntry{
+		Level:         level,
+		Message:       msg,
+		KeysAndValues: fields,
+	})
 }
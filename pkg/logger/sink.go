@@ -0,0 +1,177 @@
+package logger
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BatchWriter delivers batches of encoded log entries to a remote collector
+type BatchWriter interface {
+	// WriteBatch delivers the entries, each entry is one encoded log line
+	WriteBatch(ctx context.Context, entries [][]byte) error
+	// Close releases resources held by the writer
+	Close() error
+}
+
+// AsyncSinkConfig holds the buffering settings of an AsyncSink
+type AsyncSinkConfig struct {
+	BufferSize    int           // max entries waiting for delivery
+	BatchSize     int           // max entries per batch
+	FlushInterval time.Duration // max time an entry waits before its batch is sent
+	BlockOnFull   bool          // block logging when the buffer is full instead of dropping entries
+	WriteTimeout  time.Duration // timeout for a single batch delivery
+}
+
+// AsyncSink is a zapcore.WriteSyncer that delivers log entries asynchronously
+// in batches through a BatchWriter. When the buffer is full, entries are
+// dropped (or logging blocks if BlockOnFull is set) so a slow collector
+// never stalls request handling.
+type AsyncSink struct {
+	writer  BatchWriter
+	cfg     AsyncSinkConfig
+	entries chan []byte
+	flushes chan chan struct{}
+	done    chan struct{}
+	once    sync.Once
+	dropped atomic.Uint64
+	failed  atomic.Uint64
+}
+
+// NewAsyncSink creates an async sink and starts its delivery loop
+func NewAsyncSink(writer BatchWriter, cfg AsyncSinkConfig) *AsyncSink {
+	if cfg.BufferSize <= 0 {
+		cfg.BufferSize = 10000
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 500
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = time.Second
+	}
+	if cfg.WriteTimeout <= 0 {
+		cfg.WriteTimeout = 10 * time.Second
+	}
+
+	s := &AsyncSink{
+		writer:  writer,
+		cfg:     cfg,
+		entries: make(chan []byte, cfg.BufferSize),
+		flushes: make(chan chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// Write queues a log entry for delivery
+func (s *AsyncSink) Write(p []byte) (int, error) {
+	// zap reuses its buffer, keep a copy
+	entry := make([]byte, len(p))
+	copy(entry, p)
+
+	if s.cfg.BlockOnFull {
+		select {
+		case s.entries <- entry:
+		case <-s.done:
+		}
+		return len(p), nil
+	}
+
+	select {
+	case s.entries <- entry:
+	default:
+		s.dropped.Add(1)
+	}
+	return len(p), nil
+}
+
+// Sync waits until all queued entries have been handed to the writer
+func (s *AsyncSink) Sync() error {
+	ack := make(chan struct{})
+	select {
+	case s.flushes <- ack:
+		<-ack
+	case <-s.done:
+	}
+	return nil
+}
+
+// Close flushes queued entries, stops the delivery loop and closes the writer
+func (s *AsyncSink) Close() error {
+	var err error
+	s.once.Do(func() {
+		_ = s.Sync()
+		close(s.done)
+		err = s.writer.Close()
+	})
+	return err
+}
+
+// Dropped returns the number of entries dropped because the buffer was full
+func (s *AsyncSink) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Failed returns the number of entries lost because delivery failed
+func (s *AsyncSink) Failed() uint64 {
+	return s.failed.Load()
+}
+
+// run batches queued entries and delivers them
+func (s *AsyncSink) run() {
+	ticker := time.NewTicker(s.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([][]byte, 0, s.cfg.BatchSize)
+
+	for {
+		select {
+		case entry := <-s.entries:
+			batch = append(batch, entry)
+			if len(batch) >= s.cfg.BatchSize {
+				batch = s.deliver(batch)
+			}
+		case <-ticker.C:
+			batch = s.deliver(batch)
+		case ack := <-s.flushes:
+			batch = s.drain(batch)
+			batch = s.deliver(batch)
+			close(ack)
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// drain moves all currently queued entries into the batch, delivering full batches
+func (s *AsyncSink) drain(batch [][]byte) [][]byte {
+	for {
+		select {
+		case entry := <-s.entries:
+			batch = append(batch, entry)
+			if len(batch) >= s.cfg.BatchSize {
+				batch = s.deliver(batch)
+			}
+		default:
+			return batch
+		}
+	}
+}
+
+// deliver sends the batch and returns an empty batch for reuse
+func (s *AsyncSink) deliver(batch [][]byte) [][]byte {
+	if len(batch) == 0 {
+		return batch
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.WriteTimeout)
+	defer cancel()
+
+	if err := s.writer.WriteBatch(ctx, batch); err != nil {
+		s.failed.Add(uint64(len(batch)))
+	}
+
+	return batch[:0]
+}
@@ -0,0 +1,230 @@
+package logger
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"runtime"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// slogLevelFatal is the slog level used for Fatal messages
+const slogLevelFatal = slog.LevelError + 4
+
+// SlogLogger implements Logger on top of the standard library log/slog
+type SlogLogger struct {
+	logger *slog.Logger
+}
+
+// NewSlogLogger creates a Logger backed by l, slog.Default() is used when l is nil
+func NewSlogLogger(l *slog.Logger) *SlogLogger {
+	if l == nil {
+		l = slog.Default()
+	}
+	return &SlogLogger{logger: l}
+}
+
+// Info logs informational messages
+func (l *SlogLogger) Info(msg string, keysAndValues ...interface{}) {
+	l.log(slog.LevelInfo, msg, keysAndValues)
+}
+
+// Error logs error messages
+func (l *SlogLogger) Error(msg string, keysAndValues ...interface{}) {
+	l.log(slog.LevelError, msg, keysAndValues)
+}
+
+// Debug logs debug messages
+func (l *SlogLogger) Debug(msg string, keysAndValues ...interface{}) {
+	l.log(slog.LevelDebug, msg, keysAndValues)
+}
+
+// Warn logs warning messages
+func (l *SlogLogger) Warn(msg string, keysAndValues ...interface{}) {
+	l.log(slog.LevelWarn, msg, keysAndValues)
+}
+
+// Fatal logs a fatal message and exits
+func (l *SlogLogger) Fatal(msg string, keysAndValues ...interface{}) {
+	l.log(slogLevelFatal, msg, keysAndValues)
+	os.Exit(1)
+}
+
+// With returns a new logger with additional fields
+func (l *SlogLogger) With(keysAndValues ...interface{}) Logger {
+	return &SlogLogger{logger: l.logger.With(keysAndValues...)}
+}
+
+// Enabled reports whether messages at level are logged
+func (l *SlogLogger) Enabled(level Level) bool {
+	return l.logger.Enabled(context.Background(), slogLevel(level))
+}
+
+// Slog returns the underlying slog.Logger
+func (l *SlogLogger) Slog() *slog.Logger {
+	return l.logger
+}
+
+// log records the message with the caller of the Logger method as source
+func (l *SlogLogger) log(level slog.Level, msg string, keysAndValues []interface{}) {
+	ctx := context.Background()
+	if !l.logger.Enabled(ctx, level) {
+		return
+	}
+
+	// skip runtime.Callers, log and the Logger method
+	var pcs [1]uintptr
+	runtime.Callers(3, pcs[:])
+
+	record := slog.NewRecord(time.Now(), level, msg, pcs[0])
+	record.Add(keysAndValues...)
+	_ = l.logger.Handler().Handle(ctx, record)
+}
+
+// SlogHandler exposes a ZapLogger as a slog.Handler, so code using
+// log/slog writes to the same outputs as the gateway logger
+type SlogHandler struct {
+	logger *zap.Logger
+}
+
+// NewSlogHandler creates a slog.Handler writing through l
+func NewSlogHandler(l *ZapLogger) *SlogHandler {
+	// the handler sets the caller from the slog record itself
+	return &SlogHandler{logger: l.logger.WithOptions(zap.AddCallerSkip(-1))}
+}
+
+// Slog returns a slog.Logger writing through the zap logger
+func (l *ZapLogger) Slog() *slog.Logger {
+	return slog.New(NewSlogHandler(l))
+}
+
+// Enabled reports whether the zap logger logs records at level
+func (h *SlogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return h.logger.Core().Enabled(zapLevel(level))
+}
+
+// Handle writes the record
+func (h *SlogHandler) Handle(_ context.Context, record slog.Record) error {
+	ce := h.logger.Check(zapLevel(record.Level), record.Message)
+	if ce == nil {
+		return nil
+	}
+
+	ce.Time = record.Time
+	if record.PC != 0 {
+		frame, _ := runtime.CallersFrames([]uintptr{record.PC}).Next()
+		ce.Caller = zapcore.NewEntryCaller(frame.PC, frame.File, frame.Line, true)
+	}
+
+	fields := make([]zap.Field, 0, record.NumAttrs())
+	record.Attrs(func(attr slog.Attr) bool {
+		fields = appendAttr(fields, attr)
+		return true
+	})
+
+	ce.Write(fields...)
+	return nil
+}
+
+// WithAttrs returns a handler with additional fields
+func (h *SlogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &SlogHandler{logger: h.logger.With(appendAttrs(attrs)...)}
+}
+
+// WithGroup returns a handler nesting subsequent fields under name
+func (h *SlogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &SlogHandler{logger: h.logger.With(zap.Namespace(name))}
+}
+
+// appendAttr converts a slog attribute to zap fields
+func appendAttr(fields []zap.Field, attr slog.Attr) []zap.Field {
+	value := attr.Value.Resolve()
+	if attr.Equal(slog.Attr{}) {
+		return fields
+	}
+
+	switch value.Kind() {
+	case slog.KindGroup:
+		group := value.Group()
+		if len(group) == 0 {
+			return fields
+		}
+		// inline groups without a key, as slog does
+		if attr.Key == "" {
+			for _, a := range group {
+				fields = appendAttr(fields, a)
+			}
+			return fields
+		}
+		return append(fields, zap.Object(attr.Key, zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+			for _, f := range appendAttrs(group) {
+				f.AddTo(enc)
+			}
+			return nil
+		})))
+	case slog.KindString:
+		return append(fields, zap.String(attr.Key, value.String()))
+	case slog.KindInt64:
+		return append(fields, zap.Int64(attr.Key, value.Int64()))
+	case slog.KindUint64:
+		return append(fields, zap.Uint64(attr.Key, value.Uint64()))
+	case slog.KindFloat64:
+		return append(fields, zap.Float64(attr.Key, value.Float64()))
+	case slog.KindBool:
+		return append(fields, zap.Bool(attr.Key, value.Bool()))
+	case slog.KindDuration:
+		return append(fields, zap.Duration(attr.Key, value.Duration()))
+	case slog.KindTime:
+		return append(fields, zap.Time(attr.Key, value.Time()))
+	default:
+		if err, ok := value.Any().(error); ok {
+			return append(fields, zap.NamedError(attr.Key, err))
+		}
+		return append(fields, zap.Any(attr.Key, value.Any()))
+	}
+}
+
+// appendAttrs converts slog attributes to zap fields
+func appendAttrs(attrs []slog.Attr) []zap.Field {
+	fields := make([]zap.Field, 0, len(attrs))
+	for _, attr := range attrs {
+		fields = appendAttr(fields, attr)
+	}
+	return fields
+}
+
+// zapLevel maps slog levels to zap levels
+func zapLevel(level slog.Level) zapcore.Level {
+	switch {
+	case level >= slog.LevelError:
+		return zapcore.ErrorLevel
+	case level >= slog.LevelWarn:
+		return zapcore.WarnLevel
+	case level >= slog.LevelInfo:
+		return zapcore.InfoLevel
+	default:
+		return zapcore.DebugLevel
+	}
+}
+
+// slogLevel maps Logger levels to slog levels
+func slogLevel(level Level) slog.Level {
+	switch level {
+	case DebugLevel:
+		return slog.LevelDebug
+	case WarnLevel:
+		return slog.LevelWarn
+	case ErrorLevel:
+		return slog.LevelError
+	case FatalLevel:
+		return slogLevelFatal
+	default:
+		return slog.LevelInfo
+	}
+}
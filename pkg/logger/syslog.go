@@ -0,0 +1,239 @@
+package logger
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// SyslogConfig holds the configuration for the syslog output
+type SyslogConfig struct {
+	Network  string // udp, tcp, unix or unixgram, empty uses the local syslog socket
+	Address  string // host:port or socket path
+	Facility string // kern, user, daemon, auth, local0..local7
+	Tag      string // APP-NAME reported to syslog
+}
+
+// syslogFacilities maps facility names to their RFC 5424 codes
+var syslogFacilities = map[string]int{
+	"kern":     0,
+	"user":     1,
+	"mail":     2,
+	"daemon":   3,
+	"auth":     4,
+	"syslog":   5,
+	"lpr":      6,
+	"news":     7,
+	"uucp":     8,
+	"cron":     9,
+	"authpriv": 10,
+	"ftp":      11,
+	"local0":   16,
+	"local1":   17,
+	"local2":   18,
+	"local3":   19,
+	"local4":   20,
+	"local5":   21,
+	"local6":   22,
+	"local7":   23,
+}
+
+// localSyslogSockets are tried in order when no address is configured
+var localSyslogSockets = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// SyslogWriter sends RFC 5424 messages to a syslog daemon.
+// Connection failures are retried once with a fresh connection.
+type SyslogWriter struct {
+	network  string
+	address  string
+	facility int
+	tag      string
+	hostname string
+	pid      int
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// NewSyslogWriter creates a syslog writer and connects to the daemon
+func NewSyslogWriter(cfg *SyslogConfig) (*SyslogWriter, error) {
+	facilityName := strings.ToLower(cfg.Facility)
+	if facilityName == "" {
+		facilityName = "local0"
+	}
+	facility, ok := syslogFacilities[facilityName]
+	if !ok {
+		return nil, fmt.Errorf("unknown syslog facility %q", cfg.Facility)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+
+	tag := cfg.Tag
+	if tag == "" {
+		tag = "-"
+	}
+
+	w := &SyslogWriter{
+		network:  cfg.Network,
+		address:  cfg.Address,
+		facility: facility,
+		tag:      tag,
+		hostname: hostname,
+		pid:      os.Getpid(),
+	}
+
+	if err := w.connect(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// connect dials the syslog daemon, caller must hold mu (or be the constructor)
+func (w *SyslogWriter) connect() error {
+	if w.conn != nil {
+		w.conn.Close()
+		w.conn = nil
+	}
+
+	if w.network == "" && w.address == "" {
+		for _, path := range localSyslogSockets {
+			for _, network := range []string{"unixgram", "unix"} {
+				conn, err := net.Dial(network, path)
+				if err == nil {
+					w.conn = conn
+					return nil
+				}
+			}
+		}
+		return fmt.Errorf("no local syslog socket found")
+	}
+
+	conn, err := net.DialTimeout(w.network, w.address, 5*time.Second)
+	if err != nil {
+		return fmt.Errorf("failed to connect to syslog at %s://%s: %w", w.network, w.address, err)
+	}
+	w.conn = conn
+	return nil
+}
+
+// WriteEntry sends msg with the severity matching level
+func (w *SyslogWriter) WriteEntry(level zapcore.Level, t time.Time, msg []byte) error {
+	msg = []byte(strings.TrimRight(string(msg), "\n"))
+
+	// <PRI>VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+	line := fmt.Sprintf("<%d>1 %s %s %s %d - - %s",
+		w.facility*8+syslogSeverity(level),
+		t.Format(time.RFC3339Nano),
+		w.hostname,
+		w.tag,
+		w.pid,
+		msg,
+	)
+
+	// stream transports need framing (RFC 6587 octet counting)
+	if w.network == "tcp" || w.network == "tcp4" || w.network == "tcp6" || w.network == "unix" {
+		line = fmt.Sprintf("%d %s", len(line), line)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.conn != nil {
+		if _, err := w.conn.Write([]byte(line)); err == nil {
+			return nil
+		}
+	}
+
+	// reconnect once, e.g. after a daemon restart
+	if err := w.connect(); err != nil {
+		return err
+	}
+	_, err := w.conn.Write([]byte(line))
+	return err
+}
+
+// Close closes the connection to the daemon
+func (w *SyslogWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.conn == nil {
+		return nil
+	}
+	err := w.conn.Close()
+	w.conn = nil
+	return err
+}
+
+// syslogSeverity maps zap levels to RFC 5424 severities
+func syslogSeverity(level zapcore.Level) int {
+	switch {
+	case level >= zapcore.FatalLevel:
+		return 2 // critical
+	case level >= zapcore.ErrorLevel:
+		return 3 // error
+	case level == zapcore.WarnLevel:
+		return 4 // warning
+	case level == zapcore.InfoLevel:
+		return 6 // informational
+	default:
+		return 7 // debug
+	}
+}
+
+// syslogCore is a zapcore.Core writing encoded entries to syslog
+// with a per-entry severity
+type syslogCore struct {
+	zapcore.LevelEnabler
+	encoder zapcore.Encoder
+	writer  *SyslogWriter
+}
+
+func newSyslogCore(encoder zapcore.Encoder, writer *SyslogWriter, enab zapcore.LevelEnabler) zapcore.Core {
+	return &syslogCore{
+		LevelEnabler: enab,
+		encoder:      encoder,
+		writer:       writer,
+	}
+}
+
+func (c *syslogCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := &syslogCore{
+		LevelEnabler: c.LevelEnabler,
+		encoder:      c.encoder.Clone(),
+		writer:       c.writer,
+	}
+	for _, field := range fields {
+		field.AddTo(clone.encoder)
+	}
+	return clone
+}
+
+func (c *syslogCore) Check(entry zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return ce.AddCore(entry, c)
+	}
+	return ce
+}
+
+func (c *syslogCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	buf, err := c.encoder.EncodeEntry(entry, fields)
+	if err != nil {
+		return err
+	}
+	defer buf.Free()
+
+	return c.writer.WriteEntry(entry.Level, entry.Time, buf.Bytes())
+}
+
+func (c *syslogCore) Sync() error {
+	return nil
+}
@@ -0,0 +1,259 @@
+// Package redis is a minimal Redis client for the gateway's shared stores.
+// It speaks the Redis protocol directly over a small pool of connections, so
+// no client library is needed.
+package redis
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultTimeout bounds commands whose context has no deadline
+const defaultTimeout = 2 * time.Second
+
+// defaultPoolSize is the number of connections of a client unless its URL
+// sets pool_size
+const defaultPoolSize = 10
+
+// Client sends commands to a Redis server over a pool of connections, each
+// running one command at a time
+type Client struct {
+	addr     string
+	username string
+	password string
+	db       int
+	tls      bool
+
+	slots chan struct{} // one per open or opening connection
+
+	mu     sync.Mutex
+	idle   []*conn
+	closed bool
+}
+
+// conn is a connection of the pool
+type conn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+// New creates a client for a Redis URL such as redis://:password@redis:6379/0,
+// use rediss:// for TLS and ?pool_size=N to change the number of connections.
+// Connections are opened on first use.
+func New(rawURL string) (*Client, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis URL: %w", err)
+	}
+	if u.Scheme != "redis" && u.Scheme != "rediss" {
+		return nil, fmt.Errorf("redis URL scheme must be redis or rediss")
+	}
+
+	c := &Client{addr: u.Host, tls: u.Scheme == "rediss"}
+	if u.Port() == "" {
+		c.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		c.username = u.User.Username()
+		c.password, _ = u.User.Password()
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if c.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid redis database %q", db)
+		}
+	}
+
+	poolSize := defaultPoolSize
+	if size := u.Query().Get("pool_size"); size != "" {
+		if poolSize, err = strconv.Atoi(size); err != nil || poolSize < 1 {
+			return nil, fmt.Errorf("invalid redis pool_size %q", size)
+		}
+	}
+	c.slots = make(chan struct{}, poolSize)
+	return c, nil
+}
+
+// Close closes the idle connections and those of commands in flight once
+// they finish
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	var err error
+	for _, cn := range c.idle {
+		if closeErr := cn.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	c.idle = nil
+	return err
+}
+
+// Do sends a command and returns its reply: a string, an int64 or nil. It
+// waits for a free connection when all are busy. The command, including
+// opening a connection, must finish before the deadline of ctx, or within
+// 2 seconds without one. A connection is dropped after any error other
+// than an error reply.
+func (c *Client) Do(ctx context.Context, args ...string) (interface{}, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultTimeout)
+		defer cancel()
+	}
+
+	select {
+	case c.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("no free redis connection: %w", ctx.Err())
+	}
+	defer func() { <-c.slots }()
+
+	cn, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline, _ := ctx.Deadline()
+	_ = cn.SetDeadline(deadline)
+
+	reply, err := cn.roundTrip(args...)
+	var redisErr Error
+	if err != nil && !errors.As(err, &redisErr) {
+		cn.Close()
+		return reply, err
+	}
+	c.put(cn)
+	return reply, err
+}
+
+// get returns an idle connection or opens a new one, the caller holds a slot
+func (c *Client) get(ctx context.Context) (*conn, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, fmt.Errorf("redis client closed")
+	}
+	if n := len(c.idle); n > 0 {
+		cn := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.mu.Unlock()
+		return cn, nil
+	}
+	c.mu.Unlock()
+
+	return c.connect(ctx)
+}
+
+// put returns a connection to the pool, or closes it after Close
+func (c *Client) put(cn *conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		cn.Close()
+		return
+	}
+	c.idle = append(c.idle, cn)
+}
+
+// connect opens a connection and authenticates
+func (c *Client) connect(ctx context.Context) (*conn, error) {
+	var nc net.Conn
+	var err error
+	if c.tls {
+		dialer := &tls.Dialer{Config: &tls.Config{MinVersion: tls.VersionTLS12}}
+		nc, err = dialer.DialContext(ctx, "tcp", c.addr)
+	} else {
+		var dialer net.Dialer
+		nc, err = dialer.DialContext(ctx, "tcp", c.addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to redis: %w", err)
+	}
+	cn := &conn{Conn: nc, reader: bufio.NewReader(nc)}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = cn.SetDeadline(deadline)
+	}
+
+	var setup [][]string
+	if c.password != "" {
+		if c.username != "" {
+			setup = append(setup, []string{"AUTH", c.username, c.password})
+		} else {
+			setup = append(setup, []string{"AUTH", c.password})
+		}
+	}
+	if c.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(c.db)})
+	}
+	for _, cmd := range setup {
+		if _, err := cn.roundTrip(cmd...); err != nil {
+			cn.Close()
+			return nil, fmt.Errorf("redis %s failed: %w", cmd[0], err)
+		}
+	}
+	return cn, nil
+}
+
+// roundTrip writes a command as an array of bulk strings and reads the reply
+func (c *conn) roundTrip(args ...string) (interface{}, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(c.Conn, b.String()); err != nil {
+		return nil, fmt.Errorf("redis write failed: %w", err)
+	}
+
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("redis read failed: %w", err)
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("empty redis reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, Error(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis reply %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(c.reader, data); err != nil {
+			return nil, fmt.Errorf("redis read failed: %w", err)
+		}
+		return string(data[:n]), nil
+	default:
+		return nil, fmt.Errorf("unexpected redis reply %q", line)
+	}
+}
+
+// Error is an error reply, the connection stays usable after it
+type Error string
+
+// Error implements the error interface
+func (e Error) Error() string { return "redis: " + string(e) }